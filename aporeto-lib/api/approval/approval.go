@@ -0,0 +1,562 @@
+// Package approval implements the lifecycle of exception requests: an exception policy is
+// submitted as a pending request, approved by owners of both its tenants and only then
+// created, unless an administrator creates it directly as an approved request.
+// Rejections, revocations and expirations are recorded in the request.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/manipulate"
+)
+
+// Status of a request.
+const (
+	// StatusPending requests wait for the approval of the owners of their tenants.
+	StatusPending = "pending"
+
+	// StatusApproved requests were approved by all the owners and their policy created.
+	StatusApproved = "approved"
+
+	// StatusRejected requests were rejected by an owner before being approved.
+	StatusRejected = "rejected"
+
+	// StatusRevoked requests were withdrawn, and their policy deleted if it was created.
+	StatusRevoked = "revoked"
+
+	// StatusExpired requests reached their expiry, and their policy was deleted if it was
+	// created.
+	StatusExpired = "expired"
+)
+
+// Errors.
+var (
+	// ErrNotFound is returned by the stores when a request does not exist.
+	ErrNotFound = errors.New("exception request not found")
+
+	// ErrConflict is returned by the stores when a request was changed since it was read.
+	ErrConflict = errors.New("exception request was changed concurrently")
+)
+
+// Request is a request for an exception policy.
+type Request struct {
+	ID            string                      `json:"ID"`
+	Policy        networkpolicy.NetworkPolicy `json:"policy"`
+	Requester     string                      `json:"requester"`
+	Justification string                      `json:"justification"`
+	Expiry        time.Time                   `json:"expiry"`
+	Status        string                      `json:"status"`
+
+	// Approvals are the approvals of the owners, at most one per tenant.
+	Approvals []*Decision `json:"approvals,omitempty"`
+
+	// Closure is the rejection, revocation or expiration of the request.
+	Closure *Decision `json:"closure,omitempty"`
+
+	CreateTime time.Time `json:"createTime"`
+	UpdateTime time.Time `json:"updateTime"`
+
+	// Version is incremented by the stores on each change, to detect concurrent changes.
+	Version int `json:"version"`
+}
+
+// Decision is an approval, rejection, revocation or expiration of a request.
+type Decision struct {
+	By      string    `json:"by"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Tenants returns the tenant namespaces that must approve the request: the subject and
+// the object tenants.
+func (r *Request) Tenants() []string {
+
+	if r.Policy.SubjectTenantNamespace == r.Policy.ObjectTenantNamespace {
+		return []string{r.Policy.SubjectTenantNamespace}
+	}
+	return []string{r.Policy.SubjectTenantNamespace, r.Policy.ObjectTenantNamespace}
+}
+
+// Pending returns the tenant namespaces that did not approve the request yet.
+func (r *Request) Pending() []string {
+
+	var out []string
+	for _, t := range r.Tenants() {
+		if r.approval(t) == nil {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// approval returns the approval of a tenant, if any.
+func (r *Request) approval(tenant string) *Decision {
+
+	for _, a := range r.Approvals {
+		if a.Tenant == tenant {
+			return a
+		}
+	}
+	return nil
+}
+
+// Store stores the requests.
+type Store interface {
+
+	// Put creates or updates a request. It fails with ErrConflict if the stored request
+	// does not have the version of r, and increments the version of r otherwise.
+	Put(ctx context.Context, r *Request) error
+
+	// Get returns a request or ErrNotFound.
+	Get(ctx context.Context, id string) (*Request, error)
+
+	// List returns all the requests, oldest first.
+	List(ctx context.Context) ([]*Request, error)
+}
+
+// Workflow runs the lifecycle of the requests.
+type Workflow struct {
+	// Store stores the requests.
+	Store Store
+
+	// Owners are the users allowed to approve the requests of each tenant, by tenant
+	// namespace.
+	Owners map[string][]string
+
+	// Rules are the compliance rules checked when a request is submitted and approved.
+	// When nil, no rule is checked.
+	Rules *compliance.RuleSet
+
+	now  func() time.Time
+	lock sync.Mutex
+}
+
+// Submit validates a request and stores it as pending. Requests violating a compliance
+// rule with a deny severity are refused.
+func (w *Workflow) Submit(ctx context.Context, r *Request) error {
+
+	if r.Requester == "" {
+		return fmt.Errorf("unable to submit exception request '%s': no requester", r.Policy.Name)
+	}
+	if r.Justification == "" {
+		return fmt.Errorf("unable to submit exception request '%s': no justification", r.Policy.Name)
+	}
+
+	now := w.clock()
+	if err := w.check(r, now); err != nil {
+		return fmt.Errorf("unable to submit exception request '%s': %s", r.Policy.Name, err.Error())
+	}
+
+	for _, t := range r.Tenants() {
+		if len(w.Owners[t]) == 0 {
+			return fmt.Errorf("unable to submit exception request '%s': tenant '%s' has no owner", r.Policy.Name, t)
+		}
+	}
+
+	r.ID = newID()
+	r.Status = StatusPending
+	r.Approvals = nil
+	r.Closure = nil
+	r.CreateTime = now
+	r.UpdateTime = now
+	r.Version = 0
+
+	if err := w.Store.Put(ctx, r); err != nil {
+		return fmt.Errorf("unable to submit exception request '%s': %s", r.Policy.Name, err.Error())
+	}
+
+	logging.FromContext(ctx).Info(
+		"exception request submitted",
+		logging.String("request", r.ID),
+		logging.String("name", r.Policy.Name),
+		logging.String("requester", r.Requester),
+	)
+
+	return nil
+}
+
+// Approve records the approval of a pending request by a user, for each tenant of the
+// request the user owns. Once all the tenants approved, the policy is created with m and
+// the request is approved. The requester can not approve its own request, even as an
+// owner. The user must be authenticated by the caller.
+func (w *Workflow) Approve(ctx context.Context, m manipulate.Manipulator, id, user, comment string) (*Request, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	r, err := w.pending(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to approve exception request '%s': %s", id, err.Error())
+	}
+
+	if user == r.Requester {
+		return nil, fmt.Errorf("unable to approve exception request '%s': '%s' is its requester", id, user)
+	}
+
+	now := w.clock()
+	approved := false
+	for _, t := range r.Pending() {
+		if w.owns(user, t) {
+			r.Approvals = append(r.Approvals, &Decision{By: user, Tenant: t, Comment: comment, Time: now})
+			approved = true
+		}
+	}
+	if !approved {
+		return nil, fmt.Errorf("unable to approve exception request '%s': '%s' does not own a tenant waiting for approval", id, user)
+	}
+	r.UpdateTime = now
+
+	logger := logging.FromContext(ctx).With(logging.String("request", r.ID), logging.String("name", r.Policy.Name))
+
+	if len(r.Pending()) == 0 {
+		// The rules may have changed since the request was submitted.
+		r.Policy.Rules = w.Rules
+		if err := r.Policy.Create(ctx, m); err != nil {
+			return nil, fmt.Errorf("unable to approve exception request '%s': %s", id, err.Error())
+		}
+		r.Status = StatusApproved
+	}
+
+	if err := w.put(ctx, m, r); err != nil {
+		return nil, fmt.Errorf("unable to approve exception request '%s': %s", id, err.Error())
+	}
+
+	logger.Info("exception request approved", logging.String("by", user), logging.String("status", r.Status))
+
+	return r, nil
+}
+
+// Create creates the policy of a request with m right away and stores the request as
+// approved by the user for all its tenants, the user being its requester. It is how the
+// exception policies created directly by an administrator are recorded: they are then
+// listed, revoked and expired like the approved requests. The request is checked like by
+// Submit, but its tenants need no owner and its justification is optional. The user must
+// be authenticated by the caller.
+func (w *Workflow) Create(ctx context.Context, m manipulate.Manipulator, r *Request, user, comment string) error {
+
+	if user == "" {
+		return fmt.Errorf("unable to create exception request '%s': no user", r.Policy.Name)
+	}
+
+	now := w.clock()
+	if err := w.check(r, now); err != nil {
+		return fmt.Errorf("unable to create exception request '%s': %s", r.Policy.Name, err.Error())
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	r.ID = newID()
+	r.Requester = user
+	r.Status = StatusApproved
+	r.Approvals = nil
+	for _, t := range r.Tenants() {
+		r.Approvals = append(r.Approvals, &Decision{By: user, Tenant: t, Comment: comment, Time: now})
+	}
+	r.Closure = nil
+	r.CreateTime = now
+	r.UpdateTime = now
+	r.Version = 0
+
+	if err := r.Policy.Create(ctx, m); err != nil {
+		return fmt.Errorf("unable to create exception request '%s': %s", r.Policy.Name, err.Error())
+	}
+
+	if err := w.put(ctx, m, r); err != nil {
+		return fmt.Errorf("unable to create exception request '%s': %s", r.Policy.Name, err.Error())
+	}
+
+	logging.FromContext(ctx).Info(
+		"exception request created",
+		logging.String("request", r.ID),
+		logging.String("name", r.Policy.Name),
+		logging.String("by", user),
+	)
+
+	return nil
+}
+
+// Reject rejects a pending request. Only the owners of its tenants can reject it.
+func (w *Workflow) Reject(ctx context.Context, id, user, comment string) (*Request, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	r, err := w.pending(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reject exception request '%s': %s", id, err.Error())
+	}
+
+	tenant := ""
+	for _, t := range r.Tenants() {
+		if w.owns(user, t) {
+			tenant = t
+			break
+		}
+	}
+	if tenant == "" {
+		return nil, fmt.Errorf("unable to reject exception request '%s': '%s' does not own its tenants", id, user)
+	}
+
+	w.close(r, StatusRejected, &Decision{By: user, Tenant: tenant, Comment: comment})
+
+	if err := w.Store.Put(ctx, r); err != nil {
+		return nil, fmt.Errorf("unable to reject exception request '%s': %s", id, err.Error())
+	}
+
+	logging.FromContext(ctx).Info("exception request rejected", logging.String("request", r.ID), logging.String("by", user))
+
+	return r, nil
+}
+
+// Revoke withdraws a pending or approved request, deleting its policy with m if it was
+// created. The requester and the owners of its tenants can revoke it.
+func (w *Workflow) Revoke(ctx context.Context, m manipulate.Manipulator, id, user, comment string) (*Request, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	r, err := w.Store.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to revoke exception request '%s': %s", id, err.Error())
+	}
+
+	tenant := ""
+	for _, t := range r.Tenants() {
+		if w.owns(user, t) {
+			tenant = t
+			break
+		}
+	}
+	if tenant == "" && user != r.Requester {
+		return nil, fmt.Errorf("unable to revoke exception request '%s': '%s' is neither its requester nor an owner of its tenants", id, user)
+	}
+
+	if err := w.end(ctx, m, r, StatusRevoked, &Decision{By: user, Tenant: tenant, Comment: comment}); err != nil {
+		return nil, fmt.Errorf("unable to revoke exception request '%s': %s", id, err.Error())
+	}
+
+	logging.FromContext(ctx).Info("exception request revoked", logging.String("request", r.ID), logging.String("by", user))
+
+	return r, nil
+}
+
+// RevokePolicy revokes the approved request of the policy named name in namespace like
+// Revoke. It returns ErrNotFound if the policy has no approved request.
+func (w *Workflow) RevokePolicy(ctx context.Context, m manipulate.Manipulator, namespace, name, user, comment string) (*Request, error) {
+
+	requests, err := w.List(ctx, StatusApproved)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range requests {
+		if r.Policy.Namespace == namespace && r.Policy.Name == name {
+			return w.Revoke(ctx, m, r.ID, user, comment)
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// Expire ends the pending and approved requests past their expiry, deleting the
+// policies created with m. It returns the expired requests.
+func (w *Workflow) Expire(ctx context.Context, m manipulate.Manipulator) ([]*Request, error) {
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	requests, err := w.Store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to expire exception requests: %s", err.Error())
+	}
+
+	now := w.clock()
+	var expired []*Request
+	var errs []error
+	for _, r := range requests {
+
+		if r.Status != StatusPending && r.Status != StatusApproved {
+			continue
+		}
+		if r.Expiry.IsZero() || r.Expiry.After(now) {
+			continue
+		}
+
+		if err := w.end(ctx, m, r, StatusExpired, &Decision{By: "expiry"}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", r.ID, err.Error()))
+			continue
+		}
+
+		logging.FromContext(ctx).Info("exception request expired", logging.String("request", r.ID), logging.String("name", r.Policy.Name))
+		expired = append(expired, r)
+	}
+
+	if len(errs) != 0 {
+		return expired, fmt.Errorf("unable to expire some exception requests: %v", errs)
+	}
+
+	return expired, nil
+}
+
+// List returns the requests with one of the statuses, or all the requests without
+// statuses.
+func (w *Workflow) List(ctx context.Context, statuses ...string) ([]*Request, error) {
+
+	requests, err := w.Store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list exception requests: %s", err.Error())
+	}
+
+	if len(statuses) == 0 {
+		return requests, nil
+	}
+
+	var out []*Request
+	for _, r := range requests {
+		for _, s := range statuses {
+			if r.Status == s {
+				out = append(out, r)
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// check checks the tenants, the expiry and the compliance of a request.
+func (w *Workflow) check(r *Request, now time.Time) error {
+
+	if r.Policy.SubjectTenantNamespace == "" || r.Policy.ObjectTenantNamespace == "" {
+		return errors.New("subject and object tenants are required")
+	}
+
+	if !r.Expiry.IsZero() && !r.Expiry.After(now) {
+		return errors.New("expiry is in the past")
+	}
+
+	r.Policy.Rules = w.Rules
+	return compliance.Denied(r.Policy.Validate())
+}
+
+// put stores a request. If the request is approved, its policy was just created with m:
+// it is deleted if the request can not be stored, since the request is not approved in
+// the store.
+func (w *Workflow) put(ctx context.Context, m manipulate.Manipulator, r *Request) error {
+
+	err := w.Store.Put(ctx, r)
+	if err == nil || r.Status != StatusApproved {
+		return err
+	}
+
+	if derr := r.Policy.Delete(ctx, m); derr != nil {
+		logging.FromContext(ctx).Error(
+			"exception policy created but request not stored",
+			logging.String("request", r.ID),
+			logging.String("name", r.Policy.Name),
+			logging.Err(err),
+			logging.String("delete-error", derr.Error()),
+		)
+		return fmt.Errorf("%s, and unable to delete its policy: %s", err.Error(), derr.Error())
+	}
+
+	return err
+}
+
+// end ends a pending or approved request, deleting its policy if it was approved.
+func (w *Workflow) end(ctx context.Context, m manipulate.Manipulator, r *Request, status string, d *Decision) error {
+
+	switch r.Status {
+	case StatusPending:
+	case StatusApproved:
+		if err := r.Policy.Delete(ctx, m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("request is %s", r.Status)
+	}
+
+	w.close(r, status, d)
+
+	return w.Store.Put(ctx, r)
+}
+
+// close sets the final status of a request.
+func (w *Workflow) close(r *Request, status string, d *Decision) {
+
+	d.Time = w.clock()
+	r.Status = status
+	r.Closure = d
+	r.UpdateTime = d.Time
+}
+
+// pending returns a pending request.
+func (w *Workflow) pending(ctx context.Context, id string) (*Request, error) {
+
+	r, err := w.Store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Status != StatusPending {
+		return nil, fmt.Errorf("request is %s", r.Status)
+	}
+
+	if !r.Expiry.IsZero() && !r.Expiry.After(w.clock()) {
+		return nil, fmt.Errorf("request expired on %s", r.Expiry.Format(time.RFC3339))
+	}
+
+	return r, nil
+}
+
+// owns tells if a user owns a tenant.
+func (w *Workflow) owns(user, tenant string) bool {
+
+	for _, o := range w.Owners[tenant] {
+		if o == user {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Workflow) clock() time.Time {
+
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
+
+// newID returns a random request ID.
+func newID() string {
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sortRequests sorts requests oldest first.
+func sortRequests(requests []*Request) {
+
+	sort.SliceStable(requests, func(i, j int) bool {
+		if requests[i].CreateTime.Equal(requests[j].CreateTime) {
+			return requests[i].ID < requests[j].ID
+		}
+		return requests[i].CreateTime.Before(requests[j].CreateTime)
+	})
+}
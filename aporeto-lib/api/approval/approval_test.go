@@ -0,0 +1,419 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+)
+
+var now = time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+
+func newWorkflow(t *testing.T) *Workflow {
+
+	return &Workflow{
+		Store: NewFileStore(filepath.Join(t.TempDir(), "requests.json")),
+		Owners: map[string][]string{
+			"/acct/dmz/t1":       {"alice"},
+			"/acct/sensitive/t2": {"bob", "carol"},
+		},
+		Rules: compliance.DefaultRules(),
+		now:   func() time.Time { return now },
+	}
+}
+
+func newRequest() *Request {
+
+	return &Request{
+		Policy: networkpolicy.NetworkPolicy{
+			Namespace:              "/acct",
+			Name:                   "t1 to t2",
+			SubjectTenantNamespace: "/acct/dmz/t1",
+			SubjectTags:            []string{"$namespace=/acct/dmz/t1/private"},
+			ObjectTenantNamespace:  "/acct/sensitive/t2",
+			ObjectTags:             []string{"$namespace=/acct/sensitive/t2/private"},
+			Encrypt:                true,
+		},
+		Requester:     "dave",
+		Justification: "database replication",
+		Expiry:        now.Add(30 * 24 * time.Hour),
+	}
+}
+
+func TestWorkflow_Submit(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(r *Request)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			change: func(r *Request) {},
+		},
+		{
+			name:    "no requester",
+			change:  func(r *Request) { r.Requester = "" },
+			wantErr: "no requester",
+		},
+		{
+			name:    "no justification",
+			change:  func(r *Request) { r.Justification = "" },
+			wantErr: "no justification",
+		},
+		{
+			name:    "no object tenant",
+			change:  func(r *Request) { r.Policy.ObjectTenantNamespace = "" },
+			wantErr: "subject and object tenants are required",
+		},
+		{
+			name:    "expired",
+			change:  func(r *Request) { r.Expiry = now.Add(-time.Hour) },
+			wantErr: "expiry is in the past",
+		},
+		{
+			name:    "tenant without owner",
+			change:  func(r *Request) { r.Policy.ObjectTenantNamespace = "/acct/dmz/t3" },
+			wantErr: "tenant '/acct/dmz/t3' has no owner",
+		},
+		{
+			name:    "non compliant",
+			change:  func(r *Request) { r.Policy.Encrypt = false },
+			wantErr: "compliance violations",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			w := newWorkflow(t)
+			r := newRequest()
+			tt.change(r)
+
+			err := w.Submit(context.Background(), r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Submit() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Submit() error = %v", err)
+			}
+
+			got, err := w.Store.Get(context.Background(), r.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != StatusPending || got.Version != 1 || !got.CreateTime.Equal(now) {
+				t.Errorf("Submit() stored %+v", got)
+			}
+		})
+	}
+}
+
+func TestWorkflow_Approve(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	w := newWorkflow(t)
+	r := newRequest()
+	if err := w.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Approve(ctx, m, r.ID, "dave", ""); err == nil {
+		t.Errorf("Approve() by a user owning no tenant should fail")
+	}
+
+	// The requester may not approve its own request, even as an owner.
+	own := newRequest()
+	own.Requester = "alice"
+	if err := w.Submit(ctx, own); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Approve(ctx, m, own.ID, "alice", ""); err == nil || !strings.Contains(err.Error(), "is its requester") {
+		t.Errorf("Approve() by the requester error = %v", err)
+	}
+
+	got, err := w.Approve(ctx, m, r.ID, "alice", "ok for t1")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if got.Status != StatusPending || !reflect.DeepEqual(got.Pending(), []string{"/acct/sensitive/t2"}) {
+		t.Errorf("Approve() by the subject owner = %s, pending %v", got.Status, got.Pending())
+	}
+	if len(m.Objects("networkaccesspolicy")) != 0 {
+		t.Errorf("Approve() created the policy before all the owners approved")
+	}
+
+	if _, err := w.Approve(ctx, m, r.ID, "alice", ""); err == nil {
+		t.Errorf("Approve() twice by the same owner should fail")
+	}
+
+	got, err = w.Approve(ctx, m, r.ID, "carol", "")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if got.Status != StatusApproved || len(got.Approvals) != 2 || got.Approvals[1].Tenant != "/acct/sensitive/t2" {
+		t.Errorf("Approve() by the object owner = %+v", got)
+	}
+	if len(m.Objects("networkaccesspolicy")) != 1 {
+		t.Errorf("Approve() created %d policies, want 1", len(m.Objects("networkaccesspolicy")))
+	}
+
+	if _, err := w.Approve(ctx, m, r.ID, "bob", ""); err == nil {
+		t.Errorf("Approve() of an approved request should fail")
+	}
+}
+
+// failingStore fails to put the requests once fail is set.
+type failingStore struct {
+	Store
+	fail bool
+}
+
+func (s *failingStore) Put(ctx context.Context, r *Request) error {
+
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.Store.Put(ctx, r)
+}
+
+func TestWorkflow_ApproveStoreFailure(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	w := newWorkflow(t)
+	store := &failingStore{Store: w.Store}
+	w.Store = store
+
+	r := newRequest()
+	if err := w.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Approve(ctx, m, r.ID, "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	store.fail = true
+	if _, err := w.Approve(ctx, m, r.ID, "bob", ""); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("Approve() error = %v, want disk full", err)
+	}
+	if n := len(m.Objects("networkaccesspolicy")); n != 0 {
+		t.Errorf("Approve() left %d policies of a request still pending", n)
+	}
+
+	// The request is still pending: the approval can be retried.
+	store.fail = false
+	got, err := w.Approve(ctx, m, r.ID, "bob", "")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if got.Status != StatusApproved || len(m.Objects("networkaccesspolicy")) != 1 {
+		t.Errorf("Approve() = %s, %d policies", got.Status, len(m.Objects("networkaccesspolicy")))
+	}
+}
+
+func TestWorkflow_Reject(t *testing.T) {
+
+	ctx := context.Background()
+	w := newWorkflow(t)
+	r := newRequest()
+	if err := w.Submit(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Reject(ctx, r.ID, "dave", ""); err == nil {
+		t.Errorf("Reject() by the requester should fail")
+	}
+
+	got, err := w.Reject(ctx, r.ID, "bob", "not needed")
+	if err != nil {
+		t.Fatalf("Reject() error = %v", err)
+	}
+	want := &Decision{By: "bob", Tenant: "/acct/sensitive/t2", Comment: "not needed", Time: now}
+	if got.Status != StatusRejected || !reflect.DeepEqual(got.Closure, want) {
+		t.Errorf("Reject() = %s %+v", got.Status, got.Closure)
+	}
+
+	if _, err := w.Approve(ctx, memmanip.New(), r.ID, "alice", ""); err == nil {
+		t.Errorf("Approve() of a rejected request should fail")
+	}
+}
+
+func TestWorkflow_RevokeAndExpire(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	w := newWorkflow(t)
+
+	// The policies are in distinct namespaces as the manipulator ignores the filters.
+	approve := func(namespace string, expiry time.Time) *Request {
+		r := newRequest()
+		r.Policy.Namespace = namespace
+		r.Expiry = expiry
+		if err := w.Submit(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		for _, user := range []string{"alice", "bob"} {
+			if _, err := w.Approve(ctx, m, r.ID, user, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+
+	revoked := approve("/acct/dmz", now.Add(time.Hour))
+	short := approve("/acct/sensitive", now.Add(2*time.Hour))
+	long := approve("/acct", now.Add(48*time.Hour))
+	if len(m.Objects("networkaccesspolicy")) != 3 {
+		t.Fatalf("%d policies created, want 3", len(m.Objects("networkaccesspolicy")))
+	}
+
+	if _, err := w.Revoke(ctx, m, revoked.ID, "eve", ""); err == nil {
+		t.Errorf("Revoke() by a stranger should fail")
+	}
+	got, err := w.Revoke(ctx, m, revoked.ID, "dave", "done")
+	if err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if got.Status != StatusRevoked || got.Closure.By != "dave" || len(m.Objects("networkaccesspolicy")) != 2 {
+		t.Errorf("Revoke() = %s %+v, %d policies left", got.Status, got.Closure, len(m.Objects("networkaccesspolicy")))
+	}
+
+	w.now = func() time.Time { return now.Add(3 * time.Hour) }
+	expired, err := w.Expire(ctx, m)
+	if err != nil {
+		t.Fatalf("Expire() error = %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != short.ID || expired[0].Status != StatusExpired {
+		t.Errorf("Expire() = %+v", expired)
+	}
+	if len(m.Objects("networkaccesspolicy")) != 1 {
+		t.Errorf("Expire() left %d policies, want 1", len(m.Objects("networkaccesspolicy")))
+	}
+
+	approved, err := w.List(ctx, StatusApproved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approved) != 1 || approved[0].ID != long.ID {
+		t.Errorf("List(approved) = %+v", approved)
+	}
+}
+
+func TestWorkflow_Create(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	w := newWorkflow(t)
+
+	r := newRequest()
+	r.Policy.Encrypt = false
+	if err := w.Create(ctx, m, r, "admin", ""); err == nil || !strings.Contains(err.Error(), "compliance violations") {
+		t.Errorf("Create() error = %v, want compliance violations", err)
+	}
+	if err := w.Create(ctx, m, newRequest(), "", ""); err == nil {
+		t.Errorf("Create() without user should fail")
+	}
+	if n := len(m.Objects("networkaccesspolicy")); n != 0 {
+		t.Fatalf("Create() of invalid requests created %d policies", n)
+	}
+
+	// The tenants need no owner.
+	w.Owners = nil
+	r = newRequest()
+	r.Justification = ""
+	if err := w.Create(ctx, m, r, "admin", "CHG0042"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if r.Status != StatusApproved || r.Requester != "admin" || len(r.Pending()) != 0 {
+		t.Errorf("Create() = %s by %s, pending %v", r.Status, r.Requester, r.Pending())
+	}
+	if n := len(m.Objects("networkaccesspolicy")); n != 1 {
+		t.Errorf("Create() created %d policies, want 1", n)
+	}
+
+	got, err := w.Store.Get(ctx, r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Decision{By: "admin", Tenant: "/acct/sensitive/t2", Comment: "CHG0042", Time: now}
+	if got.Status != StatusApproved || len(got.Approvals) != 2 || !reflect.DeepEqual(got.Approvals[1], want) {
+		t.Errorf("stored request = %s %+v", got.Status, got.Approvals)
+	}
+}
+
+func TestWorkflow_RevokePolicy(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	w := newWorkflow(t)
+
+	r := newRequest()
+	if err := w.Create(ctx, m, r, "admin", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.RevokePolicy(ctx, m, "/acct", "other", "admin", ""); err != ErrNotFound {
+		t.Errorf("RevokePolicy() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := w.RevokePolicy(ctx, m, "/acct/dmz", r.Policy.Name, "admin", ""); err != ErrNotFound {
+		t.Errorf("RevokePolicy() in another namespace error = %v, want %v", err, ErrNotFound)
+	}
+
+	got, err := w.RevokePolicy(ctx, m, "/acct", r.Policy.Name, "admin", "done")
+	if err != nil {
+		t.Fatalf("RevokePolicy() error = %v", err)
+	}
+	if got.ID != r.ID || got.Status != StatusRevoked || len(m.Objects("networkaccesspolicy")) != 0 {
+		t.Errorf("RevokePolicy() = %s %s, %d policies left", got.ID, got.Status, len(m.Objects("networkaccesspolicy")))
+	}
+
+	if _, err := w.RevokePolicy(ctx, m, "/acct", r.Policy.Name, "admin", ""); err != ErrNotFound {
+		t.Errorf("RevokePolicy() of a revoked request error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+
+	ctx := context.Background()
+	s := NewFileStore(filepath.Join(t.TempDir(), "requests.json"))
+
+	if _, err := s.Get(ctx, "nope"); err != ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+
+	r := newRequest()
+	r.ID = "r1"
+	if err := s.Put(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two writers read the same version: the second one conflicts.
+	a, _ := s.Get(ctx, "r1") // nolint
+	b, _ := s.Get(ctx, "r1") // nolint
+	a.Status = StatusRejected
+	if err := s.Put(ctx, a); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	b.Status = StatusApproved
+	if err := s.Put(ctx, b); err != ErrConflict {
+		t.Errorf("Put() error = %v, want %v", err, ErrConflict)
+	}
+
+	got, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != StatusRejected || got[0].Version != 2 {
+		t.Errorf("List() = %+v", got)
+	}
+}
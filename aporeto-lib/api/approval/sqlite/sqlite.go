@@ -0,0 +1,161 @@
+// Package sqlite stores the exception requests of the approval package in a SQLite
+// database, for several processes sharing the requests on one host.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	_ "github.com/mattn/go-sqlite3" // SQLite driver.
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS exception_requests (
+	id          TEXT PRIMARY KEY,
+	status      TEXT NOT NULL,
+	create_time TEXT NOT NULL,
+	version     INTEGER NOT NULL,
+	data        TEXT NOT NULL
+)`
+
+// timeFormat formats the creation times so that they sort like the times.
+const timeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Store is an approval.Store in a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it if needed.
+func Open(path string) (*Store, error) {
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open exception requests database '%s': %s", path, err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close() // nolint
+		return nil, fmt.Errorf("unable to create exception requests table in '%s': %s", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put implements approval.Store.
+func (s *Store) Put(ctx context.Context, r *approval.Request) error {
+
+	version := r.Version
+	r.Version++
+	data, err := json.Marshal(r)
+	if err != nil {
+		r.Version = version
+		return fmt.Errorf("unable to encode exception request '%s': %s", r.ID, err)
+	}
+
+	if err := s.put(ctx, r, version, data); err != nil {
+		r.Version = version
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) put(ctx context.Context, r *approval.Request, version int, data []byte) error {
+
+	if version == 0 {
+		_, err := s.db.ExecContext(
+			ctx,
+			`INSERT INTO exception_requests (id, status, create_time, version, data) VALUES (?, ?, ?, ?, ?)`,
+			r.ID, r.Status, r.CreateTime.UTC().Format(timeFormat), r.Version, string(data),
+		)
+		if err != nil {
+			return fmt.Errorf("unable to insert exception request '%s': %s", r.ID, err)
+		}
+		return nil
+	}
+
+	res, err := s.db.ExecContext(
+		ctx,
+		`UPDATE exception_requests SET status = ?, version = ?, data = ? WHERE id = ? AND version = ?`,
+		r.Status, r.Version, string(data), r.ID, version,
+	)
+	if err != nil {
+		return fmt.Errorf("unable to update exception request '%s': %s", r.ID, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unable to update exception request '%s': %s", r.ID, err)
+	}
+	if n == 1 {
+		return nil
+	}
+
+	if _, err := s.Get(ctx, r.ID); err != nil {
+		return err
+	}
+	return approval.ErrConflict
+}
+
+// Get implements approval.Store.
+func (s *Store) Get(ctx context.Context, id string) (*approval.Request, error) {
+
+	var data string
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM exception_requests WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, approval.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read exception request '%s': %s", id, err)
+	}
+
+	return decode(data)
+}
+
+// List implements approval.Store.
+func (s *Store) List(ctx context.Context) ([]*approval.Request, error) {
+
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM exception_requests ORDER BY create_time, id`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list exception requests: %s", err)
+	}
+	defer rows.Close() // nolint
+
+	requests := []*approval.Request{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("unable to list exception requests: %s", err)
+		}
+		r, err := decode(data)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to list exception requests: %s", err)
+	}
+
+	return requests, nil
+}
+
+func decode(data string) (*approval.Request, error) {
+
+	r := &approval.Request{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, fmt.Errorf("unable to parse exception request: %s", err)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/networkpolicy"
+)
+
+func TestStore(t *testing.T) {
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "requests.db")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Get(ctx, "nope"); err != approval.ErrNotFound {
+		t.Errorf("Get() error = %v, want %v", err, approval.ErrNotFound)
+	}
+
+	created := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, id := range []string{"r2", "r1"} {
+		r := &approval.Request{
+			ID:         id,
+			Policy:     networkpolicy.NetworkPolicy{Name: id},
+			Status:     approval.StatusPending,
+			CreateTime: created.Add(time.Duration(i) * time.Second),
+		}
+		if err := s.Put(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Version != 1 {
+			t.Errorf("Put() version = %d, want 1", r.Version)
+		}
+	}
+
+	// Two writers read the same version: the second one conflicts.
+	a, _ := s.Get(ctx, "r1") // nolint
+	b, _ := s.Get(ctx, "r1") // nolint
+	a.Status = approval.StatusRejected
+	if err := s.Put(ctx, a); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	b.Status = approval.StatusApproved
+	if err := s.Put(ctx, b); err != approval.ErrConflict {
+		t.Errorf("Put() error = %v, want %v", err, approval.ErrConflict)
+	}
+	if b.Version != 1 {
+		t.Errorf("Put() changed the version of a conflicting request to %d", b.Version)
+	}
+
+	missing := &approval.Request{ID: "r3", Version: 4}
+	if err := s.Put(ctx, missing); err != approval.ErrNotFound {
+		t.Errorf("Put() error = %v, want %v", err, approval.ErrNotFound)
+	}
+
+	// The requests are kept when the database is reopened.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint
+
+	got, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "r2" || got[1].ID != "r1" {
+		t.Fatalf("List() = %+v", got)
+	}
+	if got[1].Status != approval.StatusRejected || got[1].Version != 2 {
+		t.Errorf("List() r1 = %+v", got[1])
+	}
+}
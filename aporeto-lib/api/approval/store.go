@@ -0,0 +1,148 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore stores the requests in a json file. Each change rewrites the file, so it is
+// meant for a single writer, such as ac or a server, and a few thousand requests.
+type FileStore struct {
+	path string
+	lock sync.Mutex
+}
+
+// NewFileStore returns a store using the file at path, created on the first change.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Put implements Store.
+func (s *FileStore) Put(ctx context.Context, r *Request) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, stored := range requests {
+		if stored.ID != r.ID {
+			continue
+		}
+		if stored.Version != r.Version {
+			return ErrConflict
+		}
+		r.Version++
+		requests[i] = r
+		found = true
+		break
+	}
+
+	if !found {
+		if r.Version != 0 {
+			return ErrNotFound
+		}
+		r.Version++
+		requests = append(requests, r)
+	}
+
+	if err := s.write(requests); err != nil {
+		r.Version--
+		return err
+	}
+
+	return nil
+}
+
+// Get implements Store.
+func (s *FileStore) Get(ctx context.Context, id string) (*Request, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range requests {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// List implements Store.
+func (s *FileStore) List(ctx context.Context) ([]*Request, error) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	sortRequests(requests)
+
+	return requests, nil
+}
+
+// read reads the requests. A missing file has no request.
+func (s *FileStore) read() ([]*Request, error) {
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []*Request{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read exception requests: %s", err)
+	}
+
+	requests := []*Request{}
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("unable to parse exception requests '%s': %s", s.path, err)
+	}
+
+	return requests, nil
+}
+
+// write replaces the file with the requests.
+func (s *FileStore) write(requests []*Request) error {
+
+	data, err := json.MarshalIndent(requests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode exception requests: %s", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write exception requests: %s", err)
+	}
+	defer os.Remove(tmp.Name()) // nolint
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close() // nolint
+		return fmt.Errorf("unable to write exception requests: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write exception requests: %s", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to write exception requests: %s", err)
+	}
+
+	return nil
+}
@@ -3,11 +3,11 @@ package auth
 import (
 	"context"
 	"io/ioutil"
-	"log"
 	"time"
 
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/authpolicy"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
 	"go.aporeto.io/gaia"
 	"go.aporeto.io/manipulate"
 	midgardclient "go.aporeto.io/midgard-lib/client"
@@ -67,7 +67,13 @@ func (p *Policy) Create(ctx context.Context, m manipulate.Manipulator) error {
 	// Create Read Only Authorization policies for tenants to access their namespace.
 	err := authpolicy.Create(ctx, m, tenantNamespace, p.Name, p.AuthPolicyDescription, p.AuthPolicyClaims)
 	if err != nil {
-		log.Printf("unable to create authorization policy for tenant '%s' and children namespaces: %s\n", p.Tenant, err.Error())
+		logging.FromContext(ctx).Error(
+			"unable to create authorization policy",
+			logging.Account(p.Account),
+			logging.Zone(p.Zone),
+			logging.Tenant(p.Tenant),
+			logging.Err(err),
+		)
 		return err
 	}
 
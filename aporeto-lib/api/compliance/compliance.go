@@ -0,0 +1,305 @@
+package compliance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+)
+
+// Rule kinds.
+const (
+	// KindRailToRail forbids policies from the rail FromRail to the rail ToRail. With
+	// CrossTenant, only policies between different tenants are forbidden.
+	KindRailToRail = "rail-to-rail"
+
+	// KindZoneSpan forbids policies spanning more than one zone without encryption.
+	KindZoneSpan = "zone-span-requires-encryption"
+
+	// KindWildcardDepth forbids namespace wildcards on namespaces less than MinDepth deep:
+	// '/acct/*' is 1 deep and '/acct/zone/tenant/*' is 3 deep.
+	KindWildcardDepth = "wildcard-depth"
+)
+
+// Severities.
+const (
+	SeverityDeny = "deny"
+	SeverityWarn = "warn"
+)
+
+// depthTenant is the depth of tenant namespaces: /account/zone/tenant.
+const depthTenant = 3
+
+// Rule is a compliance rule for exception policies.
+type Rule struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Severity    string `json:"severity"`
+
+	FromRail    string `json:"from-rail"`
+	ToRail      string `json:"to-rail"`
+	CrossTenant bool   `json:"cross-tenant"`
+	MinDepth    int    `json:"min-depth"`
+}
+
+// RuleSet is a set of compliance rules.
+type RuleSet struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// DefaultRules returns the recommended rules. They are only enforced when selected, no
+// rule being checked otherwise.
+func DefaultRules() *RuleSet {
+
+	return &RuleSet{
+		Rules: []*Rule{
+			{
+				Name:        "public-to-foreign-private",
+				Description: "public rail may never reach another tenant's private rail",
+				Kind:        KindRailToRail,
+				Severity:    SeverityDeny,
+				FromRail:    constants.NamespacePublic,
+				ToRail:      constants.NamespacePrivate,
+				CrossTenant: true,
+			},
+			{
+				Name:        "zone-span-encryption",
+				Description: "no exception spans more than one zone without encryption",
+				Kind:        KindZoneSpan,
+				Severity:    SeverityDeny,
+			},
+			{
+				Name:        "wildcard-above-tenant",
+				Description: "no wildcard above tenant level",
+				Kind:        KindWildcardDepth,
+				Severity:    SeverityDeny,
+				MinDepth:    depthTenant,
+			},
+		},
+	}
+}
+
+// RulesDefault is the path selecting the default rules in LoadRules.
+const RulesDefault = "default"
+
+// LoadRules reads a json rule file, or returns the default rules if path is RulesDefault.
+func LoadRules(path string) (*RuleSet, error) {
+
+	if path == RulesDefault {
+		return DefaultRules(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint
+
+	return ParseRules(f)
+}
+
+// ParseRules reads and validates json rules.
+func ParseRules(r io.Reader) (*RuleSet, error) {
+
+	rs := &RuleSet{}
+	if err := json.NewDecoder(r).Decode(rs); err != nil {
+		return nil, fmt.Errorf("unable to read compliance rules: %s", err.Error())
+	}
+
+	for i, rule := range rs.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("compliance rule %d: missing name", i+1)
+		}
+		switch rule.Severity {
+		case SeverityDeny, SeverityWarn:
+		default:
+			return nil, fmt.Errorf("compliance rule '%s': invalid severity '%s'", rule.Name, rule.Severity)
+		}
+		switch rule.Kind {
+		case KindRailToRail:
+			if rule.FromRail == "" || rule.ToRail == "" {
+				return nil, fmt.Errorf("compliance rule '%s': from-rail and to-rail are required", rule.Name)
+			}
+		case KindZoneSpan:
+		case KindWildcardDepth:
+			if rule.MinDepth == 0 {
+				rule.MinDepth = depthTenant
+			}
+		default:
+			return nil, fmt.Errorf("compliance rule '%s': invalid kind '%s'", rule.Name, rule.Kind)
+		}
+	}
+
+	return rs, nil
+}
+
+// Policy is the part of an exception policy checked by the rules. The tenant namespaces
+// locate the clauses that have no '$namespace=' tag.
+type Policy struct {
+	Name                   string
+	SubjectTenantNamespace string
+	Subject                [][]string
+	ObjectTenantNamespace  string
+	Object                 [][]string
+	Encrypt                bool
+}
+
+// Violation is a rule violated by a policy.
+type Violation struct {
+	Rule     string
+	Severity string
+	Message  string
+}
+
+func (v *Violation) String() string {
+	return fmt.Sprintf("[%s] rule '%s': %s", v.Severity, v.Rule, v.Message)
+}
+
+// Evaluate returns the violations of the rules by the policy.
+func (rs *RuleSet) Evaluate(p *Policy) []*Violation {
+
+	subjects := locateClauses(p.Subject, p.SubjectTenantNamespace)
+	objects := locateClauses(p.Object, p.ObjectTenantNamespace)
+
+	violations := []*Violation{}
+	for _, rule := range rs.Rules {
+		for _, msg := range rule.check(p, subjects, objects) {
+			violations = append(violations, &Violation{
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Message:  fmt.Sprintf("policy '%s': %s", p.Name, msg),
+			})
+		}
+	}
+
+	return violations
+}
+
+// Denied returns an error listing the violations with a deny severity, if any.
+func Denied(violations []*Violation) error {
+
+	denied := []string{}
+	for _, v := range violations {
+		if v.Severity == SeverityDeny {
+			denied = append(denied, v.String())
+		}
+	}
+	if len(denied) == 0 {
+		return nil
+	}
+	return fmt.Errorf("compliance violations: %s", strings.Join(denied, "; "))
+}
+
+// location is where a clause applies. rail is "*" when the clause covers all the rails of
+// the tenant and "" when it is unknown.
+type location struct {
+	namespace string
+	wildcard  bool
+	tenant    string
+	zone      string
+	rail      string
+}
+
+// locateClauses returns the locations of the clauses.
+func locateClauses(clauses [][]string, tenantNamespace string) []*location {
+
+	locs := []*location{}
+	for _, clause := range clauses {
+		locs = append(locs, locateClause(clause, tenantNamespace))
+	}
+	return locs
+}
+
+func locateClause(clause []string, tenantNamespace string) *location {
+
+	l := &location{namespace: utils.SetupNamespaceString(tenantNamespace)}
+	for _, tag := range clause {
+		if strings.HasPrefix(tag, constants.NamespaceKey) {
+			ns := strings.TrimPrefix(tag, constants.NamespaceKey)
+			l.wildcard = strings.HasSuffix(ns, "/*")
+			l.namespace = utils.SetupNamespaceString(strings.TrimSuffix(ns, "/*"))
+		}
+	}
+
+	segments := strings.Split(strings.TrimPrefix(l.namespace, "/"), "/")
+	if len(segments) >= 2 && segments[0] != "" {
+		l.zone = "/" + strings.Join(segments[:2], "/")
+	}
+	if len(segments) >= depthTenant {
+		l.tenant = "/" + strings.Join(segments[:depthTenant], "/")
+	}
+	switch {
+	case len(segments) > depthTenant:
+		l.rail = segments[depthTenant]
+	case len(segments) == depthTenant && l.wildcard:
+		l.rail = "*"
+	}
+
+	return l
+}
+
+func (l *location) depth() int {
+	if l.namespace == "/" {
+		return 0
+	}
+	return strings.Count(l.namespace, "/")
+}
+
+// check returns the violation messages of the rule.
+func (r *Rule) check(p *Policy, subjects, objects []*location) []string {
+
+	msgs := []string{}
+
+	switch r.Kind {
+
+	case KindRailToRail:
+		for _, s := range subjects {
+			for _, o := range objects {
+				if !matchRail(s.rail, r.FromRail) || !matchRail(o.rail, r.ToRail) {
+					continue
+				}
+				if r.CrossTenant && s.tenant == o.tenant {
+					continue
+				}
+				msgs = append(msgs, fmt.Sprintf("%s rail of '%s' may not reach %s rail of '%s'", r.FromRail, s.namespace, r.ToRail, o.namespace))
+			}
+		}
+
+	case KindZoneSpan:
+		if p.Encrypt {
+			break
+		}
+		zones := map[string]bool{}
+		for _, l := range append(append([]*location{}, subjects...), objects...) {
+			zones[l.zone] = true
+		}
+		if len(zones) > 1 {
+			msgs = append(msgs, fmt.Sprintf("spans %d zones without encryption", len(zones)))
+		}
+
+	case KindWildcardDepth:
+		for _, l := range append(append([]*location{}, subjects...), objects...) {
+			if l.wildcard && l.depth() < r.MinDepth {
+				msgs = append(msgs, fmt.Sprintf("wildcard '%s/*' is above the allowed namespace depth %d", strings.TrimSuffix(l.namespace, "/"), r.MinDepth))
+			}
+		}
+	}
+
+	if len(msgs) > 0 && r.Description != "" {
+		for i := range msgs {
+			msgs[i] = msgs[i] + " (" + r.Description + ")"
+		}
+	}
+	return msgs
+}
+
+// matchRail returns true if the rail of a location is the rail of a rule. A location
+// covering all the rails of a tenant matches any rail.
+func matchRail(rail, ruleRail string) bool {
+	return rail == ruleRail || rail == "*" || ruleRail == "*"
+}
@@ -0,0 +1,259 @@
+package compliance
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateDefaultRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   []string
+	}{
+		{
+			name: "same tenant protected to private",
+			policy: &Policy{
+				Name:    "ok",
+				Subject: [][]string{{"$namespace=/acct/zone/t1/protected"}},
+				Object:  [][]string{{"$namespace=/acct/zone/t1/private"}},
+			},
+			want: []string{},
+		},
+		{
+			name: "public to another tenant private",
+			policy: &Policy{
+				Name:    "shortcut",
+				Subject: [][]string{{"$namespace=/acct/zone/t1/public/*"}},
+				Object:  [][]string{{"$namespace=/acct/zone/t2/private/*", "app=db"}},
+			},
+			want: []string{"public-to-foreign-private"},
+		},
+		{
+			name: "tenant wildcard covers the public and private rails",
+			policy: &Policy{
+				Name:    "tenants",
+				Subject: [][]string{{"$namespace=/acct/zone/t1/*"}},
+				Object:  [][]string{{"$namespace=/acct/zone/t2/*"}},
+			},
+			want: []string{"public-to-foreign-private"},
+		},
+		{
+			name: "account wildcards",
+			policy: &Policy{
+				Name:    "everything",
+				Subject: [][]string{{"$namespace=/acct/*"}},
+				Object:  [][]string{{"$namespace=/acct/*"}},
+			},
+			want: []string{"wildcard-above-tenant", "wildcard-above-tenant"},
+		},
+		{
+			name: "two zones without encryption",
+			policy: &Policy{
+				Name:    "cross-zone",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/private/*"}},
+				Object:  [][]string{{"$namespace=/acct/sensitive/t2/private/*"}},
+			},
+			want: []string{"zone-span-encryption"},
+		},
+		{
+			name: "two zones with encryption",
+			policy: &Policy{
+				Name:    "cross-zone",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/private/*"}},
+				Object:  [][]string{{"$namespace=/acct/sensitive/t2/private/*"}},
+				Encrypt: true,
+			},
+			want: []string{},
+		},
+		{
+			name: "clauses without namespace use the tenant namespaces",
+			policy: &Policy{
+				Name:                   "tags",
+				SubjectTenantNamespace: "/acct/dmz/t1",
+				Subject:                [][]string{{"app=web"}},
+				ObjectTenantNamespace:  "/acct/sensitive/t2",
+				Object:                 [][]string{{"app=db"}},
+			},
+			want: []string{"zone-span-encryption"},
+		},
+	}
+
+	rules := DefaultRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, v := range rules.Evaluate(tt.policy) {
+				got = append(got, v.Rule)
+				if !strings.Contains(v.Message, tt.policy.Name) {
+					t.Errorf("Evaluate() message %q should name the policy", v.Message)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+			if err := Denied(rules.Evaluate(tt.policy)); (err != nil) != (len(tt.want) > 0) {
+				t.Errorf("Denied() = %v", err)
+			}
+		})
+	}
+}
+
+func TestZoneSpan(t *testing.T) {
+
+	rules, err := ParseRules(strings.NewReader(`{"rules": [
+		{"name": "zone-span", "kind": "zone-span-requires-encryption", "severity": "deny"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		want   string
+	}{
+		{
+			name: "same zone",
+			policy: &Policy{
+				Name:    "same-zone",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/public/*"}},
+				Object:  [][]string{{"$namespace=/acct/dmz/t2/private/*"}},
+			},
+		},
+		{
+			name: "two zones",
+			policy: &Policy{
+				Name:    "cross-zone",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/public"}},
+				Object:  [][]string{{"$namespace=/acct/sensitive/t2/public"}},
+			},
+			want: "policy 'cross-zone': spans 2 zones without encryption",
+		},
+		{
+			name: "two zones encrypted",
+			policy: &Policy{
+				Name:    "cross-zone",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/public"}},
+				Object:  [][]string{{"$namespace=/acct/sensitive/t2/public"}},
+				Encrypt: true,
+			},
+		},
+		{
+			name: "zones of the subject clauses",
+			policy: &Policy{
+				Name:    "subjects",
+				Subject: [][]string{{"$namespace=/acct/dmz/t1/public"}, {"$namespace=/acct/sensitive/t2/public"}},
+				Object:  [][]string{{"$namespace=/acct/prod/t3/public"}},
+			},
+			want: "policy 'subjects': spans 3 zones without encryption",
+		},
+		{
+			name: "account and zone",
+			policy: &Policy{
+				Name:    "account",
+				Subject: [][]string{{"$namespace=/acct"}},
+				Object:  [][]string{{"$namespace=/acct/dmz/t1/public"}},
+			},
+			want: "policy 'account': spans 2 zones without encryption",
+		},
+		{
+			name: "tenant namespaces",
+			policy: &Policy{
+				Name:                   "tags",
+				SubjectTenantNamespace: "/acct/dmz/t1",
+				Subject:                [][]string{{"app=web"}},
+				ObjectTenantNamespace:  "/acct/dmz/t2",
+				Object:                 [][]string{{"app=db"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := rules.Evaluate(tt.policy)
+			got := ""
+			if len(violations) > 0 {
+				got = violations[0].Message
+			}
+			if len(violations) > 1 || got != tt.want {
+				t.Errorf("Evaluate() = %v, want %q", violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			rules: `{"rules": [
+				{"name": "no-public-to-private", "kind": "rail-to-rail", "severity": "warn", "from-rail": "public", "to-rail": "private"},
+				{"name": "no-zone-wildcard", "kind": "wildcard-depth", "severity": "deny", "min-depth": 2}
+			]}`,
+		},
+		{
+			name:    "invalid severity",
+			rules:   `{"rules": [{"name": "r", "kind": "zone-span-requires-encryption", "severity": "block"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid kind",
+			rules:   `{"rules": [{"name": "r", "kind": "unknown", "severity": "deny"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing rails",
+			rules:   `{"rules": [{"name": "r", "kind": "rail-to-rail", "severity": "deny", "from-rail": "public"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRules(strings.NewReader(tt.rules)); (err != nil) != tt.wantErr {
+				t.Errorf("ParseRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWarnSeverity(t *testing.T) {
+
+	rules, err := ParseRules(strings.NewReader(`{"rules": [
+		{"name": "no-public-to-private", "kind": "rail-to-rail", "severity": "warn", "from-rail": "public", "to-rail": "private"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	violations := rules.Evaluate(&Policy{
+		Name:    "shortcut",
+		Subject: [][]string{{"$namespace=/acct/zone/t1/public"}},
+		Object:  [][]string{{"$namespace=/acct/zone/t1/private"}},
+	})
+	if len(violations) != 1 || violations[0].Severity != SeverityWarn {
+		t.Fatalf("Evaluate() = %v, want one warning", violations)
+	}
+	if err := Denied(violations); err != nil {
+		t.Errorf("Denied() = %v, want nil", err)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+
+	rules, err := LoadRules(RulesDefault)
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	if !reflect.DeepEqual(rules, DefaultRules()) {
+		t.Errorf("LoadRules() = %v, want the default rules", rules)
+	}
+
+	if _, err := LoadRules("missing.json"); err == nil {
+		t.Errorf("LoadRules() of a missing file should fail")
+	}
+}
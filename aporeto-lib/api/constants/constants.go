@@ -34,6 +34,13 @@ const (
 	MetadataOwnerKeyVal  = "@cns-customer:owner=soc"
 	MetadataTenantKey    = "@cns-customer:tenant="
 	MetadataNamespaceKey = "@cns-customer:namespace="
+	MetadataSourceKey    = "@cns-customer:source="
+	MetadataChangeIDKey  = "@cns-customer:change-id="
+)
+
+// MetadataSources
+const (
+	MetadataSourceExtnetFeed = MetadataSourceKey + "extnet-feed"
 )
 
 // AssociatedTagKeys
@@ -54,6 +61,7 @@ const (
 	ExternalNetworkAllTCP     = "all-tcp"
 	ExternalNetworkAllUDP     = "all-udp"
 	ExternalNetworkAnyCIDR    = "0.0.0.0/0"
+	ExternalNetworkAnyCIDRv6  = "::/0"
 	ExternalNetworkAllPorts   = "1:65535"
 	ExternalNetworkProtcolTCP = "tcp"
 	ExternalNetworkProtcolUDP = "udp"
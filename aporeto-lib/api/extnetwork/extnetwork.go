@@ -7,6 +7,7 @@ import (
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/externalnetwork"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
 	"go.aporeto.io/elemental"
 	"go.aporeto.io/gaia"
 	"go.aporeto.io/manipulate"
@@ -24,12 +25,35 @@ type ExternalNetwork struct {
 	Protocols   []string `json:"protocols"`
 }
 
-// Create creates an external network
+// Create creates an external network. It fails if one of the CIDRs is malformed and
+// logs a warning for each external network of the namespace it overlaps with.
 func (e *ExternalNetwork) Create(ctx context.Context, m manipulate.Manipulator) error {
 
 	namespace := utils.SetupNamespaceString(e.Account, e.Zone, e.Tenant)
 
-	err := externalnetwork.Create(
+	if err := utils.ValidateCIDRs(e.CIDRs); err != nil {
+		return fmt.Errorf("unable to create external network '%s' in tenant '%s': %s", e.Name, namespace, err.Error())
+	}
+
+	ens, err := externalnetwork.List(ctx, m, namespace)
+	if err != nil {
+		return fmt.Errorf("unable to list external networks in tenant '%s': %s", namespace, err.Error())
+	}
+
+	overlaps, err := externalnetwork.Overlaps(ens, e.Name, e.CIDRs, e.Protocols)
+	if err != nil {
+		return fmt.Errorf("unable to create external network '%s' in tenant '%s': %s", e.Name, namespace, err.Error())
+	}
+	for _, en := range overlaps {
+		logging.FromContext(ctx).Warn(
+			"external network overlaps with another external network",
+			logging.Namespace(namespace),
+			logging.String("name", e.Name),
+			logging.String("overlaps", en.Name),
+		)
+	}
+
+	err = externalnetwork.Create(
 		ctx,
 		m,
 		namespace,
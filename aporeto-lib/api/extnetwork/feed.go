@@ -0,0 +1,398 @@
+package extnetwork
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// Feed formats.
+const (
+	FeedFormatCSV  = "csv"
+	FeedFormatJSON = "json"
+)
+
+// FeedEntry is the definition of an external network in a feed. When no ports or
+// protocols are given, the external network covers all tcp and udp ports.
+type FeedEntry struct {
+	Name      string   `json:"name"`
+	CIDRs     []string `json:"cidrs"`
+	Protocols []string `json:"protocols"`
+	Ports     []string `json:"ports"`
+	Tags      []string `json:"tags"`
+}
+
+// ReadFeedFile reads a feed file. The format is given by the extension of the file.
+func ReadFeedFile(path string) ([]*FeedEntry, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint
+
+	return ReadFeed(f, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+}
+
+// ReadFeed reads and validates a feed in the given format.
+//
+// A json feed is a list of entries. A csv feed has a header line naming its columns among
+// name, cidrs, protocols, ports and tags; name and cidrs are mandatory. Multiple values
+// in a cell are separated by spaces or ';'. Lines starting with '#' are ignored.
+func ReadFeed(r io.Reader, format string) ([]*FeedEntry, error) {
+
+	var entries []*FeedEntry
+	var err error
+
+	switch format {
+	case FeedFormatCSV:
+		entries, err = readCSVFeed(r)
+	case FeedFormatJSON:
+		err = json.NewDecoder(r).Decode(&entries)
+	default:
+		return nil, fmt.Errorf("unsupported feed format '%s'", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s feed: %s", format, err.Error())
+	}
+
+	if err := validateFeed(entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func readCSVFeed(r io.Reader) ([]*FeedEntry, error) {
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header: %s", err.Error())
+	}
+
+	columns := map[string]int{}
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, c := range []string{"name", "cidrs"} {
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("missing column '%s'", c)
+		}
+	}
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	cell := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	values := func(record []string, column string) []string {
+		return strings.FieldsFunc(cell(record, column), func(r rune) bool {
+			return r == ';' || unicode.IsSpace(r)
+		})
+	}
+
+	entries := make([]*FeedEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, &FeedEntry{
+			Name:      cell(record, "name"),
+			CIDRs:     values(record, "cidrs"),
+			Protocols: values(record, "protocols"),
+			Ports:     values(record, "ports"),
+			Tags:      values(record, "tags"),
+		})
+	}
+
+	return entries, nil
+}
+
+// validateFeed ensures all entries have a unique name and valid cidrs.
+func validateFeed(entries []*FeedEntry) error {
+
+	names := map[string]bool{}
+	for i, e := range entries {
+		if e.Name == "" {
+			return fmt.Errorf("feed entry %d: missing name", i+1)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("feed entry '%s': duplicate name", e.Name)
+		}
+		names[e.Name] = true
+
+		if len(e.CIDRs) == 0 {
+			return fmt.Errorf("feed entry '%s': missing cidrs", e.Name)
+		}
+		if err := utils.ValidateCIDRs(e.CIDRs); err != nil {
+			return fmt.Errorf("feed entry '%s': %s", e.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Sync reconciles the external networks of a tenant or zone namespace with the entries
+// of a feed. Only the external networks carrying the owner and feed metadata are updated
+// or deleted: other external networks are never modified.
+type Sync struct {
+	Account string
+	Zone    string
+	Tenant  string // Tenant is empty to synchronize the zone namespace.
+	Entries []*FeedEntry
+	DryRun  bool
+}
+
+// SyncResult holds the names of the external networks for each action of a sync.
+// Conflicts are feed entries named like an external network not managed by the sync.
+type SyncResult struct {
+	Created   []string
+	Updated   []string
+	Deleted   []string
+	Unchanged []string
+	Conflicts []string
+}
+
+// Run reconciles the namespace with the feed. With DryRun, the result lists what would
+// have been done. If an action fails, the result only lists the external networks created,
+// updated or deleted before the failure.
+func (s *Sync) Run(ctx context.Context, m manipulate.Manipulator) (*SyncResult, error) {
+
+	namespace := s.namespace()
+
+	if err := validateFeed(s.Entries); err != nil {
+		return nil, err
+	}
+
+	ens, err := externalnetwork.List(ctx, m, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list external networks in namespace '%s': %s", namespace, err.Error())
+	}
+
+	p := planSync(namespace, ens, s.Entries)
+	if s.DryRun {
+		return p.result, nil
+	}
+
+	res := &SyncResult{
+		Created:   []string{},
+		Updated:   []string{},
+		Deleted:   []string{},
+		Unchanged: p.result.Unchanged,
+		Conflicts: p.result.Conflicts,
+	}
+
+	for _, en := range p.creates {
+		if err := externalnetwork.Save(ctx, m, namespace, en); err != nil {
+			return res, fmt.Errorf("unable to create external network '%s' in namespace '%s': %s", en.Name, namespace, err.Error())
+		}
+		res.Created = append(res.Created, en.Name)
+	}
+	for _, en := range p.updates {
+		if err := externalnetwork.Save(ctx, m, namespace, en); err != nil {
+			return res, fmt.Errorf("unable to update external network '%s' in namespace '%s': %s", en.Name, namespace, err.Error())
+		}
+		res.Updated = append(res.Updated, en.Name)
+	}
+	for _, en := range p.deletes {
+		if err := externalnetwork.Delete(ctx, m, namespace, en.Name); err != nil {
+			return res, fmt.Errorf("unable to delete external network '%s' in namespace '%s': %s", en.Name, namespace, err.Error())
+		}
+		res.Deleted = append(res.Deleted, en.Name)
+	}
+
+	return res, nil
+}
+
+func (s *Sync) namespace() string {
+
+	if s.Tenant == "" {
+		return utils.SetupNamespaceString(s.Account, s.Zone)
+	}
+	return utils.SetupNamespaceString(s.Account, s.Zone, s.Tenant)
+}
+
+// syncPlan holds the external networks to create, update and delete.
+type syncPlan struct {
+	creates gaia.ExternalNetworksList
+	updates gaia.ExternalNetworksList
+	deletes gaia.ExternalNetworksList
+	result  *SyncResult
+}
+
+// planSync compares the existing external networks of the namespace with the feed.
+func planSync(namespace string, existing gaia.ExternalNetworksList, entries []*FeedEntry) *syncPlan {
+
+	p := &syncPlan{
+		result: &SyncResult{
+			Created:   []string{},
+			Updated:   []string{},
+			Deleted:   []string{},
+			Unchanged: []string{},
+			Conflicts: []string{},
+		},
+	}
+
+	byName := map[string]*gaia.ExternalNetwork{}
+	for _, en := range existing {
+		byName[en.Name] = en
+	}
+
+	wanted := map[string]bool{}
+	for _, e := range entries {
+
+		wanted[e.Name] = true
+		desired := buildFeedExternalNetwork(namespace, e)
+
+		current, ok := byName[e.Name]
+		switch {
+		case !ok:
+			p.creates = append(p.creates, desired)
+			p.result.Created = append(p.result.Created, e.Name)
+
+		case !isFeedManaged(current):
+			p.result.Conflicts = append(p.result.Conflicts, e.Name)
+
+		case sameFeedExternalNetwork(current, desired):
+			p.result.Unchanged = append(p.result.Unchanged, e.Name)
+
+		default:
+			current.Description = desired.Description
+			current.Entries = desired.Entries
+			current.Ports = desired.Ports
+			current.Protocols = desired.Protocols
+			current.AssociatedTags = desired.AssociatedTags
+			current.Metadata = append(desired.Metadata, changeIDMetadata(current.Metadata)...)
+			p.updates = append(p.updates, current)
+			p.result.Updated = append(p.result.Updated, e.Name)
+		}
+	}
+
+	for _, en := range existing {
+		if !wanted[en.Name] && isFeedManaged(en) {
+			p.deletes = append(p.deletes, en)
+			p.result.Deleted = append(p.result.Deleted, en.Name)
+		}
+	}
+
+	return p
+}
+
+// buildFeedExternalNetwork returns the external network of a feed entry.
+func buildFeedExternalNetwork(namespace string, e *FeedEntry) *gaia.ExternalNetwork {
+
+	ports := e.Ports
+	if len(ports) == 0 {
+		ports = []string{constants.ExternalNetworkAllPorts}
+	}
+
+	protocols := e.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{constants.ExternalNetworkProtcolTCP, constants.ExternalNetworkProtcolUDP}
+	}
+
+	en := externalnetwork.Build(
+		namespace,
+		e.Name,
+		fmt.Sprintf("external network %s synchronized from feed", e.Name),
+		e.CIDRs,
+		ports,
+		protocols,
+		e.Tags,
+	)
+	en.Metadata = append(en.Metadata, constants.MetadataSourceExtnetFeed)
+
+	return en
+}
+
+// isFeedManaged returns true if the external network was created by a sync.
+func isFeedManaged(en *gaia.ExternalNetwork) bool {
+
+	owner, feed := false, false
+	for _, md := range en.Metadata {
+		switch md {
+		case constants.MetadataOwnerKeyVal:
+			owner = true
+		case constants.MetadataSourceExtnetFeed:
+			feed = true
+		}
+	}
+	return owner && feed
+}
+
+// sameFeedExternalNetwork returns true if the synchronized fields of a and b are equal.
+// The change IDs recorded in the metadata by audited manipulators are not synchronized.
+func sameFeedExternalNetwork(a, b *gaia.ExternalNetwork) bool {
+
+	return a.Description == b.Description &&
+		sameStrings(a.Entries, b.Entries) &&
+		sameStrings(a.Ports, b.Ports) &&
+		sameStrings(a.Protocols, b.Protocols) &&
+		sameStrings(a.AssociatedTags, b.AssociatedTags) &&
+		sameStrings(withoutChangeIDMetadata(a.Metadata), withoutChangeIDMetadata(b.Metadata))
+}
+
+// changeIDMetadata returns the change ID entries of the metadata.
+func changeIDMetadata(metadata []string) []string {
+
+	r := []string{}
+	for _, md := range metadata {
+		if strings.HasPrefix(md, constants.MetadataChangeIDKey) {
+			r = append(r, md)
+		}
+	}
+	return r
+}
+
+// withoutChangeIDMetadata returns the metadata without the change ID entries.
+func withoutChangeIDMetadata(metadata []string) []string {
+
+	r := []string{}
+	for _, md := range metadata {
+		if !strings.HasPrefix(md, constants.MetadataChangeIDKey) {
+			r = append(r, md)
+		}
+	}
+	return r
+}
+
+// sameStrings returns true if a and b contain the same strings in any order.
+func sameStrings(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	for i := range sa {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
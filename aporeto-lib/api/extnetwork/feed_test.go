@@ -0,0 +1,224 @@
+package extnetwork
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/audit"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+func TestReadFeed(t *testing.T) {
+	type args struct {
+		feed   string
+		format string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*FeedEntry
+		wantErr bool
+	}{
+		{
+			name: "csv",
+			args: args{
+				format: FeedFormatCSV,
+				feed: `# partners
+name,cidrs,protocols,ports,tags
+acme, 10.0.0.0/8;192.168.1.1 ,tcp,443,partner=acme
+saas,"2001:db8::/32 10.1.0.0/16",,,
+`,
+			},
+			want: []*FeedEntry{
+				{Name: "acme", CIDRs: []string{"10.0.0.0/8", "192.168.1.1"}, Protocols: []string{"tcp"}, Ports: []string{"443"}, Tags: []string{"partner=acme"}},
+				{Name: "saas", CIDRs: []string{"2001:db8::/32", "10.1.0.0/16"}, Protocols: []string{}, Ports: []string{}, Tags: []string{}},
+			},
+		},
+		{
+			name: "csv with columns in any order",
+			args: args{
+				format: FeedFormatCSV,
+				feed:   "CIDRs,Name\n10.0.0.0/8,acme\n",
+			},
+			want: []*FeedEntry{
+				{Name: "acme", CIDRs: []string{"10.0.0.0/8"}, Protocols: []string{}, Ports: []string{}, Tags: []string{}},
+			},
+		},
+		{
+			name: "json",
+			args: args{
+				format: FeedFormatJSON,
+				feed:   `[{"name": "acme", "cidrs": ["10.0.0.0/8"], "ports": ["443"]}]`,
+			},
+			want: []*FeedEntry{
+				{Name: "acme", CIDRs: []string{"10.0.0.0/8"}, Ports: []string{"443"}},
+			},
+		},
+		{
+			name:    "csv without cidrs column",
+			args:    args{format: FeedFormatCSV, feed: "name\nacme\n"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			args:    args{format: FeedFormatCSV, feed: "name,cidrs\nacme,10.0.0.0/40\n"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			args:    args{format: FeedFormatCSV, feed: "name,cidrs\nacme,10.0.0.0/8\nacme,10.0.0.1\n"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported format",
+			args:    args{format: "yaml", feed: ""},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadFeed(strings.NewReader(tt.args.feed), tt.args.format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadFeed() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadFeed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+
+	namespace := "/account/zone/tenant"
+
+	managed := func(e *FeedEntry) *gaia.ExternalNetwork {
+		en := buildFeedExternalNetwork(namespace, e)
+		en.ID = e.Name
+		return en
+	}
+
+	existing := gaia.ExternalNetworksList{
+		managed(&FeedEntry{Name: "same", CIDRs: []string{"10.0.0.0/8"}}),
+		managed(&FeedEntry{Name: "changed", CIDRs: []string{"10.0.0.0/8"}}),
+		managed(&FeedEntry{Name: "removed", CIDRs: []string{"10.0.0.0/8"}}),
+		{ID: "all-tcp", Name: "all-tcp", Metadata: []string{constants.MetadataOwnerKeyVal}},
+		{ID: "other", Name: "other"},
+	}
+
+	entries := []*FeedEntry{
+		{Name: "same", CIDRs: []string{"10.0.0.0/8"}},
+		{Name: "changed", CIDRs: []string{"10.0.0.0/8", "::/0"}},
+		{Name: "new", CIDRs: []string{"192.168.0.0/16"}},
+		{Name: "other", CIDRs: []string{"192.168.0.0/16"}},
+	}
+
+	p := planSync(namespace, existing, entries)
+
+	want := &SyncResult{
+		Created:   []string{"new"},
+		Updated:   []string{"changed"},
+		Deleted:   []string{"removed"},
+		Unchanged: []string{"same"},
+		Conflicts: []string{"other"},
+	}
+	if !reflect.DeepEqual(p.result, want) {
+		t.Errorf("planSync() = %v, want %v", p.result, want)
+	}
+
+	if len(p.updates) != 1 || p.updates[0].ID != "changed" || !reflect.DeepEqual(p.updates[0].Entries, entries[1].CIDRs) {
+		t.Errorf("planSync() updates = %v", p.updates)
+	}
+	if len(p.creates) != 1 || p.creates[0].ID != "" || !isFeedManaged(p.creates[0]) {
+		t.Errorf("planSync() creates = %v", p.creates)
+	}
+}
+
+func TestSyncAudited(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+	run := func(changeID string, entries []*FeedEntry) *SyncResult {
+		s := &Sync{Account: "acct", Zone: "dmz", Tenant: "t1", Entries: entries}
+		res, err := s.Run(ctx, audit.NewManipulator(m, audit.SinkFunc(func(*audit.Record) error { return nil }), audit.Options{ChangeID: changeID}))
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return res
+	}
+
+	entries := []*FeedEntry{
+		{Name: "partner", CIDRs: []string{"10.0.0.0/8"}},
+		{Name: "vendor", CIDRs: []string{"192.168.0.0/16"}},
+	}
+	if res := run("change-1", entries); len(res.Created) != 2 {
+		t.Fatalf("Run() = %+v, want 2 creations", res)
+	}
+
+	// The change ID of the creation does not make the external networks differ.
+	if res := run("change-2", entries); len(res.Unchanged) != 2 || len(res.Updated) != 0 {
+		t.Errorf("Run() of the same feed = %+v, want 2 unchanged", res)
+	}
+
+	entries[1].CIDRs = []string{"172.16.0.0/12"}
+	if res := run("change-3", entries); !reflect.DeepEqual(res.Updated, []string{"vendor"}) || len(res.Unchanged) != 1 {
+		t.Errorf("Run() of a changed feed = %+v, want vendor updated", res)
+	}
+
+	en, _ := m.Get("externalnetwork", "/acct/dmz/t1", "vendor").(*gaia.ExternalNetwork) // nolint
+	if en == nil || !reflect.DeepEqual(en.Entries, entries[1].CIDRs) {
+		t.Fatalf("Run() did not update vendor: %+v", en)
+	}
+	if got := changeIDMetadata(en.Metadata); !reflect.DeepEqual(got, []string{audit.ChangeIDMetadata("change-1")}) {
+		t.Errorf("Run() update change ID metadata = %v, want the change ID of the creation", got)
+	}
+}
+
+func TestSync_PartialFailure(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+
+	s := &Sync{Account: "acct", Zone: "dmz", Tenant: "t1", Entries: []*FeedEntry{{Name: "old", CIDRs: []string{"10.0.0.0/8"}}}}
+	if _, err := s.Run(ctx, m); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	m.OnCreate = func(mctx manipulate.Context, object elemental.Identifiable) error {
+		if object.(*gaia.ExternalNetwork).Name == "vendor" {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	s.Entries = []*FeedEntry{
+		{Name: "partner", CIDRs: []string{"10.0.0.0/8"}},
+		{Name: "vendor", CIDRs: []string{"192.168.0.0/16"}},
+	}
+	res, err := s.Run(ctx, m)
+	if err == nil {
+		t.Fatalf("Run() should fail")
+	}
+
+	want := &SyncResult{
+		Created:   []string{"partner"},
+		Updated:   []string{},
+		Deleted:   []string{},
+		Unchanged: []string{},
+		Conflicts: []string{},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Run() = %+v, want %+v", res, want)
+	}
+	if m.Get("externalnetwork", "/acct/dmz/t1", "old") == nil {
+		t.Errorf("Run() deleted old after the failure")
+	}
+}
@@ -0,0 +1,225 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// Node kinds.
+const (
+	KindAccount         = "account"
+	KindZone            = "zone"
+	KindTenant          = "tenant"
+	KindRail            = "rail"
+	KindNamespace       = "namespace"
+	KindExternalNetwork = "externalnetwork"
+)
+
+// Node is a namespace or an external network. The identifier of a namespace node is the
+// namespace. A wildcard namespace '/acct/zone/tenant/*' is represented by the node of
+// '/acct/zone/tenant'.
+type Node struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// Edge is a network access policy allowing or rejecting traffic from a subject to an
+// object. Ports are the protocols and ports of the external networks and the host services
+// of the edge.
+type Edge struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Policy    string   `json:"policy"`
+	Namespace string   `json:"namespace"`
+	Mode      string   `json:"mode"`
+	Action    string   `json:"action"`
+	Ports     []string `json:"ports"`
+}
+
+// Graph is the directed connectivity graph of a set of network access policies.
+type Graph struct {
+	Nodes []*Node `json:"nodes"`
+	Edges []*Edge `json:"edges"`
+}
+
+// Fetch returns the graph of all the network access policies and external networks
+// of an account.
+func Fetch(ctx context.Context, m manipulate.Manipulator, account string) (*Graph, error) {
+
+	namespace := utils.SetupNamespaceString(account)
+
+	policies, err := networkpolicy.ListRecursive(ctx, m, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list network access policies in namespace '%s': %s", namespace, err.Error())
+	}
+
+	extnets, err := externalnetwork.ListRecursive(ctx, m, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list external networks in namespace '%s': %s", namespace, err.Error())
+	}
+
+	return Build(policies, extnets), nil
+}
+
+// Build returns the graph of the policies. Each pair of subject and object clauses of a
+// policy is an edge. A clause with a 'cns-customer:ext:network=' tag is mapped to the
+// external network, else to the namespace of its '$namespace=' tag. A clause without a
+// namespace is mapped to the tenant of the '@cns-customer:tenant=' metadata of the policy,
+// the first one for the subject and the last one for the object, or else to the namespace
+// of the policy.
+func Build(policies gaia.NetworkAccessPoliciesList, extnets gaia.ExternalNetworksList) *Graph {
+
+	b := &builder{
+		nodes:   map[string]*Node{},
+		extnets: extnets,
+		graph:   &Graph{Nodes: []*Node{}, Edges: []*Edge{}},
+	}
+
+	for _, p := range policies {
+
+		tenants := []string{}
+		for _, md := range p.Metadata {
+			if strings.HasPrefix(md, constants.MetadataTenantKey) {
+				tenants = append(tenants, strings.TrimPrefix(md, constants.MetadataTenantKey))
+			}
+		}
+
+		subjectDefault, objectDefault := utils.SetupNamespaceString(p.Namespace), utils.SetupNamespaceString(p.Namespace)
+		if len(tenants) > 0 {
+			subjectDefault, objectDefault = tenants[0], tenants[len(tenants)-1]
+		}
+
+		for _, subject := range p.Subject {
+			from, fromPorts := b.clause(subject, subjectDefault)
+			for _, object := range p.Object {
+				to, toPorts := b.clause(object, objectDefault)
+				b.graph.Edges = append(b.graph.Edges, &Edge{
+					From:      from.ID,
+					To:        to.ID,
+					Policy:    p.Name,
+					Namespace: utils.SetupNamespaceString(p.Namespace),
+					Mode:      string(p.ApplyPolicyMode),
+					Action:    string(p.Action),
+					Ports:     append(append([]string{}, fromPorts...), toPorts...),
+				})
+			}
+		}
+	}
+
+	for _, n := range b.nodes {
+		b.graph.Nodes = append(b.graph.Nodes, n)
+	}
+	sort.Slice(b.graph.Nodes, func(i, j int) bool {
+		return b.graph.Nodes[i].ID < b.graph.Nodes[j].ID
+	})
+
+	return b.graph
+}
+
+type builder struct {
+	nodes   map[string]*Node
+	extnets gaia.ExternalNetworksList
+	graph   *Graph
+}
+
+// clause returns the node of a clause and its ports.
+func (b *builder) clause(clause []string, defaultNamespace string) (*Node, []string) {
+
+	namespace, extnet := "", ""
+	ports := []string{}
+	for _, tag := range clause {
+		switch {
+		case strings.HasPrefix(tag, constants.NamespaceKey):
+			namespace = strings.TrimSuffix(strings.TrimPrefix(tag, constants.NamespaceKey), "/*")
+		case strings.HasPrefix(tag, constants.AssociatedTagExternalNetworkKey):
+			extnet = strings.TrimPrefix(tag, constants.AssociatedTagExternalNetworkKey)
+		case strings.HasPrefix(tag, constants.AssociatedTagHostServiceKey):
+			ports = append(ports, "service "+strings.TrimPrefix(tag, constants.AssociatedTagHostServiceKey))
+		}
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	namespace = utils.SetupNamespaceString(namespace)
+
+	if extnet != "" {
+		return b.externalNetworkNode(namespace, extnet), append(ports, b.externalNetworkPorts(namespace, extnet)...)
+	}
+	return b.namespaceNode(namespace), ports
+}
+
+func (b *builder) namespaceNode(namespace string) *Node {
+
+	if n, ok := b.nodes[namespace]; ok {
+		return n
+	}
+
+	kind := KindNamespace
+	switch strings.Count(namespace, "/") {
+	case 1:
+		if namespace != "/" {
+			kind = KindAccount
+		}
+	case 2:
+		kind = KindZone
+	case 3:
+		kind = KindTenant
+	case 4:
+		kind = KindRail
+	}
+
+	n := &Node{ID: namespace, Kind: kind, Label: namespace}
+	b.nodes[namespace] = n
+	return n
+}
+
+func (b *builder) externalNetworkNode(namespace, name string) *Node {
+
+	id := "extnet:" + namespace + ":" + name
+	if n, ok := b.nodes[id]; ok {
+		return n
+	}
+
+	n := &Node{ID: id, Kind: KindExternalNetwork, Label: name}
+	b.nodes[id] = n
+	return n
+}
+
+// externalNetworkPorts returns the protocols and ports of the external network with the
+// given name, preferably in the namespace.
+func (b *builder) externalNetworkPorts(namespace, name string) []string {
+
+	var en *gaia.ExternalNetwork
+	for _, e := range b.extnets {
+		if e.Name != name {
+			continue
+		}
+		if en == nil || utils.SetupNamespaceString(e.Namespace) == namespace {
+			en = e
+		}
+	}
+	if en == nil {
+		return nil
+	}
+
+	ports := []string{}
+	for _, protocol := range en.Protocols {
+		if len(en.Ports) == 0 {
+			ports = append(ports, protocol)
+		}
+		for _, p := range en.Ports {
+			ports = append(ports, protocol+"/"+p)
+		}
+	}
+	return ports
+}
@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/gaia"
+)
+
+func testPolicies() (gaia.NetworkAccessPoliciesList, gaia.ExternalNetworksList) {
+
+	policies := gaia.NetworkAccessPoliciesList{
+		{
+			Namespace:       "/acct/zone/t1",
+			Name:            "accept from protected to private",
+			ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic,
+			Action:          gaia.NetworkAccessPolicyActionAllow,
+			Subject:         [][]string{{"$namespace=/acct/zone/t1/protected"}},
+			Object:          [][]string{{"$namespace=/acct/zone/t1/private"}},
+			Metadata:        []string{"@cns-customer:owner=soc", "@cns-customer:tenant=/acct/zone/t1"},
+		},
+		{
+			Namespace:       "/acct/zone/t1",
+			Name:            "outgoing all-tcp",
+			ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic,
+			Action:          gaia.NetworkAccessPolicyActionAllow,
+			Subject:         [][]string{{"$namespace=/acct/zone/t1/*"}},
+			Object:          [][]string{{"$namespace=/acct/zone/t1", "cns-customer:ext:network=all-tcp"}, {"$identity=processingunit"}},
+			Metadata:        []string{"@cns-customer:owner=soc", "@cns-customer:tenant=/acct/zone/t1"},
+		},
+		{
+			Namespace:       "/acct",
+			Name:            "exception",
+			ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeBidirectional,
+			Action:          gaia.NetworkAccessPolicyActionAllow,
+			Subject:         [][]string{{"app=web"}},
+			Object:          [][]string{{"app=db"}},
+			Metadata:        []string{"@cns-customer:owner=soc", "@cns-customer:tenant=/acct/zone/t1", "@cns-customer:tenant=/acct/other/t2"},
+		},
+		{
+			Namespace:       "/acct",
+			Name:            "disable /acct/other/t2",
+			ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeBidirectional,
+			Action:          gaia.NetworkAccessPolicyActionReject,
+			Subject:         [][]string{{"$namespace=/acct/other/t2/*"}},
+			Object:          [][]string{{"$namespace=/acct/*"}},
+			Metadata:        []string{"@cns-customer:owner=soc", "@cns-customer:tenant=/acct/other/t2"},
+		},
+	}
+
+	extnets := gaia.ExternalNetworksList{
+		{Namespace: "/acct/zone/t1", Name: "all-tcp", Ports: []string{"1:65535"}, Protocols: []string{"tcp"}},
+	}
+
+	return policies, extnets
+}
+
+func TestBuild(t *testing.T) {
+
+	g := Build(testPolicies())
+
+	nodes := []Node{}
+	for _, n := range g.Nodes {
+		nodes = append(nodes, *n)
+	}
+	wantNodes := []Node{
+		{ID: "/acct", Kind: KindAccount, Label: "/acct"},
+		{ID: "/acct/other/t2", Kind: KindTenant, Label: "/acct/other/t2"},
+		{ID: "/acct/zone/t1", Kind: KindTenant, Label: "/acct/zone/t1"},
+		{ID: "/acct/zone/t1/private", Kind: KindRail, Label: "/acct/zone/t1/private"},
+		{ID: "/acct/zone/t1/protected", Kind: KindRail, Label: "/acct/zone/t1/protected"},
+		{ID: "extnet:/acct/zone/t1:all-tcp", Kind: KindExternalNetwork, Label: "all-tcp"},
+	}
+	if !reflect.DeepEqual(nodes, wantNodes) {
+		t.Errorf("Build() nodes = %v, want %v", nodes, wantNodes)
+	}
+
+	edges := [][]string{}
+	for _, e := range g.Edges {
+		edges = append(edges, []string{e.From, e.To, e.Policy, e.Action, strings.Join(e.Ports, ",")})
+	}
+	wantEdges := [][]string{
+		{"/acct/zone/t1/protected", "/acct/zone/t1/private", "accept from protected to private", "Allow", ""},
+		{"/acct/zone/t1", "extnet:/acct/zone/t1:all-tcp", "outgoing all-tcp", "Allow", "tcp/1:65535"},
+		{"/acct/zone/t1", "/acct/zone/t1", "outgoing all-tcp", "Allow", ""},
+		{"/acct/zone/t1", "/acct/other/t2", "exception", "Allow", ""},
+		{"/acct/other/t2", "/acct", "disable /acct/other/t2", "Reject", ""},
+	}
+	if !reflect.DeepEqual(edges, wantEdges) {
+		t.Errorf("Build() edges = %v, want %v", edges, wantEdges)
+	}
+}
+
+func TestWrite(t *testing.T) {
+
+	g := Build(testPolicies())
+
+	tests := []struct {
+		format   string
+		contains []string
+		wantErr  bool
+	}{
+		{
+			format: FormatDOT,
+			contains: []string{
+				"digraph connectivity {",
+				`"extnet:/acct/zone/t1:all-tcp" [label="all-tcp", shape=cylinder];`,
+				`"/acct/other/t2" -> "/acct" [label="disable /acct/other/t2\nBidirectional Reject", color=red, style=dashed];`,
+			},
+		},
+		{
+			format: FormatMermaid,
+			contains: []string{
+				"flowchart LR",
+				`n5[("all-tcp")]`,
+				`n1 -.->|"disable /acct/other/t2<br>Bidirectional Reject"| n0`,
+				`n2 -->|"outgoing all-tcp<br>OutgoingTraffic Allow<br>tcp/1:65535"| n5`,
+			},
+		},
+		{
+			format:   FormatJSON,
+			contains: []string{`"kind": "externalnetwork"`},
+		},
+		{
+			format:  "svg",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			err := g.Write(buf, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(buf.String(), c) {
+					t.Errorf("Write() = %s, should contain %s", buf.String(), c)
+				}
+			}
+			if tt.format == FormatJSON {
+				out := &Graph{}
+				if err := json.Unmarshal(buf.Bytes(), out); err != nil || !reflect.DeepEqual(out, g) {
+					t.Errorf("Write() json does not round trip: %v", err)
+				}
+			}
+		})
+	}
+}
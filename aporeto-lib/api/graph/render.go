@@ -0,0 +1,114 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/gaia"
+)
+
+// Output formats.
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+	FormatJSON    = "json"
+)
+
+// Write writes the graph in the given format.
+func (g *Graph) Write(w io.Writer, format string) error {
+
+	switch format {
+	case FormatDOT:
+		return g.writeDOT(w)
+	case FormatMermaid:
+		return g.writeMermaid(w)
+	case FormatJSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(g)
+	default:
+		return fmt.Errorf("unsupported graph format '%s'", format)
+	}
+}
+
+// label returns the text of an edge: policy name, apply mode, action and ports.
+func (e *Edge) label() string {
+
+	l := e.Policy + "\n" + e.Mode + " " + e.Action
+	if len(e.Ports) > 0 {
+		l += "\n" + strings.Join(e.Ports, ", ")
+	}
+	return l
+}
+
+func (e *Edge) isReject() bool {
+	return e.Action == string(gaia.NetworkAccessPolicyActionReject)
+}
+
+var dotShapes = map[string]string{
+	KindAccount:         "tab",
+	KindZone:            "folder",
+	KindTenant:          "box",
+	KindRail:            "ellipse",
+	KindNamespace:       "ellipse",
+	KindExternalNetwork: "cylinder",
+}
+
+// writeDOT writes the graph in Graphviz DOT. Reject edges are red and dashed.
+func (g *Graph) writeDOT(w io.Writer) error {
+
+	b := &strings.Builder{}
+	b.WriteString("digraph connectivity {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(b, "  %s [label=%s, shape=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Label), dotShapes[n.Kind])
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.isReject() {
+			style = ", color=red, style=dashed"
+		}
+		fmt.Fprintf(b, "  %s -> %s [label=%s%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strconv.Quote(e.label()), style)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var mermaidShapes = map[string][2]string{
+	KindAccount:         {"[[", "]]"},
+	KindZone:            {"[/", "/]"},
+	KindTenant:          {"[", "]"},
+	KindRail:            {"(", ")"},
+	KindNamespace:       {"(", ")"},
+	KindExternalNetwork: {"[(", ")]"},
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", "<br>")
+
+// writeMermaid writes the graph as a Mermaid flowchart. Reject edges are dotted.
+func (g *Graph) writeMermaid(w io.Writer) error {
+
+	ids := map[string]string{}
+	b := &strings.Builder{}
+	b.WriteString("flowchart LR\n")
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+		shape := mermaidShapes[n.Kind]
+		fmt.Fprintf(b, "  %s%s\"%s\"%s\n", ids[n.ID], shape[0], mermaidEscaper.Replace(n.Label), shape[1])
+	}
+	for _, e := range g.Edges {
+		arrow := "-->"
+		if e.isReject() {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(b, "  %s %s|\"%s\"| %s\n", ids[e.From], arrow, mermaidEscaper.Replace(e.label()), ids[e.To])
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
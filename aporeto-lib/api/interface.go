@@ -22,6 +22,12 @@ type Disabler interface {
 	Disable(ctx context.Context, m manipulate.Manipulator) error
 }
 
+// Enabler interface defines APIs to enable a disabled object.
+type Enabler interface {
+	// Enable enables an object.
+	Enable(ctx context.Context, m manipulate.Manipulator) error
+}
+
 // CreatorDeleterDisabler interface composes the CreatorDeleter and Disabler interfaces.
 type CreatorDeleterDisabler interface {
 	CreatorDeleter
@@ -3,6 +3,7 @@ package externalnetwork
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
@@ -26,6 +27,13 @@ func Create(
 	cidrs, ports, protocols []string,
 ) error {
 
+	return Save(ctx, m, namespace, Build(namespace, name, description, cidrs, ports, protocols, nil))
+}
+
+// Build returns an external network owned by the namespace. The tags are added to the
+// associated tags of the external network.
+func Build(namespace, name, description string, cidrs, ports, protocols, tags []string) *gaia.ExternalNetwork {
+
 	// Ensure namespaces are correctly formatted.
 	namespace = utils.SetupNamespaceString(namespace)
 
@@ -37,8 +45,15 @@ func Create(
 	en.Ports = ports
 	en.Protocols = protocols
 	en.AssociatedTags = append(utils.MakeNamespaceAssociatedTags(namespace), utils.MakeExternalNetworkAssociatedTags(name)...)
+	en.AssociatedTags = append(en.AssociatedTags, tags...)
 	en.Metadata = utils.MakeTenantMetadata(namespace)
 
+	return en
+}
+
+// Save creates the external network if it has no identifier yet and updates it otherwise.
+func Save(ctx context.Context, m manipulate.Manipulator, namespace string, en *gaia.ExternalNetwork) error {
+
 	// Create a sub context so we dont retry too long.
 	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
 	defer cancel()
@@ -46,11 +61,13 @@ func Create(
 	// Create a namespace context where we are creating an object.
 	mctx := manipulate.NewContext(
 		subctx,
-		manipulate.ContextOptionNamespace(namespace),
+		manipulate.ContextOptionNamespace(utils.SetupNamespaceString(namespace)),
 	)
 
-	// Try creating multiple times in case of connection errors.
-	return m.Create(mctx, en)
+	if en.ID == "" {
+		return m.Create(mctx, en)
+	}
+	return m.Update(mctx, en)
 }
 
 // Delete deletes an external network.
@@ -107,3 +124,96 @@ func Get(ctx context.Context, m manipulate.Manipulator, namespace, name string)
 
 	return ens[0], nil
 }
+
+// List fetches all external networks in a namespace.
+func List(ctx context.Context, m manipulate.Manipulator, namespace string) (gaia.ExternalNetworksList, error) {
+
+	ens := gaia.ExternalNetworksList{}
+
+	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+	defer cancel()
+
+	mctx := manipulate.NewContext(
+		subctx,
+		manipulate.ContextOptionNamespace(utils.SetupNamespaceString(namespace)),
+	)
+
+	if err := m.RetrieveMany(mctx, &ens); err != nil {
+		return nil, err
+	}
+
+	return ens, nil
+}
+
+// ListRecursive fetches all external networks in a namespace and its children.
+func ListRecursive(ctx context.Context, m manipulate.Manipulator, namespace string) (gaia.ExternalNetworksList, error) {
+
+	ens := gaia.ExternalNetworksList{}
+
+	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+	defer cancel()
+
+	mctx := manipulate.NewContext(
+		subctx,
+		manipulate.ContextOptionNamespace(utils.SetupNamespaceString(namespace)),
+		manipulate.ContextOptionRecursive(true),
+	)
+
+	if err := m.RetrieveMany(mctx, &ens); err != nil {
+		return nil, err
+	}
+
+	return ens, nil
+}
+
+// Overlaps returns the external networks of the list, other than the one named name, whose
+// entries overlap with cidrs for at least one common protocol. Entries that are not CIDRs
+// are ignored. An empty list of protocols matches all protocols.
+func Overlaps(ens gaia.ExternalNetworksList, name string, cidrs, protocols []string) (gaia.ExternalNetworksList, error) {
+
+	set, err := utils.NewCIDRSet(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	overlaps := gaia.ExternalNetworksList{}
+	for _, en := range ens {
+
+		if en.Name == name || !protocolsOverlap(en.Protocols, protocols) {
+			continue
+		}
+
+		entries := []string{}
+		for _, e := range en.Entries {
+			if _, err := utils.ParseCIDR(e); err == nil {
+				entries = append(entries, e)
+			}
+		}
+
+		other, err := utils.NewCIDRSet(entries)
+		if err != nil {
+			return nil, err
+		}
+		if set.Overlaps(other) {
+			overlaps = append(overlaps, en)
+		}
+	}
+
+	return overlaps, nil
+}
+
+// protocolsOverlap returns true if the two lists of protocols have a protocol in common.
+func protocolsOverlap(a, b []string) bool {
+
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, pa := range a {
+		for _, pb := range b {
+			if strings.EqualFold(pa, pb) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -150,3 +150,24 @@ func Get(ctx context.Context, m manipulate.Manipulator, namespace, name string)
 
 	return nps[0], nil
 }
+
+// ListRecursive fetches all network access policies in a namespace and its children.
+func ListRecursive(ctx context.Context, m manipulate.Manipulator, namespace string) (gaia.NetworkAccessPoliciesList, error) {
+
+	nps := gaia.NetworkAccessPoliciesList{}
+
+	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+	defer cancel()
+
+	mctx := manipulate.NewContext(
+		subctx,
+		manipulate.ContextOptionNamespace(utils.SetupNamespaceString(namespace)),
+		manipulate.ContextOptionRecursive(true),
+	)
+
+	if err := m.RetrieveMany(mctx, &nps); err != nil {
+		return nil, err
+	}
+
+	return nps, nil
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ParseCIDR parses a CIDR or an IP address. IP addresses are returned as /32 or /128
+// networks, and the address of CIDRs is masked so that the network is canonical.
+func ParseCIDR(entry string) (*net.IPNet, error) {
+
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address '%s'", entry)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr '%s'", entry)
+	}
+	if ip4 := ipnet.IP.To4(); ip4 != nil && len(ipnet.Mask) == net.IPv4len {
+		ipnet.IP = ip4
+	}
+	return ipnet, nil
+}
+
+// ValidateCIDRs returns an error listing all the entries that are neither CIDRs nor IP addresses.
+func ValidateCIDRs(entries []string) error {
+
+	invalid := []string{}
+	for _, e := range entries {
+		if _, err := ParseCIDR(e); err != nil {
+			invalid = append(invalid, "'"+e+"'")
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid cidrs: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// CIDRSet is a set of IPv4 and IPv6 networks. It is always normalized: networks are
+// sorted, do not contain each other and sibling networks are merged into their parent.
+type CIDRSet struct {
+	nets []*net.IPNet
+}
+
+// NewCIDRSet returns the set of the given CIDRs and IP addresses.
+func NewCIDRSet(entries []string) (*CIDRSet, error) {
+
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		ipnet, err := ParseCIDR(e)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipnet)
+	}
+
+	return newCIDRSet(nets), nil
+}
+
+func newCIDRSet(nets []*net.IPNet) *CIDRSet {
+
+	sort.Slice(nets, func(i, j int) bool {
+		return compareNets(nets[i], nets[j]) < 0
+	})
+
+	// Networks are sorted by address, then by prefix length. A network containing others
+	// comes first, and siblings are adjacent once contained networks are removed.
+	stack := []*net.IPNet{}
+	for _, n := range nets {
+		if len(stack) > 0 && containsNet(stack[len(stack)-1], n) {
+			continue
+		}
+		stack = append(stack, n)
+		for len(stack) >= 2 && siblingNets(stack[len(stack)-2], stack[len(stack)-1]) {
+			parent := parentNet(stack[len(stack)-1])
+			stack = append(stack[:len(stack)-2], parent)
+		}
+	}
+
+	return &CIDRSet{nets: stack}
+}
+
+// Strings returns the networks of the set, IPv4 networks first.
+func (s *CIDRSet) Strings() []string {
+
+	r := make([]string, 0, len(s.nets))
+	for _, n := range s.nets {
+		r = append(r, n.String())
+	}
+	return r
+}
+
+// IsEmpty returns true if the set contains no network.
+func (s *CIDRSet) IsEmpty() bool {
+	return len(s.nets) == 0
+}
+
+// Merge returns the union of s and o.
+func (s *CIDRSet) Merge(o *CIDRSet) *CIDRSet {
+
+	nets := make([]*net.IPNet, 0, len(s.nets)+len(o.nets))
+	nets = append(nets, s.nets...)
+	nets = append(nets, o.nets...)
+
+	return newCIDRSet(nets)
+}
+
+// Intersect returns the addresses both in s and o.
+func (s *CIDRSet) Intersect(o *CIDRSet) *CIDRSet {
+
+	nets := []*net.IPNet{}
+	for _, a := range s.nets {
+		for _, b := range o.nets {
+			if containsNet(a, b) {
+				nets = append(nets, b)
+			} else if containsNet(b, a) {
+				nets = append(nets, a)
+			}
+		}
+	}
+
+	return newCIDRSet(nets)
+}
+
+// Overlaps returns true if s and o have addresses in common.
+func (s *CIDRSet) Overlaps(o *CIDRSet) bool {
+	return !s.Intersect(o).IsEmpty()
+}
+
+// Contains returns true if all addresses of o are in s.
+func (s *CIDRSet) Contains(o *CIDRSet) bool {
+	return o.Subtract(s).IsEmpty()
+}
+
+// Subtract returns the addresses of s that are not in o.
+func (s *CIDRSet) Subtract(o *CIDRSet) *CIDRSet {
+
+	pieces := append([]*net.IPNet{}, s.nets...)
+	for _, b := range o.nets {
+		next := []*net.IPNet{}
+		for _, p := range pieces {
+			switch {
+			case containsNet(b, p):
+			case containsNet(p, b):
+				next = append(next, excludeNet(p, b)...)
+			default:
+				next = append(next, p)
+			}
+		}
+		pieces = next
+	}
+
+	return newCIDRSet(pieces)
+}
+
+// compareNets orders networks by family, address and prefix length.
+func compareNets(a, b *net.IPNet) int {
+
+	if len(a.IP) != len(b.IP) {
+		return len(a.IP) - len(b.IP)
+	}
+	if c := bytes.Compare(a.IP, b.IP); c != 0 {
+		return c
+	}
+	aones, _ := a.Mask.Size()
+	bones, _ := b.Mask.Size()
+	return aones - bones
+}
+
+// containsNet returns true if a contains b.
+func containsNet(a, b *net.IPNet) bool {
+
+	if len(a.IP) != len(b.IP) {
+		return false
+	}
+	aones, _ := a.Mask.Size()
+	bones, _ := b.Mask.Size()
+	return aones <= bones && a.Contains(b.IP)
+}
+
+// parentNet returns the network one bit shorter than n.
+func parentNet(n *net.IPNet) *net.IPNet {
+
+	ones, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones-1, bits)
+	return &net.IPNet{IP: n.IP.Mask(mask), Mask: mask}
+}
+
+// siblingNets returns true if a and b are the two halves of the same network.
+func siblingNets(a, b *net.IPNet) bool {
+
+	if len(a.IP) != len(b.IP) {
+		return false
+	}
+	aones, _ := a.Mask.Size()
+	bones, _ := b.Mask.Size()
+	if aones != bones || aones == 0 || a.IP.Equal(b.IP) {
+		return false
+	}
+	return parentNet(a).IP.Equal(parentNet(b).IP)
+}
+
+// halveNet splits n into its two halves.
+func halveNet(n *net.IPNet) (*net.IPNet, *net.IPNet) {
+
+	ones, bits := n.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
+
+	lo := &net.IPNet{IP: append(net.IP{}, n.IP...), Mask: mask}
+	hi := &net.IPNet{IP: append(net.IP{}, n.IP...), Mask: mask}
+	hi.IP[ones/8] |= 0x80 >> uint(ones%8)
+
+	return lo, hi
+}
+
+// excludeNet returns the networks covering the addresses of n that are not in x.
+// x must be contained in n.
+func excludeNet(n, x *net.IPNet) []*net.IPNet {
+
+	r := []*net.IPNet{}
+	xones, _ := x.Mask.Size()
+	for {
+		ones, _ := n.Mask.Size()
+		if ones >= xones {
+			return r
+		}
+		lo, hi := halveNet(n)
+		if lo.Contains(x.IP) {
+			r = append(r, hi)
+			n = lo
+		} else {
+			r = append(r, lo)
+			n = hi
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustNewCIDRSet(entries ...string) *CIDRSet {
+	s, err := NewCIDRSet(entries)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestValidateCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{
+			name:    "ipv4 and ipv6 cidrs and addresses",
+			entries: []string{"10.0.0.0/8", "10.1.2.3", "::/0", "2001:db8::1"},
+		},
+		{
+			name:    "missing prefix length",
+			entries: []string{"10.0.0.0/"},
+			wantErr: true,
+		},
+		{
+			name:    "prefix too long",
+			entries: []string{"10.0.0.0/33"},
+			wantErr: true,
+		},
+		{
+			name:    "hostname",
+			entries: []string{"10.0.0.0/8", "example.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCIDRs(tt.entries); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateCIDRs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewCIDRSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+	}{
+		{
+			name:    "addresses and unmasked cidrs",
+			entries: []string{"10.1.2.3", "10.1.2.3/16", "2001:db8::1"},
+			want:    []string{"10.1.0.0/16", "2001:db8::1/128"},
+		},
+		{
+			name:    "contained networks",
+			entries: []string{"10.1.0.0/16", "10.0.0.0/8", "10.2.3.4"},
+			want:    []string{"10.0.0.0/8"},
+		},
+		{
+			name:    "siblings are merged recursively",
+			entries: []string{"10.0.0.128/25", "10.0.1.0/24", "10.0.0.0/25"},
+			want:    []string{"10.0.0.0/23"},
+		},
+		{
+			name:    "ipv4 first",
+			entries: []string{"::/0", "192.168.0.0/16", "0.0.0.0/0"},
+			want:    []string{"0.0.0.0/0", "::/0"},
+		},
+		{
+			name:    "empty",
+			entries: nil,
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustNewCIDRSet(tt.entries...).Strings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewCIDRSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCIDRSetOperations(t *testing.T) {
+	tests := []struct {
+		name          string
+		a             []string
+		b             []string
+		wantMerge     []string
+		wantIntersect []string
+		wantSubtract  []string
+		wantContains  bool
+	}{
+		{
+			name:          "disjoint",
+			a:             []string{"10.0.0.0/24"},
+			b:             []string{"10.0.2.0/24"},
+			wantMerge:     []string{"10.0.0.0/24", "10.0.2.0/24"},
+			wantIntersect: []string{},
+			wantSubtract:  []string{"10.0.0.0/24"},
+		},
+		{
+			name:          "contained",
+			a:             []string{"10.0.0.0/22"},
+			b:             []string{"10.0.1.0/24"},
+			wantMerge:     []string{"10.0.0.0/22"},
+			wantIntersect: []string{"10.0.1.0/24"},
+			wantSubtract:  []string{"10.0.0.0/24", "10.0.2.0/23"},
+			wantContains:  true,
+		},
+		{
+			name:          "all ipv6 minus a prefix",
+			a:             []string{"::/0"},
+			b:             []string{"8000::/2", "0.0.0.0/0"},
+			wantMerge:     []string{"0.0.0.0/0", "::/0"},
+			wantIntersect: []string{"8000::/2"},
+			wantSubtract:  []string{"::/1", "c000::/2"},
+		},
+		{
+			name:          "equal",
+			a:             []string{"192.168.0.0/16"},
+			b:             []string{"192.168.0.0/17", "192.168.128.0/17"},
+			wantMerge:     []string{"192.168.0.0/16"},
+			wantIntersect: []string{"192.168.0.0/16"},
+			wantSubtract:  []string{},
+			wantContains:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := mustNewCIDRSet(tt.a...), mustNewCIDRSet(tt.b...)
+			if got := a.Merge(b).Strings(); !reflect.DeepEqual(got, tt.wantMerge) {
+				t.Errorf("Merge() = %v, want %v", got, tt.wantMerge)
+			}
+			if got := a.Intersect(b).Strings(); !reflect.DeepEqual(got, tt.wantIntersect) {
+				t.Errorf("Intersect() = %v, want %v", got, tt.wantIntersect)
+			}
+			if got := a.Overlaps(b); got != (len(tt.wantIntersect) > 0) {
+				t.Errorf("Overlaps() = %v, want %v", got, len(tt.wantIntersect) > 0)
+			}
+			if got := a.Contains(b); got != tt.wantContains {
+				t.Errorf("Contains() = %v, want %v", got, tt.wantContains)
+			}
+			if got := a.Subtract(b).Strings(); !reflect.DeepEqual(got, tt.wantSubtract) {
+				t.Errorf("Subtract() = %v, want %v", got, tt.wantSubtract)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package networkpolicy
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
 	"go.aporeto.io/gaia"
 	"go.aporeto.io/manipulate"
 )
@@ -18,10 +22,52 @@ type NetworkPolicy struct {
 	ObjectTenantNamespace  string   `json:"object-tenant-namespace"`
 	ObjectTags             []string `json:"object-tags"`
 	PolicyMode             string   `json:"policy-mode"`
+	Encrypt                bool     `json:"encrypt"`
+
+	// Rules are the compliance rules checked before creating the policy. When nil, no
+	// rule is checked.
+	Rules *compliance.RuleSet `json:"-"`
+}
+
+// Validate returns the violations of the compliance rules by the policy.
+func (n *NetworkPolicy) Validate() []*compliance.Violation {
+
+	if n.Rules == nil {
+		return []*compliance.Violation{}
+	}
+
+	return n.Rules.Evaluate(&compliance.Policy{
+		Name:                   n.Name,
+		SubjectTenantNamespace: n.SubjectTenantNamespace,
+		Subject:                [][]string{n.SubjectTags},
+		ObjectTenantNamespace:  n.ObjectTenantNamespace,
+		Object:                 [][]string{n.ObjectTags},
+		Encrypt:                n.Encrypt,
+	})
 }
 
-// Create is an implementation of how to create an network policy.
-func (n *NetworkPolicy) Create(ctx context.Context, m manipulate.Manipulator) error {
+// Create is an implementation of how to create an network policy. It fails if the policy
+// violates a compliance rule with a deny severity and logs the other violations.
+func (n *NetworkPolicy) Create(ctx context.Context, m manipulate.Manipulator) (ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "exception-create", n.attributes()...)
+	defer func() { end(ret) }()
+
+	violations := n.Validate()
+	for _, v := range violations {
+		if v.Severity == compliance.SeverityWarn {
+			logging.FromContext(ctx).Warn(
+				"network policy violates a compliance rule",
+				logging.Namespace(n.Namespace),
+				logging.String("name", n.Name),
+				logging.String("rule", v.Rule),
+				logging.String("violation", v.Message),
+			)
+		}
+	}
+	if err := compliance.Denied(violations); err != nil {
+		return fmt.Errorf("unable to create network policy '%s': %s", n.Name, err.Error())
+	}
 
 	return networkpolicy.Create(
 		ctx,
@@ -35,12 +81,15 @@ func (n *NetworkPolicy) Create(ctx context.Context, m manipulate.Manipulator) er
 		[][]string{n.ObjectTags},
 		gaia.NetworkAccessPolicyApplyPolicyModeValue(n.PolicyMode),
 		gaia.NetworkAccessPolicyActionAllow,
-		false,
+		n.Encrypt,
 	)
 }
 
 // Delete is an implementation of how to delete an network policy.
-func (n *NetworkPolicy) Delete(ctx context.Context, m manipulate.Manipulator) error {
+func (n *NetworkPolicy) Delete(ctx context.Context, m manipulate.Manipulator) (ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "exception-delete", n.attributes()...)
+	defer func() { end(ret) }()
 
 	return networkpolicy.Delete(
 		ctx,
@@ -60,3 +109,11 @@ func (n *NetworkPolicy) Get(ctx context.Context, m manipulate.Manipulator) (*gai
 		n.Name,
 	)
 }
+
+// attributes returns the attributes of the spans of the policy operations.
+func (n *NetworkPolicy) attributes() []telemetry.Attribute {
+	return []telemetry.Attribute{
+		{Key: "namespace", Value: n.Namespace},
+		{Key: "policy", Value: n.Name},
+	}
+}
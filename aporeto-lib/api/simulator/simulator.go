@@ -0,0 +1,429 @@
+package simulator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// Sides of a flow where policies are enforced.
+const (
+	SideOutgoing = "outgoing"
+	SideIncoming = "incoming"
+)
+
+// Endpoint is one end of a flow. It is either a processing unit, described by its
+// namespace and tags, or an external address or CIDR.
+type Endpoint struct {
+	Namespace string   `json:"namespace"`
+	Tags      []string `json:"tags"`
+	CIDR      string   `json:"cidr"`
+}
+
+// IsExternal returns true if the endpoint is an external address.
+func (e *Endpoint) IsExternal() bool {
+	return e.CIDR != ""
+}
+
+// Flow is a connection from a source to a destination. The port is ignored for
+// protocols without ports.
+type Flow struct {
+	Source      Endpoint `json:"source"`
+	Destination Endpoint `json:"destination"`
+	Protocol    string   `json:"protocol"`
+	Port        int      `json:"port"`
+}
+
+// Match is a policy matching a flow on one side. ExternalNetwork is the name of the
+// external network through which the external endpoint matched, if any.
+type Match struct {
+	Side            string
+	Policy          *gaia.NetworkAccessPolicy
+	ExternalNetwork string
+}
+
+// Decision is the verdict of the enforcer on one side of the flow. Default is true when
+// no policy matched and the flow is rejected by default. Fallback is true when the
+// verdict comes from fallback policies.
+type Decision struct {
+	Side     string
+	Action   gaia.NetworkAccessPolicyActionValue
+	Default  bool
+	Fallback bool
+	Matches  []*Match
+}
+
+// Result is the verdict for a flow. Outgoing is nil when the source is external and
+// Incoming is nil when the destination is external.
+type Result struct {
+	Allowed  bool
+	Outgoing *Decision
+	Incoming *Decision
+}
+
+// Simulator evaluates flows against a set of network access policies and external networks.
+type Simulator struct {
+	policies gaia.NetworkAccessPoliciesList
+	extnets  gaia.ExternalNetworksList
+}
+
+// New returns a simulator for the given policies and external networks. Their Namespace
+// must be set.
+func New(policies gaia.NetworkAccessPoliciesList, extnets gaia.ExternalNetworksList) *Simulator {
+
+	return &Simulator{
+		policies: policies,
+		extnets:  extnets,
+	}
+}
+
+// Fetch returns a simulator for the policies and external networks of a namespace and
+// all its children. To honour policies such as the tenant disable policies, the namespace
+// should be the account namespace.
+func Fetch(ctx context.Context, m manipulate.Manipulator, namespace string) (*Simulator, error) {
+
+	policies, err := networkpolicy.ListRecursive(ctx, m, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list network access policies in namespace '%s': %s", namespace, err.Error())
+	}
+
+	extnets, err := externalnetwork.ListRecursive(ctx, m, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list external networks in namespace '%s': %s", namespace, err.Error())
+	}
+
+	return New(policies, extnets), nil
+}
+
+// FromExport returns a simulator for the policies and external networks of an export.
+// Objects without a namespace are considered to be in the namespace of the export label.
+func FromExport(r io.Reader) (*Simulator, error) {
+
+	export := gaia.NewExport()
+	if err := json.NewDecoder(r).Decode(export); err != nil {
+		return nil, fmt.Errorf("unable to read export: %s", err.Error())
+	}
+
+	policies := gaia.NetworkAccessPoliciesList{}
+	if err := decodeExportData(export.Data[gaia.NetworkAccessPolicyIdentity.Category], &policies); err != nil {
+		return nil, fmt.Errorf("unable to read network access policies: %s", err.Error())
+	}
+
+	extnets := gaia.ExternalNetworksList{}
+	if err := decodeExportData(export.Data[gaia.ExternalNetworkIdentity.Category], &extnets); err != nil {
+		return nil, fmt.Errorf("unable to read external networks: %s", err.Error())
+	}
+
+	for _, p := range policies {
+		if p.Namespace == "" {
+			p.Namespace = export.Label
+		}
+	}
+	for _, en := range extnets {
+		if en.Namespace == "" {
+			en.Namespace = export.Label
+		}
+	}
+
+	return New(policies, extnets), nil
+}
+
+func decodeExportData(data []map[string]interface{}, out interface{}) error {
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(js, out)
+}
+
+// Simulate returns the verdict for a flow. Each processing unit end of the flow enforces
+// the policies that apply to its namespace, either directly or through propagation, and
+// whose apply mode covers its side of the flow. On each side, a matching reject policy
+// takes precedence over allow policies, fallback policies are only considered when no
+// other policy matches, and the flow is rejected when no policy matches. The flow is
+// allowed when all the enforcing sides allow it.
+func (s *Simulator) Simulate(flow *Flow) (*Result, error) {
+
+	src, dst := &flow.Source, &flow.Destination
+	if src.IsExternal() && dst.IsExternal() {
+		return nil, fmt.Errorf("at least one end of the flow must be a processing unit")
+	}
+	for _, e := range []*Endpoint{src, dst} {
+		if e.IsExternal() {
+			if _, err := utils.ParseCIDR(e.CIDR); err != nil {
+				return nil, err
+			}
+		} else if e.Namespace == "" {
+			return nil, fmt.Errorf("missing namespace for processing unit with tags %v", e.Tags)
+		}
+	}
+
+	r := &Result{}
+	if !src.IsExternal() {
+		ns := utils.SetupNamespaceString(src.Namespace)
+		r.Outgoing = s.decide(SideOutgoing, ns, s.identities(src, ns, flow), s.identities(dst, ns, flow))
+	}
+	if !dst.IsExternal() {
+		ns := utils.SetupNamespaceString(dst.Namespace)
+		r.Incoming = s.decide(SideIncoming, ns, s.identities(src, ns, flow), s.identities(dst, ns, flow))
+	}
+
+	r.Allowed = (r.Outgoing == nil || r.Outgoing.Action == gaia.NetworkAccessPolicyActionAllow) &&
+		(r.Incoming == nil || r.Incoming.Action == gaia.NetworkAccessPolicyActionAllow)
+
+	return r, nil
+}
+
+// identity is the set of tags of an endpoint as seen by policies. externalNetwork is the
+// name of the external network of an external endpoint.
+type identity struct {
+	tags            []string
+	externalNetwork string
+}
+
+// identities returns the identities of an endpoint as seen by an enforcer in namespace ns.
+// An external endpoint has one identity per external network visible from ns that covers
+// its address and the protocol and port of the flow.
+func (s *Simulator) identities(e *Endpoint, ns string, flow *Flow) []*identity {
+
+	if !e.IsExternal() {
+		tags := append([]string{}, e.Tags...)
+		tags = append(tags, "$namespace="+utils.SetupNamespaceString(e.Namespace), "$identity=processingunit")
+		return []*identity{{tags: tags}}
+	}
+
+	addr, err := utils.NewCIDRSet([]string{e.CIDR})
+	if err != nil {
+		return nil
+	}
+
+	ids := []*identity{}
+	for _, en := range s.extnets {
+
+		if !isUnder(ns, en.Namespace) || !extnetServes(en, flow.Protocol, flow.Port) {
+			continue
+		}
+
+		entries := []string{}
+		for _, entry := range en.Entries {
+			if _, err := utils.ParseCIDR(entry); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+		set, err := utils.NewCIDRSet(entries)
+		if err != nil || !set.Contains(addr) {
+			continue
+		}
+
+		tags := append([]string{}, en.AssociatedTags...)
+		tags = append(tags, "$namespace="+en.Namespace, "$identity=externalnetwork", "$name="+en.Name)
+		ids = append(ids, &identity{tags: tags, externalNetwork: en.Name})
+	}
+
+	return ids
+}
+
+// decide returns the decision of the enforcer in namespace ns for one side of the flow.
+func (s *Simulator) decide(side, ns string, src, dst []*identity) *Decision {
+
+	d := &Decision{Side: side, Matches: []*Match{}}
+
+	var primary, fallback []*Match
+	for _, p := range s.policies {
+
+		if p.Disabled || !appliesTo(p, side, ns) {
+			continue
+		}
+
+		sid, ok := matchIdentities(p.Subject, src)
+		if !ok {
+			continue
+		}
+		oid, ok := matchIdentities(p.Object, dst)
+		if !ok {
+			continue
+		}
+
+		m := &Match{Side: side, Policy: p}
+		if sid.externalNetwork != "" {
+			m.ExternalNetwork = sid.externalNetwork
+		} else {
+			m.ExternalNetwork = oid.externalNetwork
+		}
+
+		d.Matches = append(d.Matches, m)
+		if p.Fallback {
+			fallback = append(fallback, m)
+		} else {
+			primary = append(primary, m)
+		}
+	}
+
+	if action, ok := verdict(primary); ok {
+		d.Action = action
+		return d
+	}
+	if action, ok := verdict(fallback); ok {
+		d.Action = action
+		d.Fallback = true
+		return d
+	}
+
+	d.Action = gaia.NetworkAccessPolicyActionReject
+	d.Default = true
+	return d
+}
+
+// verdict returns the action of a set of matching policies: reject takes precedence over
+// allow. It returns false when no policy allows or rejects.
+func verdict(matches []*Match) (gaia.NetworkAccessPolicyActionValue, bool) {
+
+	allow := false
+	for _, m := range matches {
+		switch m.Policy.Action {
+		case gaia.NetworkAccessPolicyActionReject:
+			return gaia.NetworkAccessPolicyActionReject, true
+		case gaia.NetworkAccessPolicyActionAllow:
+			allow = true
+		}
+	}
+	if allow {
+		return gaia.NetworkAccessPolicyActionAllow, true
+	}
+	return "", false
+}
+
+// appliesTo returns true if the policy is enforced on the side of a flow by an enforcer
+// in namespace ns.
+func appliesTo(p *gaia.NetworkAccessPolicy, side, ns string) bool {
+
+	switch p.ApplyPolicyMode {
+	case gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic:
+		if side != SideOutgoing {
+			return false
+		}
+	case gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic:
+		if side != SideIncoming {
+			return false
+		}
+	}
+
+	pns := utils.SetupNamespaceString(p.Namespace)
+	if p.Propagate {
+		return isUnder(ns, pns)
+	}
+	return ns == pns
+}
+
+// matchIdentities returns the first identity matching the tag expression.
+func matchIdentities(expression [][]string, ids []*identity) (*identity, bool) {
+
+	for _, id := range ids {
+		if matchExpression(expression, id.tags) {
+			return id, true
+		}
+	}
+	return nil, false
+}
+
+// matchExpression returns true if all the tags of one of the clauses of the expression
+// match the tags.
+func matchExpression(expression [][]string, tags []string) bool {
+
+	for _, clause := range expression {
+		if len(clause) == 0 {
+			continue
+		}
+		matched := true
+		for _, tag := range clause {
+			if !matchTag(tag, tags) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTag returns true if one of the tags matches. A tag ending with '*' matches all
+// the tags it prefixes: '$namespace=/acct/zone/tenant/*' matches the children of the tenant.
+func matchTag(tag string, tags []string) bool {
+
+	prefix := strings.TrimSuffix(tag, "*")
+	wildcard := prefix != tag
+	for _, t := range tags {
+		if t == tag || (wildcard && strings.HasPrefix(t, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnder returns true if ns is parent or one of its children.
+func isUnder(ns, parent string) bool {
+
+	parent = utils.SetupNamespaceString(parent)
+	return parent == "/" || ns == parent || strings.HasPrefix(ns, parent+"/")
+}
+
+// extnetServes returns true if the external network covers the protocol and port.
+// Empty protocols or ports cover all protocols or ports.
+func extnetServes(en *gaia.ExternalNetwork, protocol string, port int) bool {
+
+	if len(en.Protocols) > 0 {
+		found := false
+		for _, p := range en.Protocols {
+			if strings.EqualFold(p, protocol) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(en.Ports) == 0 || port == 0 {
+		return true
+	}
+	for _, r := range en.Ports {
+		if inPortRange(r, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// inPortRange returns true if the port is in a port or port range: '80', '1:1024'.
+func inPortRange(r string, port int) bool {
+
+	parts := strings.SplitN(r, ":", 2)
+	min, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(parts[1]); err != nil {
+			return false
+		}
+	}
+	return port >= min && port <= max
+}
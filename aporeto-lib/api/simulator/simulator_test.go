@@ -0,0 +1,244 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+
+	"go.aporeto.io/gaia"
+)
+
+// tenantPolicies returns policies shaped like the default, management, outgoing and
+// disable policies of the tenant package.
+func tenantPolicies() (gaia.NetworkAccessPoliciesList, gaia.ExternalNetworksList) {
+
+	policy := func(namespace, name string, mode gaia.NetworkAccessPolicyApplyPolicyModeValue, action gaia.NetworkAccessPolicyActionValue, subject, object [][]string) *gaia.NetworkAccessPolicy {
+		return &gaia.NetworkAccessPolicy{
+			Namespace:       namespace,
+			Name:            name,
+			ApplyPolicyMode: mode,
+			Action:          action,
+			Propagate:       true,
+			Subject:         subject,
+			Object:          object,
+		}
+	}
+
+	policies := gaia.NetworkAccessPoliciesList{
+		policy("/acct/zone/t1", "accept intra-private", gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic, gaia.NetworkAccessPolicyActionAllow,
+			[][]string{{"$namespace=/acct/zone/t1/private"}},
+			[][]string{{"$namespace=/acct/zone/t1/private"}},
+		),
+		policy("/acct/zone/t1", "management ssh", gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, gaia.NetworkAccessPolicyActionAllow,
+			[][]string{{"$namespace=/acct/zone/t1", "cns-customer:ext:network=all-tcp"}},
+			[][]string{{"$namespace=/acct/zone/t1/*", "cns-customer:ext:hostservice=ssh"}},
+		),
+		policy("/acct/zone/t1", "outgoing all-tcp", gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic, gaia.NetworkAccessPolicyActionAllow,
+			[][]string{{"$namespace=/acct/zone/t1/*"}},
+			[][]string{{"$namespace=/acct/zone/t1", "cns-customer:ext:network=all-tcp"}, {"$identity=processingunit"}},
+		),
+		policy("/acct/zone/t2", "outgoing all-tcp", gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic, gaia.NetworkAccessPolicyActionAllow,
+			[][]string{{"$namespace=/acct/zone/t2/*"}},
+			[][]string{{"$identity=processingunit"}},
+		),
+		policy("/acct", "disable /acct/zone/t2", gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, gaia.NetworkAccessPolicyActionReject,
+			[][]string{{"$namespace=/acct/zone/t2/*"}},
+			[][]string{{"$namespace=/acct/*"}},
+		),
+		policy("/acct/zone/t1", "fallback private", gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic, gaia.NetworkAccessPolicyActionAllow,
+			[][]string{{"app=monitoring"}},
+			[][]string{{"$namespace=/acct/zone/t1/private"}},
+		),
+	}
+	policies[len(policies)-1].Fallback = true
+
+	extnets := gaia.ExternalNetworksList{
+		{
+			Namespace:      "/acct/zone/t1",
+			Name:           "all-tcp",
+			Entries:        []string{"0.0.0.0/0", "::/0"},
+			Ports:          []string{"1:65535"},
+			Protocols:      []string{"tcp"},
+			AssociatedTags: []string{"cns-customer:ext:network=all-tcp"},
+		},
+	}
+
+	return policies, extnets
+}
+
+func TestSimulate(t *testing.T) {
+
+	pu := func(namespace string, tags ...string) Endpoint {
+		return Endpoint{Namespace: namespace, Tags: tags}
+	}
+	ext := func(cidr string) Endpoint {
+		return Endpoint{CIDR: cidr}
+	}
+
+	tests := []struct {
+		name         string
+		flow         Flow
+		wantAllowed  bool
+		wantOutgoing string
+		wantIncoming string
+		wantDefault  bool
+		wantFallback bool
+		wantExtnet   string
+		wantErr      bool
+	}{
+		{
+			name:         "intra private",
+			flow:         Flow{Source: pu("/acct/zone/t1/private"), Destination: pu("/acct/zone/t1/private"), Protocol: "tcp", Port: 80},
+			wantAllowed:  true,
+			wantOutgoing: "Allow",
+			wantIncoming: "Allow",
+		},
+		{
+			name:         "public to private is rejected by default",
+			flow:         Flow{Source: pu("/acct/zone/t1/public"), Destination: pu("/acct/zone/t1/private"), Protocol: "tcp", Port: 80},
+			wantOutgoing: "Allow",
+			wantIncoming: "Reject",
+			wantDefault:  true,
+		},
+		{
+			name:         "fallback policy",
+			flow:         Flow{Source: pu("/acct/zone/t1/public", "app=monitoring"), Destination: pu("/acct/zone/t1/private"), Protocol: "tcp", Port: 80},
+			wantAllowed:  true,
+			wantOutgoing: "Allow",
+			wantIncoming: "Allow",
+			wantFallback: true,
+		},
+		{
+			name:         "outgoing to external ipv4 network",
+			flow:         Flow{Source: pu("/acct/zone/t1/private"), Destination: ext("8.8.8.8"), Protocol: "tcp", Port: 443},
+			wantAllowed:  true,
+			wantOutgoing: "Allow",
+			wantExtnet:   "all-tcp",
+		},
+		{
+			name:         "outgoing to external ipv6 network with udp",
+			flow:         Flow{Source: pu("/acct/zone/t1/private"), Destination: ext("2001:db8::1"), Protocol: "udp", Port: 53},
+			wantOutgoing: "Reject",
+			wantDefault:  true,
+		},
+		{
+			name:         "management from external network",
+			flow:         Flow{Source: ext("10.0.0.0/24"), Destination: pu("/acct/zone/t1/public", "cns-customer:ext:hostservice=ssh"), Protocol: "tcp", Port: 22},
+			wantAllowed:  true,
+			wantIncoming: "Allow",
+			wantExtnet:   "all-tcp",
+		},
+		{
+			name:         "external network not visible from another tenant",
+			flow:         Flow{Source: ext("10.0.0.1"), Destination: pu("/acct/zone/t3/public", "cns-customer:ext:hostservice=ssh"), Protocol: "tcp", Port: 22},
+			wantIncoming: "Reject",
+			wantDefault:  true,
+		},
+		{
+			name:         "disabled tenant is rejected on both sides",
+			flow:         Flow{Source: pu("/acct/zone/t2/private"), Destination: pu("/acct/zone/t1/private"), Protocol: "tcp", Port: 80},
+			wantOutgoing: "Reject",
+			wantIncoming: "Reject",
+		},
+		{
+			name:    "two external ends",
+			flow:    Flow{Source: ext("10.0.0.1"), Destination: ext("10.0.0.2"), Protocol: "tcp", Port: 80},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			flow:    Flow{Source: pu("/acct/zone/t1/private"), Destination: ext("10.0.0.300"), Protocol: "tcp", Port: 80},
+			wantErr: true,
+		},
+	}
+
+	s := New(tenantPolicies())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			r, err := s.Simulate(&tt.flow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Simulate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if r.Allowed != tt.wantAllowed {
+				t.Errorf("Simulate() allowed = %v, want %v", r.Allowed, tt.wantAllowed)
+			}
+
+			isDefault, isFallback, extnet := false, false, ""
+			for _, d := range []struct {
+				decision *Decision
+				want     string
+			}{{r.Outgoing, tt.wantOutgoing}, {r.Incoming, tt.wantIncoming}} {
+				got := ""
+				if d.decision != nil {
+					got = string(d.decision.Action)
+					isDefault = isDefault || d.decision.Default
+					isFallback = isFallback || d.decision.Fallback
+					for _, m := range d.decision.Matches {
+						if m.ExternalNetwork != "" {
+							extnet = m.ExternalNetwork
+						}
+					}
+				}
+				if got != d.want {
+					t.Errorf("Simulate() action = %q, want %q", got, d.want)
+				}
+			}
+
+			if isDefault != tt.wantDefault {
+				t.Errorf("Simulate() default = %v, want %v", isDefault, tt.wantDefault)
+			}
+			if isFallback != tt.wantFallback {
+				t.Errorf("Simulate() fallback = %v, want %v", isFallback, tt.wantFallback)
+			}
+			if extnet != tt.wantExtnet {
+				t.Errorf("Simulate() external network = %q, want %q", extnet, tt.wantExtnet)
+			}
+		})
+	}
+}
+
+func TestFromExport(t *testing.T) {
+
+	export := `{
+		"label": "/acct/zone/t1",
+		"data": {
+			"networkaccesspolicies": [{
+				"name": "outgoing all-tcp",
+				"applyPolicyMode": "OutgoingTraffic",
+				"action": "Allow",
+				"propagate": true,
+				"subject": [["$namespace=/acct/zone/t1/*"]],
+				"object": [["cns-customer:ext:network=all-tcp"]]
+			}],
+			"externalnetworks": [{
+				"name": "all-tcp",
+				"entries": ["0.0.0.0/0"],
+				"protocols": ["tcp"],
+				"associatedTags": ["cns-customer:ext:network=all-tcp"]
+			}]
+		}
+	}`
+
+	s, err := FromExport(strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("FromExport() error = %v", err)
+	}
+
+	r, err := s.Simulate(&Flow{
+		Source:      Endpoint{Namespace: "/acct/zone/t1/public"},
+		Destination: Endpoint{CIDR: "1.1.1.1"},
+		Protocol:    "tcp",
+		Port:        443,
+	})
+	if err != nil {
+		t.Fatalf("Simulate() error = %v", err)
+	}
+	if !r.Allowed {
+		t.Errorf("Simulate() allowed = %v, want true", r.Allowed)
+	}
+}
@@ -0,0 +1,518 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// ArchiveVersion is the version of the archives written by Export.
+const ArchiveVersion = 1
+
+// archived are the identities of the objects archived, in restoration order after the
+// namespaces, with their constructors.
+var archived = []struct {
+	identity elemental.Identity
+	list     func() elemental.Identifiables
+	object   func() elemental.Identifiable
+}{
+	{
+		identity: gaia.NamespaceIdentity,
+		list:     func() elemental.Identifiables { return &gaia.NamespacesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewNamespace() },
+	},
+	{
+		identity: gaia.EnforcerProfileIdentity,
+		list:     func() elemental.Identifiables { return &gaia.EnforcerProfilesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewEnforcerProfile() },
+	},
+	{
+		identity: gaia.EnforcerProfileMappingPolicyIdentity,
+		list:     func() elemental.Identifiables { return &gaia.EnforcerProfileMappingPoliciesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewEnforcerProfileMappingPolicy() },
+	},
+	{
+		identity: gaia.HostServiceIdentity,
+		list:     func() elemental.Identifiables { return &gaia.HostServicesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewHostService() },
+	},
+	{
+		identity: gaia.HostServiceMappingPolicyIdentity,
+		list:     func() elemental.Identifiables { return &gaia.HostServiceMappingPoliciesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewHostServiceMappingPolicy() },
+	},
+	{
+		identity: gaia.ExternalNetworkIdentity,
+		list:     func() elemental.Identifiables { return &gaia.ExternalNetworksList{} },
+		object:   func() elemental.Identifiable { return gaia.NewExternalNetwork() },
+	},
+	{
+		identity: gaia.NetworkAccessPolicyIdentity,
+		list:     func() elemental.Identifiables { return &gaia.NetworkAccessPoliciesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewNetworkAccessPolicy() },
+	},
+	{
+		identity: gaia.APIAuthorizationPolicyIdentity,
+		list:     func() elemental.Identifiables { return &gaia.APIAuthorizationPoliciesList{} },
+		object:   func() elemental.Identifiable { return gaia.NewAPIAuthorizationPolicy() },
+	},
+}
+
+// readOnlyKeys are the keys of the archived objects set by the control plane, removed
+// before the objects are created again.
+var readOnlyKeys = []string{"ID", "namespace", "createTime", "updateTime", "normalizedTags", "zHash", "zone"}
+
+// Archive is a snapshot of a tenant: the objects of its namespace and of its children
+// namespaces, and the policies of the account carrying the metadata of the tenant, like
+// its exception policies and its disable policy.
+type Archive struct {
+	Version    int              `json:"version"`
+	Account    string           `json:"account"`
+	Zone       string           `json:"zone"`
+	Name       string           `json:"name"`
+	CreateTime time.Time        `json:"create-time"`
+	Objects    []*ArchiveObject `json:"objects"`
+}
+
+// ArchiveObject is an object of an archive, as returned by the control plane.
+type ArchiveObject struct {
+	// Identity is the identity of the object, for instance networkaccesspolicy.
+	Identity string `json:"identity"`
+
+	// Namespace is the namespace of the object.
+	Namespace string `json:"namespace"`
+
+	// Data are the attributes of the object.
+	Data map[string]interface{} `json:"data"`
+}
+
+// ReadArchive reads an archive written by Archive.Write.
+func ReadArchive(r io.Reader) (*Archive, error) {
+
+	a := &Archive{}
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	if err := d.Decode(a); err != nil {
+		return nil, fmt.Errorf("unable to read tenant archive: %s", err)
+	}
+	if a.Version != ArchiveVersion {
+		return nil, fmt.Errorf("unsupported tenant archive version %d", a.Version)
+	}
+
+	return a, nil
+}
+
+// Write writes the archive as json.
+func (a *Archive) Write(w io.Writer) error {
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(a)
+}
+
+// Export returns the archive of the tenant. The application credentials are not part of
+// it: their private keys cannot be retrieved.
+func (t *Tenant) Export(ctx context.Context, m manipulate.Manipulator) (a *Archive, ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "tenant-export", t.attributes()...)
+	defer func() { end(ret) }()
+
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
+
+	accountNs := utils.SetupNamespaceString(t.Account)
+	zoneNs := utils.SetupNamespaceString(t.Account, t.Zone)
+	tenantNs := utils.SetupNamespaceString(zoneNs, t.Name)
+
+	a = &Archive{
+		Version:    ArchiveVersion,
+		Account:    strings.Trim(t.Account, "/"),
+		Zone:       t.Zone,
+		Name:       t.Name,
+		CreateTime: time.Now().UTC(),
+	}
+
+	// The objects of the tenant subtree. The tenant namespace itself is in the zone.
+	for _, i := range archived {
+
+		dest := i.list()
+		from := tenantNs
+		if i.identity.Name == gaia.NamespaceIdentity.Name {
+			from = zoneNs
+		}
+
+		if err := retrieveRecursive(ctx, m, from, dest); err != nil {
+			logger.Error("unable to export tenant", logging.Err(err))
+			return nil, fmt.Errorf("unable to retrieve %s of tenant '%s': %s", i.identity.Name, tenantNs, err)
+		}
+
+		for _, o := range dest.List() {
+			n, ok := o.(named)
+			if !ok {
+				continue
+			}
+			path := n.GetNamespace()
+			if i.identity.Name == gaia.NamespaceIdentity.Name {
+				path = n.GetName()
+			}
+			if !within(path, tenantNs) {
+				continue
+			}
+			ao, err := archiveObject(i.identity.Name, n.GetNamespace(), o)
+			if err != nil {
+				return nil, err
+			}
+			a.Objects = append(a.Objects, ao)
+		}
+	}
+
+	// The policies of the account naming the tenant in their metadata.
+	policies, err := networkpolicy.ListRecursive(ctx, m, accountNs)
+	if err != nil {
+		logger.Error("unable to export tenant", logging.Err(err))
+		return nil, fmt.Errorf("unable to retrieve account policies of tenant '%s': %s", tenantNs, err)
+	}
+	tenantMetadata := utils.MetadataTenantKeyVal(tenantNs)
+	for _, p := range policies {
+		if within(p.Namespace, tenantNs) || !contains(p.Metadata, tenantMetadata) {
+			continue
+		}
+		ao, err := archiveObject(gaia.NetworkAccessPolicyIdentity.Name, p.Namespace, p)
+		if err != nil {
+			return nil, err
+		}
+		a.Objects = append(a.Objects, ao)
+	}
+
+	sortObjects(a.Objects)
+
+	logger.Info("tenant exported", logging.Any("objects", len(a.Objects)))
+
+	return a, nil
+}
+
+// Restore creates the objects of the archive again, in the tenant to. The zone and name of
+// to default to the ones of the archive: the tenant can be restored under another name or
+// in another zone of the same account, its namespace in the names, tags and metadata of the
+// objects being rewritten. The account policies are restored in their namespace if it
+// is still a parent of the tenant, in the account namespace otherwise. The zone must
+// exist and the tenant must not. If to.EnforcerAppCredPath is set, new enforcer
+// application credentials are created like by Create. If the restoration fails, the
+// objects it created are deleted so that it can be retried.
+func Restore(ctx context.Context, m manipulate.Manipulator, a *Archive, to *Tenant) (ret error) {
+
+	t := *to
+	if t.Account == "" {
+		t.Account = a.Account
+	}
+	if strings.Trim(t.Account, "/") != strings.Trim(a.Account, "/") {
+		return fmt.Errorf("unable to restore tenant of account '%s' in account '%s'", a.Account, t.Account)
+	}
+	if t.Zone == "" {
+		t.Zone = a.Zone
+	}
+	if t.Name == "" {
+		t.Name = a.Name
+	}
+
+	ctx, end := telemetry.StartOperation(ctx, "tenant-restore", t.attributes()...)
+	defer func() { end(ret) }()
+
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
+
+	fromNs := utils.SetupNamespaceString(a.Account, a.Zone, a.Name)
+	accountNs := utils.SetupNamespaceString(t.Account)
+	tenantNs := utils.SetupNamespaceString(t.Account, t.Zone, t.Name)
+
+	objects := make([]*ArchiveObject, len(a.Objects))
+	copy(objects, a.Objects)
+	sortObjects(objects)
+
+	var created []*restoredObject
+	for _, step := range []string{"namespaces", "objects"} {
+
+		err := telemetry.Step(ctx, step, func(ctx context.Context) error {
+			for _, ao := range objects {
+				if (ao.Identity == gaia.NamespaceIdentity.Name) != (step == "namespaces") {
+					continue
+				}
+				r, err := restoreObject(ctx, m, ao, fromNs, tenantNs, accountNs)
+				if err != nil {
+					return err
+				}
+				created = append(created, r)
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("unable to restore tenant "+step, logging.Err(err))
+			return rollback(ctx, m, created, err)
+		}
+	}
+
+	if t.EnforcerAppCredPath != "" {
+		err := telemetry.Step(ctx, "enforcer-appcreds", func(ctx context.Context) error {
+			return createEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name, t.EnforcerAppCredPath, t.EnforcerAppCredOutput)
+		})
+		if err != nil {
+			logger.Error("unable to create enforcer application credentials", logging.Err(err))
+			return rollback(ctx, m, created, err)
+		}
+	}
+
+	logger.Info("tenant restored", logging.String("from", fromNs), logging.Any("objects", len(objects)))
+
+	return nil
+}
+
+// named is implemented by the archived objects.
+type named interface {
+	GetName() string
+	GetNamespace() string
+}
+
+// retrieveRecursive retrieves the objects of the namespace and of its children.
+func retrieveRecursive(ctx context.Context, m manipulate.Manipulator, namespace string, dest elemental.Identifiables) error {
+
+	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+	defer cancel()
+
+	mctx := manipulate.NewContext(
+		subctx,
+		manipulate.ContextOptionNamespace(namespace),
+		manipulate.ContextOptionRecursive(true),
+	)
+
+	return m.RetrieveMany(mctx, dest)
+}
+
+// archiveObject returns the archived representation of the object.
+func archiveObject(identity, namespace string, o elemental.Identifiable) (*ArchiveObject, error) {
+
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode %s '%s': %s", identity, o.Identifier(), err)
+	}
+
+	ao := &ArchiveObject{Identity: identity, Namespace: namespace}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&ao.Data); err != nil {
+		return nil, fmt.Errorf("unable to encode %s '%s': %s", identity, o.Identifier(), err)
+	}
+
+	return ao, nil
+}
+
+// restoredObject is an object created by Restore, in the namespace it was created in.
+type restoredObject struct {
+	object    elemental.Identifiable
+	namespace string
+}
+
+// rollback deletes the objects created by a failed restoration, the last created first,
+// and returns the error of the restoration with the objects it was unable to delete.
+func rollback(ctx context.Context, m manipulate.Manipulator, created []*restoredObject, err error) error {
+
+	logger := logging.FromContext(ctx)
+
+	var failed []string
+	for i := len(created) - 1; i >= 0; i-- {
+
+		r := created[i]
+		name := r.object.Identifier()
+		if n, ok := r.object.(named); ok {
+			name = n.GetName()
+		}
+
+		subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+		derr := m.Delete(manipulate.NewContext(subctx, manipulate.ContextOptionNamespace(r.namespace)), r.object)
+		cancel()
+
+		if derr != nil {
+			logger.Error("unable to delete restored object", logging.Namespace(r.namespace), logging.String("name", name), logging.Err(derr))
+			failed = append(failed, fmt.Sprintf("%s '%s' in '%s'", r.object.Identity().Name, name, r.namespace))
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("%s, and unable to delete the restored %s", err, strings.Join(failed, ", "))
+	}
+
+	logger.Info("restored objects deleted", logging.Any("objects", len(created)))
+
+	return err
+}
+
+// restoreObject creates the archived object again, with the namespace from rewritten to
+// to in its strings, and returns it. The tenant namespace is created in the parent of to,
+// and the objects outside of the tenant in the account namespace if their namespace is no
+// longer a parent of it.
+func restoreObject(ctx context.Context, m manipulate.Manipulator, ao *ArchiveObject, from, to, accountNs string) (*restoredObject, error) {
+
+	var object elemental.Identifiable
+	for _, i := range archived {
+		if i.identity.Name == ao.Identity {
+			object = i.object()
+		}
+	}
+	if object == nil {
+		return nil, fmt.Errorf("unable to restore object of unknown identity '%s'", ao.Identity)
+	}
+
+	data := rewriteValue(ao.Data, from, to).(map[string]interface{})
+	for _, k := range readOnlyKeys {
+		delete(data, k)
+	}
+
+	namespace := rewrite(ao.Namespace, from, to)
+	switch {
+	case ao.Identity == gaia.NamespaceIdentity.Name && ao.Data["name"] == from:
+		// The tenant namespace is created in its new zone.
+		namespace = to[:strings.LastIndex(to, "/")]
+	case !within(namespace, to) && !within(to, namespace):
+		namespace = accountNs
+	}
+
+	// Namespaces are created with their short name in their parent.
+	if ao.Identity == gaia.NamespaceIdentity.Name {
+		if name, ok := data["name"].(string); ok {
+			data["name"] = name[strings.LastIndex(name, "/")+1:]
+		}
+	}
+
+	js, err := json.Marshal(data)
+	if err == nil {
+		err = json.Unmarshal(js, object)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode archived %s in '%s': %s", ao.Identity, ao.Namespace, err)
+	}
+	object.SetIdentifier("")
+
+	subctx, cancel := context.WithTimeout(ctx, constants.APIDefaultContextTimeout)
+	defer cancel()
+
+	mctx := manipulate.NewContext(
+		subctx,
+		manipulate.ContextOptionNamespace(namespace),
+	)
+
+	if err := m.Create(mctx, object); err != nil {
+		return nil, fmt.Errorf("unable to restore %s '%v' in '%s': %s", ao.Identity, data["name"], namespace, err)
+	}
+
+	return &restoredObject{object: object, namespace: namespace}, nil
+}
+
+// rewriteValue rewrites the namespace from to to in the strings of the json value v.
+func rewriteValue(v interface{}, from, to string) interface{} {
+
+	switch v := v.(type) {
+	case string:
+		return rewrite(v, from, to)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = rewriteValue(v[i], from, to)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k := range v {
+			out[k] = rewriteValue(v[k], from, to)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// rewrite replaces the namespace from with to in s, for instance in the tag
+// $namespace=/account/zone/tenant/public. Longer names starting like from, like
+// /account/zone/tenant2, are kept.
+func rewrite(s, from, to string) string {
+
+	if from == to {
+		return s
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, from)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+
+		end := i + len(from)
+		b.WriteString(s[:i])
+		if end == len(s) || !isNameByte(s[end]) {
+			b.WriteString(to)
+		} else {
+			b.WriteString(from)
+		}
+		s = s[end:]
+	}
+}
+
+// isNameByte returns true if c can be part of a namespace name.
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+// within returns true if namespace is parent or one of its children.
+func within(namespace, parent string) bool {
+	return namespace == parent || strings.HasPrefix(namespace, parent+"/")
+}
+
+func contains(list []string, s string) bool {
+
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortObjects sorts the objects in restoration order: the namespaces parents first,
+// then the other objects by identity, namespace and name.
+func sortObjects(objects []*ArchiveObject) {
+
+	rank := map[string]int{}
+	for i, a := range archived {
+		rank[a.identity.Name] = i
+	}
+
+	name := func(o *ArchiveObject) string {
+		s, _ := o.Data["name"].(string) // nolint
+		return s
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if rank[a.Identity] != rank[b.Identity] {
+			return rank[a.Identity] < rank[b.Identity]
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return name(a) < name(b)
+	})
+}
@@ -0,0 +1,198 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{
+			name: "namespace",
+			s:    "/acct/dmz/t1",
+			want: "/acct/dmz/t9",
+		},
+		{
+			name: "tag",
+			s:    "$namespace=/acct/dmz/t1/*",
+			want: "$namespace=/acct/dmz/t9/*",
+		},
+		{
+			name: "metadata",
+			s:    "@cns-customer:tenant=/acct/dmz/t1",
+			want: "@cns-customer:tenant=/acct/dmz/t9",
+		},
+		{
+			name: "name",
+			s:    "outgoing all-tcp for tenant /acct/dmz/t1",
+			want: "outgoing all-tcp for tenant /acct/dmz/t9",
+		},
+		{
+			name: "other tenant",
+			s:    "$namespace=/acct/dmz/t10",
+			want: "$namespace=/acct/dmz/t10",
+		},
+		{
+			name: "parent",
+			s:    "$namespace=/acct/dmz",
+			want: "$namespace=/acct/dmz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewrite(tt.s, "/acct/dmz/t1", "/acct/dmz/t9"); got != tt.want {
+				t.Errorf("rewrite() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportRestore(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+
+	t1 := &Tenant{
+		Account:          "acct",
+		Zone:             "dmz",
+		Name:             "t1",
+		AuthPolicyClaims: [][]string{{"@auth:realm=oidc", "@auth:group=t1"}},
+	}
+	for _, tn := range []*Tenant{t1, {Account: "acct", Zone: "dmz", Name: "t10"}} {
+		if err := tn.Create(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An exception policy between t1 and t10 in the zone, and one of t10 only.
+	for _, p := range []struct{ name, src, dst string }{
+		{name: "t1 to t10", src: "/acct/dmz/t1", dst: "/acct/dmz/t10"},
+		{name: "t10 to t10", src: "/acct/dmz/t10", dst: "/acct/dmz/t10"},
+	} {
+		err := networkpolicy.Create(
+			ctx, m, "/acct/dmz", p.name, p.name, p.src, p.dst,
+			[][]string{{"$namespace=" + p.src + "/public"}},
+			[][]string{{"$namespace=" + p.dst + "/public"}},
+			gaia.NetworkAccessPolicyApplyPolicyModeBidirectional,
+			gaia.NetworkAccessPolicyActionAllow,
+			true,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := t1.Export(ctx, m)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(a.Objects) != len(t1.Layout())+1 {
+		t.Fatalf("Export() archived %d objects, want %d", len(a.Objects), len(t1.Layout())+1)
+	}
+	if a.Objects[0].Identity != "namespace" || a.Objects[0].Data["name"] != "/acct/dmz/t1" {
+		t.Errorf("Export() first object = %+v, want the tenant namespace", a.Objects[0])
+	}
+
+	buf := &bytes.Buffer{}
+	if err := a.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = ReadArchive(buf); err != nil {
+		t.Fatalf("ReadArchive() error = %v", err)
+	}
+
+	if err := Restore(ctx, m, a, &Tenant{Account: "other", Name: "t9"}); err == nil {
+		t.Errorf("Restore() in another account should fail")
+	}
+	if err := Restore(ctx, m, a, &Tenant{Zone: "sensitive", Name: "t9"}); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	// The layout of the restored tenant is complete.
+	t9 := &Tenant{Account: "acct", Zone: "sensitive", Name: "t9", AuthPolicyClaims: t1.AuthPolicyClaims}
+	for _, o := range t9.Layout() {
+		if m.Get(o.Identity, o.Namespace, o.Name) == nil {
+			t.Errorf("Restore() did not create %s", o)
+		}
+	}
+
+	intra, _ := m.Get("networkaccesspolicy", "/acct/sensitive/t9", "accept intra-public").(*gaia.NetworkAccessPolicy) // nolint
+	if intra == nil || !reflect.DeepEqual(intra.Subject, [][]string{{"$namespace=/acct/sensitive/t9/public"}}) {
+		t.Errorf("Restore() intra-public policy = %+v", intra)
+	}
+
+	// The zone policy is moved to the account, the zone being no longer a parent.
+	exception, _ := m.Get("networkaccesspolicy", "/acct", "t1 to t10").(*gaia.NetworkAccessPolicy) // nolint
+	if exception == nil {
+		t.Fatalf("Restore() did not restore the exception policy in the account")
+	}
+	want := utils.MakeTenantPairMetadata("/acct/sensitive/t9", "/acct/dmz/t10")
+	if !reflect.DeepEqual(exception.Metadata, want) || exception.Subject[0][0] != "$namespace=/acct/sensitive/t9/public" {
+		t.Errorf("Restore() exception policy = %+v", exception)
+	}
+	if exception.Object[0][0] != "$namespace=/acct/dmz/t10/public" {
+		t.Errorf("Restore() rewrote the other tenant: %v", exception.Object)
+	}
+}
+
+func TestRestore_PartialFailure(t *testing.T) {
+
+	ctx := context.Background()
+	m := memmanip.New()
+
+	t1 := &Tenant{Account: "acct", Zone: "dmz", Name: "t1"}
+	if err := t1.Create(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	a, err := t1.Export(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		n := 0
+		for _, i := range archived {
+			n += len(m.Objects(i.identity.Name))
+		}
+		return n
+	}
+	before := count()
+
+	// The policies fail to be created, after the namespaces and the other objects.
+	m.OnCreate = func(_ manipulate.Context, o elemental.Identifiable) error {
+		if o.Identity().Name == gaia.NetworkAccessPolicyIdentity.Name {
+			return errors.New("forbidden")
+		}
+		return nil
+	}
+	if err := Restore(ctx, m, a, &Tenant{Name: "t9"}); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("Restore() error = %v, want forbidden", err)
+	}
+	if n := count(); n != before {
+		t.Errorf("Restore() left %d objects after failing, want none", n-before)
+	}
+
+	// Nothing is left behind: the restoration can be retried.
+	m.OnCreate = nil
+	if err := Restore(ctx, m, a, &Tenant{Name: "t9"}); err != nil {
+		t.Fatalf("Restore() retry error = %v", err)
+	}
+	if n := count(); n != 2*before {
+		t.Errorf("Restore() retry created %d objects, want %d", n-before, before)
+	}
+}
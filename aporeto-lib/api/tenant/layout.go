@@ -0,0 +1,435 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/extnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/authpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/enforcerprofile"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/enforcerprofilemapping"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/hostservice"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/hostservicemapping"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/namespace"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// Steps of the creation of a tenant, in order. Each object of the layout is created by
+// one of them.
+const (
+	StepNamespaces       = "namespaces"
+	StepEnforcerProfiles = "enforcer-profiles"
+	StepHostServices     = "host-services"
+	StepExternalNetworks = "external-networks"
+	StepDefaultPolicies  = "default-policies"
+	StepAuthPolicy       = "auth-policy"
+)
+
+// steps are the steps of the layout with the message logged when they fail.
+var steps = []struct {
+	name    string
+	failure string
+}{
+	{name: StepNamespaces, failure: "unable to create tenant and children namespaces"},
+	{name: StepEnforcerProfiles, failure: "unable to create enforcer profiles"},
+	{name: StepHostServices, failure: "unable to create host service profiles"},
+	{name: StepExternalNetworks, failure: "unable to create external networks"},
+	{name: StepDefaultPolicies, failure: "unable to create default policies"},
+	{name: StepAuthPolicy, failure: "unable to create authorization policy"},
+}
+
+// Object is an object of the default layout of a tenant.
+type Object struct {
+	// Identity is the identity of the object, for instance networkaccesspolicy.
+	Identity string
+
+	// Namespace is the namespace of the object.
+	Namespace string
+
+	// Name is the name of the object. The name of a namespace is its full path, like
+	// the control plane returns it.
+	Name string
+
+	// Step is the step of the tenant creation creating the object.
+	Step string
+
+	create func(ctx context.Context, m manipulate.Manipulator) error
+
+	// reconcile sets the checked attributes on an existing object and returns the ones
+	// that differed. It is nil if no attribute is checked.
+	reconcile func(existing elemental.Identifiable) []string
+}
+
+// Create creates the object.
+func (o *Object) Create(ctx context.Context, m manipulate.Manipulator) error {
+	return o.create(ctx, m)
+}
+
+// Reconcile sets the attributes of the layout on the existing object of the control
+// plane and returns the names of the ones that differed, if any. Only the action, subject
+// and object of the network access policies and the entries of the external networks are
+// checked.
+func (o *Object) Reconcile(existing elemental.Identifiable) []string {
+
+	if o.reconcile == nil {
+		return nil
+	}
+	return o.reconcile(existing)
+}
+
+// String returns the identity, namespace and name of the object.
+func (o *Object) String() string {
+	return fmt.Sprintf("%s '%s' in '%s'", o.Identity, o.Name, o.Namespace)
+}
+
+// Layout returns the objects created with the tenant, in creation order. The enforcer
+// application credentials are not part of it.
+func (t *Tenant) Layout() []*Object {
+
+	zoneNs := utils.SetupNamespaceString(t.Account, t.Zone)
+	tenantNs := utils.SetupNamespaceString(zoneNs, t.Name)
+
+	objects := namespaceObjects(zoneNs, t.Name, t.Description)
+	objects = append(objects, enforcerProfileObjects(tenantNs)...)
+	objects = append(objects, hostServiceObjects(tenantNs)...)
+	objects = append(objects, externalNetworkObjects(t.Account, t.Zone, t.Name)...)
+	objects = append(objects, defaultPolicyObjects(tenantNs)...)
+
+	if len(t.AuthPolicyClaims) != 0 {
+		objects = append(objects, &Object{
+			Identity:  gaia.APIAuthorizationPolicyIdentity.Name,
+			Namespace: tenantNs,
+			Name:      constants.DefaultTenantROAuthPolicy,
+			Step:      StepAuthPolicy,
+			create: func(ctx context.Context, m manipulate.Manipulator) error {
+				return authpolicy.Create(ctx, m, tenantNs, constants.DefaultTenantROAuthPolicy, t.AuthPolicyDescription, t.AuthPolicyClaims)
+			},
+		})
+	}
+
+	return objects
+}
+
+// namespaceObjects returns the tenant namespace in the namespace hierarchy
+// /account/zone/tenant with a description specified in the description parameter, and
+// its children namespaces.
+func namespaceObjects(zoneNamespace, tenant, description string) []*Object {
+
+	tenantNamespace := utils.SetupNamespaceString(zoneNamespace, tenant)
+
+	objects := []*Object{
+		{
+			Identity:  gaia.NamespaceIdentity.Name,
+			Namespace: zoneNamespace,
+			Name:      tenantNamespace,
+			Step:      StepNamespaces,
+			create: func(ctx context.Context, m manipulate.Manipulator) error {
+				if err := namespace.Create(ctx, m, zoneNamespace, tenant, description); err != nil {
+					return fmt.Errorf("unable to create tenant '%s' in zone %s: %s", tenant, zoneNamespace, err.Error())
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, child := range []string{constants.NamespacePublic, constants.NamespaceProtected, constants.NamespacePrivate} {
+		child := child
+		objects = append(objects, &Object{
+			Identity:  gaia.NamespaceIdentity.Name,
+			Namespace: tenantNamespace,
+			Name:      utils.SetupNamespaceString(tenantNamespace, child),
+			Step:      StepNamespaces,
+			create: func(ctx context.Context, m manipulate.Manipulator) error {
+				if err := namespace.Create(ctx, m, tenantNamespace, child, description); err != nil {
+					return fmt.Errorf("unable to create namespace '%s' in tenant '%s': %s", child, tenantNamespace, err.Error())
+				}
+				return nil
+			},
+		})
+	}
+
+	return objects
+}
+
+// enforcerProfileObjects returns the enforcer profiles and their mappings in tenant namespace one each for public, protected and private.
+func enforcerProfileObjects(tenantNs string) []*Object {
+
+	var objects []*Object
+	for _, child := range []string{constants.NamespacePublic, constants.NamespaceProtected, constants.NamespacePrivate} {
+
+		child := child
+		childNs := utils.SetupNamespaceString(tenantNs, child)
+
+		objects = append(objects,
+			&Object{
+				Identity:  gaia.EnforcerProfileIdentity.Name,
+				Namespace: childNs,
+				Name:      child,
+				Step:      StepEnforcerProfiles,
+				create: func(ctx context.Context, m manipulate.Manipulator) error {
+					description := fmt.Sprintf("enforcer profile utilized by all enforcers for tenant %s in %s namespace", tenantNs, child)
+					if err := enforcerprofile.Create(ctx, m, childNs, child, description); err != nil {
+						return fmt.Errorf("unable to create enforcer profile '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+					}
+					return nil
+				},
+			},
+			&Object{
+				Identity:  gaia.EnforcerProfileMappingPolicyIdentity.Name,
+				Namespace: childNs,
+				Name:      child,
+				Step:      StepEnforcerProfiles,
+				create: func(ctx context.Context, m manipulate.Manipulator) error {
+					description := fmt.Sprintf("enforcer profile mapping to map all enforcers for tenant %s in %s namespace", tenantNs, child)
+					if err := enforcerprofilemapping.Create(ctx, m, childNs, child, description); err != nil {
+						return fmt.Errorf("unable to create enforcer profile mapping '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+					}
+					return nil
+				},
+			},
+		)
+	}
+
+	return objects
+}
+
+// hostServiceObjects returns the host services and their mappings in tenant namespace one each for public, protected and private.
+func hostServiceObjects(tenantNs string) []*Object {
+
+	var objects []*Object
+	for _, child := range []string{constants.NamespacePublic, constants.NamespaceProtected, constants.NamespacePrivate} {
+
+		child := child
+		childNs := utils.SetupNamespaceString(tenantNs, child)
+
+		objects = append(objects,
+			&Object{
+				Identity:  gaia.HostServiceIdentity.Name,
+				Namespace: childNs,
+				Name:      constants.ManagementServiceName,
+				Step:      StepHostServices,
+				create: func(ctx context.Context, m manipulate.Manipulator) error {
+					description := fmt.Sprintf("management host service for rail %s in %s namespace", tenantNs, child)
+					if err := hostservice.Create(ctx, m, childNs, constants.ManagementServiceName, description, []string{constants.ManagementServices}, false); err != nil {
+						return fmt.Errorf("unable to create host service '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+					}
+					return nil
+				},
+			},
+			&Object{
+				Identity:  gaia.HostServiceMappingPolicyIdentity.Name,
+				Namespace: childNs,
+				Name:      child,
+				Step:      StepHostServices,
+				create: func(ctx context.Context, m manipulate.Manipulator) error {
+					description := fmt.Sprintf("host service mapping to map all enforcers for tenant %s in %s namespace", tenantNs, child)
+					if err := hostservicemapping.Create(ctx, m, childNs, child, description); err != nil {
+						return fmt.Errorf("unable to create host service mapping '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+					}
+					return nil
+				},
+			},
+		)
+	}
+
+	return objects
+}
+
+// externalNetworkObjects returns the external networks in tenant namespace.
+func externalNetworkObjects(account, zone, tenant string) []*Object {
+
+	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
+
+	var objects []*Object
+	for _, protocol := range []struct{ name, protocol string }{
+		{name: constants.ExternalNetworkAllTCP, protocol: constants.ExternalNetworkProtcolTCP},
+		{name: constants.ExternalNetworkAllUDP, protocol: constants.ExternalNetworkProtcolUDP},
+	} {
+		e := extnetwork.ExternalNetwork{
+			Account:     account,
+			Zone:        zone,
+			Tenant:      tenant,
+			Name:        protocol.name,
+			Description: fmt.Sprintf("default %s external network tenant %s", protocol.name, tenantNs),
+			CIDRs:       []string{constants.ExternalNetworkAnyCIDR, constants.ExternalNetworkAnyCIDRv6},
+			Ports:       []string{constants.ExternalNetworkAllPorts},
+			Protocols:   []string{protocol.protocol},
+		}
+		objects = append(objects, &Object{
+			Identity:  gaia.ExternalNetworkIdentity.Name,
+			Namespace: tenantNs,
+			Name:      e.Name,
+			Step:      StepExternalNetworks,
+			create:    e.Create,
+			reconcile: reconcileExternalNetwork(e.CIDRs),
+		})
+	}
+
+	return objects
+}
+
+// defaultPolicyObjects returns the default policies for a tenant
+func defaultPolicyObjects(tenantNs string) []*Object {
+
+	publicNs := utils.SetupNamespaceString(tenantNs, constants.NamespacePublic)
+	protectedNs := utils.SetupNamespaceString(tenantNs, constants.NamespaceProtected)
+	privateNs := utils.SetupNamespaceString(tenantNs, constants.NamespacePrivate)
+
+	// The default policies all allow their traffic.
+	policy := func(name string, subject, object [][]string, create func(ctx context.Context, m manipulate.Manipulator) error) *Object {
+		return &Object{
+			Identity:  gaia.NetworkAccessPolicyIdentity.Name,
+			Namespace: tenantNs,
+			Name:      name,
+			Step:      StepDefaultPolicies,
+			create:    create,
+			reconcile: reconcilePolicy(gaia.NetworkAccessPolicyActionAllow, subject, object),
+		}
+	}
+
+	var objects []*Object
+	for _, p := range []struct {
+		name, description, what, srcNs, dstNs string
+	}{
+		{"accept intra-public", "unidirectional incoming traffic from public to public", "intra-public", publicNs, publicNs},
+		{"accept from public to protected", "unidirectional incoming traffic from public to protected", "public to protected", publicNs, protectedNs},
+		{"accept from protected to public", "unidirectional incoming traffic from protected to public", "protected to public", protectedNs, publicNs},
+		{"accept intra-protected", "unidirectional incoming traffic from protected to protected", "intra-protected", protectedNs, protectedNs},
+		{"accept from protected to private", "unidirectional incoming traffic from protected to private", "protected to private", protectedNs, privateNs},
+		{"accept from private to protected", "unidirectional incoming traffic from private to protected", "private to protected", privateNs, protectedNs},
+		{"accept intra-private", "unidirectional incoming traffic from private to private", "intra-private", privateNs, privateNs},
+	} {
+		p := p
+		subject, object := intraTenantPolicyTags(p.srcNs, p.dstNs)
+		objects = append(objects, policy(p.name, subject, object, func(ctx context.Context, m manipulate.Manipulator) error {
+			if err := createIntraTenantPolicy(ctx, m, tenantNs, p.name, p.description, p.srcNs, p.dstNs); err != nil {
+				return fmt.Errorf("unable to create %s policy for tenant '%s': %s", p.what, tenantNs, err.Error())
+			}
+			return nil
+		}))
+	}
+
+	// Allow All Management Policy
+	subject, object := mgmtTenantPolicyTags(tenantNs)
+	objects = append(objects, policy(mgmtTenantPolicyName(tenantNs), subject, object, func(ctx context.Context, m manipulate.Manipulator) error {
+		if err := createMgmtTenantPolicy(ctx, m, tenantNs); err != nil {
+			return fmt.Errorf("unable to create allow all management traffic policy for tenant '%s': %s", tenantNs, err.Error())
+		}
+		return nil
+	}))
+
+	// Allow All Outgoing Policies
+	for _, protocol := range []string{constants.ExternalNetworkAllUDP, constants.ExternalNetworkAllTCP} {
+		protocol := protocol
+		subject, object := outgoingTenantPolicyTags(tenantNs, protocol)
+		objects = append(objects, policy(outgoingTenantPolicyName(tenantNs, protocol), subject, object, func(ctx context.Context, m manipulate.Manipulator) error {
+			if err := createDefaultOutgoingTenantPolicy(ctx, m, tenantNs, protocol); err != nil {
+				return fmt.Errorf("unable to create unidirectional allow all outgoing policy for tenant '%s': %s", tenantNs, err.Error())
+			}
+			return nil
+		}))
+	}
+
+	return objects
+}
+
+// DisablePolicy returns the policy created in the account namespace when the tenant is
+// disabled. It is not part of the layout.
+func (t *Tenant) DisablePolicy() *Object {
+
+	tenantNs := utils.SetupNamespaceString(t.Account, t.Zone, t.Name)
+
+	return &Object{
+		Identity:  gaia.NetworkAccessPolicyIdentity.Name,
+		Namespace: utils.SetupNamespaceString(t.Account),
+		Name:      disablePolicyName(tenantNs),
+		create: func(ctx context.Context, m manipulate.Manipulator) error {
+			return createDisablePolicies(ctx, m, t.Account, t.Zone, t.Name, t.Description)
+		},
+	}
+}
+
+// reconcilePolicy returns the reconcile function of a network access policy with the
+// action, subject and object, their clauses and tags in any order.
+func reconcilePolicy(action gaia.NetworkAccessPolicyActionValue, subject, object [][]string) func(elemental.Identifiable) []string {
+
+	return func(existing elemental.Identifiable) []string {
+
+		p, ok := existing.(*gaia.NetworkAccessPolicy)
+		if !ok {
+			return nil
+		}
+
+		var changed []string
+		if p.Action != action {
+			p.Action = action
+			changed = append(changed, "action")
+		}
+		if !reflect.DeepEqual(sortedClauses(p.Subject), sortedClauses(subject)) {
+			p.Subject = subject
+			changed = append(changed, "subject")
+		}
+		if !reflect.DeepEqual(sortedClauses(p.Object), sortedClauses(object)) {
+			p.Object = object
+			changed = append(changed, "object")
+		}
+
+		return changed
+	}
+}
+
+// reconcileExternalNetwork returns the reconcile function of an external network with the
+// entries, in any order.
+func reconcileExternalNetwork(entries []string) func(elemental.Identifiable) []string {
+
+	return func(existing elemental.Identifiable) []string {
+
+		en, ok := existing.(*gaia.ExternalNetwork)
+		if !ok {
+			return nil
+		}
+
+		if reflect.DeepEqual(sorted(en.Entries), sorted(entries)) {
+			return nil
+		}
+		en.Entries = entries
+
+		return []string{"entries"}
+	}
+}
+
+// sorted returns a sorted copy of the list.
+func sorted(list []string) []string {
+
+	out := append([]string{}, list...)
+	sort.Strings(out)
+	return out
+}
+
+// sortedClauses returns a copy of the tag expression with the tags of each clause sorted,
+// then the clauses sorted.
+func sortedClauses(clauses [][]string) [][]string {
+
+	out := make([][]string, len(clauses))
+	for i, c := range clauses {
+		out[i] = sorted(c)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	return out
+}
@@ -3,22 +3,19 @@ package tenant
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"path"
 	"strings"
 
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/appcred"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
-	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/extnetwork"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/authpolicy"
-	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/enforcerprofile"
-	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/enforcerprofilemapping"
-	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/hostservice"
-	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/hostservicemapping"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/namespace"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/networkpolicy"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
 	"go.aporeto.io/gaia"
 	"go.aporeto.io/manipulate"
 )
@@ -36,6 +33,10 @@ type Tenant struct {
 
 	// EnforcerAppCredPath if set to "" will not generate appcreds
 	EnforcerAppCredPath string `json:"enforcer-app-cred-path"`
+
+	// EnforcerAppCredOutput receives the appcreds when EnforcerAppCredPath is "-". It
+	// must be set in that case.
+	EnforcerAppCredOutput io.Writer `json:"-"`
 }
 
 // Create is an implementation of how to create a new tenant which has the following:
@@ -55,61 +56,47 @@ type Tenant struct {
 //               - private can talk to itself
 //  - APIAuthorizationPolicy: tenants for readonly access to their namespace
 //  - AppCreds: one for each rail to provision enforcer one time tokens
-func (t *Tenant) Create(ctx context.Context, m manipulate.Manipulator) error {
+func (t *Tenant) Create(ctx context.Context, m manipulate.Manipulator) (ret error) {
 
-	zoneNamespace := utils.SetupNamespaceString(t.Account, t.Zone)
+	ctx, end := telemetry.StartOperation(ctx, "tenant-create", t.attributes()...)
+	defer func() { end(ret) }()
 
-	// Creation of tenant namespace in a zone alongwith child namespaces public, protected and private.
-	err := createNamespaces(ctx, m, zoneNamespace, t.Name, t.Description)
-	if err != nil {
-		log.Printf("unable to create tenant '%s' and children namespaces: %s\n", t.Name, err.Error())
-		return err
-	}
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
 
-	// Creation of enforcer profiles and enforcer profile mappings
-	err = createEnforcerProfilesAndMappingPolicies(ctx, m, t.Account, t.Zone, t.Name)
-	if err != nil {
-		log.Printf("unable to create enforcer profiles for tenant '%s': %s\n", t.Name, err.Error())
-		return err
-	}
-
-	// Creation of host service profiles and service profile mappings
-	err = createHostServiceAndMappingPolicies(ctx, m, t.Account, t.Zone, t.Name)
-	if err != nil {
-		log.Printf("unable to create host service profiles for tenant '%s': %s\n", t.Name, err.Error())
-		return err
+	// Creation of the tenant layout: the tenant namespace in a zone alongwith child namespaces
+	// public, protected and private, enforcer profiles, host services, external networks,
+	// default policies and the authorization policy.
+	objects := map[string][]*Object{}
+	for _, o := range t.Layout() {
+		objects[o.Step] = append(objects[o.Step], o)
 	}
 
-	// Create external networks.
-	err = createExternalNetworks(ctx, m, t.Account, t.Zone, t.Name)
-	if err != nil {
-		log.Printf("unable to create external networks for tenant '%s': %s\n", t.Name, err.Error())
-		return err
-	}
-
-	// Creation of tenant default policies.
-	err = createDefaultPolicies(ctx, m, t.Account, t.Zone, t.Name, t.Description)
-	if err != nil {
-		log.Printf("unable to create tenant '%s' and children namespaces: %s\n", t.Name, err.Error())
-		return err
-	}
-
-	// Generate tenant namespace
-	tenantNamespace := utils.SetupNamespaceString(zoneNamespace, t.Name)
-
-	if len(t.AuthPolicyClaims) != 0 {
-		err = authpolicy.Create(ctx, m, tenantNamespace, constants.DefaultTenantROAuthPolicy, t.AuthPolicyDescription, t.AuthPolicyClaims)
+	for _, s := range steps {
+		if len(objects[s.name]) == 0 {
+			continue
+		}
+		err := telemetry.Step(ctx, s.name, func(ctx context.Context) error {
+			for _, o := range objects[s.name] {
+				if err := o.Create(ctx, m); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 		if err != nil {
-			log.Printf("unable to create authorization policy for tenant '%s' and children namespaces: %s\n", t.Name, err.Error())
+			logger.Error(s.failure, logging.Err(err))
 			return err
 		}
 	}
 
 	if t.EnforcerAppCredPath != "" {
 		// Creation of tenant Application Credentials to generate enforcer one time token.
-		err = createEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name, t.EnforcerAppCredPath)
+		err := telemetry.Step(ctx, "enforcer-appcreds", func(ctx context.Context) error {
+			return createEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name, t.EnforcerAppCredPath, t.EnforcerAppCredOutput)
+		})
 		if err != nil {
-			log.Printf("unable to create tenant '%s' and children namespaces: %s\n", t.Name, err.Error())
+			logger.Error("unable to create enforcer application credentials", logging.Err(err))
 			return err
 		}
 	}
@@ -118,6 +105,8 @@ func (t *Tenant) Create(ctx context.Context, m manipulate.Manipulator) error {
 	// TODO: get accountid to pass into here.
 	//CreateAWSAutoRegistrationAuth(ctx, m, t.Account, tenantNamespace, t.AuthPolicyDescription, t.AuthPolicyClaims)
 
+	logger.Info("tenant created")
+
 	return nil
 }
 
@@ -125,193 +114,149 @@ func (t *Tenant) Create(ctx context.Context, m manipulate.Manipulator) error {
 //  - Delete AppCreds: no enforcer can register anymore.
 //  - Delete AuthPolicy: tenants cant log into this namespace (Admins can)
 //  - Create NetworkAccessPolicy: no outbound or inbound communication can happen.
-func (t *Tenant) Disable(ctx context.Context, m manipulate.Manipulator) error {
+func (t *Tenant) Disable(ctx context.Context, m manipulate.Manipulator) (ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "tenant-disable", t.attributes()...)
+	defer func() { end(ret) }()
+
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
 
 	zoneNamespace := utils.SetupNamespaceString(t.Account, t.Zone)
 
 	// Delete Application Credentials to register new enforcers.
-	_ = deleteEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name) // nolint
+	_ = telemetry.Step(ctx, "enforcer-appcreds", func(ctx context.Context) error { // nolint
+		return deleteEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name)
+	})
 
 	// Generate tenant namesapace
 	tenantNamespace := utils.SetupNamespaceString(zoneNamespace, t.Name)
 
 	// Delete Read Only Authorization policies for tenants to access their namespace.
-	_ = authpolicy.Delete(ctx, m, tenantNamespace, constants.DefaultTenantROAuthPolicy) // nolint
+	_ = telemetry.Step(ctx, "auth-policy", func(ctx context.Context) error { // nolint
+		return authpolicy.Delete(ctx, m, tenantNamespace, constants.DefaultTenantROAuthPolicy)
+	})
 
 	// Create rules that block traffic from and to this tenant.
-	err := createDisablePolicies(ctx, m, t.Account, t.Zone, t.Name, t.Description)
+	err := telemetry.Step(ctx, "disable-policies", func(ctx context.Context) error {
+		return createDisablePolicies(ctx, m, t.Account, t.Zone, t.Name, t.Description)
+	})
 	if err != nil {
-		log.Printf("unable to create network access policies to disable tenant '%s': %s\n", t.Name, err.Error())
+		logger.Error("unable to create network access policies to disable tenant", logging.Err(err))
 		return err
 	}
 
+	logger.Info("tenant disabled")
+
 	return nil
 }
 
-// Delete is an implementation of how to delete everything related to this tenant.
-//  - Delete Namespace: everything recursively is removed.
-//  - Delete All Tenant Exception Policies.
-func (t *Tenant) Delete(ctx context.Context, m manipulate.Manipulator) error {
+// Enable is an implementation of how to enable a disabled tenant. This reverts Disable:
+//  - Delete NetworkAccessPolicy: communication is allowed again.
+//  - Create AuthPolicy: if the tenant has auth policy claims.
+//  - Create AppCreds: if the tenant has an enforcer appcred path.
+func (t *Tenant) Enable(ctx context.Context, m manipulate.Manipulator) (ret error) {
 
-	zoneNamespace := utils.SetupNamespaceString(t.Account, t.Zone)
+	ctx, end := telemetry.StartOperation(ctx, "tenant-enable", t.attributes()...)
+	defer func() { end(ret) }()
 
-	// Delete the tenant namespace. This will delete all objects in that namespace and children.
-	err := namespace.Delete(ctx, m, zoneNamespace, t.Name)
-	if err != nil {
-		log.Printf("unable to delete zone '%s' namespace in account '%s': %s\n", t.Zone, t.Account, err.Error())
-		return err
-	}
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
 
 	// Delete rules that block traffic from and to this tenant.
-	err = deleteDisablePolicies(ctx, m, t.Account, t.Zone, t.Name)
+	err := telemetry.Step(ctx, "disable-policies", func(ctx context.Context) error {
+		return deleteDisablePolicies(ctx, m, t.Account, t.Zone, t.Name)
+	})
 	if err != nil {
-		log.Printf("unable to delete network access policies to disable tenant '%s': %s\n", t.Name, err.Error())
+		logger.Error("unable to delete network access policies to enable tenant", logging.Err(err))
 		return err
 	}
 
-	// Delete all rules for this tenant at account level which have this tenant.
-	err = deleteTenantPolicies(ctx, m, t.Account, t.Zone, t.Name)
-	if err != nil {
-		log.Printf("unable to delete network access policies to disable tenant '%s': %s\n", t.Name, err.Error())
-		return err
-	}
+	tenantNamespace := utils.SetupNamespaceString(t.Account, t.Zone, t.Name)
 
-	return nil
-}
-
-// createNamespaces creates a tenant namespace in the namespace hierarchy
-// /account/zone/tenant with a description specified in the description parameter.
-// It also creates the children namespaces.
-func createNamespaces(ctx context.Context, m manipulate.Manipulator, zoneNamespace, tenant, description string) error {
-
-	// Create Tenant
-	err := namespace.Create(ctx, m, zoneNamespace, tenant, description)
-	if err != nil {
-		return fmt.Errorf("unable to create tenant '%s' in zone %s: %s", tenant, zoneNamespace, err.Error())
-	}
-
-	tenantNamespace := utils.SetupNamespaceString(zoneNamespace, tenant)
-
-	// Create Public
-	err = namespace.Create(ctx, m, tenantNamespace, constants.NamespacePublic, description)
-	if err != nil {
-		return fmt.Errorf("unable to create namespace '%s' in tenant '%s': %s", constants.NamespacePublic, tenantNamespace, err.Error())
-	}
-
-	// Create Protected
-	err = namespace.Create(ctx, m, tenantNamespace, constants.NamespaceProtected, description)
-	if err != nil {
-		return fmt.Errorf("unable to create namespace '%s' in tenant '%s': %s", constants.NamespaceProtected, tenantNamespace, err.Error())
-	}
-
-	// Create Private
-	err = namespace.Create(ctx, m, tenantNamespace, constants.NamespacePrivate, description)
-	if err != nil {
-		return fmt.Errorf("unable to create namespace '%s' in tenant '%s': %s", constants.NamespacePrivate, tenantNamespace, err.Error())
-	}
-
-	return nil
-}
-
-// createEnforcerProfilesAndMappingPolicies creates a enforcer profiles in tenant namespace one each for public, protected and private.
-func createEnforcerProfilesAndMappingPolicies(ctx context.Context, m manipulate.Manipulator, account, zone, tenant string) error {
-
-	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
-	children := []string{constants.NamespacePublic, constants.NamespaceProtected, constants.NamespacePrivate}
-	for i := range children {
-
-		child := children[i]
-		childNs := utils.SetupNamespaceString(tenantNs, child)
-
-		description := fmt.Sprintf("enforcer profile utilized by all enforcers for tenant %s in %s namespace", tenantNs, child)
-		err := enforcerprofile.Create(ctx, m, childNs, child, description)
+	if len(t.AuthPolicyClaims) != 0 {
+		err = telemetry.Step(ctx, "auth-policy", func(ctx context.Context) error {
+			return authpolicy.Create(ctx, m, tenantNamespace, constants.DefaultTenantROAuthPolicy, t.AuthPolicyDescription, t.AuthPolicyClaims)
+		})
 		if err != nil {
-			return fmt.Errorf("unable to create enforcer profile '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+			logger.Error("unable to create authorization policy", logging.Err(err))
+			return err
 		}
+	}
 
-		description = fmt.Sprintf("enforcer profile mapping to map all enforcers for tenant %s in %s namespace", tenantNs, child)
-		err = enforcerprofilemapping.Create(ctx, m, childNs, child, description)
+	if t.EnforcerAppCredPath != "" {
+		err = telemetry.Step(ctx, "enforcer-appcreds", func(ctx context.Context) error {
+			return createEnforcerAppcreds(ctx, m, t.Account, t.Zone, t.Name, t.EnforcerAppCredPath, t.EnforcerAppCredOutput)
+		})
 		if err != nil {
-			return fmt.Errorf("unable to create enforcer profile mapping '%s' in tenant '%s': %s", child, tenantNs, err.Error())
+			logger.Error("unable to create enforcer application credentials", logging.Err(err))
+			return err
 		}
 	}
 
+	logger.Info("tenant enabled")
+
 	return nil
 }
 
-// createHostServiceAndMappingPolicies creates a host profiles in tenant namespace one each for public, protected and private.
-func createHostServiceAndMappingPolicies(ctx context.Context, m manipulate.Manipulator, account, zone, tenant string) error {
-
-	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
+// Delete is an implementation of how to delete everything related to this tenant.
+//  - Delete Namespace: everything recursively is removed.
+//  - Delete All Tenant Exception Policies.
+func (t *Tenant) Delete(ctx context.Context, m manipulate.Manipulator) (ret error) {
 
-	children := []string{constants.NamespacePublic, constants.NamespaceProtected, constants.NamespacePrivate}
-	for i := range children {
+	ctx, end := telemetry.StartOperation(ctx, "tenant-delete", t.attributes()...)
+	defer func() { end(ret) }()
 
-		child := children[i]
-		childNs := utils.SetupNamespaceString(tenantNs, child)
+	ctx = logging.With(ctx, logging.Account(t.Account), logging.Zone(t.Zone), logging.Tenant(t.Name))
+	logger := logging.FromContext(ctx)
 
-		description := fmt.Sprintf("management host service for rail %s in %s namespace", tenantNs, child)
-		err := hostservice.Create(ctx, m, childNs, constants.ManagementServiceName, description, []string{constants.ManagementServices}, false)
-		if err != nil {
-			return fmt.Errorf("unable to create host service '%s' in tenant '%s': %s", child, tenantNs, err.Error())
-		}
+	zoneNamespace := utils.SetupNamespaceString(t.Account, t.Zone)
 
-		description = fmt.Sprintf("host service mapping to map all enforcers for tenant %s in %s namespace", tenantNs, child)
-		err = hostservicemapping.Create(ctx, m, childNs, child, description)
-		if err != nil {
-			return fmt.Errorf("unable to create host service mapping '%s' in tenant '%s': %s", child, tenantNs, err.Error())
-		}
+	// Delete the tenant namespace. This will delete all objects in that namespace and children.
+	err := telemetry.Step(ctx, "namespace", func(ctx context.Context) error {
+		return namespace.Delete(ctx, m, zoneNamespace, t.Name)
+	})
+	if err != nil {
+		logger.Error("unable to delete tenant namespace", logging.Err(err))
+		return err
 	}
 
-	return nil
-}
-
-// createExternalNetworks creates external networks in tenant namespace.
-func createExternalNetworks(ctx context.Context, m manipulate.Manipulator, account, zone, tenant string) error {
-
-	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
-
-	e := extnetwork.ExternalNetwork{
-		Account:     account,
-		Zone:        zone,
-		Tenant:      tenant,
-		Name:        constants.ExternalNetworkAllTCP,
-		Description: fmt.Sprintf("default %s external network tenant %s", constants.ExternalNetworkAllTCP, tenantNs),
-		CIDRs:       []string{constants.ExternalNetworkAnyCIDR},
-		Ports:       []string{constants.ExternalNetworkAllPorts},
-		Protocols:   []string{constants.ExternalNetworkProtcolTCP},
-	}
-	err := e.Create(ctx, m)
+	// Delete rules that block traffic from and to this tenant.
+	err = telemetry.Step(ctx, "disable-policies", func(ctx context.Context) error {
+		return deleteDisablePolicies(ctx, m, t.Account, t.Zone, t.Name)
+	})
 	if err != nil {
+		logger.Error("unable to delete network access policies of tenant", logging.Err(err))
 		return err
 	}
 
-	e = extnetwork.ExternalNetwork{
-		Account:     account,
-		Zone:        zone,
-		Tenant:      tenant,
-		Name:        constants.ExternalNetworkAllUDP,
-		Description: fmt.Sprintf("default %s external network tenant %s", constants.ExternalNetworkAllUDP, tenantNs),
-		CIDRs:       []string{constants.ExternalNetworkAnyCIDR},
-		Ports:       []string{constants.ExternalNetworkAllPorts},
-		Protocols:   []string{constants.ExternalNetworkProtcolUDP},
-	}
-	err = e.Create(ctx, m)
+	// Delete all rules for this tenant at account level which have this tenant.
+	err = telemetry.Step(ctx, "tenant-policies", func(ctx context.Context) error {
+		return deleteTenantPolicies(ctx, m, t.Account, t.Zone, t.Name)
+	})
 	if err != nil {
+		logger.Error("unable to delete exception policies of tenant", logging.Err(err))
 		return err
 	}
 
+	logger.Info("tenant deleted")
+
 	return nil
 }
 
+// attributes returns the attributes of the spans of the tenant operations.
+func (t *Tenant) attributes() []telemetry.Attribute {
+	return []telemetry.Attribute{
+		{Key: "account", Value: t.Account},
+		{Key: "zone", Value: t.Zone},
+		{Key: "tenant", Value: t.Name},
+	}
+}
+
 // createIntraTenantPolicy creates the specified network access policy to allow traffic within the tenant
 func createIntraTenantPolicy(ctx context.Context, m manipulate.Manipulator, tenantNs, name, description, srcNs, dstNs string) error {
 
-	srcNsTag := "$namespace=" + srcNs
-	srcTag := [][]string{{srcNsTag}}
-
-	dstNsTag := "$namespace=" + dstNs
-	dstTag := [][]string{{dstNsTag}}
+	srcTag, dstTag := intraTenantPolicyTags(srcNs, dstNs)
 
 	return networkpolicy.Create(
 		ctx,
@@ -332,15 +277,9 @@ func createIntraTenantPolicy(ctx context.Context, m manipulate.Manipulator, tena
 // createMgmtTenantPolicy creates the network access policy to allow management traffic (SSH) into the tenant namespace
 func createMgmtTenantPolicy(ctx context.Context, m manipulate.Manipulator, tenantNs string) error {
 
-	srcNsTag := "$namespace=" + tenantNs
-	srcTag := [][]string{{srcNsTag}}
-	srcTag[0] = append(srcTag[0], utils.MakeExternalNetworkAssociatedTags(constants.ExternalNetworkAllTCP)...)
+	srcTag, dstTag := mgmtTenantPolicyTags(tenantNs)
 
-	dstNsWildcardTag := "$namespace=" + utils.SetupNamespaceString(tenantNs, "*")
-	dstTag := [][]string{{dstNsWildcardTag}}
-	dstTag[0] = append(dstTag[0], utils.MakeHostServiceAssociatedTags(constants.ManagementServiceName)...)
-
-	name := fmt.Sprintf("management %s for tenant %s", constants.ManagementServiceName, tenantNs)
+	name := mgmtTenantPolicyName(tenantNs)
 	description := fmt.Sprintf("allow all bidirectional management traffic to/from tenant %s", tenantNs)
 	return networkpolicy.Create(
 		ctx,
@@ -358,100 +297,74 @@ func createMgmtTenantPolicy(ctx context.Context, m manipulate.Manipulator, tenan
 	)
 }
 
-// createDefaultOutgoingTenantPolicy creates a network access policy to allow outgoing traffic from the tenant namespace
-func createDefaultOutgoingTenantPolicy(ctx context.Context, m manipulate.Manipulator, tenantNs string) (ret error) {
+// intraTenantPolicyTags returns the subject and object of the policy between two rails
+// of a tenant.
+func intraTenantPolicyTags(srcNs, dstNs string) (subject, object [][]string) {
+	return [][]string{{"$namespace=" + srcNs}}, [][]string{{"$namespace=" + dstNs}}
+}
 
-	srcNsWildcardTag := "$namespace=" + utils.SetupNamespaceString(tenantNs, "*")
-	srcTag := [][]string{{srcNsWildcardTag}}
-	protocols := []string{constants.ExternalNetworkAllUDP, constants.ExternalNetworkAllTCP}
+// mgmtTenantPolicyTags returns the subject and object of the management policy of the
+// tenant.
+func mgmtTenantPolicyTags(tenantNs string) (subject, object [][]string) {
 
-	for i := range protocols {
-		dstNsTag := "$namespace=" + tenantNs
-		dstTag := [][]string{{dstNsTag}}
-		dstTag[0] = append(dstTag[0], utils.MakeExternalNetworkAssociatedTags(protocols[i])...)
-		dstTag = append(dstTag, []string{"$identity=processingunit"})
+	srcNsTag := "$namespace=" + tenantNs
+	srcTag := [][]string{{srcNsTag}}
+	srcTag[0] = append(srcTag[0], utils.MakeExternalNetworkAssociatedTags(constants.ExternalNetworkAllTCP)...)
 
-		name := fmt.Sprintf("outgoing %s for tenant %s", protocols[i], tenantNs)
-		description := fmt.Sprintf("allow all unidirectional outgoing traffic from tenant %s for %s", tenantNs, protocols[i])
-		err := networkpolicy.Create(
-			ctx,
-			m,
-			tenantNs,
-			name,
-			description,
-			tenantNs,
-			tenantNs,
-			srcTag,
-			dstTag,
-			gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic,
-			gaia.NetworkAccessPolicyActionAllow,
-			false,
-		)
-		if err != nil {
-			if ret == nil {
-				ret = fmt.Errorf("unable to create a default policy for tenant '%s' %s", tenantNs, name)
-			} else {
-				ret = fmt.Errorf("%s and %s", err.Error(), name)
-			}
-		}
-	}
+	dstNsWildcardTag := "$namespace=" + utils.SetupNamespaceString(tenantNs, "*")
+	dstTag := [][]string{{dstNsWildcardTag}}
+	dstTag[0] = append(dstTag[0], utils.MakeHostServiceAssociatedTags(constants.ManagementServiceName)...)
 
-	return ret
+	return srcTag, dstTag
 }
 
-// createDefaultPolicies creates default policies for a tenant
-func createDefaultPolicies(ctx context.Context, m manipulate.Manipulator, account, zone, tenant, description string) error {
-
-	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
-	publicNs := utils.SetupNamespaceString(tenantNs, constants.NamespacePublic)
-	protectedNs := utils.SetupNamespaceString(tenantNs, constants.NamespaceProtected)
-	privateNs := utils.SetupNamespaceString(tenantNs, constants.NamespacePrivate)
+// mgmtTenantPolicyName returns the name of the management policy of the tenant.
+func mgmtTenantPolicyName(tenantNs string) string {
+	return fmt.Sprintf("management %s for tenant %s", constants.ManagementServiceName, tenantNs)
+}
 
-	// Public to Public Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept intra-public", "unidirectional incoming traffic from public to public", publicNs, publicNs); err != nil {
-		return fmt.Errorf("unable to create intra-public policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+// createDefaultOutgoingTenantPolicy creates a network access policy to allow outgoing traffic from the tenant namespace
+// to the external network of the protocol
+func createDefaultOutgoingTenantPolicy(ctx context.Context, m manipulate.Manipulator, tenantNs, protocol string) error {
 
-	// Public to Protected Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept from public to protected", "unidirectional incoming traffic from public to protected", publicNs, protectedNs); err != nil {
-		return fmt.Errorf("unable to create public to protected policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+	srcTag, dstTag := outgoingTenantPolicyTags(tenantNs, protocol)
 
-	// Protected to Public Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept from protected to public", "unidirectional incoming traffic from protected to public", protectedNs, publicNs); err != nil {
-		return fmt.Errorf("unable to create protected to public policy for tenant '%s': %s", tenantNs, err.Error())
-	}
-
-	// Protected to Protected Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept intra-protected", "unidirectional incoming traffic from protected to protected", protectedNs, protectedNs); err != nil {
-		return fmt.Errorf("unable to create intra-protected policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+	name := outgoingTenantPolicyName(tenantNs, protocol)
+	description := fmt.Sprintf("allow all unidirectional outgoing traffic from tenant %s for %s", tenantNs, protocol)
+	return networkpolicy.Create(
+		ctx,
+		m,
+		tenantNs,
+		name,
+		description,
+		tenantNs,
+		tenantNs,
+		srcTag,
+		dstTag,
+		gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic,
+		gaia.NetworkAccessPolicyActionAllow,
+		false,
+	)
+}
 
-	// Protected to Private Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept from protected to private", "unidirectional incoming traffic from protected to private", protectedNs, privateNs); err != nil {
-		return fmt.Errorf("unable to create protected to private policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+// outgoingTenantPolicyTags returns the subject and object of the outgoing policy of the
+// tenant for the protocol.
+func outgoingTenantPolicyTags(tenantNs, protocol string) (subject, object [][]string) {
 
-	// Private to Protected Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept from private to protected", "unidirectional incoming traffic from private to protected", privateNs, protectedNs); err != nil {
-		return fmt.Errorf("unable to create private to protected policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+	srcNsWildcardTag := "$namespace=" + utils.SetupNamespaceString(tenantNs, "*")
+	srcTag := [][]string{{srcNsWildcardTag}}
 
-	// Private to Private Allow
-	if err := createIntraTenantPolicy(ctx, m, tenantNs, "accept intra-private", "unidirectional incoming traffic from private to private", privateNs, privateNs); err != nil {
-		return fmt.Errorf("unable to create intra-private policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+	dstNsTag := "$namespace=" + tenantNs
+	dstTag := [][]string{{dstNsTag}}
+	dstTag[0] = append(dstTag[0], utils.MakeExternalNetworkAssociatedTags(protocol)...)
+	dstTag = append(dstTag, []string{"$identity=processingunit"})
 
-	// Allow All Management Policy
-	if err := createMgmtTenantPolicy(ctx, m, tenantNs); err != nil {
-		return fmt.Errorf("unable to create allow all management traffic policy for tenant '%s': %s", tenantNs, err.Error())
-	}
+	return srcTag, dstTag
+}
 
-	// Allow All Outgoing Policy
-	if err := createDefaultOutgoingTenantPolicy(ctx, m, tenantNs); err != nil {
-		return fmt.Errorf("unable to create unidirectional allow all outgoing policy for tenant '%s': %s", tenantNs, err.Error())
-	}
-	return nil
+// outgoingTenantPolicyName returns the name of the outgoing policy of the tenant for the protocol.
+func outgoingTenantPolicyName(tenantNs, protocol string) string {
+	return fmt.Sprintf("outgoing %s for tenant %s", protocol, tenantNs)
 }
 
 // createDisablePolicies creates disable policies for a tenant
@@ -466,7 +379,7 @@ func createDisablePolicies(ctx context.Context, m manipulate.Manipulator, accoun
 	tenantWildcardNs := utils.SetupNamespaceString(tenantNs, "*")
 	tenantWildcardNsTag := "$namespace=" + tenantWildcardNs
 	tenantWildcardNsTags := [][]string{{tenantWildcardNsTag}}
-	name := "disable " + tenantNs
+	name := disablePolicyName(tenantNs)
 
 	return networkpolicy.Create(
 		ctx,
@@ -489,7 +402,7 @@ func deleteDisablePolicies(ctx context.Context, m manipulate.Manipulator, accoun
 
 	accountNs := utils.SetupNamespaceString(account)
 	tenantNs := utils.SetupNamespaceString(account, zone, tenant)
-	name := "disable " + tenantNs
+	name := disablePolicyName(tenantNs)
 
 	return networkpolicy.Delete(
 		ctx,
@@ -499,7 +412,13 @@ func deleteDisablePolicies(ctx context.Context, m manipulate.Manipulator, accoun
 	)
 }
 
-func write(name string, data []byte, out string) error {
+// disablePolicyName returns the name of the disable policy of the tenant.
+func disablePolicyName(tenantNs string) string {
+	return "disable " + tenantNs
+}
+
+// write writes the data to the file name in the directory out, or to w if out is "-".
+func write(name string, data []byte, out string, w io.Writer) error {
 
 	name = strings.Replace(name, " ", "-", -1)
 
@@ -507,13 +426,17 @@ func write(name string, data []byte, out string) error {
 		return ioutil.WriteFile(path.Join(out, name), data, 0744)
 	}
 
-	fmt.Println(string(data))
+	if w == nil {
+		return fmt.Errorf("unable to write '%s': no output for '-'", name)
+	}
+	_, err := fmt.Fprintln(w, string(data))
 
-	return nil
+	return err
 }
 
 // createEnforcerAppcreds generates application credentials that can be used by CI pipeline to generate enforcer tokens.
-func createEnforcerAppcreds(ctx context.Context, m manipulate.Manipulator, account, zone, tenant, dir string) error {
+// When dir is "-", they are written to w.
+func createEnforcerAppcreds(ctx context.Context, m manipulate.Manipulator, account, zone, tenant, dir string, w io.Writer) error {
 
 	if dir == "" {
 		return fmt.Errorf("no output directory specified")
@@ -538,12 +461,12 @@ func createEnforcerAppcreds(ctx context.Context, m manipulate.Manipulator, accou
 		)
 
 		if errs[i] == nil {
-			errs[i] = write(fmt.Sprintf("enforcer-%s-%s-%s-creds.json", zone, tenant, rail), js, dir)
+			errs[i] = write(fmt.Sprintf("enforcer-%s-%s-%s-creds.json", zone, tenant, rail), js, dir, w)
 		}
 
 		if errs[i] != nil {
 			errFlag = true
-			log.Printf("[error] failed to create application credential for tenant '%s' rail '%s'\n", tenant, rail)
+			logging.FromContext(ctx).Error("failed to create application credential", logging.Rail(rail), logging.Err(errs[i]))
 			continue
 		}
 	}
@@ -0,0 +1,52 @@
+package tenant
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		out     string
+		w       io.Writer
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "directory",
+			out:  dir,
+		},
+		{
+			name: "writer",
+			out:  "-",
+			w:    &bytes.Buffer{},
+			want: "data\n",
+		},
+		{
+			name:    "no writer",
+			out:     "-",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := write("app cred", []byte("data"), tt.out, tt.w); (err != nil) != tt.wantErr {
+				t.Fatalf("write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if buf, ok := tt.w.(*bytes.Buffer); ok && buf.String() != tt.want {
+				t.Errorf("write() wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dir, "app-cred")); err != nil || string(data) != "data" {
+		t.Errorf("write() file = %q, %v", data, err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/libs/namespace"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/internal/utils"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
 	"go.aporeto.io/manipulate"
 )
 
@@ -25,15 +26,29 @@ func New(account, name, description string) *Zone {
 }
 
 // Create is an implementation of how to create a zone namespace.
-func (z *Zone) Create(ctx context.Context, m manipulate.Manipulator) error {
+func (z *Zone) Create(ctx context.Context, m manipulate.Manipulator) (ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "zone-create", z.attributes()...)
+	defer func() { end(ret) }()
 
 	accountNamespace := utils.SetupNamespaceString(z.Account)
 	return namespace.Create(ctx, m, accountNamespace, z.Name, z.Description)
 }
 
 // Delete is an implementation of how to delete a zone namespace.
-func (z *Zone) Delete(ctx context.Context, m manipulate.Manipulator) error {
+func (z *Zone) Delete(ctx context.Context, m manipulate.Manipulator) (ret error) {
+
+	ctx, end := telemetry.StartOperation(ctx, "zone-delete", z.attributes()...)
+	defer func() { end(ret) }()
 
 	accountNamespace := utils.SetupNamespaceString(z.Account)
 	return namespace.Delete(ctx, m, accountNamespace, z.Name)
 }
+
+// attributes returns the attributes of the spans of the zone operations.
+func (z *Zone) attributes() []telemetry.Attribute {
+	return []telemetry.Attribute{
+		{Key: "account", Value: z.Account},
+		{Key: "zone", Value: z.Name},
+	}
+}
@@ -0,0 +1,270 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+// Operations recorded.
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// Record is an audited mutation. Before is empty for a creation and After is empty for
+// a deletion. Error is set when the mutation failed.
+type Record struct {
+	Time                time.Time       `json:"time"`
+	ChangeID            string          `json:"changeID"`
+	Operation           string          `json:"operation"`
+	Identity            string          `json:"identity"`
+	CredentialNamespace string          `json:"credentialNamespace"`
+	Namespace           string          `json:"namespace"`
+	Object              string          `json:"object"`
+	ID                  string          `json:"ID"`
+	Name                string          `json:"name"`
+	Before              json.RawMessage `json:"before,omitempty"`
+	After               json.RawMessage `json:"after,omitempty"`
+	Error               string          `json:"error,omitempty"`
+}
+
+// Options configures an audited manipulator.
+type Options struct {
+	// Identity is who is making the changes, for instance the name of the credential.
+	Identity string
+
+	// CredentialNamespace is the namespace of the credential. It is also the target
+	// namespace of the operations without a namespace in their context.
+	CredentialNamespace string
+
+	// ChangeID identifies all the changes made through the manipulator. A random
+	// identifier is generated if empty.
+	ChangeID string
+
+	// OnSinkError is called with the record the sink failed to write and the error of
+	// the sink. By default, the error is logged with the logger of the context of the
+	// operation.
+	OnSinkError func(r *Record, err error)
+}
+
+// manipulator records the mutations of the manipulator it wraps.
+type manipulator struct {
+	manipulate.Manipulator
+
+	sink Sink
+	opts Options
+}
+
+// NewManipulator returns a manipulator recording every Create, Update and Delete made
+// through m to the sink. The objects it creates with metadata are tagged with the change
+// ID metadata. The operations return the result of the mutation: the errors of the sink
+// are reported to Options.OnSinkError.
+func NewManipulator(m manipulate.Manipulator, sink Sink, opts Options) manipulate.Manipulator {
+
+	if opts.ChangeID == "" {
+		opts.ChangeID = NewChangeID()
+	}
+
+	return &manipulator{
+		Manipulator: m,
+		sink:        sink,
+		opts:        opts,
+	}
+}
+
+// NewChangeID returns a random change ID.
+func NewChangeID() string {
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ChangeIDMetadata returns the metadata entry of a change ID.
+func ChangeIDMetadata(changeID string) string {
+	return constants.MetadataChangeIDKey + changeID
+}
+
+func (m *manipulator) Create(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	addMetadata(object, ChangeIDMetadata(m.opts.ChangeID))
+
+	err := m.Manipulator.Create(mctx, object)
+
+	r := m.record(mctx, OperationCreate, object, err)
+	r.After = body(object)
+
+	return m.write(mctx, r, err)
+}
+
+func (m *manipulator) Update(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	var before json.RawMessage
+	if current := m.retrieve(mctx, object); current != nil {
+		before = body(current)
+	}
+
+	err := m.Manipulator.Update(mctx, object)
+
+	r := m.record(mctx, OperationUpdate, object, err)
+	r.Before = before
+	r.After = body(object)
+
+	return m.write(mctx, r, err)
+}
+
+func (m *manipulator) Delete(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	// The object to delete may only have its identifier: the record describes the
+	// stored object.
+	stored := m.retrieve(mctx, object)
+	if stored == nil {
+		stored = object
+	}
+
+	err := m.Manipulator.Delete(mctx, object)
+
+	r := m.record(mctx, OperationDelete, stored, err)
+	r.Before = body(stored)
+
+	return m.write(mctx, r, err)
+}
+
+// retrieve returns the stored object with the identifier of object, or nil if it can not
+// be retrieved.
+func (m *manipulator) retrieve(mctx manipulate.Context, object elemental.Identifiable) elemental.Identifiable {
+
+	current := newIdentifiable(object)
+	if current == nil {
+		return nil
+	}
+	if err := m.Manipulator.Retrieve(mctx, current); err != nil {
+		return nil
+	}
+	return current
+}
+
+// record returns the record of an operation on the object.
+func (m *manipulator) record(mctx manipulate.Context, operation string, object elemental.Identifiable, err error) *Record {
+
+	r := &Record{
+		Time:                time.Now().UTC(),
+		ChangeID:            m.opts.ChangeID,
+		Operation:           operation,
+		Identity:            m.opts.Identity,
+		CredentialNamespace: m.opts.CredentialNamespace,
+		Namespace:           m.opts.CredentialNamespace,
+		Object:              object.Identity().Name,
+		ID:                  object.Identifier(),
+		Name:                objectName(object),
+	}
+	if mctx != nil && mctx.Namespace() != "" {
+		r.Namespace = mctx.Namespace()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// write writes the record to the sink, reports the error of the sink and returns the
+// error of the operation.
+func (m *manipulator) write(mctx manipulate.Context, r *Record, err error) error {
+
+	serr := m.sink.Write(r)
+	if serr == nil {
+		return err
+	}
+
+	if m.opts.OnSinkError != nil {
+		m.opts.OnSinkError(r, serr)
+		return err
+	}
+
+	ctx := context.Background()
+	if mctx != nil && mctx.Context() != nil {
+		ctx = mctx.Context()
+	}
+	logging.FromContext(ctx).Error(
+		"unable to write audit record",
+		append(
+			[]logging.Field{logging.String("changeID", r.ChangeID), logging.String("operation", r.Operation), logging.Namespace(r.Namespace), logging.Err(serr)},
+			logging.Object(r.Object, r.Name)...,
+		)...,
+	)
+
+	return err
+}
+
+// body returns the json representation of the object.
+func body(object elemental.Identifiable) json.RawMessage {
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// objectName returns the name of the object, if it has one.
+func objectName(object elemental.Identifiable) string {
+
+	v := reflect.Indirect(reflect.ValueOf(object))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Name"); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// addMetadata adds an entry to the metadata of the object, if it has metadata.
+func addMetadata(object elemental.Identifiable, entry string) {
+
+	v := reflect.Indirect(reflect.ValueOf(object))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	f := v.FieldByName("Metadata")
+	if !f.IsValid() || !f.CanSet() || f.Type() != reflect.TypeOf([]string{}) {
+		return
+	}
+
+	md := f.Interface().([]string)
+	for _, e := range md {
+		if e == entry {
+			return
+		}
+	}
+	f.Set(reflect.ValueOf(append(md, entry)))
+}
+
+// newIdentifiable returns an empty object of the same type and identifier as object.
+func newIdentifiable(object elemental.Identifiable) elemental.Identifiable {
+
+	t := reflect.TypeOf(object)
+	if t.Kind() != reflect.Ptr {
+		return nil
+	}
+	o, ok := reflect.New(t.Elem()).Interface().(elemental.Identifiable)
+	if !ok {
+		return nil
+	}
+	o.SetIdentifier(object.Identifier())
+	return o
+}
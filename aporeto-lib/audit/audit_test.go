@@ -0,0 +1,201 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/constants"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+func readRecords(t *testing.T, buf *bytes.Buffer) []*Record {
+
+	records := []*Record{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		r := &Record{}
+		if err := json.Unmarshal([]byte(line), r); err != nil {
+			t.Fatalf("invalid json line %q: %s", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestManipulator(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	m := NewManipulator(memmanip.New(), NewJSONLinesSink(buf), Options{
+		Identity:            "ci",
+		CredentialNamespace: "/acct",
+		ChangeID:            "change-1",
+	})
+
+	mctx := manipulate.NewContext(context.Background(), manipulate.ContextOptionNamespace("/acct/zone/t1"))
+
+	en := gaia.NewExternalNetwork()
+	en.Name = "partner"
+	en.Entries = []string{"10.0.0.0/8"}
+	en.Metadata = []string{constants.MetadataOwnerKeyVal}
+	if err := m.Create(mctx, en); err != nil {
+		t.Fatal(err)
+	}
+
+	wantMetadata := []string{constants.MetadataOwnerKeyVal, "@cns-customer:change-id=change-1"}
+	if !reflect.DeepEqual(en.Metadata, wantMetadata) {
+		t.Errorf("Create() metadata = %v, want %v", en.Metadata, wantMetadata)
+	}
+
+	en.Entries = []string{"10.0.0.0/16"}
+	if err := m.Update(mctx, en); err != nil {
+		t.Fatal(err)
+	}
+	// The deleted object only has its identifier: the record has the stored object.
+	deleted := gaia.NewExternalNetwork()
+	deleted.SetIdentifier(en.Identifier())
+	if err := m.Delete(manipulate.NewContext(context.Background()), deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readRecords(t, buf)
+	if len(records) != 3 {
+		t.Fatalf("records = %d, want 3", len(records))
+	}
+
+	tests := []struct {
+		operation  string
+		namespace  string
+		wantBefore string
+		wantAfter  string
+	}{
+		{OperationCreate, "/acct/zone/t1", "", "10.0.0.0/8"},
+		{OperationUpdate, "/acct/zone/t1", "10.0.0.0/8", "10.0.0.0/16"},
+		{OperationDelete, "/acct", "10.0.0.0/16", ""},
+	}
+	for i, tt := range tests {
+		r := records[i]
+		if r.Operation != tt.operation || r.Namespace != tt.namespace || r.ChangeID != "change-1" ||
+			r.Identity != "ci" || r.CredentialNamespace != "/acct" ||
+			r.Object != "externalnetwork" || r.Name != "partner" || r.ID != en.Identifier() {
+			t.Errorf("record %d = %+v", i, r)
+		}
+		if got := strings.Contains(string(r.Before), tt.wantBefore); tt.wantBefore != "" && !got || tt.wantBefore == "" && len(r.Before) != 0 {
+			t.Errorf("record %d before = %s, want %s", i, r.Before, tt.wantBefore)
+		}
+		if got := strings.Contains(string(r.After), tt.wantAfter); tt.wantAfter != "" && !got || tt.wantAfter == "" && len(r.After) != 0 {
+			t.Errorf("record %d after = %s, want %s", i, r.After, tt.wantAfter)
+		}
+	}
+}
+
+func TestManipulatorErrors(t *testing.T) {
+
+	mctx := manipulate.NewContext(context.Background())
+
+	t.Run("failed operations are recorded", func(t *testing.T) {
+
+		buf := &bytes.Buffer{}
+		mm := memmanip.New()
+		mm.OnCreate = func(manipulate.Context, elemental.Identifiable) error { return errors.New("boom") }
+		m := NewManipulator(mm, NewJSONLinesSink(buf), Options{})
+
+		if err := m.Create(mctx, gaia.NewExternalNetwork()); err == nil || err.Error() != "boom" {
+			t.Errorf("Create() error = %v, want boom", err)
+		}
+		records := readRecords(t, buf)
+		if len(records) != 1 || records[0].Error != "boom" || records[0].ChangeID == "" {
+			t.Errorf("records = %+v", records)
+		}
+	})
+
+	t.Run("sink errors are reported", func(t *testing.T) {
+
+		var reported []string
+		mm := memmanip.New()
+		m := NewManipulator(mm, SinkFunc(func(*Record) error { return errors.New("disk full") }), Options{
+			OnSinkError: func(r *Record, err error) { reported = append(reported, r.Operation+": "+err.Error()) },
+		})
+
+		en := gaia.NewExternalNetwork()
+		en.Name = "partner"
+		if err := m.Create(mctx, en); err != nil {
+			t.Errorf("Create() error = %v, want the result of the creation", err)
+		}
+		if mm.Get("externalnetwork", "", "partner") == nil {
+			t.Errorf("Create() did not create the object")
+		}
+
+		mm.OnCreate = func(manipulate.Context, elemental.Identifiable) error { return errors.New("boom") }
+		if err := m.Create(mctx, gaia.NewExternalNetwork()); err == nil || err.Error() != "boom" {
+			t.Errorf("Create() error = %v, want boom", err)
+		}
+
+		want := []string{"create: disk full", "create: disk full"}
+		if !reflect.DeepEqual(reported, want) {
+			t.Errorf("OnSinkError() = %v, want %v", reported, want)
+		}
+	})
+
+	t.Run("sink errors are logged by default", func(t *testing.T) {
+
+		buf := &bytes.Buffer{}
+		logger, err := logging.New(buf, logging.FormatText, logging.LevelError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := manipulate.NewContext(logging.NewContext(context.Background(), logger))
+
+		m := NewManipulator(memmanip.New(), SinkFunc(func(*Record) error { return errors.New("disk full") }), Options{})
+
+		if err := m.Create(ctx, gaia.NewExternalNetwork()); err != nil {
+			t.Errorf("Create() error = %v", err)
+		}
+		if !strings.Contains(buf.String(), "unable to write audit record") || !strings.Contains(buf.String(), "disk full") {
+			t.Errorf("Create() logged %q", buf.String())
+		}
+	})
+}
+
+// writerFunc is a function used as an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestJSONLinesSink_Err(t *testing.T) {
+
+	fail := false
+	s := NewJSONLinesSink(writerFunc(func(p []byte) (int, error) {
+		if fail {
+			return 0, errors.New("disk full")
+		}
+		return len(p), nil
+	}))
+
+	if err := s.Write(&Record{Operation: OperationCreate}); err != nil || s.Err() != nil {
+		t.Fatalf("Write() error = %v, Err() = %v", err, s.Err())
+	}
+
+	fail = true
+	if err := s.Write(&Record{Operation: OperationUpdate}); err == nil {
+		t.Errorf("Write() should fail")
+	}
+
+	// The error is kept after a successful write.
+	fail = false
+	if err := s.Write(&Record{Operation: OperationDelete}); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	if err := s.Err(); err == nil || err.Error() != "disk full" {
+		t.Errorf("Err() = %v, want disk full", err)
+	}
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink stores audit records.
+type Sink interface {
+	Write(r *Record) error
+}
+
+// SinkFunc is a function used as a sink.
+type SinkFunc func(r *Record) error
+
+// Write calls f.
+func (f SinkFunc) Write(r *Record) error {
+	return f(r)
+}
+
+// JSONLinesSink writes records as json lines. It keeps the first error of its writes so
+// that the records lost can be reported once the changes are made.
+type JSONLinesSink struct {
+	w    io.Writer
+	err  error
+	lock sync.Mutex
+}
+
+// NewJSONLinesSink returns a sink writing records as json lines to w.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// Write writes the record on one line.
+func (s *JSONLinesSink) Write(r *Record) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := json.Marshal(r)
+	if err == nil {
+		_, err = s.w.Write(append(data, '\n'))
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+
+	return err
+}
+
+// Err returns the first error of the writes, if any.
+func (s *JSONLinesSink) Err() error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+// FileSink writes records as json lines to a file.
+type FileSink struct {
+	*JSONLinesSink
+	file *os.File
+}
+
+// NewFileSink returns a sink appending json lines to the file, which is created if needed.
+func NewFileSink(path string) (*FileSink, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		JSONLinesSink: NewJSONLinesSink(f),
+		file:          f,
+	}, nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
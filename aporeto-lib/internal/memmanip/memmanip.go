@@ -0,0 +1,232 @@
+// Package memmanip provides an in-memory manipulator for the tests of the packages
+// creating and reading objects through a manipulate.Manipulator.
+package memmanip
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// ErrNotFound is returned for the objects that are not stored.
+var ErrNotFound = errors.New("not found")
+
+// Manipulator stores the objects in memory by identity like the control plane: the
+// created objects get an identifier and the namespace of the context, and the namespaces
+// get their full path as name. The objects are copied when stored and retrieved.
+//
+// Filters are ignored: RetrieveMany returns all the objects of the identity in the
+// namespace of the context and, if recursive, in its children namespaces.
+type Manipulator struct {
+	manipulate.Manipulator
+
+	// OnCreate, if set, is called before every creation. The creation fails with its
+	// error, if any.
+	OnCreate func(mctx manipulate.Context, object elemental.Identifiable) error
+
+	lock    sync.Mutex
+	objects map[string][]elemental.Identifiable
+	created []string
+	next    int
+}
+
+// New returns an empty manipulator.
+func New() *Manipulator {
+	return &Manipulator{objects: map[string][]elemental.Identifiable{}}
+}
+
+// Create stores a copy of the object.
+func (m *Manipulator) Create(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	if m.OnCreate != nil {
+		if err := m.OnCreate(mctx, object); err != nil {
+			return err
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	setString(object, "Namespace", mctx.Namespace())
+	if ns, ok := object.(*gaia.Namespace); ok {
+		ns.Name = strings.TrimSuffix(mctx.Namespace(), "/") + "/" + ns.Name
+	}
+
+	m.next++
+	object.SetIdentifier(fmt.Sprintf("%d", m.next))
+
+	identity := object.Identity().Name
+	m.objects[identity] = append(m.objects[identity], clone(object))
+	m.created = append(m.created, mctx.Namespace()+" "+identity)
+
+	return nil
+}
+
+// Retrieve retrieves the object with the identifier of object.
+func (m *Manipulator) Retrieve(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	i := m.index(object)
+	if i < 0 {
+		return ErrNotFound
+	}
+	reflect.ValueOf(object).Elem().Set(reflect.ValueOf(m.objects[object.Identity().Name][i]).Elem())
+
+	return nil
+}
+
+// RetrieveMany retrieves the objects of the identity of dest.
+func (m *Manipulator) RetrieveMany(mctx manipulate.Context, dest elemental.Identifiables) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	list := reflect.ValueOf(dest).Elem()
+	for _, o := range m.objects[dest.Identity().Name] {
+		if namespace := getString(o, "Namespace"); namespace == mctx.Namespace() || mctx.Recursive() && within(namespace, mctx.Namespace()) {
+			list.Set(reflect.Append(list, reflect.ValueOf(clone(o))))
+		}
+	}
+
+	return nil
+}
+
+// Update replaces the object with the identifier of object.
+func (m *Manipulator) Update(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	i := m.index(object)
+	if i < 0 {
+		return ErrNotFound
+	}
+	m.objects[object.Identity().Name][i] = clone(object)
+
+	return nil
+}
+
+// Delete deletes the object with the identifier of object.
+func (m *Manipulator) Delete(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	i := m.index(object)
+	if i < 0 {
+		return ErrNotFound
+	}
+	identity := object.Identity().Name
+	m.objects[identity] = append(m.objects[identity][:i], m.objects[identity][i+1:]...)
+
+	return nil
+}
+
+// Objects returns the objects of the identity, in the order of their creation.
+func (m *Manipulator) Objects(identity string) []elemental.Identifiable {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r := []elemental.Identifiable{}
+	for _, o := range m.objects[identity] {
+		r = append(r, clone(o))
+	}
+	return r
+}
+
+// Get returns the object of the identity with the namespace and name, or nil.
+func (m *Manipulator) Get(identity, namespace, name string) elemental.Identifiable {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, o := range m.objects[identity] {
+		if getString(o, "Namespace") == namespace && getString(o, "Name") == name {
+			return clone(o)
+		}
+	}
+	return nil
+}
+
+// Remove removes the object of the identity with the namespace and name, as if it was
+// deleted by someone else.
+func (m *Manipulator) Remove(identity, namespace, name string) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, o := range m.objects[identity] {
+		if getString(o, "Namespace") == namespace && getString(o, "Name") == name {
+			m.objects[identity] = append(m.objects[identity][:i], m.objects[identity][i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Reset removes all the objects.
+func (m *Manipulator) Reset() {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.objects = map[string][]elemental.Identifiable{}
+}
+
+// Created returns the namespace and identity of every creation, in order, as
+// "<namespace> <identity>".
+func (m *Manipulator) Created() []string {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]string{}, m.created...)
+}
+
+// index returns the index of the object with the identifier of object, or -1.
+func (m *Manipulator) index(object elemental.Identifiable) int {
+
+	for i, o := range m.objects[object.Identity().Name] {
+		if o.Identifier() == object.Identifier() {
+			return i
+		}
+	}
+	return -1
+}
+
+// within returns true if namespace is a child of parent.
+func within(namespace, parent string) bool {
+	return parent == "/" || strings.HasPrefix(namespace, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// clone returns a shallow copy of the object.
+func clone(object elemental.Identifiable) elemental.Identifiable {
+
+	v := reflect.New(reflect.TypeOf(object).Elem())
+	v.Elem().Set(reflect.ValueOf(object).Elem())
+	return v.Interface().(elemental.Identifiable)
+}
+
+func getString(object elemental.Identifiable, field string) string {
+
+	if f := reflect.ValueOf(object).Elem().FieldByName(field); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+func setString(object elemental.Identifiable, field, value string) {
+
+	if f := reflect.ValueOf(object).Elem().FieldByName(field); f.IsValid() && f.Kind() == reflect.String && f.CanSet() {
+		f.SetString(value)
+	}
+}
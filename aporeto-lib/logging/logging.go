@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Logger is a structured logger. Adapters for zap and slog are in the zapadapter and
+// slogadapter packages.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+
+	// With returns a logger adding the fields to every message.
+	With(fields ...Field) Logger
+}
+
+// Field is a key value added to a message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any returns a field.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// String returns a field with a string value.
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the error field.
+func Err(err error) Field {
+
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Account returns the account field.
+func Account(account string) Field { return String("account", account) }
+
+// Zone returns the zone field.
+func Zone(zone string) Field { return String("zone", zone) }
+
+// Tenant returns the tenant field.
+func Tenant(tenant string) Field { return String("tenant", tenant) }
+
+// Rail returns the rail field.
+func Rail(rail string) Field { return String("rail", rail) }
+
+// Namespace returns the namespace field.
+func Namespace(namespace string) Field { return String("namespace", namespace) }
+
+// Object returns the fields of an object: its identity, for instance externalnetwork,
+// and its name.
+func Object(identity, name string) []Field {
+	return []Field{String("object", identity), String("name", name)}
+}
+
+// Level is the level of a message.
+type Level int
+
+// Levels.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel returns the level named s: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level '%s': must be debug, info, warn or error", s)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger. The library operations log to the
+// logger of their context.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of the context, or a logger discarding everything.
+func FromContext(ctx context.Context) Logger {
+
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(Logger); ok && l != nil {
+			return l
+		}
+	}
+	return Nop()
+}
+
+// With returns a context carrying the logger of ctx with the fields added.
+func With(ctx context.Context, fields ...Field) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(fields...))
+}
+
+// Nop returns a logger discarding everything.
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...Field) {}
+func (nopLogger) Info(string, ...Field)  {}
+func (nopLogger) Warn(string, ...Field)  {}
+func (nopLogger) Error(string, ...Field) {}
+func (l nopLogger) With(...Field) Logger { return l }
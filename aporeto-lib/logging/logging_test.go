@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		level  Level
+		want   string
+	}{
+		{
+			name:   "text",
+			format: FormatText,
+			level:  LevelInfo,
+			want: "2021-06-01T10:00:00Z INFO  creating tenant account=acct zone=dmz tenant=t1\n" +
+				"2021-06-01T10:00:00Z WARN  overlap account=acct zone=dmz tenant=t1 object=externalnetwork name=\"all tcp\"\n" +
+				"2021-06-01T10:00:00Z ERROR failed account=acct zone=dmz tenant=t1 rail=private error=\"not allowed\"\n",
+		},
+		{
+			name:   "json",
+			format: FormatJSON,
+			level:  LevelWarn,
+			want: `{"time":"2021-06-01T10:00:00Z","level":"warn","msg":"overlap","account":"acct","zone":"dmz","tenant":"t1","object":"externalnetwork","name":"all tcp"}` + "\n" +
+				`{"time":"2021-06-01T10:00:00Z","level":"error","msg":"failed","account":"acct","zone":"dmz","tenant":"t1","rail":"private","error":"not allowed"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			buf := &bytes.Buffer{}
+			l, err := New(buf, tt.format, tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.(*writerLogger).out.now = func() time.Time { return time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC) }
+
+			l = l.With(Account("acct"), Zone("dmz"), Tenant("t1"))
+			l.Debug("hidden")
+			l.Info("creating tenant")
+			l.Warn("overlap", Object("externalnetwork", "all tcp")...)
+			l.Error("failed", Rail("private"), Err(errors.New("not allowed")))
+
+			if got := buf.String(); got != tt.want {
+				t.Errorf("New() logged\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := New(&bytes.Buffer{}, "xml", LevelInfo); err == nil {
+		t.Errorf("New() should fail with an invalid format")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Level
+		wantErr bool
+	}{
+		{in: "debug", want: LevelDebug},
+		{in: "INFO", want: LevelInfo},
+		{in: "warning", want: LevelWarn},
+		{in: "error", want: LevelError},
+		{in: "trace", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLevel(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	if _, ok := FromContext(context.Background()).(nopLogger); !ok {
+		t.Errorf("FromContext() should default to a no-op logger")
+	}
+
+	buf := &bytes.Buffer{}
+	l, _ := New(buf, FormatText, LevelDebug) // nolint
+	ctx := With(NewContext(context.Background(), l), Tenant("t1"))
+	FromContext(ctx).Info("hello")
+
+	if !bytes.Contains(buf.Bytes(), []byte("hello tenant=t1")) {
+		t.Errorf("FromContext() logged %q", buf.String())
+	}
+}
@@ -0,0 +1,43 @@
+//go:build go1.21
+// +build go1.21
+
+package slogadapter
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+)
+
+// logger logs to a slog logger.
+type logger struct {
+	l *slog.Logger
+}
+
+// New returns a logger logging to l.
+func New(l *slog.Logger) logging.Logger {
+	return &logger{l: l}
+}
+
+func (l *logger) Debug(msg string, fields ...logging.Field) { l.log(slog.LevelDebug, msg, fields) }
+func (l *logger) Info(msg string, fields ...logging.Field)  { l.log(slog.LevelInfo, msg, fields) }
+func (l *logger) Warn(msg string, fields ...logging.Field)  { l.log(slog.LevelWarn, msg, fields) }
+func (l *logger) Error(msg string, fields ...logging.Field) { l.log(slog.LevelError, msg, fields) }
+
+func (l *logger) With(fields ...logging.Field) logging.Logger {
+	return &logger{l: l.l.With(attrs(fields)...)}
+}
+
+func (l *logger) log(level slog.Level, msg string, fields []logging.Field) {
+	l.l.Log(context.Background(), level, msg, attrs(fields)...)
+}
+
+func attrs(fields []logging.Field) []interface{} {
+
+	as := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		as = append(as, slog.Any(f.Key, f.Value))
+	}
+	return as
+}
@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the loggers returned by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// writerLogger writes the messages to a writer, one per line.
+type writerLogger struct {
+	out    *output
+	level  Level
+	fields []Field
+}
+
+// output is shared by a logger and the loggers derived from it with With.
+type output struct {
+	w      io.Writer
+	format string
+	now    func() time.Time
+	lock   sync.Mutex
+}
+
+// New returns a logger writing the messages of at least the level to w, one per line,
+// as text (time, level, message then key=value fields) or as json objects.
+func New(w io.Writer, format string, level Level) (Logger, error) {
+
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("invalid log format '%s': must be %s or %s", format, FormatText, FormatJSON)
+	}
+
+	return &writerLogger{
+		out:   &output{w: w, format: format, now: time.Now},
+		level: level,
+	}, nil
+}
+
+func (l *writerLogger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *writerLogger) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *writerLogger) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *writerLogger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+func (l *writerLogger) With(fields ...Field) Logger {
+
+	return &writerLogger{
+		out:    l.out,
+		level:  l.level,
+		fields: append(append([]Field{}, l.fields...), fields...),
+	}
+}
+
+func (l *writerLogger) log(level Level, msg string, fields []Field) {
+
+	if level < l.level {
+		return
+	}
+
+	all := append(append([]Field{}, l.fields...), fields...)
+	t := l.out.now().UTC().Format(time.RFC3339)
+
+	var line []byte
+	switch l.out.format {
+	case FormatJSON:
+		line = jsonLine(t, level, msg, all)
+	default:
+		line = textLine(t, level, msg, all)
+	}
+
+	l.out.lock.Lock()
+	defer l.out.lock.Unlock()
+
+	_, _ = l.out.w.Write(line) // nolint
+}
+
+// textLine formats a message as text.
+func textLine(t string, level Level, msg string, fields []Field) []byte {
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %-5s %s", t, strings.ToUpper(level.String()), msg)
+	for _, f := range fields {
+		b.WriteString(" " + f.Key + "=" + textValue(f.Value))
+	}
+	b.WriteString("\n")
+
+	return []byte(b.String())
+}
+
+// textValue formats a value, quoting it if needed.
+func textValue(v interface{}) string {
+
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// jsonLine formats a message as a json object. The fields come after the time, level and
+// message keys, in order.
+func jsonLine(t string, level Level, msg string, fields []Field) []byte {
+
+	b := &strings.Builder{}
+	b.WriteString("{")
+	writeJSON(b, "time", t)
+	b.WriteString(",")
+	writeJSON(b, "level", level.String())
+	b.WriteString(",")
+	writeJSON(b, "msg", msg)
+	for _, f := range fields {
+		b.WriteString(",")
+		writeJSON(b, f.Key, f.Value)
+	}
+	b.WriteString("}\n")
+
+	return []byte(b.String())
+}
+
+func writeJSON(b *strings.Builder, key string, value interface{}) {
+
+	k, _ := json.Marshal(key) // nolint
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprint(value)) // nolint
+	}
+	b.Write(k)
+	b.WriteString(":")
+	b.Write(v)
+}
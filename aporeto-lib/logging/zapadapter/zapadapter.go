@@ -0,0 +1,34 @@
+package zapadapter
+
+import (
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.uber.org/zap"
+)
+
+// logger logs to a zap logger.
+type logger struct {
+	l *zap.Logger
+}
+
+// New returns a logger logging to l.
+func New(l *zap.Logger) logging.Logger {
+	return &logger{l: l.WithOptions(zap.AddCallerSkip(1))}
+}
+
+func (l *logger) Debug(msg string, fields ...logging.Field) { l.l.Debug(msg, zapFields(fields)...) }
+func (l *logger) Info(msg string, fields ...logging.Field)  { l.l.Info(msg, zapFields(fields)...) }
+func (l *logger) Warn(msg string, fields ...logging.Field)  { l.l.Warn(msg, zapFields(fields)...) }
+func (l *logger) Error(msg string, fields ...logging.Field) { l.l.Error(msg, zapFields(fields)...) }
+
+func (l *logger) With(fields ...logging.Field) logging.Logger {
+	return &logger{l: l.l.With(zapFields(fields)...)}
+}
+
+func zapFields(fields []logging.Field) []zap.Field {
+
+	zfields := make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		zfields = append(zfields, zap.Any(f.Key, f.Value))
+	}
+	return zfields
+}
@@ -2,6 +2,7 @@ package manipctx
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/audit"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
 	"go.aporeto.io/gaia"
 	"go.aporeto.io/manipulate"
 	"go.aporeto.io/manipulate/maniphttp"
@@ -53,38 +56,113 @@ func APICACertPool(ca string) (*x509.CertPool, error) {
 
 // Manipulator creates the manipulator used to process commands. Currently
 // only HTTP manipulator is supported, and a constants.OptionTokenKey field is therefore required.
+// The calls are instrumented with the telemetry package.
 func Manipulator(ctx context.Context, credsPath string) (manipulate.Manipulator, error) {
 
-	if credsPath == "" {
-		return nil, ErrMissingCreds
+	m, _, err := manipulator(ctx, credsPath)
+	return m, err
+}
+
+// AuditedManipulator creates the manipulator like Manipulator and records every
+// mutation made through it to the sink. The identity of the records is the name and
+// namespace of the credential. A random change ID is used if changeID is empty.
+func AuditedManipulator(ctx context.Context, credsPath string, sink audit.Sink, changeID string) (manipulate.Manipulator, error) {
+
+	m, appCred, err := manipulator(ctx, credsPath)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := ioutil.ReadFile(credsPath)
+	return audit.NewManipulator(m, sink, audit.Options{
+		Identity:            appCred.Name,
+		CredentialNamespace: appCred.Namespace,
+		ChangeID:            changeID,
+	}), nil
+}
+
+// Subscriber creates a push subscriber receiving the events of the namespace of the
+// credential and of all its children namespaces.
+func Subscriber(ctx context.Context, credsPath string) (manipulate.Subscriber, error) {
+
+	m, _, err := httpManipulator(ctx, credsPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read credential file: %s", err)
+		return nil, err
 	}
 
-	var appCred *gaia.Credential
-	appCred, tlsConfig, err := midgardclient.ParseCredentials(data)
+	return maniphttp.NewSubscriber(m, maniphttp.SubscriberOptionRecursive(true)), nil
+}
+
+// manipulator creates the instrumented HTTP manipulator and returns the credential it uses.
+func manipulator(ctx context.Context, credsPath string) (manipulate.Manipulator, *gaia.Credential, error) {
+
+	m, appCred, err := httpManipulator(ctx, credsPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse credential: %s", err)
+		return nil, nil, err
 	}
 
-	token, err := midgardclient.
-		NewClientWithTLS(appCred.APIURL, tlsConfig).
-		IssueFromCertificate(context.Background(), 2*time.Hour)
+	return telemetry.NewManipulator(m), appCred, nil
+}
+
+// Identity authenticates with the credential and returns its name, the identity of the
+// user of the credential.
+func Identity(ctx context.Context, credsPath string) (string, error) {
+
+	appCred, _, _, err := authenticate(credsPath)
+	if err != nil {
+		return "", err
+	}
+
+	return appCred.Name, nil
+}
+
+// httpManipulator creates the HTTP manipulator and returns the credential it uses.
+func httpManipulator(ctx context.Context, credsPath string) (manipulate.Manipulator, *gaia.Credential, error) {
+
+	appCred, tlsConfig, token, err := authenticate(credsPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get token from app creds: %s", err)
+		return nil, nil, err
 	}
 
 	subctx, cancel := context.WithTimeout(ctx, apiContextTimeout)
 	defer cancel()
 
-	return maniphttp.New(
+	m, err := maniphttp.New(
 		subctx,
 		appCred.APIURL,
 		maniphttp.OptionNamespace(appCred.Namespace),
 		maniphttp.OptionTLSConfig(tlsConfig),
 		maniphttp.OptionToken(token),
 	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, appCred, nil
+}
+
+// authenticate reads the credential and gets a token with its certificate.
+func authenticate(credsPath string) (*gaia.Credential, *tls.Config, string, error) {
+
+	if credsPath == "" {
+		return nil, nil, "", ErrMissingCreds
+	}
+
+	data, err := ioutil.ReadFile(credsPath)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unable to read credential file: %s", err)
+	}
+
+	appCred, tlsConfig, err := midgardclient.ParseCredentials(data)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unable to parse credential: %s", err)
+	}
+
+	token, err := midgardclient.
+		NewClientWithTLS(appCred.APIURL, tlsConfig).
+		IssueFromCertificate(context.Background(), 2*time.Hour)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("unable to get token from app creds: %s", err)
+	}
+
+	return appCred, tlsConfig, token, nil
 }
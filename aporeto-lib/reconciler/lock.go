@@ -0,0 +1,63 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultLockInterval is the interval between two attempts to acquire a lock.
+const DefaultLockInterval = 5 * time.Second
+
+// Lock is an exclusive lock on a file. The reconcilers of an account sharing the file
+// elect their leader with it: only the process holding the lock reconciles.
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock waits until it holds the lock on the file at path, creating the file if
+// needed, or until ctx is canceled. It tries to acquire it every interval.
+func AcquireLock(ctx context.Context, path string, interval time.Duration) (*Lock, error) {
+
+	if interval == 0 {
+		interval = DefaultLockInterval
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file '%s': %s", path, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := tryLock(file)
+		if err != nil {
+			file.Close() // nolint
+			return nil, fmt.Errorf("unable to lock '%s': %s", path, err)
+		}
+		if ok {
+			return &Lock{file: file}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close() // nolint
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+
+	if err := unlock(l.file); err != nil {
+		l.file.Close() // nolint
+		return fmt.Errorf("unable to unlock '%s': %s", l.file.Name(), err)
+	}
+
+	return l.file.Close()
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+package reconciler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "reconciler.lock")
+
+	leader, err := AcquireLock(context.Background(), path, time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireLock() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLock(ctx, path, time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("AcquireLock() of a held lock error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The follower becomes the leader once the lock is released.
+	acquired := make(chan error)
+	go func() {
+		l, err := AcquireLock(context.Background(), path, time.Millisecond)
+		if err == nil {
+			err = l.Release()
+		}
+		acquired <- err
+	}()
+
+	if err := leader.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("AcquireLock() after release error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("AcquireLock() did not acquire the released lock")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package reconciler
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive lock on file without waiting. It returns false if another
+// open file holds it.
+func tryLock(file *os.File) (bool, error) {
+
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// unlock releases the lock on file.
+func unlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package reconciler
+
+import (
+	"errors"
+	"os"
+)
+
+// errLockUnsupported is returned as the file locks are not implemented on Windows.
+var errLockUnsupported = errors.New("file locks are not supported on windows")
+
+func tryLock(file *os.File) (bool, error) {
+	return false, errLockUnsupported
+}
+
+func unlock(file *os.File) error {
+	return errLockUnsupported
+}
@@ -0,0 +1,75 @@
+package reconciler
+
+import (
+	"context"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+// pushSource is a Source receiving the push events of the control plane.
+type pushSource struct {
+	s manipulate.Subscriber
+}
+
+// NewPushSource returns a Source notifying the push events of the layout identities
+// received by s. The subscriber must be recursive to receive the events of the tenants.
+// A reconnection is notified like a change as events may have been missed.
+func NewPushSource(s manipulate.Subscriber) Source {
+	return &pushSource{s: s}
+}
+
+// Start implements Source.
+func (p *pushSource) Start(ctx context.Context) <-chan struct{} {
+
+	logger := logging.FromContext(ctx)
+
+	filter := elemental.NewPushFilter()
+	for _, i := range identities {
+		filter.FilterIdentity(i.identity.Name)
+	}
+
+	changes := make(chan struct{}, 1)
+	p.s.Start(ctx, filter)
+
+	go func() {
+		defer close(changes)
+
+		for {
+			select {
+
+			case <-ctx.Done():
+				return
+
+			case <-p.s.Events():
+				notify(changes)
+
+			case err := <-p.s.Errors():
+				logger.Warn("push channel error", logging.Err(err))
+
+			case status := <-p.s.Status():
+				switch status {
+				case manipulate.SubscriberStatusReconnection:
+					logger.Info("push channel reconnected")
+					notify(changes)
+				case manipulate.SubscriberStatusDisconnection:
+					logger.Warn("push channel disconnected")
+				case manipulate.SubscriberStatusFinalDisconnection:
+					return
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+// notify sends a change to changes unless one is already pending.
+func notify(changes chan struct{}) {
+
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,343 @@
+// Package reconciler keeps the tenants of an account in their default layout: it watches
+// the objects created with the tenants, reports the ones missing from the layout computed
+// by tenant.Layout or whose checked attributes differ from it and, in repair mode, creates
+// or updates them again.
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/tenant"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// Defaults of the options.
+const (
+	DefaultResync   = 10 * time.Minute
+	DefaultDebounce = 5 * time.Second
+)
+
+// identities are the identities of the objects of the tenant layouts, with the lists
+// retrieving them.
+var identities = []struct {
+	identity elemental.Identity
+	list     func() elemental.Identifiables
+}{
+	{identity: gaia.NamespaceIdentity, list: func() elemental.Identifiables { return &gaia.NamespacesList{} }},
+	{identity: gaia.EnforcerProfileIdentity, list: func() elemental.Identifiables { return &gaia.EnforcerProfilesList{} }},
+	{identity: gaia.EnforcerProfileMappingPolicyIdentity, list: func() elemental.Identifiables { return &gaia.EnforcerProfileMappingPoliciesList{} }},
+	{identity: gaia.HostServiceIdentity, list: func() elemental.Identifiables { return &gaia.HostServicesList{} }},
+	{identity: gaia.HostServiceMappingPolicyIdentity, list: func() elemental.Identifiables { return &gaia.HostServiceMappingPoliciesList{} }},
+	{identity: gaia.ExternalNetworkIdentity, list: func() elemental.Identifiables { return &gaia.ExternalNetworksList{} }},
+	{identity: gaia.NetworkAccessPolicyIdentity, list: func() elemental.Identifiables { return &gaia.NetworkAccessPoliciesList{} }},
+	{identity: gaia.APIAuthorizationPolicyIdentity, list: func() elemental.Identifiables { return &gaia.APIAuthorizationPoliciesList{} }},
+}
+
+// Options are the options of the reconciler.
+type Options struct {
+	// Logger logs the deviations and the passes. Nothing is logged if nil.
+	Logger logging.Logger
+
+	// Account is the account whose tenants are reconciled.
+	Account string
+
+	// Tenants are the tenants whose configuration is known, for instance their
+	// authorization policy claims. The other tenants of the account are discovered from
+	// the namespaces /account/zone/tenant and have no authorization policy. Only the
+	// configured tenants are recreated when their namespace is deleted.
+	Tenants []*tenant.Tenant
+
+	// Repair creates the missing objects and updates the mismatched ones. They are only
+	// reported otherwise.
+	Repair bool
+
+	// Resync is the interval of the full passes run without events.
+	Resync time.Duration
+
+	// Debounce is how long the reconciler waits after an event for the next ones before
+	// running a pass.
+	Debounce time.Duration
+}
+
+// Deviation is an object of the layout of a tenant missing from the control plane, or
+// whose attributes differ from the layout.
+type Deviation struct {
+	// Tenant is the namespace of the tenant.
+	Tenant string
+
+	// Object is the missing or mismatched object.
+	Object *tenant.Object
+
+	// Mismatch are the attributes of the existing object that differ from the layout. The
+	// object is missing if empty.
+	Mismatch []string
+
+	// Repaired is true if the object was created or updated again.
+	Repaired bool
+
+	// Err is the error of the repair.
+	Err error
+}
+
+// String returns a description of the deviation.
+func (d *Deviation) String() string {
+
+	what := "missing " + d.Object.String()
+	if len(d.Mismatch) != 0 {
+		what = fmt.Sprintf("mismatched %s of %s", strings.Join(d.Mismatch, ", "), d.Object)
+	}
+
+	switch {
+	case d.Repaired:
+		return fmt.Sprintf("tenant '%s': repaired %s", d.Tenant, what)
+	case d.Err != nil:
+		return fmt.Sprintf("tenant '%s': unable to repair %s: %s", d.Tenant, what, d.Err)
+	default:
+		return fmt.Sprintf("tenant '%s': %s", d.Tenant, what)
+	}
+}
+
+// Source notifies the changes of the objects of the tenant layouts.
+type Source interface {
+	// Start starts watching the objects. The returned channel receives a value after
+	// changes and is closed when ctx is canceled or when the source stops.
+	Start(ctx context.Context) <-chan struct{}
+}
+
+// Reconciler reconciles the tenants of an account with their default layout.
+type Reconciler struct {
+	m    manipulate.Manipulator
+	opts Options
+}
+
+// New returns a reconciler reading and repairing the objects with m.
+func New(m manipulate.Manipulator, opts Options) *Reconciler {
+
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop()
+	}
+	if opts.Resync == 0 {
+		opts.Resync = DefaultResync
+	}
+	if opts.Debounce == 0 {
+		opts.Debounce = DefaultDebounce
+	}
+	opts.Account = "/" + strings.Trim(opts.Account, "/")
+
+	return &Reconciler{m: m, opts: opts}
+}
+
+// Run runs a pass, then a pass after the changes notified by source and every resync
+// interval, until ctx is canceled. The passes failing are logged and retried at the
+// next change or resync.
+func (r *Reconciler) Run(ctx context.Context, source Source) error {
+
+	ctx = logging.NewContext(ctx, r.opts.Logger.With(logging.Account(r.opts.Account)))
+
+	changes := source.Start(ctx)
+	r.pass(ctx)
+
+	resync := time.NewTicker(r.opts.Resync)
+	defer resync.Stop()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+
+		case <-ctx.Done():
+			return nil
+
+		case _, ok := <-changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("unable to watch account '%s': event source stopped", r.opts.Account)
+			}
+			if debounce == nil {
+				debounce = time.After(r.opts.Debounce)
+			}
+
+		case <-debounce:
+			debounce = nil
+			r.pass(ctx)
+
+		case <-resync.C:
+			debounce = nil
+			r.pass(ctx)
+		}
+	}
+}
+
+// pass runs Reconcile and logs its outcome.
+func (r *Reconciler) pass(ctx context.Context) {
+
+	logger := logging.FromContext(ctx)
+
+	deviations, err := r.Reconcile(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("unable to reconcile tenants", logging.Err(err))
+		}
+		return
+	}
+
+	for _, d := range deviations {
+		fields := append(
+			[]logging.Field{logging.Tenant(d.Tenant), logging.Namespace(d.Object.Namespace)},
+			logging.Object(d.Object.Identity, d.Object.Name)...,
+		)
+		if len(d.Mismatch) != 0 {
+			fields = append(fields, logging.String("mismatch", strings.Join(d.Mismatch, ",")))
+		}
+		switch {
+		case d.Repaired:
+			logger.Info("tenant layout object repaired", fields...)
+		case d.Err != nil:
+			logger.Error("unable to repair tenant layout object", append(fields, logging.Err(d.Err))...)
+		case len(d.Mismatch) != 0:
+			logger.Warn("tenant layout object mismatched", fields...)
+		default:
+			logger.Warn("tenant layout object missing", fields...)
+		}
+	}
+
+	logger.Debug("tenants reconciled", logging.Any("deviations", len(deviations)))
+}
+
+// Reconcile compares the objects of the account with the layouts of its tenants once and
+// returns the deviations, in the order of the tenants and of their layouts. The existing
+// objects are compared with the attributes checked by tenant.Object.Reconcile. In repair
+// mode, the missing objects are created and the mismatched ones updated in that order.
+func (r *Reconciler) Reconcile(ctx context.Context) ([]*Deviation, error) {
+
+	existing, namespaces, err := r.retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var deviations []*Deviation
+	for _, t := range r.tenants(namespaces) {
+
+		// A disabled tenant has no authorization policy.
+		disabled := existing[key(t.DisablePolicy())] != nil
+		tenantNs := "/" + strings.Trim(strings.Join([]string{t.Account, t.Zone, t.Name}, "/"), "/")
+
+		for _, o := range t.Layout() {
+			if disabled && o.Step == tenant.StepAuthPolicy {
+				continue
+			}
+
+			current := existing[key(o)]
+			if current == nil {
+				d := &Deviation{Tenant: tenantNs, Object: o}
+				if r.opts.Repair {
+					if d.Err = o.Create(ctx, r.m); d.Err == nil {
+						d.Repaired = true
+					}
+				}
+				deviations = append(deviations, d)
+				continue
+			}
+
+			mismatch := o.Reconcile(current)
+			if len(mismatch) == 0 {
+				continue
+			}
+			d := &Deviation{Tenant: tenantNs, Object: o, Mismatch: mismatch}
+			if r.opts.Repair {
+				mctx := manipulate.NewContext(ctx, manipulate.ContextOptionNamespace(o.Namespace))
+				if d.Err = r.m.Update(mctx, current); d.Err == nil {
+					d.Repaired = true
+				}
+			}
+			deviations = append(deviations, d)
+		}
+	}
+
+	return deviations, nil
+}
+
+// objectKey identifies an object of the control plane.
+type objectKey struct {
+	identity  string
+	namespace string
+	name      string
+}
+
+func key(o *tenant.Object) objectKey {
+	return objectKey{identity: o.Identity, namespace: o.Namespace, name: o.Name}
+}
+
+// named is implemented by the objects of the layouts.
+type named interface {
+	GetName() string
+	GetNamespace() string
+}
+
+// retrieve returns the objects of the layout identities in the account and its children
+// namespaces, and the names of these namespaces.
+func (r *Reconciler) retrieve(ctx context.Context) (map[objectKey]elemental.Identifiable, []string, error) {
+
+	mctx := manipulate.NewContext(
+		ctx,
+		manipulate.ContextOptionNamespace(r.opts.Account),
+		manipulate.ContextOptionRecursive(true),
+	)
+
+	existing := map[objectKey]elemental.Identifiable{}
+	var namespaces []string
+	for _, i := range identities {
+
+		dest := i.list()
+		if err := r.m.RetrieveMany(mctx, dest); err != nil {
+			return nil, nil, fmt.Errorf("unable to retrieve %s in account '%s': %s", i.identity.Name, r.opts.Account, err)
+		}
+
+		for _, o := range dest.List() {
+			n, ok := o.(named)
+			if !ok {
+				continue
+			}
+			existing[objectKey{identity: i.identity.Name, namespace: n.GetNamespace(), name: n.GetName()}] = o
+			if i.identity.Name == gaia.NamespaceIdentity.Name {
+				namespaces = append(namespaces, n.GetName())
+			}
+		}
+	}
+
+	return existing, namespaces, nil
+}
+
+// tenants returns the configured tenants and the ones discovered in namespaces.
+func (r *Reconciler) tenants(namespaces []string) []*tenant.Tenant {
+
+	configured := map[string]bool{}
+	tenants := append([]*tenant.Tenant{}, r.opts.Tenants...)
+	for _, t := range tenants {
+		configured[strings.Trim(t.Zone, "/")+"/"+strings.Trim(t.Name, "/")] = true
+	}
+
+	prefix := r.opts.Account + "/"
+	for _, ns := range namespaces {
+
+		if !strings.HasPrefix(ns, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(ns, prefix), "/")
+		if len(parts) != 2 || configured[parts[0]+"/"+parts[1]] {
+			continue
+		}
+
+		configured[parts[0]+"/"+parts[1]] = true
+		tenants = append(tenants, &tenant.Tenant{Account: r.opts.Account, Zone: parts[0], Name: parts[1]})
+	}
+
+	return tenants
+}
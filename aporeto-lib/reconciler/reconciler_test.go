@@ -0,0 +1,264 @@
+package reconciler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/tenant"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// chanSource is a Source notifying the values sent to it.
+type chanSource chan struct{}
+
+func (s chanSource) Start(context.Context) <-chan struct{} {
+	return s
+}
+
+// tamper changes the attributes checked by the reconciler of an intra-tenant policy and of
+// an external network of the tenant t1.
+func tamper(t *testing.T, m *memmanip.Manipulator) {
+
+	mctx := manipulate.NewContext(context.Background())
+
+	p := m.Get("networkaccesspolicy", "/acct/dmz/t1", "accept intra-private").(*gaia.NetworkAccessPolicy)
+	p.Action = gaia.NetworkAccessPolicyActionReject
+	p.Object = [][]string{{"$namespace=/acct"}}
+	if err := m.Update(mctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	en := m.Get("externalnetwork", "/acct/dmz/t1", "all-tcp").(*gaia.ExternalNetwork)
+	en.Entries = []string{"10.0.0.0/8"}
+	if err := m.Update(mctx, en); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTenant() *tenant.Tenant {
+
+	return &tenant.Tenant{
+		Account:          "/acct",
+		Zone:             "dmz",
+		Name:             "t1",
+		Description:      "tenant t1",
+		AuthPolicyClaims: [][]string{{"@auth:realm=oidc", "@auth:group=t1"}},
+	}
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+
+	const (
+		tenantNs  = "/acct/dmz/t1"
+		privateNs = "/acct/dmz/t1/private"
+	)
+
+	tests := []struct {
+		name       string
+		configured bool
+		repair     bool
+		change     func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant)
+		want       []string
+	}{
+		{
+			name:       "no deviation",
+			configured: true,
+			change:     func(*testing.T, *memmanip.Manipulator, *tenant.Tenant) {},
+		},
+		{
+			name:       "missing objects",
+			configured: true,
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				for _, o := range [][3]string{
+					{"hostservice", privateNs, "ssh"},
+					{"networkaccesspolicy", tenantNs, "accept intra-private"},
+					{"apiauthorizationpolicy", tenantNs, "default"},
+				} {
+					if err := m.Remove(o[0], o[1], o[2]); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			want: []string{
+				"tenant '/acct/dmz/t1': missing hostservice 'ssh' in '/acct/dmz/t1/private'",
+				"tenant '/acct/dmz/t1': missing networkaccesspolicy 'accept intra-private' in '/acct/dmz/t1'",
+				"tenant '/acct/dmz/t1': missing apiauthorizationpolicy 'default' in '/acct/dmz/t1'",
+			},
+		},
+		{
+			name:       "repaired objects",
+			configured: true,
+			repair:     true,
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				if err := m.Remove("externalnetwork", tenantNs, "all-udp"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{
+				"tenant '/acct/dmz/t1': repaired missing externalnetwork 'all-udp' in '/acct/dmz/t1'",
+			},
+		},
+		{
+			name:       "mismatched attributes",
+			configured: true,
+			change:     func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) { tamper(t, m) },
+			want: []string{
+				"tenant '/acct/dmz/t1': mismatched entries of externalnetwork 'all-tcp' in '/acct/dmz/t1'",
+				"tenant '/acct/dmz/t1': mismatched action, object of networkaccesspolicy 'accept intra-private' in '/acct/dmz/t1'",
+			},
+		},
+		{
+			name:       "reordered tags and clauses",
+			configured: true,
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				reverse := func(clauses [][]string) [][]string {
+					out := [][]string{}
+					for i := len(clauses) - 1; i >= 0; i-- {
+						c := []string{}
+						for j := len(clauses[i]) - 1; j >= 0; j-- {
+							c = append(c, clauses[i][j])
+						}
+						out = append(out, c)
+					}
+					return out
+				}
+				p := m.Get("networkaccesspolicy", tenantNs, "outgoing all-tcp for tenant "+tenantNs).(*gaia.NetworkAccessPolicy)
+				p.Subject = reverse(p.Subject)
+				p.Object = reverse(p.Object)
+				if err := m.Update(manipulate.NewContext(context.Background()), p); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:       "repaired attributes",
+			configured: true,
+			repair:     true,
+			change:     func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) { tamper(t, m) },
+			want: []string{
+				"tenant '/acct/dmz/t1': repaired mismatched entries of externalnetwork 'all-tcp' in '/acct/dmz/t1'",
+				"tenant '/acct/dmz/t1': repaired mismatched action, object of networkaccesspolicy 'accept intra-private' in '/acct/dmz/t1'",
+			},
+		},
+		{
+			name:       "disabled tenant",
+			configured: true,
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				if err := tn.DisablePolicy().Create(context.Background(), m); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.Remove("apiauthorizationpolicy", tenantNs, "default"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "discovered tenant",
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				if err := m.Remove("apiauthorizationpolicy", tenantNs, "default"); err != nil {
+					t.Fatal(err)
+				}
+				if err := m.Remove("enforcerprofile", privateNs, "private"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{
+				"tenant '/acct/dmz/t1': missing enforcerprofile 'private' in '/acct/dmz/t1/private'",
+			},
+		},
+		{
+			name:       "deleted configured tenant",
+			configured: true,
+			change: func(t *testing.T, m *memmanip.Manipulator, tn *tenant.Tenant) {
+				m.Reset()
+			},
+			want: func() []string {
+				var want []string
+				for _, o := range newTenant().Layout() {
+					want = append(want, "tenant '/acct/dmz/t1': missing "+o.String())
+				}
+				return want
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			ctx := context.Background()
+			m := memmanip.New()
+			tn := newTenant()
+			if err := tn.Create(ctx, m); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, m, tn)
+
+			opts := Options{Account: "acct", Repair: tt.repair}
+			if tt.configured {
+				opts.Tenants = []*tenant.Tenant{tn}
+			}
+			r := New(m, opts)
+
+			deviations, err := r.Reconcile(ctx)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			var got []string
+			for _, d := range deviations {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Reconcile() = %q, want %q", got, tt.want)
+			}
+
+			if !tt.repair {
+				return
+			}
+			if deviations, err = r.Reconcile(ctx); err != nil || len(deviations) != 0 {
+				t.Errorf("Reconcile() after repair = %v, %v", deviations, err)
+			}
+		})
+	}
+}
+
+func TestReconciler_Run(t *testing.T) {
+
+	m := memmanip.New()
+	if err := newTenant().Create(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	source := make(chanSource)
+	r := New(m, Options{Account: "/acct", Repair: true, Debounce: 10 * time.Millisecond, Resync: time.Hour})
+
+	done := make(chan error)
+	go func() { done <- r.Run(ctx, source) }()
+
+	if err := m.Remove("networkaccesspolicy", "/acct/dmz/t1", "accept intra-public"); err != nil {
+		t.Fatal(err)
+	}
+	source <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Get("networkaccesspolicy", "/acct/dmz/t1", "accept intra-public") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Run() did not repair the policy after the event")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	// The source stopping stops the reconciler.
+	close(source)
+	if err := New(m, Options{Account: "/acct"}).Run(context.Background(), source); err == nil {
+		t.Errorf("Run() with a stopped source should fail")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticator authenticates the requests of the server.
+type Authenticator interface {
+	// Authenticate returns the identity of the client of the request, or an error if the
+	// request is not authenticated.
+	Authenticate(r *http.Request) (string, error)
+}
+
+// AuthenticatorFunc is an Authenticator function.
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+// Authenticate implements Authenticator.
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// errUnauthenticated is returned for the requests without credentials.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// TokenAuthenticator authenticates the requests with a bearer token. It maps the
+// accepted tokens to the identity of their client.
+type TokenAuthenticator map[string]string
+
+// Authenticate implements Authenticator.
+func (a TokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", errUnauthenticated
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for t, identity := range a {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return identity, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid token")
+}
+
+// CertificateAuthenticator authenticates the requests with the client certificate
+// verified by the TLS server, which must require and verify client certificates. The
+// identity is the common name of the certificate. If Allowed is not empty, only its
+// common names are accepted.
+type CertificateAuthenticator struct {
+	Allowed []string
+}
+
+// Authenticate implements Authenticator.
+func (a *CertificateAuthenticator) Authenticate(r *http.Request) (string, error) {
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", errUnauthenticated
+	}
+
+	identity := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if identity == "" {
+		return "", fmt.Errorf("client certificate has no common name")
+	}
+	if len(a.Allowed) != 0 && !contains(a.Allowed, identity) {
+		return "", fmt.Errorf("client '%s' is not allowed", identity)
+	}
+
+	return identity, nil
+}
+
+type identityKey struct{}
+
+// Identity returns the identity of the authenticated client of a request context.
+func Identity(ctx context.Context) string {
+
+	identity, _ := ctx.Value(identityKey{}).(string)
+	return identity
+}
+
+// withIdentity returns a copy of ctx with the identity of the client.
+func withIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+)
+
+func TestNew_NoAuthenticator(t *testing.T) {
+
+	if _, err := New(context.Background(), memmanip.New(), Options{}); err == nil {
+		t.Errorf("New() without authenticator should fail")
+	}
+}
+
+func TestServer_Unauthenticated(t *testing.T) {
+
+	s := newServer(t, memmanip.New(), Options{})
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{
+			name: "no token",
+		},
+		{
+			name:   "invalid token",
+			header: "Bearer nope",
+		},
+		{
+			name:   "basic auth",
+			header: "Basic " + testToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET /v1/openapi.json = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestServer_IdempotencyPerClient(t *testing.T) {
+
+	s, err := New(context.Background(), memmanip.New(), Options{
+		Authenticator: TokenAuthenticator{testToken: "tester", "other-token": "other"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := map[string]string{HeaderIdempotencyKey: "k1"}
+	zone := `{"account":"acct","name":"dmz"}`
+	if w := do(t, s, http.MethodPost, "/v1/zones", zone, key, nil); w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/zones = %d: %s", w.Code, w.Body.String())
+	}
+
+	other := map[string]string{HeaderIdempotencyKey: "k1", "Authorization": "Bearer other-token"}
+	w := do(t, s, http.MethodPost, "/v1/zones", zone, other, nil)
+	if w.Header().Get(HeaderIdempotencyReplayed) != "" {
+		t.Errorf("POST /v1/zones of another client replayed the response")
+	}
+}
+
+func TestCertificateAuthenticator(t *testing.T) {
+
+	chains := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		state   *tls.ConnectionState
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "no tls",
+			wantErr: true,
+		},
+		{
+			name:    "no verified certificate",
+			state:   &tls.ConnectionState{},
+			wantErr: true,
+		},
+		{
+			name:  "verified certificate",
+			state: chains("ci"),
+			want:  "ci",
+		},
+		{
+			name:    "allowed",
+			allowed: []string{"ci"},
+			state:   chains("ci"),
+			want:    "ci",
+		},
+		{
+			name:    "not allowed",
+			allowed: []string{"ci"},
+			state:   chains("someone"),
+			wantErr: true,
+		},
+		{
+			name:    "no common name",
+			state:   chains(""),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/jobs", nil)
+			r.TLS = tt.state
+			got, err := (&CertificateAuthenticator{Allowed: tt.allowed}).Authenticate(r)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Authenticate() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
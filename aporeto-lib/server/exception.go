@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/networkpolicy"
+	"go.aporeto.io/manipulate"
+)
+
+// exception is an exception policy created through the exception workflow, as a request
+// approved by the client of the server.
+type exception struct {
+	networkpolicy.NetworkPolicy
+	Justification string    `json:"justification"`
+	Expiry        time.Time `json:"expiry"`
+
+	workflow *approval.Workflow
+}
+
+// Create creates the policy and records it as an exception request approved by the
+// client of ctx.
+func (e *exception) Create(ctx context.Context, m manipulate.Manipulator) error {
+
+	if e.workflow == nil {
+		return fmt.Errorf("unable to create exception policy '%s': no exception workflow", e.Name)
+	}
+
+	r := &approval.Request{
+		Policy:        e.NetworkPolicy,
+		Justification: e.Justification,
+		Expiry:        e.Expiry,
+	}
+
+	return e.workflow.Create(ctx, m, r, Identity(ctx), "")
+}
+
+// Delete revokes the approved exception request of the policy as the client of ctx. The
+// policies created before the exception workflow have no request and are deleted
+// directly.
+func (e *exception) Delete(ctx context.Context, m manipulate.Manipulator) error {
+
+	if e.workflow == nil {
+		return fmt.Errorf("unable to delete exception policy '%s': no exception workflow", e.Name)
+	}
+
+	_, err := e.workflow.RevokePolicy(ctx, m, e.Namespace, e.Name, Identity(ctx), "")
+	if err == approval.ErrNotFound {
+		return e.NetworkPolicy.Delete(ctx, m)
+	}
+
+	return err
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Headers of the idempotent requests.
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotency-Replayed"
+)
+
+// response is a response kept to be replayed.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyEntry is the state of an idempotency key.
+type idempotencyEntry struct {
+	fingerprint string
+	response    *response // nil while the request is handled.
+	expires     time.Time
+}
+
+// idempotency keeps the responses to the requests with an idempotency key, so that a
+// client retrying a request does not run the operation twice.
+type idempotency struct {
+	ttl time.Duration
+	now func() time.Time
+
+	entries map[string]*idempotencyEntry
+	lock    sync.Mutex
+}
+
+func newIdempotency(ttl time.Duration) *idempotency {
+
+	return &idempotency{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]*idempotencyEntry{},
+	}
+}
+
+// fingerprint identifies a request by its method, path, query and body.
+func fingerprint(r *http.Request, body []byte) string {
+
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n")) // nolint
+	h.Write(body)                                                              // nolint
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// begin registers a request with the key. It returns the response to replay if the same
+// request was already handled, or the status of the error if the key is in use.
+func (i *idempotency) begin(key, fp string) (*response, int) {
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	now := i.now()
+	for k, e := range i.entries {
+		if e.response != nil && now.After(e.expires) {
+			delete(i.entries, k)
+		}
+	}
+
+	e, ok := i.entries[key]
+	if !ok {
+		i.entries[key] = &idempotencyEntry{fingerprint: fp}
+		return nil, 0
+	}
+
+	if e.fingerprint != fp {
+		return nil, http.StatusUnprocessableEntity
+	}
+
+	if e.response == nil {
+		return nil, http.StatusConflict
+	}
+
+	return e.response, 0
+}
+
+// end keeps the response to the request with the key. Server errors are not kept so that
+// the request can be retried.
+func (i *idempotency) end(key string, resp *response) {
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if resp.status >= http.StatusInternalServerError {
+		delete(i.entries, key)
+		return
+	}
+
+	e, ok := i.entries[key]
+	if !ok {
+		return
+	}
+
+	e.response = resp
+	e.expires = i.now().Add(i.ttl)
+}
+
+// recorder is a response writer keeping the response.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.body.Write(b)
+}
+
+func (r *recorder) WriteHeader(status int) {
+
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) response() *response {
+
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return &response{status: status, header: r.header.Clone(), body: r.body.Bytes()}
+}
+
+// write writes the response to w.
+func (resp *response) write(w http.ResponseWriter) {
+
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body) // nolint
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+)
+
+// Status of a job.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is an operation running in the background, such as the creation of a tenant.
+type Job struct {
+	ID         string    `json:"ID"`
+	Operation  string    `json:"operation"`
+	Object     string    `json:"object"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+// done tells if the job has ended.
+func (j *Job) done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// jobs runs and keeps track of the jobs.
+type jobs struct {
+	ctx     context.Context
+	timeout time.Duration
+	ttl     time.Duration
+	now     func() time.Time
+
+	jobs map[string]*Job
+	wg   sync.WaitGroup
+	lock sync.RWMutex
+}
+
+// newJobs returns jobs running on ctx, each for at most timeout. Ended jobs are forgotten
+// after ttl.
+func newJobs(ctx context.Context, timeout, ttl time.Duration) *jobs {
+
+	return &jobs{
+		ctx:     ctx,
+		timeout: timeout,
+		ttl:     ttl,
+		now:     time.Now,
+		jobs:    map[string]*Job{},
+	}
+}
+
+// start runs f in the background and returns a copy of its job.
+func (js *jobs) start(operation, object string, f func(ctx context.Context) error) *Job {
+
+	j := js.add(operation, object)
+
+	js.wg.Add(1)
+	go func() {
+		defer js.wg.Done()
+
+		ctx, cancel := context.WithTimeout(js.ctx, js.timeout)
+		defer cancel()
+
+		js.exec(ctx, j.ID, f)
+	}()
+
+	return j
+}
+
+// run runs f on ctx and returns a copy of its ended job.
+func (js *jobs) run(ctx context.Context, operation, object string, f func(ctx context.Context) error) *Job {
+
+	j := js.add(operation, object)
+	js.exec(ctx, j.ID, f)
+
+	j, _ = js.get(j.ID)
+	return j
+}
+
+// add adds a pending job and returns a copy of it.
+func (js *jobs) add(operation, object string) *Job {
+
+	now := js.now()
+	j := &Job{
+		ID:         newID(),
+		Operation:  operation,
+		Object:     object,
+		Status:     JobPending,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+
+	js.lock.Lock()
+	defer js.lock.Unlock()
+
+	js.expire(now)
+	js.jobs[j.ID] = j
+
+	out := *j
+	return &out
+}
+
+// exec runs the job.
+func (js *jobs) exec(ctx context.Context, id string, f func(ctx context.Context) error) {
+
+	js.update(id, JobRunning, nil)
+
+	ctx = logging.With(ctx, logging.String("job", id))
+	if err := f(ctx); err != nil {
+		logging.FromContext(ctx).Error("job failed", logging.Err(err))
+		js.update(id, JobFailed, err)
+		return
+	}
+
+	js.update(id, JobSucceeded, nil)
+}
+
+// update sets the status of a job.
+func (js *jobs) update(id string, status string, err error) {
+
+	js.lock.Lock()
+	defer js.lock.Unlock()
+
+	j, ok := js.jobs[id]
+	if !ok {
+		return
+	}
+
+	j.Status = status
+	j.UpdateTime = js.now()
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
+
+// get returns a copy of a job.
+func (js *jobs) get(id string) (*Job, bool) {
+
+	js.lock.RLock()
+	defer js.lock.RUnlock()
+
+	j, ok := js.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	out := *j
+	return &out, true
+}
+
+// list returns a copy of the jobs, oldest first.
+func (js *jobs) list() []*Job {
+
+	js.lock.RLock()
+	defer js.lock.RUnlock()
+
+	out := make([]*Job, 0, len(js.jobs))
+	for _, j := range js.jobs {
+		c := *j
+		out = append(out, &c)
+	}
+
+	sort.Slice(out, func(i, k int) bool {
+		if out[i].CreateTime.Equal(out[k].CreateTime) {
+			return out[i].ID < out[k].ID
+		}
+		return out[i].CreateTime.Before(out[k].CreateTime)
+	})
+
+	return out
+}
+
+// wait waits for the running jobs to end.
+func (js *jobs) wait() {
+	js.wg.Wait()
+}
+
+// expire forgets the jobs that ended more than ttl ago. The lock must be held.
+func (js *jobs) expire(now time.Time) {
+
+	for id, j := range js.jobs {
+		if j.done() && now.Sub(j.UpdateTime) > js.ttl {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// newID returns a random identifier.
+func newID() string {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
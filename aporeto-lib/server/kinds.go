@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/extnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/hostservice"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/oidc"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/tenant"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/zone"
+	"go.aporeto.io/manipulate"
+)
+
+// Actions on objects other than create and delete.
+const (
+	ActionDisable = "disable"
+	ActionEnable  = "enable"
+)
+
+// kind is a kind of object managed by the server.
+type kind struct {
+	// name is the path of the kind, for instance tenants.
+	name string
+
+	// operation prefixes the name of the operations, for instance tenant-create.
+	operation string
+
+	// required are the json keys that must be set to identify an object.
+	required []string
+
+	// async runs the operations as jobs.
+	async bool
+
+	// new returns an empty object.
+	new func() api.CreatorDeleter
+
+	// configure sets the fields of a decoded object that are owned by the server and not
+	// by the clients. It may be nil.
+	configure func(s *Server, o api.CreatorDeleter)
+
+	// validate checks a decoded object before it is created. It may be nil.
+	validate func(o api.CreatorDeleter) error
+}
+
+// kinds returns the kinds of objects managed by the server.
+func kinds() []*kind {
+
+	return []*kind{
+		{
+			name:      "zones",
+			operation: "zone",
+			required:  []string{"account", "name"},
+			new:       func() api.CreatorDeleter { return &zone.Zone{} },
+		},
+		{
+			name:      "tenants",
+			operation: "tenant",
+			required:  []string{"account", "zone", "name"},
+			async:     true,
+			new:       func() api.CreatorDeleter { return &tenant.Tenant{} },
+			configure: func(s *Server, o api.CreatorDeleter) {
+				// Credentials are only written to the directory of the server, never to a
+				// path given by a client.
+				o.(*tenant.Tenant).EnforcerAppCredPath = s.opts.EnforcerAppCredDir
+			},
+		},
+		{
+			name:      "hostservices",
+			operation: "hostservice",
+			required:  []string{"account", "zone", "tenant", "rail", "name"},
+			new:       func() api.CreatorDeleter { return &hostservice.Service{} },
+		},
+		{
+			name:      "externalnetworks",
+			operation: "externalnetwork",
+			required:  []string{"account", "zone", "tenant", "name"},
+			new:       func() api.CreatorDeleter { return &extnetwork.ExternalNetwork{} },
+		},
+		{
+			name:      "exceptions",
+			operation: "exception",
+			required:  []string{"namespace", "name"},
+			new:       func() api.CreatorDeleter { return &exception{} },
+			configure: func(s *Server, o api.CreatorDeleter) {
+				e := o.(*exception)
+				e.workflow = s.opts.Workflow
+				if e.workflow != nil {
+					e.Rules = e.workflow.Rules
+				}
+			},
+			validate: func(o api.CreatorDeleter) error {
+				return compliance.Denied(o.(*exception).Validate())
+			},
+		},
+		{
+			name:      "oidcproviders",
+			operation: "oidcprovider",
+			required:  []string{"account", "zone", "tenant", "name"},
+			new:       func() api.CreatorDeleter { return &oidc.OIDC{} },
+		},
+	}
+}
+
+// action returns the function running the action on the object, or nil if the object
+// does not support it.
+func action(o api.CreatorDeleter, name string) func(context.Context, manipulate.Manipulator) error {
+
+	switch name {
+	case ActionDisable:
+		if d, ok := o.(api.Disabler); ok {
+			return d.Disable
+		}
+	case ActionEnable:
+		if e, ok := o.(api.Enabler); ok {
+			return e.Enable
+		}
+	}
+
+	return nil
+}
+
+// actions returns the actions supported by the objects of the kind.
+func (k *kind) actions() []string {
+
+	var out []string
+	for _, name := range []string{ActionDisable, ActionEnable} {
+		if action(k.new(), name) != nil {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
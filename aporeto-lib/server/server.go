@@ -0,0 +1,440 @@
+// Package server is an HTTP API to onboard tenants: it creates, disables, enables and
+// deletes zones, tenants, host services, external networks, exception policies and OIDC
+// providers with the operations of the api packages. The API is described by the OpenAPI
+// spec served at /v1/openapi.json. Exception policies are recorded as exception requests
+// of the approval package, approved by the client creating them.
+package server
+
+import (
+	"bytes"
+	"context"
+	_ "embed" // for the OpenAPI spec.
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
+	"go.aporeto.io/manipulate"
+)
+
+// openAPI is the spec of the API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// Defaults of the options.
+const (
+	DefaultJobTimeout     = 10 * time.Minute
+	DefaultJobTTL         = 24 * time.Hour
+	DefaultIdempotencyTTL = 24 * time.Hour
+)
+
+// maxBodySize is the maximum size of a request body.
+const maxBodySize = 1 << 20
+
+// Options are the options of the server.
+type Options struct {
+	// Authenticator authenticates the requests. It is required: every request, including
+	// the ones reading the jobs and the spec, must be authenticated.
+	Authenticator Authenticator
+
+	// Logger logs the requests and the operations. Nothing is logged if nil.
+	Logger logging.Logger
+
+	// Workflow records the exception policies as approved exception requests, and its
+	// compliance rules are checked before creating them. The exception policies can not
+	// be created nor deleted if nil.
+	Workflow *approval.Workflow
+
+	// EnforcerAppCredDir is the directory where the enforcer application credentials of
+	// the tenants are written. They are not created if empty.
+	EnforcerAppCredDir string
+
+	// JobTimeout is the maximum duration of a job.
+	JobTimeout time.Duration
+
+	// JobTTL is how long ended jobs are kept.
+	JobTTL time.Duration
+
+	// IdempotencyTTL is how long the responses to the requests with an idempotency key
+	// are kept.
+	IdempotencyTTL time.Duration
+}
+
+// Server is the HTTP API.
+type Server struct {
+	m           manipulate.Manipulator
+	opts        Options
+	ctx         context.Context
+	kinds       map[string]*kind
+	jobs        *jobs
+	idempotency *idempotency
+}
+
+// Error is the body of an error response.
+type Error struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+// New returns a server running the operations with m. The jobs run on ctx: canceling it
+// cancels them. It fails if the options have no authenticator.
+func New(ctx context.Context, m manipulate.Manipulator, opts Options) (*Server, error) {
+
+	if opts.Authenticator == nil {
+		return nil, fmt.Errorf("unable to create server: no authenticator")
+	}
+	if opts.Logger == nil {
+		opts.Logger = logging.Nop()
+	}
+	if opts.JobTimeout == 0 {
+		opts.JobTimeout = DefaultJobTimeout
+	}
+	if opts.JobTTL == 0 {
+		opts.JobTTL = DefaultJobTTL
+	}
+	if opts.IdempotencyTTL == 0 {
+		opts.IdempotencyTTL = DefaultIdempotencyTTL
+	}
+
+	ctx = logging.NewContext(ctx, opts.Logger)
+
+	s := &Server{
+		m:           m,
+		opts:        opts,
+		ctx:         ctx,
+		kinds:       map[string]*kind{},
+		jobs:        newJobs(ctx, opts.JobTimeout, opts.JobTTL),
+		idempotency: newIdempotency(opts.IdempotencyTTL),
+	}
+	for _, k := range kinds() {
+		s.kinds[k.name] = k
+	}
+
+	return s, nil
+}
+
+// Wait waits for the running jobs to end.
+func (s *Server) Wait() {
+	s.jobs.wait()
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	logger := s.opts.Logger.With(logging.String("method", r.Method), logging.String("path", r.URL.Path))
+
+	identity, err := s.opts.Authenticator.Authenticate(r)
+	if err != nil {
+		logger.Warn("request not authenticated", logging.Err(err))
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized: %s", err))
+		return
+	}
+	logger = logger.With(logging.String("identity", identity))
+	r = r.WithContext(withIdentity(r.Context(), identity))
+
+	rec := newRecorder()
+	s.serve(rec, r)
+	resp := rec.response()
+	resp.write(w)
+
+	if resp.status >= http.StatusInternalServerError {
+		logger.Error("request failed", logging.Any("status", resp.status))
+		return
+	}
+	logger.Debug("request handled", logging.Any("status", resp.status))
+}
+
+// serve routes the request.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for '%s'", r.URL.Path))
+		return
+	}
+	parts = parts[1:]
+
+	switch {
+
+	case len(parts) == 1 && parts[0] == "openapi.json":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPI) // nolint
+
+	case len(parts) == 1 && parts[0] == "jobs":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.jobs.list())
+
+	case len(parts) == 2 && parts[0] == "jobs":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		j, ok := s.jobs.get(parts[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job '%s'", parts[1]))
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+
+	case len(parts) == 1 && s.kinds[parts[0]] != nil:
+		if !allow(w, r, http.MethodPost, http.MethodDelete) {
+			return
+		}
+		s.idempotent(w, r, func(w http.ResponseWriter, r *http.Request, body []byte) {
+			k := s.kinds[parts[0]]
+			if r.Method == http.MethodPost {
+				s.create(w, r, k, body)
+				return
+			}
+			s.delete(w, r, k)
+		})
+
+	case len(parts) == 2 && s.kinds[parts[0]] != nil && contains(s.kinds[parts[0]].actions(), parts[1]):
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+		s.idempotent(w, r, func(w http.ResponseWriter, r *http.Request, body []byte) {
+			s.action(w, r, s.kinds[parts[0]], parts[1], body)
+		})
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no route for '%s'", r.URL.Path))
+	}
+}
+
+// create creates an object from the body.
+func (s *Server) create(w http.ResponseWriter, r *http.Request, k *kind, body []byte) {
+
+	o, err := s.decode(k, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if k.validate != nil {
+		if err := k.validate(o); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	s.run(w, r, k, k.operation+"-create", body, o.Create, http.StatusCreated)
+}
+
+// delete deletes the object identified by the query parameters.
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, k *kind) {
+
+	query := map[string]string{}
+	for key, values := range r.URL.Query() {
+		query[key] = values[0]
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	o, err := s.decode(k, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.run(w, r, k, k.operation+"-delete", body, o.Delete, http.StatusOK)
+}
+
+// action runs an action on the object of the body.
+func (s *Server) action(w http.ResponseWriter, r *http.Request, k *kind, name string, body []byte) {
+
+	o, err := s.decode(k, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.run(w, r, k, k.operation+"-"+name, body, action(o, name), http.StatusOK)
+}
+
+// run runs an operation. The operations of the async kinds run as jobs and the response
+// is the pending job. The other operations run in the request and the response is the
+// ended job, with the status if it succeeded.
+func (s *Server) run(
+	w http.ResponseWriter,
+	r *http.Request,
+	k *kind,
+	operation string,
+	body []byte,
+	f func(context.Context, manipulate.Manipulator) error,
+	status int,
+) {
+
+	object := name(body)
+
+	logging.FromContext(s.ctx).Info(
+		"running operation",
+		logging.String("operation", operation),
+		logging.String("object", object),
+		logging.String("identity", Identity(r.Context())),
+		logging.Any("async", k.async),
+	)
+
+	run := func(ctx context.Context) error { return f(ctx, s.m) }
+
+	if k.async {
+		j := s.jobs.start(operation, object, run)
+		w.Header().Set("Location", "/v1/jobs/"+j.ID)
+		writeJSON(w, http.StatusAccepted, j)
+		return
+	}
+
+	// The request context is only used for its cancellation, the logger is the one of
+	// the server.
+	ctx := logging.NewContext(r.Context(), logging.FromContext(s.ctx))
+	ctx, cancel := context.WithTimeout(ctx, s.opts.JobTimeout)
+	defer cancel()
+
+	j := s.jobs.run(ctx, operation, object, run)
+
+	if j.Status == JobFailed {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("%s", j.Error))
+		return
+	}
+
+	writeJSON(w, status, j)
+}
+
+// decode decodes an object of the kind and checks that the fields identifying it are set.
+func (s *Server) decode(k *kind, body []byte) (api.CreatorDeleter, error) {
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", k.operation, err.Error())
+	}
+
+	var missing []string
+	for _, key := range k.required {
+		if v, ok := fields[key]; !ok || v == nil || v == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, fmt.Errorf("invalid %s: missing %s", k.operation, strings.Join(missing, ", "))
+	}
+
+	o := k.new()
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(o); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", k.operation, err.Error())
+	}
+
+	if k.configure != nil {
+		k.configure(s, o)
+	}
+
+	return o, nil
+}
+
+// idempotent handles a request that changes something. If the request has an idempotency
+// key, the response is kept and replayed to the same request with the same key.
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, h func(http.ResponseWriter, *http.Request, []byte)) {
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to read body: %s", err.Error()))
+		return
+	}
+
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" {
+		h(w, r, body)
+		return
+	}
+
+	// The keys of the clients are distinct: a client never replays the response of
+	// another one.
+	resp, status := s.idempotency.begin(Identity(r.Context())+" "+key, fingerprint(r, body))
+	switch status {
+	case http.StatusConflict:
+		writeError(w, status, fmt.Errorf("a request with idempotency key '%s' is in progress", key))
+		return
+	case http.StatusUnprocessableEntity:
+		writeError(w, status, fmt.Errorf("idempotency key '%s' was used for another request", key))
+		return
+	}
+
+	if resp != nil {
+		w.Header().Set(HeaderIdempotencyReplayed, "true")
+		resp.write(w)
+		return
+	}
+
+	rec := newRecorder()
+	h(rec, r, body)
+	resp = rec.response()
+	s.idempotency.end(Identity(r.Context())+" "+key, resp)
+	resp.write(w)
+}
+
+// allow checks the method of the request.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+
+	if contains(methods, r.Method) {
+		return true
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on '%s'", r.Method, r.URL.Path))
+
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data = []byte(`{"code":500,"error":"unable to encode response"}`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n')) // nolint
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &Error{Code: status, Error: err.Error()})
+}
+
+// name returns the name of the object of a body, for the logs and the jobs.
+func name(body []byte) string {
+
+	var o struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(body, &o) // nolint
+
+	return o.Name
+}
+
+func contains(list []string, s string) bool {
+
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/internal/memmanip"
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+// testToken is the token of the test client.
+const testToken = "test-token"
+
+// newServer returns a server accepting the test token.
+func newServer(t *testing.T, m manipulate.Manipulator, opts Options) *Server {
+
+	t.Helper()
+
+	opts.Authenticator = TokenAuthenticator{testToken: "tester"}
+	s, err := New(context.Background(), m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// do sends a request with the test token to the server and decodes the response in
+// out, if not nil.
+func do(t *testing.T, h http.Handler, method, path, body string, header map[string]string, out interface{}) *httptest.ResponseRecorder {
+
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %s", method, path, w.Body.String(), err)
+		}
+	}
+
+	return w
+}
+
+// waitJob polls a job until it ends.
+func waitJob(t *testing.T, h http.Handler, location string) *Job {
+
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j := &Job{}
+		if w := do(t, h, http.MethodGet, location, "", nil, j); w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", location, w.Code, w.Body.String())
+		}
+		if j.done() {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not end", location)
+	return nil
+}
+
+func TestServer_Errors(t *testing.T) {
+
+	s := newServer(t, memmanip.New(), Options{Workflow: &approval.Workflow{Rules: compliance.DefaultRules()}})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "unknown route",
+			method:     http.MethodGet,
+			path:       "/v1/namespaces",
+			wantStatus: http.StatusNotFound,
+			wantError:  "no route for '/v1/namespaces'",
+		},
+		{
+			name:       "unknown action",
+			method:     http.MethodPost,
+			path:       "/v1/zones/disable",
+			body:       `{"account":"acct","name":"dmz"}`,
+			wantStatus: http.StatusNotFound,
+			wantError:  "no route for '/v1/zones/disable'",
+		},
+		{
+			name:       "unknown job",
+			method:     http.MethodGet,
+			path:       "/v1/jobs/nope",
+			wantStatus: http.StatusNotFound,
+			wantError:  "no job 'nope'",
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodGet,
+			path:       "/v1/zones",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantError:  "method GET not allowed on '/v1/zones'",
+		},
+		{
+			name:       "invalid json",
+			method:     http.MethodPost,
+			path:       "/v1/zones",
+			body:       `{"account":`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid zone: unexpected end of JSON input",
+		},
+		{
+			name:       "missing fields",
+			method:     http.MethodPost,
+			path:       "/v1/hostservices",
+			body:       `{"account":"acct","zone":"dmz","name":"ssh"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid hostservice: missing tenant, rail",
+		},
+		{
+			name:       "unknown field",
+			method:     http.MethodPost,
+			path:       "/v1/zones",
+			body:       `{"account":"acct","name":"dmz","color":"blue"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  `invalid zone: json: unknown field "color"`,
+		},
+		{
+			name:       "missing query parameter",
+			method:     http.MethodDelete,
+			path:       "/v1/zones?account=acct",
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid zone: missing name",
+		},
+		{
+			name:   "non compliant exception",
+			method: http.MethodPost,
+			path:   "/v1/exceptions",
+			body: `{
+				"namespace": "/acct",
+				"name": "public-to-private",
+				"subject-tenant-namespace": "/acct/dmz/t1",
+				"subject-tags": ["$namespace=/acct/dmz/t1/public"],
+				"object-tenant-namespace": "/acct/dmz/t2",
+				"object-tags": ["$namespace=/acct/dmz/t2/private"],
+				"policy-mode": "OutgoingTraffic",
+				"justification": "test"
+			}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantError:  "compliance violations",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			e := &Error{}
+			w := do(t, s, tt.method, tt.path, tt.body, nil, e)
+
+			if w.Code != tt.wantStatus || e.Code != tt.wantStatus {
+				t.Errorf("status = %d, code = %d, want %d", w.Code, e.Code, tt.wantStatus)
+			}
+			if !strings.Contains(e.Error, tt.wantError) {
+				t.Errorf("error = %q, want %q", e.Error, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestServer_Zones(t *testing.T) {
+
+	m := memmanip.New()
+	s := newServer(t, m, Options{})
+
+	j := &Job{}
+	w := do(t, s, http.MethodPost, "/v1/zones", `{"account":"acct","name":"dmz"}`, nil, j)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/zones = %d: %s", w.Code, w.Body.String())
+	}
+	if j.Operation != "zone-create" || j.Object != "dmz" || j.Status != JobSucceeded {
+		t.Errorf("POST /v1/zones returned %+v", j)
+	}
+	if got := m.Created(); len(got) != 1 || got[0] != "/acct namespace" {
+		t.Errorf("POST /v1/zones created %v", got)
+	}
+
+	// The job of a synchronous operation is kept too.
+	got := &Job{}
+	do(t, s, http.MethodGet, "/v1/jobs/"+j.ID, "", nil, got)
+	if got.ID != j.ID || got.Status != JobSucceeded {
+		t.Errorf("GET /v1/jobs/%s = %+v", j.ID, got)
+	}
+
+	j = &Job{}
+	w = do(t, s, http.MethodDelete, "/v1/zones?account=acct&name=dmz", "", nil, j)
+	if w.Code != http.StatusOK || j.Operation != "zone-delete" || j.Status != JobSucceeded {
+		t.Errorf("DELETE /v1/zones = %d %+v", w.Code, j)
+	}
+
+	e := &Error{}
+	w = do(t, s, http.MethodDelete, "/v1/zones?account=acct&name=dmz", "", nil, e)
+	if w.Code != http.StatusInternalServerError || e.Error == "" {
+		t.Errorf("DELETE /v1/zones of a deleted zone = %d %+v", w.Code, e)
+	}
+}
+
+func TestServer_Tenants(t *testing.T) {
+
+	m := memmanip.New()
+	s := newServer(t, m, Options{})
+	tenant := `{"account":"acct","zone":"dmz","name":"t1"}`
+
+	for _, op := range []struct {
+		path      string
+		operation string
+	}{
+		{path: "/v1/tenants", operation: "tenant-create"},
+		{path: "/v1/tenants/disable", operation: "tenant-disable"},
+		{path: "/v1/tenants/enable", operation: "tenant-enable"},
+	} {
+		j := &Job{}
+		w := do(t, s, http.MethodPost, op.path, tenant, nil, j)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("POST %s = %d: %s", op.path, w.Code, w.Body.String())
+		}
+		if j.Operation != op.operation || j.Object != "t1" || j.done() {
+			t.Errorf("POST %s returned %+v", op.path, j)
+		}
+
+		location := w.Header().Get("Location")
+		if location != "/v1/jobs/"+j.ID {
+			t.Fatalf("POST %s location = %q", op.path, location)
+		}
+		if j = waitJob(t, s, location); j.Status != JobSucceeded {
+			t.Errorf("%s job = %+v", op.operation, j)
+		}
+	}
+
+	if got := m.Created(); !contains(got, "/acct/dmz namespace") || !contains(got, "/acct networkaccesspolicy") {
+		t.Errorf("tenant jobs created %v", got)
+	}
+
+	jobs := []*Job{}
+	do(t, s, http.MethodGet, "/v1/jobs", "", nil, &jobs)
+	if len(jobs) != 3 || jobs[0].Operation != "tenant-create" {
+		t.Errorf("GET /v1/jobs = %+v", jobs)
+	}
+}
+
+func TestServer_Exceptions(t *testing.T) {
+
+	m := memmanip.New()
+	w := &approval.Workflow{
+		Store: approval.NewFileStore(filepath.Join(t.TempDir(), "requests.json")),
+		Rules: compliance.DefaultRules(),
+	}
+	s := newServer(t, m, Options{Workflow: w})
+
+	body := `{
+		"namespace": "/acct",
+		"name": "replication",
+		"subject-tenant-namespace": "/acct/dmz/t1",
+		"subject-tags": ["$namespace=/acct/dmz/t1/private"],
+		"object-tenant-namespace": "/acct/sensitive/t2",
+		"object-tags": ["$namespace=/acct/sensitive/t2/private"],
+		"encrypt": true,
+		"justification": "database replication"
+	}`
+	if resp := do(t, s, http.MethodPost, "/v1/exceptions", body, nil, nil); resp.Code != http.StatusCreated {
+		t.Fatalf("POST /v1/exceptions = %d: %s", resp.Code, resp.Body.String())
+	}
+	if n := len(m.Objects("networkaccesspolicy")); n != 1 {
+		t.Errorf("POST /v1/exceptions created %d policies, want 1", n)
+	}
+
+	// The policy is recorded as a request approved by the client.
+	approved, err := w.List(context.Background(), approval.StatusApproved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(approved) != 1 || approved[0].Requester != "tester" || approved[0].Justification != "database replication" {
+		t.Fatalf("POST /v1/exceptions recorded %+v", approved)
+	}
+
+	if resp := do(t, s, http.MethodDelete, "/v1/exceptions?namespace=/acct&name=replication", "", nil, nil); resp.Code != http.StatusOK {
+		t.Fatalf("DELETE /v1/exceptions = %d: %s", resp.Code, resp.Body.String())
+	}
+	if n := len(m.Objects("networkaccesspolicy")); n != 0 {
+		t.Errorf("DELETE /v1/exceptions left %d policies", n)
+	}
+
+	r, err := w.Store.Get(context.Background(), approved[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != approval.StatusRevoked || r.Closure.By != "tester" {
+		t.Errorf("DELETE /v1/exceptions left the request %s %+v", r.Status, r.Closure)
+	}
+}
+
+func TestServer_FailedJob(t *testing.T) {
+
+	m := memmanip.New()
+	m.OnCreate = func(manipulate.Context, elemental.Identifiable) error { return errors.New("forbidden") }
+	s := newServer(t, m, Options{})
+
+	w := do(t, s, http.MethodPost, "/v1/tenants", `{"account":"acct","zone":"dmz","name":"t1"}`, nil, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /v1/tenants = %d: %s", w.Code, w.Body.String())
+	}
+
+	j := waitJob(t, s, w.Header().Get("Location"))
+	if j.Status != JobFailed || !strings.Contains(j.Error, "forbidden") {
+		t.Errorf("job = %+v", j)
+	}
+}
+
+func TestServer_Idempotency(t *testing.T) {
+
+	m := memmanip.New()
+	block := make(chan struct{})
+	m.OnCreate = func(manipulate.Context, elemental.Identifiable) error { <-block; return nil }
+	s := newServer(t, m, Options{})
+	zone := `{"account":"acct","name":"dmz"}`
+	key := map[string]string{HeaderIdempotencyKey: "k1"}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- do(t, s, http.MethodPost, "/v1/zones", zone, key, nil) }()
+
+	// Wait for the first request to be in progress.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.jobs.list()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if w := do(t, s, http.MethodPost, "/v1/zones", zone, key, nil); w.Code != http.StatusConflict {
+		t.Errorf("request in progress = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	close(block)
+	w1 := <-first
+	if w1.Code != http.StatusCreated {
+		t.Fatalf("first request = %d: %s", w1.Code, w1.Body.String())
+	}
+
+	w2 := do(t, s, http.MethodPost, "/v1/zones", zone, key, nil)
+	if w2.Code != http.StatusCreated || w2.Body.String() != w1.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", w2.Code, w2.Body.String(), w1.Code, w1.Body.String())
+	}
+	if w2.Header().Get(HeaderIdempotencyReplayed) != "true" {
+		t.Errorf("replay is missing the %s header", HeaderIdempotencyReplayed)
+	}
+	if w1.Header().Get(HeaderIdempotencyReplayed) != "" {
+		t.Errorf("first request has the %s header", HeaderIdempotencyReplayed)
+	}
+
+	w3 := do(t, s, http.MethodPost, "/v1/zones", `{"account":"acct","name":"other"}`, key, nil)
+	if w3.Code != http.StatusUnprocessableEntity {
+		t.Errorf("other request with the same key = %d, want %d", w3.Code, http.StatusUnprocessableEntity)
+	}
+
+	if got := m.Created(); len(got) != 1 {
+		t.Errorf("zone created %d times", len(got))
+	}
+
+	// Without a key, the request runs again.
+	do(t, s, http.MethodPost, "/v1/zones", zone, nil, nil)
+	if got := m.Created(); len(got) != 2 {
+		t.Errorf("zone created %d times", len(got))
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+
+	s := newServer(t, memmanip.New(), Options{})
+
+	spec := struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}{}
+	if w := do(t, s, http.MethodGet, "/v1/openapi.json", "", nil, &spec); w.Code != http.StatusOK {
+		t.Fatalf("GET /v1/openapi.json = %d", w.Code)
+	}
+
+	want := map[string]string{
+		"/v1/jobs get":         "job-list",
+		"/v1/jobs/{id} get":    "job-get",
+		"/v1/openapi.json get": "openapi",
+	}
+	for _, k := range kinds() {
+		want["/v1/"+k.name+" post"] = k.operation + "-create"
+		want["/v1/"+k.name+" delete"] = k.operation + "-delete"
+		for _, a := range k.actions() {
+			want["/v1/"+k.name+"/"+a+" post"] = k.operation + "-" + a
+		}
+	}
+
+	got := map[string]string{}
+	for path, methods := range spec.Paths {
+		for method, op := range methods {
+			got[path+" "+method] = op.OperationID
+		}
+	}
+
+	for route, op := range want {
+		if got[route] != op {
+			t.Errorf("spec of %s has operation %q, want %q", route, got[route], op)
+		}
+	}
+	for route := range got {
+		if _, ok := want[route]; !ok {
+			t.Errorf("spec documents %s which is not served", route)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/manipulate"
+)
+
+// API operations recorded.
+const (
+	APIOperationRetrieveMany = "retrieve-many"
+	APIOperationRetrieve     = "retrieve"
+	APIOperationCreate       = "create"
+	APIOperationUpdate       = "update"
+	APIOperationDelete       = "delete"
+	APIOperationDeleteMany   = "delete-many"
+	APIOperationCount        = "count"
+)
+
+// manipulator instruments the manipulator it wraps.
+type manipulator struct {
+	manipulate.Manipulator
+}
+
+// NewManipulator returns a manipulator recording the latency and outcome of every call
+// made through m, and tracing each one as a child of the span of the manipulate context.
+func NewManipulator(m manipulate.Manipulator) manipulate.Manipulator {
+	return &manipulator{Manipulator: m}
+}
+
+func (m *manipulator) RetrieveMany(mctx manipulate.Context, dest elemental.Identifiables) error {
+	return observe(mctx, dest.Identity(), APIOperationRetrieveMany, func() error {
+		return m.Manipulator.RetrieveMany(mctx, dest)
+	})
+}
+
+func (m *manipulator) Retrieve(mctx manipulate.Context, object elemental.Identifiable) error {
+	return observe(mctx, object.Identity(), APIOperationRetrieve, func() error {
+		return m.Manipulator.Retrieve(mctx, object)
+	})
+}
+
+func (m *manipulator) Create(mctx manipulate.Context, object elemental.Identifiable) error {
+	return observe(mctx, object.Identity(), APIOperationCreate, func() error {
+		return m.Manipulator.Create(mctx, object)
+	})
+}
+
+func (m *manipulator) Update(mctx manipulate.Context, object elemental.Identifiable) error {
+	return observe(mctx, object.Identity(), APIOperationUpdate, func() error {
+		return m.Manipulator.Update(mctx, object)
+	})
+}
+
+func (m *manipulator) Delete(mctx manipulate.Context, object elemental.Identifiable) error {
+	return observe(mctx, object.Identity(), APIOperationDelete, func() error {
+		return m.Manipulator.Delete(mctx, object)
+	})
+}
+
+func (m *manipulator) DeleteMany(mctx manipulate.Context, identity elemental.Identity) error {
+	return observe(mctx, identity, APIOperationDeleteMany, func() error {
+		return m.Manipulator.DeleteMany(mctx, identity)
+	})
+}
+
+func (m *manipulator) Count(mctx manipulate.Context, identity elemental.Identity) (int, error) {
+
+	var n int
+	err := observe(mctx, identity, APIOperationCount, func() (err error) {
+		n, err = m.Manipulator.Count(mctx, identity)
+		return err
+	})
+
+	return n, err
+}
+
+// observe records the call f.
+func observe(mctx manipulate.Context, identity elemental.Identity, operation string, f func() error) error {
+
+	ctx := context.Background()
+	if mctx != nil && mctx.Context() != nil {
+		ctx = mctx.Context()
+	}
+
+	attrs := []Attribute{{Key: "identity", Value: identity.Name}}
+	if mctx != nil && mctx.Namespace() != "" {
+		attrs = append(attrs, Attribute{Key: "namespace", Value: mctx.Namespace()})
+	}
+
+	start := time.Now()
+	_, span := Start(ctx, "api."+operation, attrs...)
+	err := f()
+	span.End(err)
+	currentMetrics().ObserveAPICall(identity.Name, operation, Outcome(err), time.Since(start))
+
+	return err
+}
@@ -0,0 +1,50 @@
+package otel
+
+import (
+	"context"
+
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracer of the library.
+const InstrumentationName = "github.com/PaloAltoNetworks/cns-customer/aporeto-lib"
+
+// Tracer starts OpenTelemetry spans.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a tracer using the provider. Use telemetry.SetTracer to enable it.
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: provider.Tracer(InstrumentationName)}
+}
+
+// Start implements telemetry.Tracer.
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...telemetry.Attribute) (context.Context, telemetry.Span) {
+
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, attribute.String(a.Key, a.Value))
+	}
+
+	ctx, s := t.tracer.Start(ctx, name, trace.WithAttributes(kvs...))
+	return ctx, &span{span: s}
+}
+
+// span is an OpenTelemetry span.
+type span struct {
+	span trace.Span
+}
+
+// End records the error, if any, and ends the span.
+func (s *span) End(err error) {
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "cns_customer"
+
+// Metrics records the library metrics in Prometheus collectors:
+// cns_customer_api_requests_total and cns_customer_api_request_duration_seconds by
+// identity, operation and outcome, cns_customer_operations_total and
+// cns_customer_operation_duration_seconds by operation and outcome.
+type Metrics struct {
+	apiRequests       *prom.CounterVec
+	apiDuration       *prom.HistogramVec
+	operations        *prom.CounterVec
+	operationDuration *prom.HistogramVec
+}
+
+// NewMetrics returns the metrics registered to reg. Use telemetry.SetMetrics to enable
+// them.
+func NewMetrics(reg prom.Registerer) (*Metrics, error) {
+
+	m := &Metrics{
+		apiRequests: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: namespace,
+				Name:      "api_requests_total",
+				Help:      "Number of API requests made by the library.",
+			},
+			[]string{"identity", "operation", "outcome"},
+		),
+		apiDuration: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: namespace,
+				Name:      "api_request_duration_seconds",
+				Help:      "Duration of the API requests made by the library.",
+				Buckets:   prom.DefBuckets,
+			},
+			[]string{"identity", "operation", "outcome"},
+		),
+		operations: prom.NewCounterVec(
+			prom.CounterOpts{
+				Namespace: namespace,
+				Name:      "operations_total",
+				Help:      "Number of tenant, zone and exception policy operations.",
+			},
+			[]string{"operation", "outcome"},
+		),
+		operationDuration: prom.NewHistogramVec(
+			prom.HistogramOpts{
+				Namespace: namespace,
+				Name:      "operation_duration_seconds",
+				Help:      "Duration of the tenant, zone and exception policy operations.",
+				Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+			},
+			[]string{"operation", "outcome"},
+		),
+	}
+
+	for _, c := range []prom.Collector{m.apiRequests, m.apiDuration, m.operations, m.operationDuration} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// ObserveAPICall implements telemetry.Metrics.
+func (m *Metrics) ObserveAPICall(identity, operation, outcome string, duration time.Duration) {
+
+	m.apiRequests.WithLabelValues(identity, operation, outcome).Inc()
+	m.apiDuration.WithLabelValues(identity, operation, outcome).Observe(duration.Seconds())
+}
+
+// ObserveOperation implements telemetry.Metrics.
+func (m *Metrics) ObserveOperation(operation, outcome string, duration time.Duration) {
+
+	m.operations.WithLabelValues(operation, outcome).Inc()
+	m.operationDuration.WithLabelValues(operation, outcome).Observe(duration.Seconds())
+}
@@ -0,0 +1,138 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Outcomes of the operations.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Metrics records the API calls and the high level operations. The identity of an API
+// call is the identity of the object, for instance externalnetwork, and its operation
+// is the manipulator method, for instance create.
+type Metrics interface {
+	ObserveAPICall(identity, operation, outcome string, duration time.Duration)
+	ObserveOperation(operation, outcome string, duration time.Duration)
+}
+
+// Attribute is a key value describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Span is a traced step. End is called once with the error of the step, if any.
+type Span interface {
+	End(err error)
+}
+
+// Tracer starts spans. The returned context holds the span so that the spans started
+// from it are its children.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+var (
+	metrics Metrics = noopMetrics{}
+	tracer  Tracer  = noopTracer{}
+	lock    sync.RWMutex
+)
+
+// SetMetrics sets the metrics used by the library. Passing nil disables the metrics,
+// which is the default.
+func SetMetrics(m Metrics) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+// SetTracer sets the tracer used by the library. Passing nil disables tracing, which is
+// the default.
+func SetTracer(t Tracer) {
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if t == nil {
+		t = noopTracer{}
+	}
+	tracer = t
+}
+
+func currentMetrics() Metrics {
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return metrics
+}
+
+func currentTracer() Tracer {
+
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return tracer
+}
+
+// Start starts a span, child of the span of ctx if any.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return currentTracer().Start(ctx, name, attrs...)
+}
+
+// StartOperation starts a high level operation, like the creation of a tenant. The
+// returned function ends its span and records its metrics.
+func StartOperation(ctx context.Context, operation string, attrs ...Attribute) (context.Context, func(error)) {
+
+	start := time.Now()
+	ctx, span := Start(ctx, operation, attrs...)
+
+	return ctx, func(err error) {
+		span.End(err)
+		currentMetrics().ObserveOperation(operation, Outcome(err), time.Since(start))
+	}
+}
+
+// Step runs f in a span named name.
+func Step(ctx context.Context, name string, f func(ctx context.Context) error) error {
+
+	ctx, span := Start(ctx, name)
+	err := f(ctx)
+	span.End(err)
+
+	return err
+}
+
+// Outcome returns the outcome of an operation returning err.
+func Outcome(err error) string {
+
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveAPICall(string, string, string, time.Duration) {}
+func (noopMetrics) ObserveOperation(string, string, time.Duration)       {}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
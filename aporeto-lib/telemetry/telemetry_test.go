@@ -0,0 +1,121 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+type spanKey struct{}
+
+// testTracer records the spans as their path from the root span.
+type testTracer struct {
+	ended []string
+}
+
+type testSpan struct {
+	tracer *testTracer
+	path   string
+}
+
+func (s *testSpan) End(err error) {
+	if err != nil {
+		s.path += " (" + err.Error() + ")"
+	}
+	s.tracer.ended = append(s.tracer.ended, s.path)
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+
+	path := name
+	if parent, ok := ctx.Value(spanKey{}).(string); ok {
+		path = parent + "/" + name
+	}
+	return context.WithValue(ctx, spanKey{}, path), &testSpan{tracer: t, path: path}
+}
+
+// testMetrics records the observations as strings.
+type testMetrics struct {
+	observed []string
+}
+
+func (m *testMetrics) ObserveAPICall(identity, operation, outcome string, duration time.Duration) {
+	m.observed = append(m.observed, strings.Join([]string{"api", identity, operation, outcome}, " "))
+}
+
+func (m *testMetrics) ObserveOperation(operation, outcome string, duration time.Duration) {
+	m.observed = append(m.observed, strings.Join([]string{"operation", operation, outcome}, " "))
+}
+
+// testManipulator fails the deletions.
+type testManipulator struct {
+	manipulate.Manipulator
+}
+
+func (m *testManipulator) Create(manipulate.Context, elemental.Identifiable) error { return nil }
+
+func (m *testManipulator) Delete(manipulate.Context, elemental.Identifiable) error {
+	return errors.New("forbidden")
+}
+
+func TestNoop(t *testing.T) {
+
+	ctx := context.Background()
+	sctx, end := StartOperation(ctx, "tenant-create")
+	if sctx != ctx {
+		t.Errorf("StartOperation() changed the context without a tracer")
+	}
+	end(nil)
+
+	if err := Step(ctx, "namespaces", func(context.Context) error { return errors.New("boom") }); err == nil {
+		t.Errorf("Step() should return the error of the step")
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+
+	tracer := &testTracer{}
+	metrics := &testMetrics{}
+	SetTracer(tracer)
+	SetMetrics(metrics)
+	defer SetTracer(nil)
+	defer SetMetrics(nil)
+
+	m := NewManipulator(&testManipulator{})
+
+	ctx, end := StartOperation(context.Background(), "tenant-create", Attribute{Key: "tenant", Value: "t1"})
+	err := Step(ctx, "external-networks", func(ctx context.Context) error {
+		mctx := manipulate.NewContext(ctx, manipulate.ContextOptionNamespace("/acct/zone/t1"))
+		if err := m.Create(mctx, gaia.NewExternalNetwork()); err != nil {
+			return err
+		}
+		return m.Delete(mctx, gaia.NewExternalNetwork())
+	})
+	end(err)
+
+	wantSpans := []string{
+		"tenant-create/external-networks/api.create",
+		"tenant-create/external-networks/api.delete (forbidden)",
+		"tenant-create/external-networks (forbidden)",
+		"tenant-create (forbidden)",
+	}
+	if !reflect.DeepEqual(tracer.ended, wantSpans) {
+		t.Errorf("spans = %v, want %v", tracer.ended, wantSpans)
+	}
+
+	wantMetrics := []string{
+		"api externalnetwork create success",
+		"api externalnetwork delete error",
+		"operation tenant-create error",
+	}
+	if !reflect.DeepEqual(metrics.observed, wantMetrics) {
+		t.Errorf("metrics = %v, want %v", metrics.observed, wantMetrics)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/apoxfrm"
+	"go.aporeto.io/gaia"
+)
+
+// deleteEntry identifies an object to delete.
+type deleteEntry struct {
+	Identity  string `json:"identity"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// deleteList is the list of objects to delete.
+type deleteList struct {
+	Label   string        `json:"label,omitempty"`
+	Objects []deleteEntry `json:"objects"`
+}
+
+// appendEntries adds an entry for every object of a given identity.
+func (d *deleteList) appendEntries(identity string, objects []map[string]interface{}) {
+
+	for _, o := range objects {
+		name, _ := o["name"].(string)
+		namespace, _ := o["namespace"].(string)
+		d.Objects = append(d.Objects, deleteEntry{
+			Identity:  identity,
+			Namespace: namespace,
+			Name:      name,
+		})
+	}
+}
+
+// rollback generates the inverse of a migration so that it can be backed out:
+// - rollback-<file>: an import in Remove mode for the import label. When sent to the
+//   control plane, it removes every object created by the migration.
+// - rollback-list-<file>: the list of objects created by the migration.
+//
+// If label is empty, it is derived from the label of the output file, otherwise
+// out-<file> is not needed and only the remove import is generated.
+func rollback(dir, file, label string) {
+
+	list := &deleteList{Objects: []deleteEntry{}}
+
+	if label == "" {
+		importedData := readExport(dir, "out-"+file)
+		if importedData.Label == "" {
+			panic("no import label found in out-" + file)
+		}
+
+		label = importedData.Label
+		list.Label = label
+		list.appendEntries(gaia.ExternalNetworkIdentity.Name, importedData.Data[gaia.ExternalNetworkIdentity.Category])
+		list.appendEntries(gaia.NetworkRuleSetPolicyIdentity.Name, importedData.Data[gaia.NetworkRuleSetPolicyIdentity.Category])
+		list.appendEntries(gaia.HostServiceIdentity.Name, importedData.Data[gaia.HostServiceIdentity.Category])
+		list.appendEntries(gaia.HostServiceMappingPolicyIdentity.Name, importedData.Data[gaia.HostServiceMappingPolicyIdentity.Category])
+		writeYAML(dir, "rollback-list-"+file, list)
+	}
+
+	importData := gaia.NewImport()
+	importData.Mode = gaia.ImportModeRemove
+	importData.Data.Label = label
+
+	writeYAML(dir, "rollback-"+file, importData)
+
+	fmt.Println("Rollback label:          " + label)
+	fmt.Printf("Rollback objects:        %d\n", len(list.Objects))
+}
+
+// finalize generates the deletion of the v1 objects of <file> once the v2 objects are
+// imported:
+// - finalize-<file>: an import in Remove mode for the label of <file>. When sent to the
+//   control plane, it removes the v1 objects imported with that label.
+// - finalize-list-<file>: the v1 network access policies, external networks, host
+//   services and host service mapping policies it removes.
+//
+// It refuses to do so unless out-<file> is verified to be equivalent to <file>.
+func finalize(m *apoxfrm.Migrator, dir, file string, extraFiles []string) bool {
+
+	if !verify(m, dir, file, extraFiles) {
+		fmt.Println("    Error: v2 policies are not equivalent to v1 policies. Not finalizing.")
+		return false
+	}
+
+	exportedData := readExport(dir, file)
+	if exportedData.Label == "" {
+		fmt.Println("    Error: no import label found in " + file + ". Not finalizing.")
+		return false
+	}
+
+	list := &deleteList{
+		Label:   exportedData.Label,
+		Objects: []deleteEntry{},
+	}
+	list.appendEntries(gaia.NetworkAccessPolicyIdentity.Name, exportedData.Data[gaia.NetworkAccessPolicyIdentity.Category])
+	list.appendEntries(gaia.ExternalNetworkIdentity.Name, exportedData.Data[gaia.ExternalNetworkIdentity.Category])
+	list.appendEntries(gaia.HostServiceIdentity.Name, exportedData.Data[gaia.HostServiceIdentity.Category])
+	list.appendEntries(gaia.HostServiceMappingPolicyIdentity.Name, exportedData.Data[gaia.HostServiceMappingPolicyIdentity.Category])
+	writeYAML(dir, "finalize-list-"+file, list)
+
+	importData := gaia.NewImport()
+	importData.Mode = gaia.ImportModeRemove
+	importData.Data.Label = exportedData.Label
+	writeYAML(dir, "finalize-"+file, importData)
+
+	fmt.Println("Finalize label:          " + exportedData.Label + " (v2: " + m.Config().Naming().Name(exportedData.Label) + ")")
+	fmt.Printf("Finalize objects:        %d\n", len(list.Objects))
+
+	return true
+}
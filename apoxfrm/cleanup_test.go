@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/apoxfrm"
+	"github.com/ghodss/yaml"
+	"go.aporeto.io/gaia"
+)
+
+const cleanupExport = `
+label: tenant
+identities:
+  - externalnetworks
+  - networkaccesspolicies
+data:
+  externalnetworks:
+    - name: ssh
+      namespace: /customer/root/zone/tenant
+      associatedTags:
+        - customer:ext:net=ssh
+      entries:
+        - 10.0.0.0/8
+      servicePorts:
+        - tcp/22
+  networkaccesspolicies:
+    - name: ssh
+      namespace: /customer/root/zone/tenant
+      action: Allow
+      applyPolicyMode: OutgoingTraffic
+      subject:
+        - - app=x
+      object:
+        - - customer:ext:net=ssh
+`
+
+// migrate writes the test export in a temporary directory and migrates it.
+func migrate(t *testing.T) (*apoxfrm.Migrator, string) {
+
+	m, err := apoxfrm.New(apoxfrm.Options{ExtnetPrefixes: []string{"customer:ext:net="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tenant.yaml"), []byte(cleanupExport), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !process(m, dir, "tenant.yaml", nil, false) {
+		t.Fatal("process() failed")
+	}
+
+	return m, dir
+}
+
+// readYAML reads a yaml file generated in dir into v.
+func readYAML(t *testing.T, dir, file string, v interface{}) {
+
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_rollback(t *testing.T) {
+
+	_, dir := migrate(t)
+	label := readExport(dir, "out-tenant.yaml").Label
+
+	tests := []struct {
+		name      string
+		label     string
+		wantLabel string
+		wantList  bool
+	}{
+		{
+			name:      "label of the output file",
+			wantLabel: label,
+			wantList:  true,
+		},
+		{
+			name:      "explicit label",
+			label:     "other-v2",
+			wantLabel: "other-v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			os.Remove(filepath.Join(dir, "rollback-list-tenant.yaml")) // nolint
+			rollback(dir, "tenant.yaml", tt.label)
+
+			importData := &gaia.Import{}
+			readYAML(t, dir, "rollback-tenant.yaml", importData)
+			if importData.Mode != gaia.ImportModeRemove || importData.Data.Label != tt.wantLabel {
+				t.Errorf("rollback() import = %s %s, want %s %s", importData.Mode, importData.Data.Label, gaia.ImportModeRemove, tt.wantLabel)
+			}
+
+			_, err := os.Stat(filepath.Join(dir, "rollback-list-tenant.yaml"))
+			if (err == nil) != tt.wantList {
+				t.Fatalf("rollback() generated the list = %v, want %v", err == nil, tt.wantList)
+			}
+			if !tt.wantList {
+				return
+			}
+			list := &deleteList{}
+			readYAML(t, dir, "rollback-list-tenant.yaml", list)
+			if list.Label != label || len(list.Objects) == 0 {
+				t.Errorf("rollback() list = %+v", list)
+			}
+		})
+	}
+}
+
+func Test_finalize(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, dir string)
+		want    bool
+		wantObj []deleteEntry
+	}{
+		{
+			name:   "verified",
+			change: func(*testing.T, string) {},
+			want:   true,
+			wantObj: []deleteEntry{
+				{Identity: "networkaccesspolicy", Namespace: "/customer/root/zone/tenant", Name: "ssh"},
+				{Identity: "externalnetwork", Namespace: "/customer/root/zone/tenant", Name: "ssh"},
+			},
+		},
+		{
+			name: "not verified",
+			change: func(t *testing.T, dir string) {
+				out := readExport(dir, "out-tenant.yaml")
+				delete(out.Data, gaia.NetworkRuleSetPolicyIdentity.Category)
+				writeYAML(dir, "out-tenant.yaml", out)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			m, dir := migrate(t)
+			tt.change(t, dir)
+
+			if got := finalize(m, dir, "tenant.yaml", nil); got != tt.want {
+				t.Fatalf("finalize() = %v, want %v", got, tt.want)
+			}
+
+			if !tt.want {
+				for _, file := range []string{"finalize-tenant.yaml", "finalize-list-tenant.yaml"} {
+					if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+						t.Errorf("finalize() generated %s", file)
+					}
+				}
+				return
+			}
+
+			importData := &gaia.Import{}
+			readYAML(t, dir, "finalize-tenant.yaml", importData)
+			if importData.Mode != gaia.ImportModeRemove || importData.Data.Label != "tenant" {
+				t.Errorf("finalize() import = %s %s, want %s tenant", importData.Mode, importData.Data.Label, gaia.ImportModeRemove)
+			}
+
+			list := &deleteList{}
+			readYAML(t, dir, "finalize-list-tenant.yaml", list)
+			if list.Label != "tenant" || !reflect.DeepEqual(list.Objects, tt.wantObj) {
+				t.Errorf("finalize() list = %+v, want %+v", list.Objects, tt.wantObj)
+			}
+		})
+	}
+}
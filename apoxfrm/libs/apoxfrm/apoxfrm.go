@@ -0,0 +1,549 @@
+// Package apoxfrm migrates network access policies and external networks to network rule
+// set policies. It works on in-memory exports so that the migration can be embedded in
+// other tools. The apoxfrm command is a thin wrapper working on files.
+package apoxfrm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/hostservice"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/hostservicemapping"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/verifier"
+	"github.com/ghodss/yaml"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+
+	"go.aporeto.io/gaia"
+)
+
+// Options configures a Migrator.
+type Options struct {
+	// ExtnetPrefixes are the prefixes of the tags referencing external networks.
+	ExtnetPrefixes []string
+
+	// ExtnetPatterns are regular expressions matching the tags referencing external networks.
+	ExtnetPatterns []string
+
+	// HostServicePrefix is the prefix of the tags referencing host services.
+	HostServicePrefix string
+
+	// Naming is the naming strategy of migrated objects. Defaults to the migration suffix.
+	Naming *utils.Naming
+
+	// NoMerge keeps one network rule set policy per generated rule set instead of merging
+	// the network rule set policies sharing the same subject.
+	NoMerge bool
+
+	// Collapse emits only the effective direction of bidirectional policies and drops
+	// ineffective policies.
+	Collapse bool
+
+	// Strict makes Migrate fail if any object has errors or warnings.
+	Strict bool
+
+	// Logger receives the progress of the migration. Defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// Result is the result of a migration.
+type Result struct {
+	// Import contains the v2 objects to import.
+	Import *gaia.Import
+
+	// Diagnostics lists the warnings found in the network policies.
+	Diagnostics []*networkpolicies.Diagnostics
+
+	// Decisions lists how network policies were collapsed or dropped.
+	Decisions []*networkpolicies.Decision
+
+	// Skipped lists the objects that were already migrated and are not migrated again.
+	Skipped []string
+
+	// Errors lists the errors found while migrating the objects. The diagnostics are
+	// part of the errors.
+	Errors []error
+}
+
+// A Migrator migrates exports.
+type Migrator struct {
+	cfg  *utils.Config
+	opts Options
+}
+
+// New returns a new Migrator.
+func New(opts Options) (*Migrator, error) {
+
+	cfg, err := utils.NewConfig(opts.ExtnetPrefixes, opts.ExtnetPatterns, opts.HostServicePrefix, opts.Naming)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+
+	return &Migrator{
+		cfg:  cfg,
+		opts: opts,
+	}, nil
+}
+
+// Config returns the configuration of the migrator.
+func (m *Migrator) Config() *utils.Config {
+	return m.cfg
+}
+
+// ReadExport reads a yaml or json export.
+func ReadExport(r io.Reader) (*gaia.Export, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read export: %s", err)
+	}
+
+	export := gaia.NewExport()
+	if err := yaml.Unmarshal(data, export); err != nil {
+		return nil, fmt.Errorf("unable to decode export: %s", err)
+	}
+
+	return export, nil
+}
+
+// MigrateReader reads an export and the exports of its parent namespaces and migrates it.
+func (m *Migrator) MigrateReader(r io.Reader, parents ...io.Reader) (*Result, error) {
+
+	export, err := ReadExport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pexports := make([]*gaia.Export, 0, len(parents))
+	for _, p := range parents {
+		pexport, err := ReadExport(p)
+		if err != nil {
+			return nil, err
+		}
+		pexports = append(pexports, pexport)
+	}
+
+	return m.Migrate(export, pexports...)
+}
+
+// Migrate migrates an export. The external networks of the exports of the parent
+// namespaces are used to resolve the network policies but are not migrated.
+//
+// An error is returned if an object can not be decoded or, in strict mode, if any
+// object has errors or warnings. In the latter case the result is returned as well.
+func (m *Migrator) Migrate(export *gaia.Export, parents ...*gaia.Export) (*Result, error) {
+
+	res := &Result{
+		Import:      gaia.NewImport(),
+		Diagnostics: []*networkpolicies.Diagnostics{},
+		Decisions:   []*networkpolicies.Decision{},
+		Skipped:     []string{},
+		Errors:      []error{},
+	}
+
+	extnets := export.Data["externalnetworks"]
+	netpols := export.Data["networkaccesspolicies"]
+
+	gextnets, xextnets, err := m.xfrmExtNets(res, extnets, parentExternalNetworks(parents))
+	if err != nil {
+		return nil, err
+	}
+
+	netrulesetpolicies, err := m.xfrmNetPols(res, netpols, gextnets)
+	if err != nil {
+		return nil, err
+	}
+
+	importData := res.Import.Data
+	importData.Label = m.cfg.Naming().Name(export.Label)
+	importData.Data["externalnetworks"] = res.skipExisting("external network", xextnets, extnets)
+	importData.Identities = append(importData.Identities, "externalnetworks")
+	importData.Data["networkrulesetpolicies"] = res.skipExisting("network rule set policy", netrulesetpolicies, export.Data["networkrulesetpolicies"])
+	importData.Identities = append(importData.Identities, "networkrulesetpolicies")
+
+	if hostservices := export.Data["hostservices"]; len(hostservices) > 0 {
+		xhostservices, err := m.xfrmHostServices(res, hostservices)
+		if err != nil {
+			return nil, err
+		}
+		importData.Data["hostservices"] = res.skipExisting("host service", xhostservices, hostservices)
+		importData.Identities = append(importData.Identities, "hostservices")
+	}
+	if mappings := export.Data["hostservicemappingpolicies"]; len(mappings) > 0 {
+		xmappings, err := m.xfrmHostServiceMappings(res, mappings)
+		if err != nil {
+			return nil, err
+		}
+		importData.Data["hostservicemappingpolicies"] = res.skipExisting("host service mapping policy", xmappings, mappings)
+		importData.Identities = append(importData.Identities, "hostservicemappingpolicies")
+	}
+
+	if m.opts.Strict && len(res.Errors) > 0 {
+		return res, fmt.Errorf("strict mode: %d errors found", len(res.Errors))
+	}
+
+	return res, nil
+}
+
+// Explain explains the transformation of the network policies of an export. Network rule
+// set policies are not merged so that each of them can be traced back to its network policy.
+func (m *Migrator) Explain(export *gaia.Export, parents ...*gaia.Export) ([]*networkpolicies.Explanation, error) {
+
+	extnetList, _, err := m.xfrmExtNets(&Result{}, export.Data["externalnetworks"], parentExternalNetworks(parents))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := networkpolicies.Options{
+		Config:   m.cfg,
+		Collapse: m.opts.Collapse,
+		Logger:   m.opts.Logger,
+	}
+
+	explanations := []*networkpolicies.Explanation{}
+	for _, n := range export.Data["networkaccesspolicies"] {
+		netpol := gaia.NewNetworkAccessPolicy()
+		if err := mapstructure.Decode(n, netpol); err != nil {
+			return nil, fmt.Errorf("error in network policy: %s", err)
+		}
+		explanations = append(explanations, networkpolicies.Explain(netpol, extnetList, opts))
+	}
+
+	return explanations, nil
+}
+
+// Verify checks that the network rule set policies and external networks of a migrated
+// export allow and reject exactly the same flows as the network access policies and
+// external networks of the original export.
+func (m *Migrator) Verify(export, migrated *gaia.Export, parents ...*gaia.Export) (*verifier.Report, error) {
+
+	extraextnets := parentExternalNetworks(parents)
+
+	// External networks from the parent hierarchy are not part of the migrated export.
+	// They are expected to be migrated with the parent namespace.
+	var extnets, v2extnets gaia.ExternalNetworksList
+	for i, e := range append(extraextnets, export.Data["externalnetworks"]...) {
+		extnet, err := externalnetwork.Decode(e)
+		if err != nil {
+			return nil, fmt.Errorf("error in external network: %s", err)
+		}
+		// Already migrated external networks are part of the v2 model.
+		if m.cfg.Naming().IsMigrated(extnet.Name) {
+			v2extnets = append(v2extnets, extnet)
+			continue
+		}
+		extnets = append(extnets, extnet)
+		if i < len(extraextnets) {
+			v2extnets = append(v2extnets, externalnetwork.Transform(m.cfg, extnet))
+		}
+	}
+	for _, e := range migrated.Data["externalnetworks"] {
+		extnet := gaia.NewExternalNetwork()
+		if err := mapstructure.Decode(e, extnet); err != nil {
+			return nil, fmt.Errorf("error in external network: %s", err)
+		}
+		v2extnets = append(v2extnets, extnet)
+	}
+
+	var netpols gaia.NetworkAccessPoliciesList
+	for _, n := range export.Data["networkaccesspolicies"] {
+		netpol := gaia.NewNetworkAccessPolicy()
+		if err := mapstructure.Decode(n, netpol); err != nil {
+			return nil, fmt.Errorf("error in network policy: %s", err)
+		}
+		netpols = append(netpols, netpol)
+	}
+
+	// Network rule set policies already in the export are part of the v2 model.
+	var rulesets gaia.NetworkRuleSetPoliciesList
+	for _, n := range append(export.Data["networkrulesetpolicies"], migrated.Data["networkrulesetpolicies"]...) {
+		ruleset := gaia.NewNetworkRuleSetPolicy()
+		if err := mapstructure.Decode(n, ruleset); err != nil {
+			return nil, fmt.Errorf("error in network rule set policy: %s", err)
+		}
+		rulesets = append(rulesets, ruleset)
+	}
+
+	return verifier.New(m.cfg, netpols, extnets, rulesets, v2extnets).Verify(), nil
+}
+
+// xfrmNetPols transforms the network access policies into new policies called network ruleset policies.
+//
+// Arguments:
+// - netpols: network policies
+// - extnetList: external networks (from complete ns hierarcy that may be needed to resolve these policies)
+//
+// Returns:
+// - netrulesetpolicies: network rule set policies.
+//
+// The key things to observe w.r.t. new policies are:
+// - Bidirectional policy mode not supported.
+// - One network rule set policy is applied to a set of processing units and contains both ingress and egress rules for this set of processing units.
+// - Multiple network rule set policies can still be applied to the same set of processing units.
+// - Port matching is a part of incoming and outgoing rules.
+//
+// Unless NoMerge is set, network rule set policies sharing the same subject are merged.
+// Otherwise every network rule set policy can be traced back to a single network access
+// policy.
+//
+// If Collapse is set, bidirectional policies whose subjects or objects are all external
+// networks only generate the effective direction and ineffective policies are dropped.
+// Every decision is recorded in the result.
+//
+func (m *Migrator) xfrmNetPols(res *Result, netpols []map[string]interface{}, extnetList gaia.ExternalNetworksList) (netrulesetpolicies []map[string]interface{}, err error) {
+
+	netrulesetpolicies = make([]map[string]interface{}, 0)
+	rulesets := gaia.NetworkRuleSetPoliciesList{}
+	opts := networkpolicies.Options{
+		Config:   m.cfg,
+		Collapse: m.opts.Collapse,
+		Quiet:    true,
+		Logger:   m.opts.Logger,
+	}
+
+	for _, n := range netpols {
+
+		netpol := gaia.NewNetworkAccessPolicy()
+		if err := mapstructure.Decode(n, netpol); err != nil {
+			return nil, fmt.Errorf("error in network policy: %s", err)
+		}
+
+		transformations, decision, err := networkpolicies.Transform(netpol, extnetList, opts)
+		if err != nil {
+			if d, ok := err.(*networkpolicies.Diagnostics); ok {
+				res.Diagnostics = append(res.Diagnostics, d)
+			}
+			res.Errors = append(res.Errors, err)
+		}
+		if decision != nil {
+			res.Decisions = append(res.Decisions, decision)
+		}
+
+		m.opts.Logger.Info(
+			"Network Policy",
+			zap.String("ns", netpol.Namespace),
+			zap.String("name", netpol.Name),
+			zap.Reflect("mode", netpol.ApplyPolicyMode),
+			zap.Strings("ports", netpol.Ports),
+			zap.Bool("propagate", netpol.Propagate),
+			zap.Int("num-subjects", len(netpol.Subject)),
+			zap.Int("num-objects", len(netpol.Object)),
+		)
+
+		rulesets = append(rulesets, transformations...)
+	}
+
+	if !m.opts.NoMerge {
+		merged := networkpolicies.Merge(rulesets)
+		m.opts.Logger.Info(
+			"Merged Network Rule Set Policies",
+			zap.Int("num-rulesets", len(rulesets)),
+			zap.Int("num-merged-rulesets", len(merged)),
+		)
+		rulesets = merged
+	}
+
+	for _, r := range rulesets {
+		netrulesetpolicies = append(netrulesetpolicies, networkpolicies.Encode(r))
+	}
+
+	return netrulesetpolicies, nil
+}
+
+// xfrmExtNets transforms the external networks to a v2 model.
+// The key thing here is an external network can not define protocols and ports in the external network definition.
+//
+// Arguments:
+// - extnets: external networks that are in this ns level and will need to be reimported.
+// - extraextnets: external networks that are in the higher ns level if any. these will not be generated in file to import.
+//
+// Returns:
+// - extnetList: list of external networks which will be used to resolve policies.
+// - xextnets: transformed external networks that will need to be added to import files.
+//
+func (m *Migrator) xfrmExtNets(res *Result, extnets, extraextnets []map[string]interface{}) (extnetList gaia.ExternalNetworksList, xextnets []map[string]interface{}, err error) {
+
+	all := append(extraextnets, extnets...)
+	for i, e := range all {
+
+		extnet, err := externalnetwork.Decode(e)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error in external network: %s", err)
+		}
+
+		// Already migrated external networks are neither used to resolve policies nor migrated again
+		if m.migrated(res, "external network", extnet.Namespace, extnet.Name, all) {
+			continue
+		}
+
+		// create a global list that can be used in network policies
+		extnetList = append(extnetList, extnet)
+
+		// Process the external network - Create a v2 copy, add suffix to name, remove protocol and ports
+		v2extnet := externalnetwork.Transform(m.cfg, extnet)
+
+		m.opts.Logger.Info(
+			"External Network",
+			zap.String("ns", extnet.Namespace),
+			zap.String("name", extnet.Name),
+			zap.String("type", string(extnet.Type)),
+			zap.Strings("entries", extnet.Entries),
+			zap.Strings("ports", extnet.ServicePorts),
+		)
+
+		// Dont export extra external networks
+		if i >= len(extraextnets) {
+			xe, err := externalnetwork.Encode(v2extnet)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error in external network: %s", err)
+			}
+			xextnets = append(xextnets, xe)
+		}
+	}
+	return extnetList, xextnets, nil
+}
+
+// xfrmHostServices transforms the host services to a v2 model. Network rule set policies
+// reference the host services with the migration suffix, so a v2 copy of every host
+// service is generated.
+func (m *Migrator) xfrmHostServices(res *Result, hostservices []map[string]interface{}) (xhostservices []map[string]interface{}, err error) {
+
+	xhostservices = make([]map[string]interface{}, 0)
+	for _, h := range hostservices {
+
+		hs, err := hostservice.Decode(h)
+		if err != nil {
+			return nil, fmt.Errorf("error in host service: %s", err)
+		}
+
+		if m.migrated(res, "host service", hs.Namespace, hs.Name, hostservices) {
+			continue
+		}
+
+		v2hs := hostservice.Transform(m.cfg, hs)
+
+		m.opts.Logger.Info(
+			"Host Service",
+			zap.String("ns", hs.Namespace),
+			zap.String("name", hs.Name),
+			zap.Strings("services", hs.Services),
+			zap.Bool("hostModeEnabled", hs.HostModeEnabled),
+		)
+
+		xh, err := hostservice.Encode(v2hs)
+		if err != nil {
+			return nil, fmt.Errorf("error in host service: %s", err)
+		}
+		xhostservices = append(xhostservices, xh)
+	}
+	return xhostservices, nil
+}
+
+// xfrmHostServiceMappings transforms the host service mapping policies so that the
+// enforcers are mapped to the v2 host services.
+func (m *Migrator) xfrmHostServiceMappings(res *Result, mappings []map[string]interface{}) (xmappings []map[string]interface{}, err error) {
+
+	xmappings = make([]map[string]interface{}, 0)
+	for _, mp := range mappings {
+
+		policy, err := hostservicemapping.Decode(mp)
+		if err != nil {
+			return nil, fmt.Errorf("error in host service mapping policy: %s", err)
+		}
+
+		if m.migrated(res, "host service mapping policy", policy.Namespace, policy.Name, mappings) {
+			continue
+		}
+
+		v2policy := hostservicemapping.Transform(m.cfg, policy)
+
+		m.opts.Logger.Info(
+			"Host Service Mapping Policy",
+			zap.String("ns", policy.Namespace),
+			zap.String("name", policy.Name),
+			zap.Any("object", v2policy.Object),
+		)
+
+		xm, err := hostservicemapping.Encode(v2policy)
+		if err != nil {
+			return nil, fmt.Errorf("error in host service mapping policy: %s", err)
+		}
+		xmappings = append(xmappings, xm)
+	}
+	return xmappings, nil
+}
+
+// migrated returns true if the object named name in namespace is already migrated and
+// records it as skipped. Migrated objects are recognized by their name only: with the
+// suffix or prefix strategy, a v1 object whose name happens to look migrated, like
+// web-v2, is skipped as well. The skipped objects without their original object in
+// objects may be such v1 objects and are logged as warnings. A template naming using
+// the migration ID avoids these collisions.
+func (m *Migrator) migrated(res *Result, identity, namespace, name string, objects []map[string]interface{}) bool {
+
+	if !m.cfg.Naming().IsMigrated(name) {
+		return false
+	}
+
+	res.Skipped = append(res.Skipped, identity+" "+name+" already migrated")
+
+	original := m.cfg.Naming().Original(name)
+	for _, o := range objects {
+		if n, _ := o["name"].(string); n == original {
+			if ns, _ := o["namespace"].(string); ns == namespace {
+				return true
+			}
+		}
+	}
+
+	m.opts.Logger.Warn(
+		"Skipped object named like a migrated object without its original object",
+		zap.String("identity", identity),
+		zap.String("ns", namespace),
+		zap.String("name", name),
+		zap.String("original", original),
+		zap.String("naming", m.cfg.Naming().String()),
+	)
+
+	return true
+}
+
+// skipExisting removes the generated objects that already exist in the export. This
+// happens when the namespace was already partially migrated, and makes re-runs idempotent.
+func (res *Result) skipExisting(identity string, generated, existing []map[string]interface{}) []map[string]interface{} {
+
+	names := map[string]struct{}{}
+	for _, o := range existing {
+		if name, ok := o["name"].(string); ok {
+			names[name] = struct{}{}
+		}
+	}
+
+	r := make([]map[string]interface{}, 0, len(generated))
+	for _, o := range generated {
+		name, _ := o["name"].(string)
+		if _, ok := names[name]; ok {
+			res.Skipped = append(res.Skipped, identity+" "+name+" already exists")
+			continue
+		}
+		r = append(r, o)
+	}
+	return r
+}
+
+// parentExternalNetworks returns the external networks of the exports of the parent namespaces.
+func parentExternalNetworks(parents []*gaia.Export) (extraextnets []map[string]interface{}) {
+
+	for _, p := range parents {
+		extraextnets = append(extraextnets, p.Data["externalnetworks"]...)
+	}
+	return
+}
@@ -0,0 +1,286 @@
+package apoxfrm
+
+import (
+	"strings"
+	"testing"
+
+	"go.aporeto.io/gaia"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testExport = `
+label: tenant
+identities:
+  - externalnetworks
+  - networkaccesspolicies
+data:
+  externalnetworks:
+    - name: ssh
+      namespace: /customer/root/zone/tenant
+      associatedTags:
+        - customer:ext:net=ssh
+      entries:
+        - 10.0.0.0/8
+      servicePorts:
+        - tcp/22
+    - name: web-v2
+      namespace: /customer/root/zone/tenant
+      associatedTags:
+        - customer:ext:net=web-v2
+      entries:
+        - 192.168.0.0/16
+  networkaccesspolicies:
+    - name: ssh
+      namespace: /customer/root/zone/tenant
+      action: Allow
+      applyPolicyMode: OutgoingTraffic
+      subject:
+        - - app=x
+      object:
+        - - customer:ext:net=ssh
+    - name: negated
+      namespace: /customer/root/zone/tenant
+      action: Allow
+      applyPolicyMode: OutgoingTraffic
+      negateObject: true
+      subject:
+        - - app=x
+      object:
+        - - app=y
+`
+
+const testParent = `
+label: zone
+data:
+  externalnetworks:
+    - name: dns
+      namespace: /customer/root/zone
+      associatedTags:
+        - customer:ext:net=dns
+      entries:
+        - 8.8.8.8
+      servicePorts:
+        - udp/53
+      propagate: true
+`
+
+func names(objects []map[string]interface{}) []string {
+
+	r := []string{}
+	for _, o := range objects {
+		name, _ := o["name"].(string)
+		r = append(r, name)
+	}
+	return r
+}
+
+func TestNew(t *testing.T) {
+
+	if _, err := New(Options{ExtnetPatterns: []string{"("}}); err == nil {
+		t.Errorf("New() expected error on invalid pattern")
+	}
+	if _, err := New(Options{ExtnetPrefixes: []string{""}}); err == nil {
+		t.Errorf("New() expected error on empty prefix")
+	}
+
+	m, err := New(Options{ExtnetPrefixes: []string{"customer:ext:net="}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := m.Config().Naming().Name("x"); got != "x-v2" {
+		t.Errorf("New() default naming = %v, want x-v2", got)
+	}
+}
+
+func TestMigrator_MigrateReader(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		opts            Options
+		wantErr         bool
+		wantExtnets     []string
+		wantSkipped     int
+		wantDiagnostics []string
+		wantNumRulesets int
+		wantNumErrors   int
+		wantImportLabel string
+	}{
+		{
+			name:            "default",
+			opts:            Options{ExtnetPrefixes: []string{"customer:ext:net="}},
+			wantExtnets:     []string{"ssh-v2"},
+			wantSkipped:     1,
+			wantDiagnostics: []string{"negated"},
+			wantNumRulesets: 1,
+			wantNumErrors:   1,
+			wantImportLabel: "tenant-v2",
+		},
+		{
+			name:            "strict",
+			opts:            Options{ExtnetPrefixes: []string{"customer:ext:net="}, Strict: true},
+			wantErr:         true,
+			wantExtnets:     []string{"ssh-v2"},
+			wantSkipped:     1,
+			wantDiagnostics: []string{"negated"},
+			wantNumRulesets: 1,
+			wantNumErrors:   1,
+			wantImportLabel: "tenant-v2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			m, err := New(tt.opts)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			res, err := m.MigrateReader(strings.NewReader(testExport), strings.NewReader(testParent))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MigrateReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res == nil {
+				t.Fatalf("MigrateReader() no result")
+			}
+
+			if got := res.Import.Data.Label; got != tt.wantImportLabel {
+				t.Errorf("MigrateReader() label = %v, want %v", got, tt.wantImportLabel)
+			}
+			if got := names(res.Import.Data.Data["externalnetworks"]); strings.Join(got, ",") != strings.Join(tt.wantExtnets, ",") {
+				t.Errorf("MigrateReader() externalnetworks = %v, want %v", got, tt.wantExtnets)
+			}
+			if got := len(res.Import.Data.Data["networkrulesetpolicies"]); got != tt.wantNumRulesets {
+				t.Errorf("MigrateReader() len(networkrulesetpolicies) = %v, want %v", got, tt.wantNumRulesets)
+			}
+			if got := len(res.Skipped); got != tt.wantSkipped {
+				t.Errorf("MigrateReader() len(Skipped) = %v, want %v: %v", got, tt.wantSkipped, res.Skipped)
+			}
+			if got := len(res.Errors); got != tt.wantNumErrors {
+				t.Errorf("MigrateReader() len(Errors) = %v, want %v: %v", got, tt.wantNumErrors, res.Errors)
+			}
+			if len(res.Diagnostics) != len(tt.wantDiagnostics) {
+				t.Fatalf("MigrateReader() Diagnostics = %v, want %v", res.Diagnostics, tt.wantDiagnostics)
+			}
+			for i, d := range res.Diagnostics {
+				if d.Policy != tt.wantDiagnostics[i] || !d.Exception {
+					t.Errorf("MigrateReader() Diagnostics[%d] = %v, want exception for %v", i, d.Policy, tt.wantDiagnostics[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMigrator_Migrate_Skipped(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		extnets      []string
+		wantSkipped  []string
+		wantWarnings []string
+	}{
+		{
+			name:        "migrated",
+			extnets:     []string{"ssh", "ssh-v2"},
+			wantSkipped: []string{"external network ssh-v2 already migrated", "external network ssh-v2 already exists"},
+		},
+		{
+			name:         "named like a migrated object",
+			extnets:      []string{"ssh", "web-v2"},
+			wantSkipped:  []string{"external network web-v2 already migrated"},
+			wantWarnings: []string{"web-v2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			m, err := New(Options{ExtnetPrefixes: []string{"customer:ext:net="}, Logger: zap.New(core)})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			export := gaia.NewExport()
+			for _, name := range tt.extnets {
+				export.Data["externalnetworks"] = append(export.Data["externalnetworks"], map[string]interface{}{
+					"name":           name,
+					"namespace":      "/customer/root/zone/tenant",
+					"associatedTags": []string{"customer:ext:net=" + name},
+					"entries":        []string{"10.0.0.0/8"},
+				})
+			}
+
+			res, err := m.Migrate(export)
+			if err != nil {
+				t.Fatalf("Migrate() error = %v", err)
+			}
+			if strings.Join(res.Skipped, ",") != strings.Join(tt.wantSkipped, ",") {
+				t.Errorf("Migrate() Skipped = %v, want %v", res.Skipped, tt.wantSkipped)
+			}
+
+			warnings := []string{}
+			for _, e := range logs.All() {
+				warnings = append(warnings, e.ContextMap()["name"].(string))
+			}
+			if strings.Join(warnings, ",") != strings.Join(tt.wantWarnings, ",") {
+				t.Errorf("Migrate() warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestMigrator_Migrate_Idempotent(t *testing.T) {
+
+	m, err := New(Options{ExtnetPrefixes: []string{"customer:ext:net="}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	export, err := ReadExport(strings.NewReader(testExport))
+	if err != nil {
+		t.Fatalf("ReadExport() error = %v", err)
+	}
+	// Drop the negated policy so that the migration is equivalent.
+	export.Data["networkaccesspolicies"] = export.Data["networkaccesspolicies"][:1]
+
+	parent, err := ReadExport(strings.NewReader(testParent))
+	if err != nil {
+		t.Fatalf("ReadExport() error = %v", err)
+	}
+
+	res, err := m.Migrate(export, parent)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+
+	report, err := m.Verify(export, res.Import.Data, parent)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !report.Equivalent() {
+		t.Errorf("Verify() discrepancies = %v", report.Discrepancies)
+	}
+
+	// Migrating the export with the migrated objects imported generates nothing new.
+	migrated := gaia.NewExport()
+	migrated.Label = export.Label
+	for k, v := range export.Data {
+		migrated.Data[k] = v
+	}
+	for k, v := range res.Import.Data.Data {
+		migrated.Data[k] = append(migrated.Data[k], v...)
+	}
+
+	res, err = m.Migrate(migrated, parent)
+	if err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	for identity, objects := range res.Import.Data.Data {
+		if len(objects) != 0 {
+			t.Errorf("Migrate() %s = %v, want none", identity, names(objects))
+		}
+	}
+}
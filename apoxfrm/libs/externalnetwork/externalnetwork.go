@@ -2,6 +2,8 @@ package externalnetwork
 
 import (
 	"fmt"
+	"net"
+	"regexp"
 	"strings"
 
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
@@ -9,6 +11,22 @@ import (
 	"go.aporeto.io/gaia"
 )
 
+// fqdnRegex matches domain names, optionally starting with a wildcard label.
+var fqdnRegex = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?\.)*[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+// IsFQDN returns true if an external network entry is a domain name rather than an IP
+// address or a CIDR.
+func IsFQDN(entry string) bool {
+
+	if net.ParseIP(entry) != nil {
+		return false
+	}
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return false
+	}
+	return len(entry) <= 253 && fqdnRegex.MatchString(entry)
+}
+
 // Decode a gaia.ExternalNetwork from a map. Also performs sanity checks.
 func Decode(e map[string]interface{}) (*gaia.ExternalNetwork, error) {
 
@@ -17,24 +35,44 @@ func Decode(e map[string]interface{}) (*gaia.ExternalNetwork, error) {
 		return nil, err
 	}
 
-	if extnet.Type != gaia.ExternalNetworkTypeSubnet {
-		return nil, fmt.Errorf("unhandled external network type")
+	switch extnet.Type {
+
+	case gaia.ExternalNetworkTypeSubnet:
+		// Entries of a subnet can be IP addresses, CIDRs or FQDNs.
+		for _, entry := range extnet.Entries {
+			if net.ParseIP(entry) != nil {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(entry); err == nil {
+				continue
+			}
+			if !IsFQDN(entry) {
+				return nil, fmt.Errorf("external network '%s' has invalid entry '%s'", extnet.Name, entry)
+			}
+		}
+
+	case gaia.ExternalNetworkTypeENI:
+		// Entries of an ENI are managed by the cloud provider and are kept as is.
+
+	default:
+		return nil, fmt.Errorf("external network '%s' has unhandled type '%s'", extnet.Name, extnet.Type)
 	}
 
 	return extnet, nil
 }
 
 // Transform a gaia.ExternalNetwork to a v2 version.
-func Transform(extnet *gaia.ExternalNetwork) *gaia.ExternalNetwork {
+func Transform(cfg *utils.Config, extnet *gaia.ExternalNetwork) *gaia.ExternalNetwork {
 
-	// Process the external network - Create a v2 copy, add suffix to name, remove protocol and ports
+	// Process the external network - Create a v2 copy, add suffix to name, remove protocol and ports.
+	// The type and entries are kept as is.
 	v2extnet := extnet.DeepCopy()
-	v2extnet.Name = v2extnet.Name + utils.MigrationSuffix
+	v2extnet.Name = cfg.Naming().Name(v2extnet.Name)
 	v2extnet.ServicePorts = []string{}
 	associatedTags := []string{}
 	for _, t := range v2extnet.AssociatedTags {
-		if strings.HasPrefix(t, utils.ExtnetPrefix) {
-			t = t + utils.MigrationSuffix
+		if cfg.IsExtnetTag(t) {
+			t = cfg.Naming().Tag(t)
 		}
 		associatedTags = append(associatedTags, t)
 	}
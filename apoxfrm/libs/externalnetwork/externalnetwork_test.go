@@ -55,10 +55,10 @@ func TestTransform(t *testing.T) {
 			},
 		},
 	}
-	utils.ExtnetPrefix = "customer:ext:net="
+	cfg := utils.MustNewConfig([]string{"customer:ext:net="}, nil, "", nil)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := Transform(tt.args.extnet)
+			got := Transform(cfg, tt.args.extnet)
 			if got.Name != tt.want.Name {
 				t.Errorf("Transform() Name = %v, want %v", got.Name, tt.want.Name)
 			}
@@ -84,3 +84,85 @@ func TestTransform(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		e        map[string]interface{}
+		wantType gaia.ExternalNetworkTypeValue
+		wantErr  bool
+	}{
+		{
+			name:     "default type",
+			e:        map[string]interface{}{"name": "default", "entries": []string{"10.0.0.0/8"}},
+			wantType: gaia.ExternalNetworkTypeSubnet,
+		},
+		{
+			name:     "subnet",
+			e:        map[string]interface{}{"name": "subnet", "type": "Subnet", "entries": []string{"10.0.0.0/8", "192.168.1.1", "::/0"}},
+			wantType: gaia.ExternalNetworkTypeSubnet,
+		},
+		{
+			name:     "fqdn",
+			e:        map[string]interface{}{"name": "fqdn", "type": "Subnet", "entries": []string{"www.example.com", "*.example.org", "10.0.0.0/8"}},
+			wantType: gaia.ExternalNetworkTypeSubnet,
+		},
+		{
+			name:     "eni",
+			e:        map[string]interface{}{"name": "eni", "type": "ENI", "entries": []string{"eni-0123456789abcdef0"}},
+			wantType: gaia.ExternalNetworkTypeENI,
+		},
+		{
+			name:    "invalid entry",
+			e:       map[string]interface{}{"name": "invalid", "type": "Subnet", "entries": []string{"10.0.0.999/8"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			e:       map[string]interface{}{"name": "unknown", "type": "Unknown"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.e)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Type != tt.wantType {
+				t.Errorf("Decode() Type = %v, want %v", got.Type, tt.wantType)
+			}
+			v2 := Transform(utils.MustNewConfig(nil, nil, "", nil), got)
+			if v2.Type != got.Type || len(v2.Entries) != len(got.Entries) {
+				t.Errorf("Transform() Type = %v Entries = %v, want %v %v", v2.Type, v2.Entries, got.Type, got.Entries)
+			}
+		})
+	}
+}
+
+func TestIsFQDN(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  bool
+	}{
+		{entry: "www.example.com", want: true},
+		{entry: "*.example.com", want: true},
+		{entry: "localhost", want: true},
+		{entry: "10.0.0.1", want: false},
+		{entry: "10.0.0.0/8", want: false},
+		{entry: "2001:db8::/32", want: false},
+		{entry: "10.0.0.999", want: false},
+		{entry: "bad_entry/8", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			if got := IsFQDN(tt.entry); got != tt.want {
+				t.Errorf("IsFQDN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
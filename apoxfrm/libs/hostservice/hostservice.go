@@ -0,0 +1,73 @@
+package hostservice
+
+import (
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"github.com/mitchellh/mapstructure"
+	"go.aporeto.io/gaia"
+)
+
+// Decode a gaia.HostService from a map.
+func Decode(h map[string]interface{}) (*gaia.HostService, error) {
+
+	hostservice := gaia.NewHostService()
+	if err := mapstructure.Decode(h, hostservice); err != nil {
+		return nil, err
+	}
+
+	return hostservice, nil
+}
+
+// Transform a gaia.HostService to a v2 version.
+func Transform(cfg *utils.Config, hostservice *gaia.HostService) *gaia.HostService {
+
+	// Create a v2 copy, add suffix to name and to the tags referencing the host service.
+	// Services are kept as is as they define what the host service protects.
+	v2hostservice := hostservice.DeepCopy()
+	v2hostservice.Name = cfg.Naming().Name(v2hostservice.Name)
+	v2hostservice.AssociatedTags = ConvertTags(cfg, v2hostservice.AssociatedTags)
+	return v2hostservice
+}
+
+// ConvertTags adds the migration suffix to the tags referencing host services. A $name
+// tag is converted only if the tags select host services.
+func ConvertTags(cfg *utils.Config, tags []string) []string {
+
+	identity := false
+	for _, t := range tags {
+		if t == "$identity="+gaia.HostServiceIdentity.Name {
+			identity = true
+			break
+		}
+	}
+
+	r := []string{}
+	for _, t := range tags {
+		if cfg.IsHostServiceTag(t) {
+			t = cfg.Naming().Tag(t)
+		} else if identity && strings.HasPrefix(t, utils.ExtnetNamePrefix) {
+			t = cfg.Naming().Tag(t)
+		}
+		r = append(r, t)
+	}
+	return r
+}
+
+// Encode a gaia.HostService into a map.
+func Encode(h *gaia.HostService) (map[string]interface{}, error) {
+
+	xh := map[string]interface{}{}
+	if err := mapstructure.Decode(h, &xh); err != nil {
+		return nil, err
+	}
+
+	for k, v := range xh {
+		keySpec := h.SpecificationForAttribute(strings.ToLower(k))
+		if !keySpec.Exposed || keySpec.ReadOnly || keySpec.Autogenerated || v == keySpec.DefaultValue {
+			delete(xh, k)
+		}
+	}
+
+	return xh, nil
+}
@@ -0,0 +1,96 @@
+package hostservice
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"go.aporeto.io/gaia"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name string
+		hs   *gaia.HostService
+		want *gaia.HostService
+	}{
+		{
+			name: "name",
+			hs:   &gaia.HostService{Name: "ssh", Services: []string{"tcp/22"}},
+			want: &gaia.HostService{Name: "ssh" + utils.MigrationSuffix, Services: []string{"tcp/22"}},
+		},
+		{
+			name: "associated tags",
+			hs: &gaia.HostService{
+				Name:            "protection",
+				HostModeEnabled: true,
+				AssociatedTags:  []string{"$name=protection", "customer:ext:hostservice=protection", "rail=a"},
+			},
+			want: &gaia.HostService{
+				Name:            "protection" + utils.MigrationSuffix,
+				HostModeEnabled: true,
+				AssociatedTags:  []string{"$name=protection", "customer:ext:hostservice=protection" + utils.MigrationSuffix, "rail=a"},
+			},
+		},
+	}
+	cfg := utils.MustNewConfig(nil, nil, "customer:ext:hostservice=", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Transform(cfg, tt.hs)
+			if got.Name != tt.want.Name {
+				t.Errorf("Transform() Name = %v, want %v", got.Name, tt.want.Name)
+			}
+			if got.HostModeEnabled != tt.want.HostModeEnabled {
+				t.Errorf("Transform() HostModeEnabled = %v, want %v", got.HostModeEnabled, tt.want.HostModeEnabled)
+			}
+			if len(got.Services) != len(tt.want.Services) {
+				t.Errorf("Transform() Services = %v, want %v", got.Services, tt.want.Services)
+			}
+			if len(got.AssociatedTags) != len(tt.want.AssociatedTags) {
+				t.Errorf("Transform() AssociatedTags = %v, want %v", got.AssociatedTags, tt.want.AssociatedTags)
+			}
+			for i := range got.AssociatedTags {
+				if got.AssociatedTags[i] != tt.want.AssociatedTags[i] {
+					t.Errorf("Transform() AssociatedTags = %v, want %v", got.AssociatedTags[i], tt.want.AssociatedTags[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConvertTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{
+			name: "prefix",
+			tags: []string{"$namespace=/a/*", "customer:ext:hostservice=ssh"},
+			want: []string{"$namespace=/a/*", "customer:ext:hostservice=ssh" + utils.MigrationSuffix},
+		},
+		{
+			name: "name with host service identity",
+			tags: []string{"$identity=hostservice", "$name=ssh"},
+			want: []string{"$identity=hostservice", "$name=ssh" + utils.MigrationSuffix},
+		},
+		{
+			name: "name without host service identity",
+			tags: []string{"$identity=enforcer", "$name=ssh"},
+			want: []string{"$identity=enforcer", "$name=ssh"},
+		},
+		{
+			name: "other tags",
+			tags: []string{"$identity=hostservice", "rail=a"},
+			want: []string{"$identity=hostservice", "rail=a"},
+		},
+	}
+	cfg := utils.MustNewConfig(nil, nil, "customer:ext:hostservice=", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConvertTags(cfg, tt.tags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ConvertTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package hostservicemapping
+
+import (
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/hostservice"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"github.com/mitchellh/mapstructure"
+	"go.aporeto.io/gaia"
+)
+
+// Decode a gaia.HostServiceMappingPolicy from a map.
+func Decode(h map[string]interface{}) (*gaia.HostServiceMappingPolicy, error) {
+
+	policy := gaia.NewHostServiceMappingPolicy()
+	if err := mapstructure.Decode(h, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Transform a gaia.HostServiceMappingPolicy to a v2 version that maps the enforcers
+// to the v2 host services.
+func Transform(cfg *utils.Config, policy *gaia.HostServiceMappingPolicy) *gaia.HostServiceMappingPolicy {
+
+	v2policy := policy.DeepCopy()
+	v2policy.Name = cfg.Naming().Name(v2policy.Name)
+
+	object := [][]string{}
+	for _, tags := range v2policy.Object {
+		object = append(object, hostservice.ConvertTags(cfg, tags))
+	}
+	v2policy.Object = object
+
+	return v2policy
+}
+
+// Encode a gaia.HostServiceMappingPolicy into a map.
+func Encode(h *gaia.HostServiceMappingPolicy) (map[string]interface{}, error) {
+
+	xh := map[string]interface{}{}
+	if err := mapstructure.Decode(h, &xh); err != nil {
+		return nil, err
+	}
+
+	for k, v := range xh {
+		keySpec := h.SpecificationForAttribute(strings.ToLower(k))
+		if !keySpec.Exposed || keySpec.ReadOnly || keySpec.Autogenerated || v == keySpec.DefaultValue {
+			delete(xh, k)
+		}
+	}
+
+	return xh, nil
+}
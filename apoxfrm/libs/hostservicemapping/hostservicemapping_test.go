@@ -0,0 +1,68 @@
+package hostservicemapping
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"go.aporeto.io/gaia"
+)
+
+func TestTransform(t *testing.T) {
+
+	subject := [][]string{{"$namespace=/a/b/*", "$identity=enforcer"}}
+
+	tests := []struct {
+		name   string
+		policy *gaia.HostServiceMappingPolicy
+		want   *gaia.HostServiceMappingPolicy
+	}{
+		{
+			name: "host service tags",
+			policy: &gaia.HostServiceMappingPolicy{
+				Name:    "enforcers",
+				Subject: subject,
+				Object: [][]string{
+					{"$namespace=/a/b/*", "customer:ext:hostservice=protection"},
+					{"$namespace=/a/b/*", "customer:ext:hostservice=ssh"},
+				},
+			},
+			want: &gaia.HostServiceMappingPolicy{
+				Name:    "enforcers" + utils.MigrationSuffix,
+				Subject: subject,
+				Object: [][]string{
+					{"$namespace=/a/b/*", "customer:ext:hostservice=protection" + utils.MigrationSuffix},
+					{"$namespace=/a/b/*", "customer:ext:hostservice=ssh" + utils.MigrationSuffix},
+				},
+			},
+		},
+		{
+			name: "other tags",
+			policy: &gaia.HostServiceMappingPolicy{
+				Name:    "rail",
+				Subject: subject,
+				Object:  [][]string{{"$identity=hostservice", "rail=a"}},
+			},
+			want: &gaia.HostServiceMappingPolicy{
+				Name:    "rail" + utils.MigrationSuffix,
+				Subject: subject,
+				Object:  [][]string{{"$identity=hostservice", "rail=a"}},
+			},
+		},
+	}
+	cfg := utils.MustNewConfig(nil, nil, "customer:ext:hostservice=", nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Transform(cfg, tt.policy)
+			if got.Name != tt.want.Name {
+				t.Errorf("Transform() Name = %v, want %v", got.Name, tt.want.Name)
+			}
+			if !reflect.DeepEqual(got.Subject, tt.want.Subject) {
+				t.Errorf("Transform() Subject = %v, want %v", got.Subject, tt.want.Subject)
+			}
+			if !reflect.DeepEqual(got.Object, tt.want.Object) {
+				t.Errorf("Transform() Object = %v, want %v", got.Object, tt.want.Object)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package networkpolicies
+
+import (
+	"fmt"
+)
+
+// Clauses of a network policy a warning can refer to.
+const (
+	ClausePolicy  = ""
+	ClauseSubject = "subject"
+	ClauseObject  = "object"
+)
+
+// Warning is a single warning found in a network policy. Index is the index of the
+// subject or object clause and is only meaningful if Clause is not ClausePolicy.
+type Warning struct {
+	Clause string `json:"clause,omitempty"`
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+}
+
+// Diagnostics lists the warnings found in a network policy. If Exception is set, the
+// migrated policy is not equivalent to the original one and must be reviewed.
+//
+// Diagnostics implements error so that it can be returned by Transform and Get.
+type Diagnostics struct {
+	Namespace string     `json:"namespace,omitempty"`
+	Policy    string     `json:"policy"`
+	Exception bool       `json:"exception"`
+	Warnings  []*Warning `json:"warnings"`
+}
+
+// Error implements error.
+func (d *Diagnostics) Error() string {
+	return fmt.Sprintf("policy: %s warnings/errors found", d.Policy)
+}
+
+// String returns printable diagnostics.
+func (d *Diagnostics) String() string {
+
+	s := ""
+	if d.Exception {
+		s = fmt.Sprintf("\n    Policy=%s (Exception)\n", d.Policy)
+	} else {
+		s = fmt.Sprintf("\n    Policy=%s\n", d.Policy)
+	}
+
+	for _, w := range d.Warnings {
+		if w.Clause == ClausePolicy {
+			s += fmt.Sprintf("      - %-42s%v\n", w.Name+":", true)
+		}
+	}
+	for _, clause := range []string{ClauseSubject, ClauseObject} {
+		buf := ""
+		for _, w := range d.Warnings {
+			if w.Clause == clause {
+				buf += fmt.Sprintf("          - [%d] %-49s%v\n", w.Index, w.Name+":", true)
+			}
+		}
+		if buf != "" {
+			s += "      - " + clause + "s:\n" + buf
+		}
+	}
+
+	return s
+}
+
+// diagnostics returns the diagnostics of the network policy, or nil if no warning was found.
+func (n *netPolInfo) diagnostics() *Diagnostics {
+
+	d := &Diagnostics{
+		Namespace: n.netpol.Namespace,
+		Policy:    n.netpol.Name,
+		Exception: n.exceptions,
+		Warnings:  []*Warning{},
+	}
+
+	add := func(clause string, index int, name string, found bool) {
+		if found {
+			d.Warnings = append(d.Warnings, &Warning{Clause: clause, Index: index, Name: name})
+		}
+	}
+
+	add(ClausePolicy, 0, "badNameReferencesWithNoIdentitySpecified", n.badNameReferencesWithNoIdentitySpecified)
+	add(ClausePolicy, 0, "negationsNotSupported", n.negationsNotSupported)
+	add(ClausePolicy, 0, "candidateForUnidirectionalPolicy", n.candidateForUnidirectionalPolicy)
+	add(ClausePolicy, 0, "ineffectivePolicy", n.ineffectivePolicy)
+
+	for i := range n.subjectExternalNetworksNoNameRefButExtNetsFound {
+		add(ClauseSubject, i, "subjectNeedsIntersection", n.subjectNeedsIntersection[i])
+		add(ClauseSubject, i, "subjectExternalNetworksPortMigrationNotPossible", n.subjectExternalNetworksPortMigrationNotPossible[i])
+		add(ClauseSubject, i, "subjectExternalNetworksNoNameRefButExtNetsFound", n.subjectExternalNetworksNoNameRefButExtNetsFound[i])
+	}
+	for i := range n.objectExternalNetworksNoNameRefButExtNetsFound {
+		add(ClauseObject, i, "objectNeedsIntersection", n.objectNeedsIntersection[i])
+		add(ClauseObject, i, "objectExternalNetworksPortMigrationNotPossible", n.objectExternalNetworksPortMigrationNotPossible[i])
+		add(ClauseObject, i, "objectExternalNetworksNoNameRefButExtNetsFound", n.objectExternalNetworksNoNameRefButExtNetsFound[i])
+	}
+
+	if len(d.Warnings) == 0 {
+		return nil
+	}
+	return d
+}
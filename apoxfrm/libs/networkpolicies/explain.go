@@ -0,0 +1,92 @@
+package networkpolicies
+
+import (
+	"go.aporeto.io/gaia"
+)
+
+// Rewrite is a tag of a subject or object clause renamed to reference the v2 objects.
+type Rewrite struct {
+	Clause string `json:"clause"`
+	Index  int    `json:"index"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Intersection is the intersection of the policy ports with the service ports of an
+// external network referenced by a subject or object clause.
+type Intersection struct {
+	Clause          string   `json:"clause"`
+	Index           int      `json:"index"`
+	ExternalNetwork string   `json:"externalNetwork"`
+	PolicyPorts     []string `json:"policyPorts"`
+	ServicePorts    []string `json:"servicePorts"`
+	Ports           []string `json:"ports"`
+}
+
+// Narrowed returns true if the intersection restricted the policy ports.
+func (i *Intersection) Narrowed() bool {
+	return !equalSlices(i.PolicyPorts, i.Ports)
+}
+
+// Explanation relates a network policy to the network rule set policies it generates,
+// without merging them, and records the rewrites and intersections performed.
+type Explanation struct {
+	Policy        *gaia.NetworkAccessPolicy       `json:"policy"`
+	Rulesets      gaia.NetworkRuleSetPoliciesList `json:"rulesets"`
+	Rewrites      []*Rewrite                      `json:"rewrites"`
+	Intersections []*Intersection                 `json:"intersections"`
+	Decision      *Decision                       `json:"decision,omitempty"`
+	Diagnostics   *Diagnostics                    `json:"diagnostics,omitempty"`
+}
+
+// Explain transforms a network policy and explains the transformation. Diagnostics are
+// part of the explanation and are never printed.
+func Explain(netpol *gaia.NetworkAccessPolicy, extnetList gaia.ExternalNetworksList, opts Options) *Explanation {
+
+	opts.Quiet = true
+	n := newNetPolInfo(netpol, opts)
+	n.resolveExternalNetworks(extnetList)
+	d := n.diagnostics()
+	n.xfrm()
+
+	e := &Explanation{
+		Policy:        netpol,
+		Rulesets:      n.rulesets,
+		Rewrites:      []*Rewrite{},
+		Intersections: []*Intersection{},
+		Decision:      n.decision,
+		Diagnostics:   d,
+	}
+
+	e.explainClauses(n, ClauseSubject, netpol.Subject, n.subjectExternalNetworks, n.subjectExternalNetworksNoNameRefButExtNetsFound)
+	e.explainClauses(n, ClauseObject, netpol.Object, n.objectExternalNetworks, n.objectExternalNetworksNoNameRefButExtNetsFound)
+
+	return e
+}
+
+func (e *Explanation) explainClauses(n *netPolInfo, clause string, ref [][]string, extnets []gaia.ExternalNetworksList, noNameRef []bool) {
+
+	for i, tags := range ref {
+
+		for j, t := range refConvertExtNetworks(n.cfg, tags) {
+			if t != tags[j] {
+				e.Rewrites = append(e.Rewrites, &Rewrite{Clause: clause, Index: i, From: tags[j], To: t})
+			}
+		}
+
+		// Ports of clauses whose external networks are not referenced by name are not intersected.
+		if noNameRef[i] {
+			continue
+		}
+		for _, extnet := range extnets[i] {
+			e.Intersections = append(e.Intersections, &Intersection{
+				Clause:          clause,
+				Index:           i,
+				ExternalNetwork: extnet.Name,
+				PolicyPorts:     n.netpol.Ports,
+				ServicePorts:    extnet.ServicePorts,
+				Ports:           intersect(n.opts.logger(), n.netpol.Ports, extnet.ServicePorts),
+			})
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package networkpolicies
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"go.aporeto.io/gaia"
+)
+
+func TestExplain(t *testing.T) {
+
+	cfg := utils.MustNewConfig([]string{"customer:ext:net="}, nil, "", nil)
+
+	extnetList := gaia.ExternalNetworksList{
+		&gaia.ExternalNetwork{
+			Name:           "web",
+			Namespace:      "/customer/root/zone/tenant",
+			AssociatedTags: []string{"customer:ext:net=web"},
+			ServicePorts:   []string{"tcp/443"},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		netpol          *gaia.NetworkAccessPolicy
+		wantRulesets    []string
+		wantRewrites    []Rewrite
+		wantNarrowed    []bool
+		wantDiagnostics bool
+	}{
+		{
+			name: "external network",
+			netpol: &gaia.NetworkAccessPolicy{
+				Name:            "web",
+				Namespace:       "/customer/root/zone/tenant",
+				Action:          gaia.NetworkAccessPolicyActionAllow,
+				ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic,
+				Ports:           []string{"tcp/1:1000"},
+				Subject:         [][]string{{"app=x"}},
+				Object:          [][]string{{"customer:ext:net=web", "$namespace=/customer/root/zone/tenant"}},
+			},
+			wantRulesets:    []string{"web-v2"},
+			wantRewrites:    []Rewrite{{Clause: ClauseObject, Index: 0, From: "customer:ext:net=web", To: "customer:ext:net=web-v2"}},
+			wantNarrowed:    []bool{true},
+			wantDiagnostics: true,
+		},
+		{
+			name: "processing units",
+			netpol: &gaia.NetworkAccessPolicy{
+				Name:            "pu2pu",
+				Namespace:       "/customer/root/zone/tenant",
+				Action:          gaia.NetworkAccessPolicyActionAllow,
+				ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeBidirectional,
+				Subject:         [][]string{{"app=x"}},
+				Object:          [][]string{{"app=y"}},
+			},
+			wantRulesets: []string{"pu2pu-v2", "pu2pu-v2"},
+			wantRewrites: []Rewrite{},
+			wantNarrowed: []bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			e := Explain(tt.netpol, extnetList, Options{Config: cfg})
+
+			rulesets := []string{}
+			for _, r := range e.Rulesets {
+				rulesets = append(rulesets, r.Name)
+			}
+			if !reflect.DeepEqual(rulesets, tt.wantRulesets) {
+				t.Errorf("Explain() rulesets = %v, want %v", rulesets, tt.wantRulesets)
+			}
+
+			rewrites := []Rewrite{}
+			for _, r := range e.Rewrites {
+				rewrites = append(rewrites, *r)
+			}
+			if !reflect.DeepEqual(rewrites, tt.wantRewrites) {
+				t.Errorf("Explain() rewrites = %v, want %v", rewrites, tt.wantRewrites)
+			}
+
+			narrowed := []bool{}
+			for _, i := range e.Intersections {
+				narrowed = append(narrowed, i.Narrowed())
+			}
+			if !reflect.DeepEqual(narrowed, tt.wantNarrowed) {
+				t.Errorf("Explain() narrowed = %v, want %v", narrowed, tt.wantNarrowed)
+			}
+
+			if (e.Diagnostics != nil) != tt.wantDiagnostics {
+				t.Errorf("Explain() diagnostics = %v, want %v", e.Diagnostics, tt.wantDiagnostics)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package networkpolicies
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/portranges"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/gaia/protocols"
+)
+
+// Merge merges network rule set policies that share the same subject, namespace and
+// propagate setting into one policy with the combined incoming and outgoing rules.
+// Rules that only differ by their ports are merged into one rule, and adjacent or
+// overlapping port ranges are collapsed.
+//
+// The merged policy keeps the name of the first policy merged and records the names of
+// all the policies it replaces in its description.
+func Merge(rulesets gaia.NetworkRuleSetPoliciesList) gaia.NetworkRuleSetPoliciesList {
+
+	merged := gaia.NetworkRuleSetPoliciesList{}
+	names := map[*gaia.NetworkRuleSetPolicy][]string{}
+	index := map[string]*gaia.NetworkRuleSetPolicy{}
+
+	for _, r := range rulesets {
+
+		key := mergeKey(r)
+		m, ok := index[key]
+		if !ok {
+			m = r.DeepCopy()
+			m.IncomingRules = copyRules(r.IncomingRules)
+			m.OutgoingRules = copyRules(r.OutgoingRules)
+			index[key] = m
+			names[m] = []string{r.Name}
+			merged = append(merged, m)
+			continue
+		}
+
+		m.IncomingRules = append(m.IncomingRules, copyRules(r.IncomingRules)...)
+		m.OutgoingRules = append(m.OutgoingRules, copyRules(r.OutgoingRules)...)
+		m.AssociatedTags = unionTags(m.AssociatedTags, r.AssociatedTags)
+		m.Metadata = unionTags(m.Metadata, r.Metadata)
+		names[m] = append(names[m], r.Name)
+	}
+
+	for _, m := range merged {
+		m.IncomingRules = mergeRules(m.IncomingRules)
+		m.OutgoingRules = mergeRules(m.OutgoingRules)
+		if len(names[m]) > 1 {
+			m.Description = "merged network rule set policies: " + strings.Join(names[m], ", ")
+		}
+	}
+
+	return merged
+}
+
+// mergeKey returns the key used to decide which rulesets can be merged.
+func mergeKey(r *gaia.NetworkRuleSetPolicy) string {
+	return fmt.Sprintf("%s|%t|%t|%t|%s", r.Namespace, r.Propagate, r.Disabled, r.Fallback, clausesKey(r.Subject))
+}
+
+// clausesKey returns a key for tags clauses that does not depend on ordering.
+func clausesKey(clauses [][]string) string {
+
+	keys := []string{}
+	for _, clause := range clauses {
+		tags := append([]string{}, clause...)
+		sort.Strings(tags)
+		keys = append(keys, strings.Join(tags, ","))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ";")
+}
+
+// ruleKey returns the key used to decide which rules can be merged.
+func ruleKey(r *gaia.NetworkRule) string {
+	return fmt.Sprintf("%s|%t|%t|%s", r.Action, r.LogsDisabled, r.ObservationEnabled, clausesKey(r.Object))
+}
+
+// mergeRules merges rules which only differ by ports.
+func mergeRules(rules []*gaia.NetworkRule) []*gaia.NetworkRule {
+
+	merged := []*gaia.NetworkRule{}
+	index := map[string]*gaia.NetworkRule{}
+
+	for _, r := range rules {
+
+		key := ruleKey(r)
+		m, ok := index[key]
+		if !ok {
+			m = r.DeepCopy()
+			index[key] = m
+			merged = append(merged, m)
+			continue
+		}
+
+		// No ports means any port.
+		if len(m.ProtocolPorts) == 0 || len(r.ProtocolPorts) == 0 {
+			m.ProtocolPorts = []string{}
+			continue
+		}
+		m.ProtocolPorts = append(m.ProtocolPorts, r.ProtocolPorts...)
+	}
+
+	for _, m := range merged {
+		m.ProtocolPorts = collapseProtocolPorts(m.ProtocolPorts)
+	}
+
+	return merged
+}
+
+// collapseProtocolPorts removes duplicates and collapses adjacent or overlapping tcp
+// and udp port ranges. Other protocols are kept as is.
+func collapseProtocolPorts(protocolPorts []string) []string {
+
+	for _, pp := range protocolPorts {
+		if strings.EqualFold(pp, protocols.ANY) {
+			return []string{pp}
+		}
+	}
+
+	collapsed := []string{}
+	protos := []string{}
+	ranges := map[string][]string{}
+	spelling := map[string]string{}
+
+	for _, pp := range protocolPorts {
+
+		parts := strings.SplitN(pp, "/", 2)
+		proto := strings.ToUpper(parts[0])
+		if proto != protocols.L4ProtocolTCP && proto != protocols.L4ProtocolUDP {
+			collapsed = appendUnique(collapsed, pp)
+			continue
+		}
+
+		if _, ok := ranges[proto]; !ok {
+			protos = append(protos, proto)
+			spelling[proto] = parts[0]
+		}
+		if len(parts) == 1 {
+			ranges[proto] = append(ranges[proto], "1:65535")
+		} else {
+			ranges[proto] = append(ranges[proto], parts[1])
+		}
+	}
+
+	tcpudp := []string{}
+	for _, proto := range protos {
+		merged, err := portranges.MergePortRanges(ranges[proto])
+		if err != nil {
+			// Keep ports untouched if they cannot be parsed.
+			for _, r := range ranges[proto] {
+				tcpudp = appendUnique(tcpudp, spelling[proto]+"/"+r)
+			}
+			continue
+		}
+		for _, r := range merged {
+			tcpudp = append(tcpudp, spelling[proto]+"/"+r)
+		}
+	}
+
+	return append(tcpudp, collapsed...)
+}
+
+func copyRules(rules []*gaia.NetworkRule) []*gaia.NetworkRule {
+
+	r := make([]*gaia.NetworkRule, 0, len(rules))
+	for _, rule := range rules {
+		r = append(r, rule.DeepCopy())
+	}
+	return r
+}
+
+func unionTags(a, b []string) []string {
+
+	r := append([]string{}, a...)
+	for _, t := range b {
+		r = appendUnique(r, t)
+	}
+	return r
+}
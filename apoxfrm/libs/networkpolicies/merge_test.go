@@ -0,0 +1,106 @@
+package networkpolicies
+
+import (
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/gaia"
+)
+
+func TestMerge(t *testing.T) {
+
+	newRuleSet := func(name string, subject [][]string, outgoing ...*gaia.NetworkRule) *gaia.NetworkRuleSetPolicy {
+		r := gaia.NewNetworkRuleSetPolicy()
+		r.Name = name
+		r.Namespace = "/customer/root/zone/tenant"
+		r.Propagate = true
+		r.Subject = subject
+		r.OutgoingRules = outgoing
+		return r
+	}
+
+	newRule := func(object [][]string, ports ...string) *gaia.NetworkRule {
+		r := gaia.NewNetworkRule()
+		r.Object = object
+		r.ProtocolPorts = ports
+		return r
+	}
+
+	web := [][]string{{"$identity=processingunit", "app=web"}}
+	webReordered := [][]string{{"app=web", "$identity=processingunit"}}
+	db := [][]string{{"app=db"}}
+	ssh := [][]string{{"$name=ssh-v2", "customer:ext:net=ssh-v2"}}
+
+	tests := []struct {
+		name     string
+		rulesets gaia.NetworkRuleSetPoliciesList
+		want     []string
+		wantOut  [][]string
+	}{
+		{
+			name: "different subjects",
+			rulesets: gaia.NetworkRuleSetPoliciesList{
+				newRuleSet("a-v2", web, newRule(db, "tcp/5432")),
+				newRuleSet("b-v2", db, newRule(web, "tcp/80")),
+			},
+			want:    []string{"a-v2", "b-v2"},
+			wantOut: [][]string{{"tcp/5432"}, {"tcp/80"}},
+		},
+		{
+			name: "same subject different objects",
+			rulesets: gaia.NetworkRuleSetPoliciesList{
+				newRuleSet("a-v2", web, newRule(db, "tcp/5432")),
+				newRuleSet("b-v2", webReordered, newRule(ssh, "tcp/22")),
+			},
+			want:    []string{"a-v2"},
+			wantOut: [][]string{{"tcp/5432"}, {"tcp/22"}},
+		},
+		{
+			name: "duplicate rules",
+			rulesets: gaia.NetworkRuleSetPoliciesList{
+				newRuleSet("a-v2", web, newRule(db, "tcp/5432")),
+				newRuleSet("b-v2", web, newRule(db, "tcp/5432")),
+			},
+			want:    []string{"a-v2"},
+			wantOut: [][]string{{"tcp/5432"}},
+		},
+		{
+			name: "adjacent ports",
+			rulesets: gaia.NetworkRuleSetPoliciesList{
+				newRuleSet("a-v2", web, newRule(db, "tcp/80:90", "icmp")),
+				newRuleSet("b-v2", web, newRule(db, "tcp/91:100", "udp/53", "icmp")),
+			},
+			want:    []string{"a-v2"},
+			wantOut: [][]string{{"tcp/80:100", "udp/53", "icmp"}},
+		},
+		{
+			name: "any port wins",
+			rulesets: gaia.NetworkRuleSetPoliciesList{
+				newRuleSet("a-v2", web, newRule(db, "tcp/80")),
+				newRuleSet("b-v2", web, newRule(db)),
+			},
+			want:    []string{"a-v2"},
+			wantOut: [][]string{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge(tt.rulesets)
+			names := []string{}
+			outs := [][]string{}
+			for _, r := range got {
+				names = append(names, r.Name)
+				for _, o := range r.OutgoingRules {
+					outs = append(outs, o.ProtocolPorts)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Merge() names = %v, want %v", names, tt.want)
+			}
+			if !reflect.DeepEqual(outs, tt.wantOut) {
+				t.Errorf("Merge() ports = %v, want %v", outs, tt.wantOut)
+			}
+		})
+	}
+}
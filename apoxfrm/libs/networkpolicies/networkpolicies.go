@@ -7,8 +7,61 @@ import (
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
 	"github.com/mitchellh/mapstructure"
 	"go.aporeto.io/gaia"
+	"go.uber.org/zap"
 )
 
+// defaultConfig is used when no configuration is given: no tag references external
+// networks or host services and migrated objects are named with the migration suffix.
+var defaultConfig = utils.MustNewConfig(nil, nil, "", nil)
+
+// Options configures the transformation of network policies.
+type Options struct {
+	// Config is the migration configuration.
+	Config *utils.Config
+
+	// Collapse emits only the effective direction of bidirectional policies whose subjects
+	// or objects are all external networks, and drops ineffective policies whose subjects
+	// and objects are all external networks.
+	Collapse bool
+
+	// Quiet does not log the diagnostics of the network policies. They are still
+	// returned as errors.
+	Quiet bool
+
+	// Logger receives the diagnostics and the service ports that can not be parsed.
+	// Defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// logger returns the logger of the options.
+func (o Options) logger() *zap.Logger {
+
+	if o.Logger == nil {
+		return zap.NewNop()
+	}
+	return o.Logger
+}
+
+// Decision actions.
+const (
+	DecisionCollapsedToOutgoing = "collapsed to outgoing"
+	DecisionCollapsedToIncoming = "collapsed to incoming"
+	DecisionDropped             = "dropped"
+)
+
+// Decision records how a network policy was rewritten when collapsing.
+type Decision struct {
+	Namespace string `json:"namespace,omitempty"`
+	Policy    string `json:"policy"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason"`
+}
+
+// String returns a printable decision.
+func (d *Decision) String() string {
+	return fmt.Sprintf("policy=%s %s: %s", d.Policy, d.Action, d.Reason)
+}
+
 // netPolInfo stores all information related to a network policy
 type netPolInfo struct {
 	// Network Policy
@@ -30,6 +83,10 @@ type netPolInfo struct {
 	incomingRule *gaia.NetworkRule
 
 	// Transformations
+	cfg             *utils.Config
+	opts            Options
+	decision        *Decision
+	rulesets        gaia.NetworkRuleSetPoliciesList
 	transformations []map[string]interface{}
 
 	// Warnings
@@ -48,10 +105,12 @@ type netPolInfo struct {
 	exceptions                               bool
 }
 
-func newNetPolInfo(netpol *gaia.NetworkAccessPolicy) *netPolInfo {
+func newNetPolInfo(netpol *gaia.NetworkAccessPolicy, opts Options) *netPolInfo {
 
 	n := &netPolInfo{
 		netpol:                               netpol,
+		cfg:                                  opts.Config,
+		opts:                                 opts,
 		subjectExternalNetworks:              make([]gaia.ExternalNetworksList, len(netpol.Subject)),
 		subjectProtocolPorts:                 make([][]string, len(netpol.Subject)),
 		objectExternalNetworks:               make([]gaia.ExternalNetworksList, len(netpol.Object)),
@@ -62,6 +121,7 @@ func newNetPolInfo(netpol *gaia.NetworkAccessPolicy) *netPolInfo {
 		allObjectsReferenceExternalNetworks:  true,
 
 		// Transformations
+		rulesets:        gaia.NetworkRuleSetPoliciesList{},
 		transformations: make([]map[string]interface{}, 0),
 
 		// Warnings
@@ -73,6 +133,10 @@ func newNetPolInfo(netpol *gaia.NetworkAccessPolicy) *netPolInfo {
 		objectExternalNetworksPortMigrationNotPossible:  make([]bool, len(netpol.Object)),
 	}
 
+	if n.cfg == nil {
+		n.cfg = defaultConfig
+	}
+
 	for i := 0; i < len(netpol.Subject); i++ {
 		n.subjectProtocolPorts[i] = []string{}
 	}
@@ -90,13 +154,13 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 
 	// Check for external network name references
 	for i := range n.netpol.Subject {
-		ref := refHasExtNextworks(n.netpol.Subject[i])
+		ref := refHasExtNextworks(n.cfg, n.netpol.Subject[i])
 		n.subjectHasExternalNetworks = n.subjectHasExternalNetworks || ref
 		n.allSubjectsReferenceExternalNetworks = n.allSubjectsReferenceExternalNetworks && ref
 		n.subjectExternalNetworks[i] = extnetsFromTags(n.netpol.Namespace, n.netpol.Subject[i], extnetList)
 	}
 	for i := range n.netpol.Object {
-		ref := refHasExtNextworks(n.netpol.Object[i])
+		ref := refHasExtNextworks(n.cfg, n.netpol.Object[i])
 		n.objectHasExternalNetworks = n.objectHasExternalNetworks || ref
 		n.allObjectsReferenceExternalNetworks = n.allObjectsReferenceExternalNetworks && ref
 		n.objectExternalNetworks[i] = extnetsFromTags(n.netpol.Namespace, n.netpol.Object[i], extnetList)
@@ -104,7 +168,7 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 
 	// Get actual matching external networks
 	for i := range n.netpol.Subject {
-		ref := refHasExtNextworks(n.netpol.Subject[i])
+		ref := refHasExtNextworks(n.cfg, n.netpol.Subject[i])
 		if ref != (len(n.subjectExternalNetworks[i]) != 0) {
 			n.subjectExternalNetworksNoNameRefButExtNetsFound[i] = true
 			if !n.allObjectsReferenceExternalNetworks {
@@ -113,7 +177,7 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 		}
 	}
 	for i := range n.netpol.Object {
-		ref := refHasExtNextworks(n.netpol.Object[i])
+		ref := refHasExtNextworks(n.cfg, n.netpol.Object[i])
 		if ref != (len(n.objectExternalNetworks[i]) != 0) {
 			n.objectExternalNetworksNoNameRefButExtNetsFound[i] = true
 			if !n.allSubjectsReferenceExternalNetworks {
@@ -130,7 +194,7 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 			continue
 		}
 		for j, e := range eList {
-			portProtos := intersect(n.netpol.Ports, e.ServicePorts)
+			portProtos := intersect(n.opts.logger(), n.netpol.Ports, e.ServicePorts)
 			if j == 0 {
 				n.subjectProtocolPorts[i] = portProtos
 			} else if !equalSlices(n.subjectProtocolPorts[i], portProtos) {
@@ -143,6 +207,11 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 						n.subjectNeedsIntersection[i] = true
 					}
 				}
+			} else if len(n.netpol.Ports) > 0 && len(e.ServicePorts) > 0 {
+				// No traffic matches both the policy and the external network. Using the
+				// policy ports would widen the policy.
+				n.exceptions = true
+				n.subjectExternalNetworksPortMigrationNotPossible[i] = true
 			}
 		}
 	}
@@ -152,7 +221,7 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 			continue
 		}
 		for j, e := range eList {
-			portProtos := intersect(n.netpol.Ports, e.ServicePorts)
+			portProtos := intersect(n.opts.logger(), n.netpol.Ports, e.ServicePorts)
 			if j == 0 {
 				n.objectProtocolPorts[i] = portProtos
 			} else if !equalSlices(n.objectProtocolPorts[i], portProtos) {
@@ -165,6 +234,11 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 						n.objectNeedsIntersection[i] = true
 					}
 				}
+			} else if len(n.netpol.Ports) > 0 && len(e.ServicePorts) > 0 {
+				// No traffic matches both the policy and the external network. Using the
+				// policy ports would widen the policy.
+				n.exceptions = true
+				n.objectExternalNetworksPortMigrationNotPossible[i] = true
 			}
 		}
 	}
@@ -182,71 +256,23 @@ func (n *netPolInfo) resolveExternalNetworks(extnetList gaia.ExternalNetworksLis
 	n.ineffectivePolicy = (n.allObjectsReferenceExternalNetworks && n.allSubjectsReferenceExternalNetworks)
 }
 
-func (n *netPolInfo) checkAndPrintWarnings(verbose bool) bool {
-
-	warning := ""
+// checkAndLogWarnings logs the diagnostics of the network policy unless quiet and
+// returns them as an error.
+func (n *netPolInfo) checkAndLogWarnings() error {
 
-	if n.badNameReferencesWithNoIdentitySpecified {
-		warning += fmt.Sprintf("      - badNameReferencesWithNoIdentitySpecified: %v\n", n.badNameReferencesWithNoIdentitySpecified)
+	d := n.diagnostics()
+	if d == nil {
+		return nil
 	}
-	if n.negationsNotSupported {
-		warning += fmt.Sprintf("      - negationsNotSupported:                    %v\n", n.negationsNotSupported)
-	}
-	if verbose || n.candidateForUnidirectionalPolicy {
-		warning += fmt.Sprintf("      - candidateForUnidirectionalPolicy:         %v\n", n.candidateForUnidirectionalPolicy)
-	}
-	if verbose || n.ineffectivePolicy {
-		warning += fmt.Sprintf("      - ineffectivePolicy:                        %v\n", n.ineffectivePolicy)
-	}
-	if len(n.subjectExternalNetworksNoNameRefButExtNetsFound) > 0 {
-		buf := ""
-		for i := 0; i < len(n.subjectExternalNetworksNoNameRefButExtNetsFound); i++ {
-			if verbose || n.subjectNeedsIntersection[i] {
-				buf += fmt.Sprintf("          - [%d] subjectNeedsIntersection:                        %v\n", i, n.subjectNeedsIntersection[i])
-			}
-			if verbose || n.subjectExternalNetworksPortMigrationNotPossible[i] {
-				buf += fmt.Sprintf("          - [%d] subjectExternalNetworksPortMigrationNotPossible: %v\n", i, n.subjectExternalNetworksPortMigrationNotPossible[i])
-			}
-			if verbose || n.subjectExternalNetworksNoNameRefButExtNetsFound[i] {
-				buf += fmt.Sprintf("          - [%d] subjectExternalNetworksNoNameRefButExtNetsFound: %v\n", i, n.subjectExternalNetworksNoNameRefButExtNetsFound[i])
-			}
-		}
-		if buf != "" {
-			warning += "      - subjects:\n"
-			warning += buf
-		}
+	if !n.opts.Quiet {
+		n.opts.logger().Warn(
+			"network policy has diagnostics",
+			zap.String("ns", n.netpol.Namespace),
+			zap.String("name", n.netpol.Name),
+			zap.String("diagnostics", d.String()),
+		)
 	}
-	if len(n.objectExternalNetworksNoNameRefButExtNetsFound) > 0 {
-		buf := ""
-		for i := 0; i < len(n.objectExternalNetworksNoNameRefButExtNetsFound); i++ {
-			if verbose || n.objectNeedsIntersection[i] {
-				buf += fmt.Sprintf("          - [%d] objectNeedsIntersection:                         %v\n", i, n.objectNeedsIntersection[i])
-			}
-			if verbose || n.objectExternalNetworksPortMigrationNotPossible[i] {
-				buf += fmt.Sprintf("          - [%d] objectExternalNetworksPortMigrationNotPossible:  %v\n", i, n.objectExternalNetworksPortMigrationNotPossible[i])
-			}
-			if verbose || n.objectExternalNetworksNoNameRefButExtNetsFound[i] {
-				buf += fmt.Sprintf("          - [%d] objectExternalNetworksNoNameRefButExtNetsFound:  %v\n", i, n.objectExternalNetworksNoNameRefButExtNetsFound[i])
-			}
-		}
-		if buf != "" {
-			warning += "      - objects:\n"
-			warning += buf
-		}
-	}
-
-	if verbose || warning != "" {
-
-		if n.exceptions {
-			warning = fmt.Sprintf("\n    Policy=%s (Exception)\n", n.netpol.Name) + warning
-		} else {
-			warning = fmt.Sprintf("\n    Policy=%s\n", n.netpol.Name) + warning
-		}
-
-		fmt.Println(warning)
-	}
-
-	return warning == ""
+	return d
 }
 
 func (n *netPolInfo) xfrm() {
@@ -259,7 +285,7 @@ func (n *netPolInfo) xfrm() {
 	n.outgoing.Disabled = n.netpol.Disabled
 	n.outgoing.Fallback = n.netpol.Fallback
 	n.outgoing.Metadata = n.netpol.Metadata
-	n.outgoing.Name = n.netpol.Name + utils.MigrationSuffix
+	n.outgoing.Name = n.cfg.Naming().Name(n.netpol.Name)
 	n.outgoing.Namespace = n.netpol.Namespace
 	n.outgoing.NormalizedTags = n.netpol.NormalizedTags
 	n.outgoing.Propagate = n.netpol.Propagate
@@ -281,8 +307,8 @@ func (n *netPolInfo) xfrm() {
 	n.incomingRule = n.outgoingRule.DeepCopy()
 
 	// Setup subjects/objects
-	subjects := refConvertTags(n.netpol.Subject)
-	objects := refConvertTags(n.netpol.Object)
+	subjects := refConvertTags(n.cfg, n.netpol.Subject)
+	objects := refConvertTags(n.cfg, n.netpol.Object)
 	n.incoming.Subject = objects
 	n.outgoing.Subject = subjects
 
@@ -319,50 +345,95 @@ func (n *netPolInfo) xfrm() {
 	}
 
 	// Create transformations
-	if n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional ||
-		n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic {
-		xn := map[string]interface{}{}
-		if err := mapstructure.Decode(n.incoming, &xn); err != nil {
-			panic(err)
-		}
+	emitIncoming := n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional ||
+		n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic
+	emitOutgoing := n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional ||
+		n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic
 
-		for k, v := range xn {
-			keySpec := n.incoming.SpecificationForAttribute(strings.ToLower(k))
-			if !keySpec.Exposed || keySpec.ReadOnly || keySpec.Autogenerated || v == keySpec.DefaultValue {
-				delete(xn, k)
-			}
-		}
+	if n.opts.Collapse {
+		emitIncoming, emitOutgoing = n.collapse(emitIncoming, emitOutgoing)
+	}
 
-		n.transformations = append(n.transformations, xn)
+	if emitIncoming {
+		n.rulesets = append(n.rulesets, n.incoming)
+		n.transformations = append(n.transformations, Encode(n.incoming))
 	}
 
-	if n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional ||
-		n.netpol.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic {
-		xn := map[string]interface{}{}
-		if err := mapstructure.Decode(n.outgoing, &xn); err != nil {
-			panic(err)
-		}
+	if emitOutgoing {
+		n.rulesets = append(n.rulesets, n.outgoing)
+		n.transformations = append(n.transformations, Encode(n.outgoing))
+	}
+}
 
-		for k, v := range xn {
-			keySpec := n.outgoing.SpecificationForAttribute(strings.ToLower(k))
-			if !keySpec.Exposed || keySpec.ReadOnly || keySpec.Autogenerated || v == keySpec.DefaultValue {
-				delete(xn, k)
-			}
+// collapse decides which of the incoming and outgoing rulesets are effective and records
+// the decision. A ruleset whose subjects are all external networks applies to no
+// processing unit and is not effective.
+func (n *netPolInfo) collapse(emitIncoming, emitOutgoing bool) (bool, bool) {
+
+	decide := func(action, reason string) {
+		n.decision = &Decision{
+			Namespace: n.netpol.Namespace,
+			Policy:    n.netpol.Name,
+			Action:    action,
+			Reason:    reason,
 		}
+	}
+
+	switch {
 
-		n.transformations = append(n.transformations, xn)
+	case n.ineffectivePolicy:
+		decide(DecisionDropped, "subjects and objects are all external networks")
+		return false, false
+
+	case !n.candidateForUnidirectionalPolicy:
+		return emitIncoming, emitOutgoing
+
+	case n.allObjectsReferenceExternalNetworks:
+		decide(DecisionCollapsedToOutgoing, "objects are all external networks")
+		return false, true
+
+	default:
+		decide(DecisionCollapsedToIncoming, "subjects are all external networks")
+		return true, false
 	}
 }
 
+// Encode a gaia.NetworkRuleSetPolicy into a map that only has the attributes to import.
+func Encode(r *gaia.NetworkRuleSetPolicy) map[string]interface{} {
+
+	xn := map[string]interface{}{}
+	if err := mapstructure.Decode(r, &xn); err != nil {
+		panic(err)
+	}
+
+	for k, v := range xn {
+		keySpec := r.SpecificationForAttribute(strings.ToLower(k))
+		if !keySpec.Exposed || keySpec.ReadOnly || keySpec.Autogenerated || v == keySpec.DefaultValue {
+			delete(xn, k)
+		}
+	}
+
+	return xn
+}
+
+// Transform returns the network rule set policies equivalent to a network policy. If the
+// policy was collapsed or dropped, the decision is returned. If warnings were found, the
+// returned error is a *Diagnostics.
+func Transform(netpol *gaia.NetworkAccessPolicy, extnetList gaia.ExternalNetworksList, opts Options) (gaia.NetworkRuleSetPoliciesList, *Decision, error) {
+
+	n := newNetPolInfo(netpol, opts)
+	n.resolveExternalNetworks(extnetList)
+	err := n.checkAndLogWarnings()
+	n.xfrm()
+	return n.rulesets, n.decision, err
+}
+
 // Get returns network policy information
-func Get(netpol *gaia.NetworkAccessPolicy, extnetList gaia.ExternalNetworksList) ([]map[string]interface{}, error) {
+func Get(netpol *gaia.NetworkAccessPolicy, extnetList gaia.ExternalNetworksList, opts Options) ([]map[string]interface{}, error) {
 
-	var err error
-	n := newNetPolInfo(netpol)
+	n := newNetPolInfo(netpol, opts)
 	n.resolveExternalNetworks(extnetList)
-	if !n.checkAndPrintWarnings(false) {
-		err = fmt.Errorf("policy: %s warnings/errors found", netpol.Name)
-	}
+	err := n.checkAndLogWarnings()
 	n.xfrm()
 	return n.transformations, err
 }
@@ -460,8 +460,8 @@ func TestGet(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			utils.ExtnetPrefix = tt.prefix
-			got, err := Get(tt.args.netpol, tt.args.extnetList)
+			cfg := utils.MustNewConfig([]string{tt.prefix}, nil, "", nil)
+			got, err := Get(tt.args.netpol, tt.args.extnetList, Options{Config: cfg})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -497,3 +497,99 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestTransform(t *testing.T) {
+
+	cfg := utils.MustNewConfig([]string{"customer:ext:net="}, nil, "", nil)
+
+	extnetList := gaia.ExternalNetworksList{
+		&gaia.ExternalNetwork{
+			Name:           "ssh",
+			Namespace:      "/customer/root/zone/tenant",
+			AssociatedTags: []string{"customer:ext:net=ssh"},
+			ServicePorts:   []string{"tcp/22"},
+		},
+		&gaia.ExternalNetwork{
+			Name:           "web",
+			Namespace:      "/customer/root/zone/tenant",
+			AssociatedTags: []string{"customer:ext:net=web"},
+			ServicePorts:   []string{"tcp/443"},
+		},
+	}
+
+	newNetpol := func(mode gaia.NetworkAccessPolicyApplyPolicyModeValue, subject, object [][]string) *gaia.NetworkAccessPolicy {
+		n := gaia.NewNetworkAccessPolicy()
+		n.Name = "netpol"
+		n.Namespace = "/customer/root/zone/tenant"
+		n.Action = gaia.NetworkAccessPolicyActionAllow
+		n.ApplyPolicyMode = mode
+		n.Subject = subject
+		n.Object = object
+		return n
+	}
+
+	pu := [][]string{{"$identity=processingunit", "app=web"}}
+	ssh := [][]string{{"customer:ext:net=ssh"}}
+	web := [][]string{{"customer:ext:net=web"}}
+
+	tests := []struct {
+		name         string
+		netpol       *gaia.NetworkAccessPolicy
+		collapse     bool
+		wantSubjects [][][]string
+		wantDecision string
+	}{
+		{
+			name:         "bidirectional not collapsed",
+			netpol:       newNetpol(gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, pu, ssh),
+			wantSubjects: [][][]string{{{"customer:ext:net=ssh" + utils.MigrationSuffix}}, pu},
+		},
+		{
+			name:         "bidirectional to external networks",
+			netpol:       newNetpol(gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, pu, ssh),
+			collapse:     true,
+			wantSubjects: [][][]string{pu},
+			wantDecision: DecisionCollapsedToOutgoing,
+		},
+		{
+			name:         "bidirectional from external networks",
+			netpol:       newNetpol(gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, ssh, pu),
+			collapse:     true,
+			wantSubjects: [][][]string{pu},
+			wantDecision: DecisionCollapsedToIncoming,
+		},
+		{
+			name:         "bidirectional between processing units",
+			netpol:       newNetpol(gaia.NetworkAccessPolicyApplyPolicyModeBidirectional, pu, [][]string{{"app=db"}}),
+			collapse:     true,
+			wantSubjects: [][][]string{{{"app=db"}}, pu},
+		},
+		{
+			name:         "ineffective",
+			netpol:       newNetpol(gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic, web, ssh),
+			collapse:     true,
+			wantSubjects: [][][]string{},
+			wantDecision: DecisionDropped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, decision, _ := Transform(tt.netpol, extnetList, Options{Config: cfg, Collapse: tt.collapse})
+			subjects := [][][]string{}
+			for _, r := range got {
+				subjects = append(subjects, r.Subject)
+			}
+			if !reflect.DeepEqual(subjects, tt.wantSubjects) {
+				t.Errorf("Transform() subjects = %v, want %v", subjects, tt.wantSubjects)
+			}
+			action := ""
+			if decision != nil {
+				action = decision.Action
+			}
+			if action != tt.wantDecision {
+				t.Errorf("Transform() decision = %v, want %v", action, tt.wantDecision)
+			}
+		})
+	}
+}
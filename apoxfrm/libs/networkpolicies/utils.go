@@ -2,7 +2,7 @@ package networkpolicies
 
 import (
 	"fmt"
-	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/portranges"
@@ -26,6 +26,12 @@ func match(tags []string, e *gaia.ExternalNetwork) bool {
 			if name[1] != e.Name {
 				return false
 			}
+		} else if strings.HasPrefix(t, "$type=") {
+			// External networks are either subnets (IPs, CIDRs or FQDNs) or ENIs.
+			typ := strings.SplitN(t, "=", 2)
+			if !strings.EqualFold(typ[1], string(e.Type)) {
+				return false
+			}
 		} else {
 			found := false
 			for _, n := range e.AssociatedTags {
@@ -65,10 +71,10 @@ func refHasBadNames(ref [][]string) bool {
 	return false
 }
 
-func refHasExtNextworks(ref []string) bool {
+func refHasExtNextworks(cfg *utils.Config, ref []string) bool {
 
 	for _, tag := range ref {
-		if strings.HasPrefix(tag, utils.ExtnetPrefix) {
+		if cfg.IsExtnetTag(tag) {
 			return true
 		} else if strings.HasPrefix(tag, utils.ExtnetNamePrefix) {
 			return true
@@ -77,23 +83,25 @@ func refHasExtNextworks(ref []string) bool {
 	return false
 }
 
-func refConvertTags(ref [][]string) [][]string {
+func refConvertTags(cfg *utils.Config, ref [][]string) [][]string {
 	r := [][]string{}
 	for _, obj := range ref {
-		conv := refConvertExtNetworks(obj)
+		conv := refConvertExtNetworks(cfg, obj)
 		r = append(r, conv)
 	}
 	return r
 }
 
-func refConvertExtNetworks(ref []string) []string {
+func refConvertExtNetworks(cfg *utils.Config, ref []string) []string {
 
 	r := []string{}
 	for _, tag := range ref {
-		if strings.HasPrefix(tag, utils.ExtnetPrefix) {
-			r = append(r, tag+utils.MigrationSuffix)
+		if cfg.IsExtnetTag(tag) {
+			r = append(r, cfg.Naming().Tag(tag))
 		} else if strings.HasPrefix(tag, utils.ExtnetNamePrefix) {
-			r = append(r, tag+utils.MigrationSuffix)
+			r = append(r, cfg.Naming().Tag(tag))
+		} else if cfg.IsHostServiceTag(tag) {
+			r = append(r, cfg.Naming().Tag(tag))
 		} else {
 			r = append(r, tag)
 		}
@@ -121,15 +129,18 @@ func parseServicePort(servicePort string) (string, string, error) {
 }
 
 // extractProtocolsPorts is a helper function to extract ports for a given protocol from servicePorts.
-func extractProtocolsPorts(protocol string, servicePorts []string, restrictedPortList []string) []string {
+// An empty restrictedPortList does not restrict the ports, otherwise the ports of every
+// service port are restricted to the ones of restrictedPortList for the protocol, and
+// dropped if it has none.
+func extractProtocolsPorts(logger *zap.Logger, protocol string, servicePorts []string, restrictedPortList []string) []string {
 
 	ports := []string{}
-	restrictedPortsMap := make(map[int]struct{})
+	restrictedSpecs := []*portspec.PortSpec{}
 
 	for _, restrictedPort := range restrictedPortList {
 		rprotocol, rports, err := parseServicePort(restrictedPort)
 		if err != nil {
-			zap.L().Error("unable to parse restrictedPort", zap.Error(err))
+			logger.Error("unable to parse restrictedPort", zap.Error(err))
 			continue
 		}
 
@@ -142,16 +153,18 @@ func extractProtocolsPorts(protocol string, servicePorts []string, restrictedPor
 			continue
 		}
 
-		for port := uint32(portSpec.Min); port <= uint32(portSpec.Max); port++ {
-			restrictedPortsMap[int(port)] = struct{}{}
-		}
+		restrictedSpecs = append(restrictedSpecs, portSpec)
+	}
+	if len(restrictedPortList) != 0 && len(restrictedSpecs) == 0 {
+		return ports
 	}
+	restricted := portspec.NewPortSet(restrictedSpecs...)
 
 	for _, servicePort := range servicePorts {
 
 		sprotocol, sports, err := parseServicePort(servicePort)
 		if err != nil {
-			zap.L().Error("unable to parse servicePort", zap.Error(err))
+			logger.Error("unable to parse servicePort", zap.Error(err))
 			continue
 		}
 
@@ -163,7 +176,7 @@ func extractProtocolsPorts(protocol string, servicePorts []string, restrictedPor
 			continue
 		}
 
-		lports, err := portranges.TrimPortRange(sports, restrictedPortsMap)
+		lports, err := portranges.TrimPortRanges(sports, restricted)
 		if err != nil {
 			continue
 		}
@@ -191,138 +204,351 @@ func appendUnique(a []string, b string) []string {
 	return a
 }
 
-func extractNonTCPAndUDPProtocols(a []string, b []string) []string {
+// Protocols other than TCP and UDP with specific intersection semantics.
+const (
+	protocolICMP  = "icmp"
+	protocolICMP6 = "icmp6"
+	protocolSCTP  = "sctp"
+)
 
-	sort.Strings(a)
-	sort.Strings(b)
+// protocolNumbers maps raw protocol numbers to the names used in service ports.
+var protocolNumbers = map[string]string{
+	"1":   protocolICMP,
+	"6":   strings.ToLower(protocols.L4ProtocolTCP),
+	"17":  strings.ToLower(protocols.L4ProtocolUDP),
+	"58":  protocolICMP6,
+	"132": protocolSCTP,
+}
 
-	protoMap := make(map[string]map[string][]string)
+// normalizeProtocol replaces a raw protocol number by its name in servicePort.
+func normalizeProtocol(servicePort string) string {
 
-	for _, a1 := range a {
-		rprotocol, rports, err := parseServicePort(a1)
-		if err != nil {
-			zap.L().Error("unable to parse restrictedPort", zap.Error(err))
-			continue
-		}
+	parts := strings.SplitN(servicePort, "/", 2)
+	name, ok := protocolNumbers[parts[0]]
+	if !ok {
+		return servicePort
+	}
+	parts[0] = name
+	return strings.Join(parts, "/")
+}
 
-		if strings.EqualFold(protocols.L4ProtocolTCP, rprotocol) || strings.EqualFold(protocols.L4ProtocolUDP, rprotocol) {
-			continue
-		}
+// protoSpec is a parsed service port for a protocol other than TCP and UDP:
+// - icmp and icmp6: an optional type and optional codes (icmp/<type>/<code>,<from>:<to>).
+// - sctp: an optional port range.
+// - any other protocol: the protocol only.
+type protoSpec struct {
+	raw      string
+	protocol string
+
+	// icmp
+	icmpType int
+	codes    []bool
+
+	// sctp
+	portMin int
+	portMax int
+}
 
-		codeMap, ok := protoMap[rprotocol]
-		if !ok {
-			codeMap = make(map[string][]string)
-		}
+const anyValue = -1
 
-		if rports == "" {
-			codeMap = nil
-		}
+// parseProtoSpec parses a service port for a protocol other than TCP and UDP.
+func parseProtoSpec(servicePort string) (*protoSpec, error) {
 
-		if codeMap == nil {
-			protoMap[rprotocol] = codeMap
-			continue
+	protocol, rest, err := parseServicePort(servicePort)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &protoSpec{
+		raw:      servicePort,
+		protocol: strings.ToLower(protocol),
+		icmpType: anyValue,
+		portMin:  anyValue,
+		portMax:  anyValue,
+	}
+
+	if rest == "" {
+		return p, nil
+	}
+
+	switch p.protocol {
+
+	case protocolICMP, protocolICMP6:
+		parts := strings.SplitN(rest, "/", 2)
+		t, err := strconv.Atoi(parts[0])
+		if err != nil || t < 0 || t > 255 {
+			return nil, fmt.Errorf("invalid icmp type in '%s'", servicePort)
+		}
+		p.icmpType = t
+		if len(parts) == 2 {
+			if p.codes, err = parseCodes(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid icmp code in '%s': %s", servicePort, err)
+			}
 		}
 
-		if !strings.Contains(rports, "/") {
-			codeMap[rports] = nil
-			protoMap[rprotocol] = codeMap
-			continue
+	case protocolSCTP:
+		spec, err := portspec.NewPortSpecFromString(rest, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sctp ports in '%s': %s", servicePort, err)
 		}
+		p.portMin, p.portMax = int(spec.Min), int(spec.Max)
 
-		parts := strings.Split(rports, "/")
-		code := parts[0]
-		codeTypeList, ok := codeMap[code]
-		if !ok {
-			codeTypeList = []string{}
+	default:
+		return nil, fmt.Errorf("protocol %s does not support ports in '%s'", protocol, servicePort)
+	}
+
+	return p, nil
+}
+
+// parseCodes parses a list of icmp codes and code ranges such as 0,3:5.
+func parseCodes(s string) ([]bool, error) {
+
+	codes := make([]bool, 256)
+	for _, c := range strings.Split(s, ",") {
+		bounds := strings.SplitN(c, ":", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+		if from < 0 || to > 255 || from > to {
+			return nil, fmt.Errorf("code out of range '%s'", c)
 		}
+		for i := from; i <= to; i++ {
+			codes[i] = true
+		}
+	}
+	return codes, nil
+}
+
+// formatCodes formats icmp codes as a list of codes and code ranges.
+func formatCodes(codes []bool) string {
 
-		if codeTypeList == nil {
-			protoMap[rprotocol] = codeMap
+	r := []string{}
+	for i := 0; i < len(codes); i++ {
+		if !codes[i] {
 			continue
 		}
+		j := i
+		for j+1 < len(codes) && codes[j+1] {
+			j++
+		}
+		if i == j {
+			r = append(r, strconv.Itoa(i))
+		} else {
+			r = append(r, fmt.Sprintf("%d:%d", i, j))
+		}
+		i = j
+	}
+	return strings.Join(r, ",")
+}
 
-		codeMap[code] = appendUnique(codeTypeList, parts[1])
-		protoMap[rprotocol] = codeMap
+// contains returns true if all the traffic matched by o is matched by p.
+func (p *protoSpec) contains(o *protoSpec) bool {
+
+	if p.protocol != o.protocol {
+		return false
 	}
 
-	for _, b1 := range b {
+	switch p.protocol {
 
-		sprotocol, sports, err := parseServicePort(b1)
-		if err != nil {
-			zap.L().Error("unable to parse servicePort", zap.Error(err))
-			continue
+	case protocolICMP, protocolICMP6:
+		if p.icmpType == anyValue {
+			return true
+		}
+		if p.icmpType != o.icmpType {
+			return false
+		}
+		if p.codes == nil {
+			return true
+		}
+		if o.codes == nil {
+			return false
+		}
+		for i := range o.codes {
+			if o.codes[i] && !p.codes[i] {
+				return false
+			}
 		}
+		return true
 
-		if strings.EqualFold(sprotocol, protocols.L4ProtocolTCP) || strings.EqualFold(sprotocol, protocols.L4ProtocolUDP) {
-			continue
+	case protocolSCTP:
+		if p.portMin == anyValue {
+			return true
+		}
+		if o.portMin == anyValue {
+			return false
 		}
+		return p.portMin <= o.portMin && o.portMax <= p.portMax
+	}
 
-		codeMap, ok := protoMap[sprotocol]
-		if !ok {
-			codeMap = make(map[string][]string)
+	return true
+}
+
+// intersect returns the traffic matched by both p and o, or nil if there is none.
+func (p *protoSpec) intersect(o *protoSpec) *protoSpec {
+
+	if p.protocol != o.protocol {
+		return nil
+	}
+	if p.contains(o) {
+		return o
+	}
+	if o.contains(p) {
+		return p
+	}
+
+	switch p.protocol {
+
+	case protocolICMP, protocolICMP6:
+		if p.icmpType != o.icmpType {
+			return nil
 		}
+		// Same type as neither contains the other, so both have codes.
+		codes := make([]bool, 256)
+		found := false
+		for i := range codes {
+			codes[i] = p.codes[i] && o.codes[i]
+			found = found || codes[i]
+		}
+		if !found {
+			return nil
+		}
+		r := *p
+		r.codes = codes
+		r.raw = fmt.Sprintf("%s/%d/%s", p.protocol, p.icmpType, formatCodes(codes))
+		return &r
 
-		if sports == "" {
-			codeMap = nil
+	case protocolSCTP:
+		min, max := p.portMin, p.portMax
+		if o.portMin > min {
+			min = o.portMin
+		}
+		if o.portMax < max {
+			max = o.portMax
+		}
+		if min > max {
+			return nil
+		}
+		r := *p
+		r.portMin, r.portMax = min, max
+		if min == max {
+			r.raw = fmt.Sprintf("%s/%d", p.protocol, min)
+		} else {
+			r.raw = fmt.Sprintf("%s/%d:%d", p.protocol, min, max)
 		}
+		return &r
+	}
+
+	return nil
+}
+
+// parseProtoSpecs parses the service ports of protocols other than TCP and UDP, grouped
+// by protocol. Protocols are returned in order of appearance.
+func parseProtoSpecs(logger *zap.Logger, servicePorts []string) ([]string, map[string][]*protoSpec) {
 
-		if codeMap == nil {
-			protoMap[sprotocol] = codeMap
+	order := []string{}
+	specs := map[string][]*protoSpec{}
+
+	for _, servicePort := range servicePorts {
+
+		protocol := strings.SplitN(servicePort, "/", 2)[0]
+		if strings.EqualFold(protocol, protocols.L4ProtocolTCP) || strings.EqualFold(protocol, protocols.L4ProtocolUDP) {
 			continue
 		}
 
-		if !strings.Contains(sports, "/") {
-			codeMap[sports] = nil
-			protoMap[sprotocol] = codeMap
+		p, err := parseProtoSpec(servicePort)
+		if err != nil {
+			logger.Error("unable to parse servicePort", zap.Error(err))
 			continue
 		}
 
-		parts := strings.Split(sports, "/")
-		code := parts[0]
-		codeTypeList, ok := codeMap[code]
-		if !ok {
-			codeTypeList = []string{}
+		if _, ok := specs[p.protocol]; !ok {
+			order = append(order, p.protocol)
 		}
+		specs[p.protocol] = append(specs[p.protocol], p)
+	}
 
-		if codeTypeList == nil {
-			protoMap[sprotocol] = codeMap
-			continue
-		}
+	return order, specs
+}
+
+// reduceProtoSpecs removes the duplicates and the specs contained in other specs.
+func reduceProtoSpecs(specs []*protoSpec) []string {
 
-		codeMap[code] = appendUnique(codeTypeList, parts[1])
-		protoMap[sprotocol] = codeMap
+	r := []string{}
+	for i, p := range specs {
+		contained := false
+		for j, o := range specs {
+			if i == j || !o.contains(p) {
+				continue
+			}
+			// Keep the first of equal specs.
+			if !p.contains(o) || j < i {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			r = appendUnique(r, p.raw)
+		}
 	}
+	return r
+}
+
+// extractNonTCPAndUDPProtocols returns the protocols other than TCP and UDP from a,
+// restricted by the ones from b. An empty b does not restrict a, otherwise the protocols
+// not found in b are dropped.
+func extractNonTCPAndUDPProtocols(logger *zap.Logger, a []string, b []string) []string {
+
+	order, aspecs := parseProtoSpecs(logger, a)
+	_, bspecs := parseProtoSpecs(logger, b)
 
 	protos := []string{}
-	for k, v := range protoMap {
-		if v == nil {
-			protos = append(protos, k)
+	for _, protocol := range order {
+
+		if len(b) == 0 {
+			protos = append(protos, reduceProtoSpecs(aspecs[protocol])...)
 			continue
 		}
 
-		for k1, v1 := range v {
-			if v1 == nil {
-				protos = append(protos, fmt.Sprintf("%s/%s", k, k1))
-				continue
-			}
+		restrictions, ok := bspecs[protocol]
+		if !ok {
+			continue
+		}
 
-			for _, v2 := range v1 {
-				protos = append(protos, fmt.Sprintf("%s/%s/%s", k, k1, v2))
-				continue
+		intersections := []*protoSpec{}
+		for _, p := range aspecs[protocol] {
+			for _, o := range restrictions {
+				if i := p.intersect(o); i != nil {
+					intersections = append(intersections, i)
+				}
 			}
 		}
+		protos = append(protos, reduceProtoSpecs(intersections)...)
 	}
 
 	return protos
 }
 
-func intersect(a []string, b []string) []string {
+// intersect returns the service ports of a restricted by the ones of b. The service ports
+// that can not be parsed are logged to logger and ignored.
+func intersect(logger *zap.Logger, a []string, b []string) []string {
 
-	// Condition tcp and udp protocols and remove any
+	// Condition tcp and udp protocols, protocol numbers, and remove any and invalid entries
 	set1 := make([]string, 0)
 	for _, pp := range a {
+		pp = normalizeProtocol(pp)
+		if _, _, err := parseServicePort(pp); err != nil && !strings.EqualFold(pp, protocols.ANY) {
+			logger.Error("unable to parse servicePort", zap.Error(err))
+			continue
+		}
 		if strings.EqualFold(pp, protocols.L4ProtocolTCP) || strings.EqualFold(pp, protocols.L4ProtocolUDP) {
-			set1 = append(set1, pp+"/1:65535")
+			set1 = append(set1, pp+"/"+portspec.NewFullPortSet().String())
 		} else if !strings.EqualFold(pp, protocols.ANY) {
 			set1 = append(set1, pp)
 		} else {
@@ -333,8 +559,13 @@ func intersect(a []string, b []string) []string {
 	}
 	set2 := make([]string, 0)
 	for _, pp := range b {
+		pp = normalizeProtocol(pp)
+		if _, _, err := parseServicePort(pp); err != nil && !strings.EqualFold(pp, protocols.ANY) {
+			logger.Error("unable to parse servicePort", zap.Error(err))
+			continue
+		}
 		if strings.EqualFold(pp, protocols.L4ProtocolTCP) || strings.EqualFold(pp, protocols.L4ProtocolUDP) {
-			set2 = append(set2, pp+"/1:65535")
+			set2 = append(set2, pp+"/"+portspec.NewFullPortSet().String())
 		} else if !strings.EqualFold(pp, protocols.ANY) {
 			set2 = append(set2, pp)
 		} else {
@@ -355,19 +586,19 @@ func intersect(a []string, b []string) []string {
 	}
 
 	i := []string{}
-	y := extractProtocolsPorts(protocols.L4ProtocolTCP, cset1, cset2)
+	y := extractProtocolsPorts(logger, protocols.L4ProtocolTCP, cset1, cset2)
 	for _, y1 := range y {
 
 		i = append(i, fmt.Sprintf("%s/%s", protocols.L4ProtocolTCP, y1))
 	}
 
-	y = extractProtocolsPorts(protocols.L4ProtocolUDP, cset1, cset2)
+	y = extractProtocolsPorts(logger, protocols.L4ProtocolUDP, cset1, cset2)
 	for _, y1 := range y {
 
 		i = append(i, fmt.Sprintf("%s/%s", protocols.L4ProtocolUDP, y1))
 	}
 
-	y = extractNonTCPAndUDPProtocols(cset1, cset2)
+	y = extractNonTCPAndUDPProtocols(logger, cset1, cset2)
 	i = append(i, y...)
 	return i
 }
@@ -414,3 +645,9 @@ func extnetsFromTags(policyNamespace string, tags []string, eList gaia.ExternalN
 	}
 	return
 }
+
+// ExternalNetworksFromTags returns the external networks from eList that are visible from
+// policyNamespace and are selected by the tags of a policy clause.
+func ExternalNetworksFromTags(policyNamespace string, tags []string, eList gaia.ExternalNetworksList) gaia.ExternalNetworksList {
+	return extnetsFromTags(policyNamespace, tags, eList)
+}
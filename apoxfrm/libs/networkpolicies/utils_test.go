@@ -6,6 +6,7 @@ import (
 
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
 	"go.aporeto.io/gaia"
+	"go.uber.org/zap"
 )
 
 func Test_match(t *testing.T) {
@@ -107,14 +108,51 @@ func Test_refHasExtNextworks(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		e := utils.ExtnetPrefix
-		utils.ExtnetPrefix = "customer:ext:network=x"
+		cfg := utils.MustNewConfig([]string{"customer:ext:network=x"}, nil, "", nil)
 		t.Run(tt.name, func(t *testing.T) {
-			if got := refHasExtNextworks(tt.args.ref); got != tt.want {
+			if got := refHasExtNextworks(cfg, tt.args.ref); got != tt.want {
 				t.Errorf("refHasExtNextworks() = %v, want %v", got, tt.want)
 			}
 		})
-		utils.ExtnetPrefix = e
+	}
+}
+
+func Test_refConvertExtNetworks(t *testing.T) {
+
+	cfg := utils.MustNewConfig(
+		[]string{"customer:ext:net=", "legacy:ext:net="},
+		[]string{`^extnet:[a-z]+=`},
+		"customer:ext:hostservice=",
+		nil,
+	)
+
+	tests := []struct {
+		name string
+		ref  []string
+		want []string
+	}{
+		{
+			name: "prefixes",
+			ref:  []string{"customer:ext:net=ssh", "legacy:ext:net=web", "app=x"},
+			want: []string{"customer:ext:net=ssh-v2", "legacy:ext:net=web-v2", "app=x"},
+		},
+		{
+			name: "pattern",
+			ref:  []string{"extnet:name=dns", "extnet:Name=dns"},
+			want: []string{"extnet:name=dns-v2", "extnet:Name=dns"},
+		},
+		{
+			name: "name and host service",
+			ref:  []string{"$name=ssh", "customer:ext:hostservice=ssh"},
+			want: []string{"$name=ssh-v2", "customer:ext:hostservice=ssh-v2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refConvertExtNetworks(cfg, tt.ref); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("refConvertExtNetworks() = %v, want %v", got, tt.want)
+			}
+		})
 	}
 }
 
@@ -174,7 +212,7 @@ func Test_intersect(t *testing.T) {
 				a: []string{"tcp/22"},
 				b: []string{"udp/53"},
 			},
-			want: []string{"TCP/22"},
+			want: []string{},
 		},
 		{
 			name: "different 2",
@@ -182,7 +220,7 @@ func Test_intersect(t *testing.T) {
 				a: []string{"udp/53"},
 				b: []string{"tcp/22"},
 			},
-			want: []string{"UDP/53"},
+			want: []string{},
 		},
 		{
 			name: "tcp 1",
@@ -278,7 +316,7 @@ func Test_intersect(t *testing.T) {
 				a: []string{"icmp/12/1"},
 				b: []string{"icmp/11/1"},
 			},
-			want: []string{"icmp/12/1", "icmp/11/1"},
+			want: []string{},
 		},
 		{
 			name: "icmp 4",
@@ -296,6 +334,70 @@ func Test_intersect(t *testing.T) {
 			},
 			want: []string{"icmp/11/1"},
 		},
+		{
+			name: "icmp codes",
+			args: args{
+				a: []string{"icmp/3/0:5"},
+				b: []string{"icmp/3/4:10", "icmp/11"},
+			},
+			want: []string{"icmp/3/4:5"},
+		},
+		{
+			name: "icmp6 dropped by icmp",
+			args: args{
+				a: []string{"icmp6/128", "icmp/8"},
+				b: []string{"icmp/0"},
+			},
+			want: []string{},
+		},
+		{
+			name: "sctp 1",
+			args: args{
+				a: []string{"sctp"},
+				b: []string{"sctp/5000:6000"},
+			},
+			want: []string{"sctp/5000:6000"},
+		},
+		{
+			name: "sctp 2",
+			args: args{
+				a: []string{"sctp/5000:6000"},
+				b: []string{"sctp/5500:7000"},
+			},
+			want: []string{"sctp/5500:6000"},
+		},
+		{
+			name: "sctp 3",
+			args: args{
+				a: []string{"sctp/5000"},
+				b: []string{"sctp/6000"},
+			},
+			want: []string{},
+		},
+		{
+			name: "protocol numbers 1",
+			args: args{
+				a: []string{"1/8", "6/22", "132/80"},
+				b: []string{"icmp", "tcp/1:100", "sctp"},
+			},
+			want: []string{"TCP/22", "icmp/8", "sctp/80"},
+		},
+		{
+			name: "protocol numbers 2",
+			args: args{
+				a: []string{"47", "50"},
+				b: []string{"47", "tcp/22"},
+			},
+			want: []string{"47"},
+		},
+		{
+			name: "protocol numbers 3",
+			args: args{
+				a: []string{"58/128/0"},
+				b: []string{},
+			},
+			want: []string{"icmp6/128/0"},
+		},
 		{
 			name: "multiprotocol 1",
 			args: args{
@@ -323,7 +425,7 @@ func Test_intersect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := intersect(tt.args.a, tt.args.b); !reflect.DeepEqual(got, tt.want) {
+			if got := intersect(zap.NewNop(), tt.args.a, tt.args.b); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("intersect() = %v, want %v", got, tt.want)
 			}
 		})
@@ -358,6 +460,18 @@ func Test_extnetsFromTags(t *testing.T) {
 	extnetParentNsNoPropagate.Namespace = "/policy"
 	extnetParentNsNoPropagate.AssociatedTags = []string{"ext:network=parent-ns-no-propagate", "tag=match"}
 
+	extnetENI := gaia.NewExternalNetwork()
+	extnetENI.Name = "match-eni"
+	extnetENI.Namespace = "/policy/child"
+	extnetENI.Type = gaia.ExternalNetworkTypeENI
+	extnetENI.AssociatedTags = []string{"ext:network=match-eni", "tag=match"}
+
+	extnetFQDN := gaia.NewExternalNetwork()
+	extnetFQDN.Name = "match-fqdn"
+	extnetFQDN.Namespace = "/policy/child"
+	extnetFQDN.Entries = []string{"www.example.com", "*.example.org"}
+	extnetFQDN.AssociatedTags = []string{"ext:network=match-fqdn", "tag=match"}
+
 	extnetNonParentNs := gaia.NewExternalNetwork()
 	extnetNonParentNs.Name = "non-parent-ns"
 	extnetNonParentNs.Namespace = "/pol"
@@ -404,6 +518,38 @@ func Test_extnetsFromTags(t *testing.T) {
 				extnetParentNs,
 			},
 		},
+		{
+			name: "all types",
+			args: args{
+				policyNamespace: "/policy/child",
+				tags:            []string{"tag=match"},
+				eList: gaia.ExternalNetworksList{
+					extnetSameNs,
+					extnetENI,
+					extnetFQDN,
+				},
+			},
+			wantExtnetList: gaia.ExternalNetworksList{
+				extnetSameNs,
+				extnetENI,
+				extnetFQDN,
+			},
+		},
+		{
+			name: "$type",
+			args: args{
+				policyNamespace: "/policy/child",
+				tags:            []string{"$identity=externalnetwork", "$type=ENI", "tag=match"},
+				eList: gaia.ExternalNetworksList{
+					extnetSameNs,
+					extnetENI,
+					extnetFQDN,
+				},
+			},
+			wantExtnetList: gaia.ExternalNetworksList{
+				extnetENI,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -446,7 +592,7 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 				a: []string{},
 				b: []string{"icmp"},
 			},
-			want: []string{"icmp"},
+			want: []string{},
 		},
 		{
 			name: "basic-b-1",
@@ -454,7 +600,7 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 				a: []string{},
 				b: []string{"icmp/1"},
 			},
-			want: []string{"icmp/1"},
+			want: []string{},
 		},
 		{
 			name: "basic-b-icmp-1/1-1/2",
@@ -462,7 +608,7 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 				a: []string{},
 				b: []string{"icmp", "icmp/1/1", "icmp/1/2"},
 			},
-			want: []string{"icmp"},
+			want: []string{},
 		},
 		{
 			name: "basic-equal",
@@ -518,7 +664,7 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 				a: []string{"icmp/1", "icmp/1/2"},
 				b: []string{"icmp", "icmp6"},
 			},
-			want: []string{"icmp", "icmp6"},
+			want: []string{"icmp/1"},
 		},
 		{
 			name: "very complex",
@@ -526,7 +672,7 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 				a: []string{"icmp/1", "icmp/1/2", "icmp/1/3", "icmp/2/2"},
 				b: []string{"icmp/1", "icmp6"},
 			},
-			want: []string{"icmp/1", "icmp/2/2", "icmp6"},
+			want: []string{"icmp/1"},
 		},
 		{
 			name: "duplicates",
@@ -536,10 +682,66 @@ func Test_extractNonTCPAndUDPProtocols(t *testing.T) {
 			},
 			want: []string{"icmp/8/0"},
 		},
+		{
+			name: "icmp different types",
+			args: args{
+				a: []string{"icmp/8", "icmp/0"},
+				b: []string{"icmp/11"},
+			},
+			want: []string{},
+		},
+		{
+			name: "icmp code lists",
+			args: args{
+				a: []string{"icmp/3/0,2,4"},
+				b: []string{"icmp/3/2:3"},
+			},
+			want: []string{"icmp/3/2"},
+		},
+		{
+			name: "icmp code ranges",
+			args: args{
+				a: []string{"icmp/3/0:3,10"},
+				b: []string{"icmp/3/2:12"},
+			},
+			want: []string{"icmp/3/2:3,10"},
+		},
+		{
+			name: "icmp6",
+			args: args{
+				a: []string{"icmp6", "icmp/8"},
+				b: []string{"icmp6/128/0"},
+			},
+			want: []string{"icmp6/128/0"},
+		},
+		{
+			name: "sctp",
+			args: args{
+				a: []string{"sctp/80", "sctp/443"},
+				b: []string{"sctp/1:100"},
+			},
+			want: []string{"sctp/80"},
+		},
+		{
+			name: "other protocols",
+			args: args{
+				a: []string{"47", "gre", "50"},
+				b: []string{"50"},
+			},
+			want: []string{"50"},
+		},
+		{
+			name: "invalid",
+			args: args{
+				a: []string{"gre/5", "icmp/256"},
+				b: []string{},
+			},
+			want: []string{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := extractNonTCPAndUDPProtocols(tt.args.a, tt.args.b); !reflect.DeepEqual(got, tt.want) {
+			if got := extractNonTCPAndUDPProtocols(zap.NewNop(), tt.args.a, tt.args.b); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("extractNonTCPAndUDPProtocols() = %v, want %v", got, tt.want)
 			}
 		})
@@ -2,6 +2,7 @@ package portranges
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/portspec"
@@ -10,6 +11,10 @@ import (
 // TrimPortRange returns ranges such that if no entries in exist in filteredPortMap, the
 // complete sports are returned. However, if filteredPortMap has entries, the ranges
 // returned are intersection of sports and filteredPortMap.
+//
+// The ports of filteredPortMap are converted to sorted intervals, so the cost does not
+// depend on the size of sports. Prefer TrimPortRanges when the filter is already a set
+// of ranges.
 func TrimPortRange(sports string, filteredPortMap map[int]struct{}) ([]string, error) {
 
 	// return early if there are no ports in policy
@@ -18,29 +23,54 @@ func TrimPortRange(sports string, filteredPortMap map[int]struct{}) ([]string, e
 		return []string{sports}, nil
 	}
 
-	pspec, err := portspec.NewPortSpecFromString(sports, nil)
-	if err != nil {
-		return []string{}, err
+	ports := make([]int, 0, len(filteredPortMap))
+	for port := range filteredPortMap {
+		if port < 0 || port > portspec.MaxPort {
+			continue
+		}
+		ports = append(ports, port)
 	}
+	sort.Ints(ports)
 
-	// single value
-	if pspec.Min == pspec.Max {
-		if _, ok := filteredPortMap[int(pspec.Min)]; ok {
-			return []string{sports}, nil
+	// no port of the map is valid, so none of sports is kept
+	if len(ports) == 0 {
+		if _, err := portspec.NewPortSpecFromString(sports, nil); err != nil {
+			return []string{}, err
 		}
 		return []string{}, nil
 	}
 
-	// range
-	includePorts := []int{}
-	for i := uint32(pspec.Min); i <= uint32(pspec.Max); i++ {
-		if _, ok := filteredPortMap[int(i)]; !ok {
-			continue
-		}
-		includePorts = append(includePorts, int(i))
+	return TrimPortRanges(sports, portspec.NewPortSet(buildSpecs(ports)...))
+}
+
+// TrimPortRanges returns the intersection of sports with the filter. If the filter is
+// empty, the complete sports are returned.
+func TrimPortRanges(sports string, filter *portspec.PortSet) ([]string, error) {
+
+	// return early if there are no ports in policy
+	// remove this when we remove ports from ext networks
+	if filter.IsEmpty() {
+		return []string{sports}, nil
 	}
 
-	return buildRanges(includePorts), nil
+	sset, err := portspec.ParsePortSet([]string{sports})
+	if err != nil {
+		return []string{}, err
+	}
+
+	return sset.Intersect(filter).Strings(), nil
+}
+
+// MergePortRanges returns the smallest list of ranges covering all ranges, merging
+// ranges that overlap or are adjacent. The returned ranges are sorted.
+func MergePortRanges(ranges []string) ([]string, error) {
+
+	set, err := portspec.ParsePortSet(ranges)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return set.Strings(), nil
 }
 
 // CreatePortList take a map of of ports mentioned in the port clause of network policy and
@@ -68,27 +98,27 @@ func fmtRange(start, end int) []string {
 	return []string{r}
 }
 
-// buildRangesR is a recursive function to return a list of ranges
-// representing the numbers in the ports list.
+// buildSpecs returns the port specs of the sequences of consecutive ports in the ports list.
 // ports list is expected to be sorted in ascending order.
-func buildRangesR(ports []int, start, curr int) []string {
-	if len(ports) == 1 {
-		return fmtRange(start, curr)
-	}
-	// len(ports) > 1
-	sports := []string{}
-	if ports[0]+1 != ports[1] {
-		sports = fmtRange(start, curr)
-		start = ports[1]
+func buildSpecs(ports []int) []*portspec.PortSpec {
+
+	specs := []*portspec.PortSpec{}
+	for i := 0; i < len(ports); {
+		start := ports[i]
+		for i++; i < len(ports) && ports[i] == ports[i-1]+1; i++ {
+		}
+		specs = append(specs, &portspec.PortSpec{Min: uint16(start), Max: uint16(ports[i-1])})
 	}
-	return append(sports, buildRangesR(ports[1:], start, ports[1])...)
+	return specs
 }
 
 // buildRanges returns a list of ranges to represent ports in the ports list.
 // ports list is expected to be sorted in ascending order.
 func buildRanges(ports []int) []string {
-	if len(ports) == 0 {
-		return []string{}
+
+	ranges := []string{}
+	for _, spec := range buildSpecs(ports) {
+		ranges = append(ranges, fmtRange(int(spec.Min), int(spec.Max))...)
 	}
-	return buildRangesR(ports, ports[0], ports[0])
+	return ranges
 }
@@ -1,11 +1,58 @@
 package portranges
 
 import (
+	"math/rand"
 	"reflect"
 	"sort"
+	"strconv"
 	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/portspec"
 )
 
+// trimPortRangeByPort is the former implementation of TrimPortRange expanding every port
+// of the range. It is the reference of the interval based implementation.
+func trimPortRangeByPort(sports string, filteredPortMap map[int]struct{}) ([]string, error) {
+
+	if len(filteredPortMap) == 0 {
+		return []string{sports}, nil
+	}
+
+	pspec, err := portspec.NewPortSpecFromString(sports, nil)
+	if err != nil {
+		return []string{}, err
+	}
+
+	includePorts := []int{}
+	for i := uint32(pspec.Min); i <= uint32(pspec.Max); i++ {
+		if _, ok := filteredPortMap[int(i)]; !ok {
+			continue
+		}
+		includePorts = append(includePorts, int(i))
+	}
+
+	ranges := []string{}
+	for i := 0; i < len(includePorts); {
+		start := includePorts[i]
+		for i++; i < len(includePorts) && includePorts[i] == includePorts[i-1]+1; i++ {
+		}
+		ranges = append(ranges, fmtRange(start, includePorts[i-1])...)
+	}
+	return ranges, nil
+}
+
+// portMap returns a map with the ports of the ranges.
+func portMap(ranges ...[2]int) map[int]struct{} {
+
+	m := map[int]struct{}{}
+	for _, r := range ranges {
+		for p := r[0]; p <= r[1]; p++ {
+			m[p] = struct{}{}
+		}
+	}
+	return m
+}
+
 func Test_buildRanges(t *testing.T) {
 	type args struct {
 		ports []int
@@ -295,3 +342,243 @@ func TestCreatePortList(t *testing.T) {
 		})
 	}
 }
+
+func TestMergePortRanges(t *testing.T) {
+	type args struct {
+		ranges []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			args: args{
+				ranges: []string{},
+			},
+			want: []string{},
+		},
+		{
+			name: "single",
+			args: args{
+				ranges: []string{"80"},
+			},
+			want: []string{"80"},
+		},
+		{
+			name: "adjacent",
+			args: args{
+				ranges: []string{"91:100", "80:90"},
+			},
+			want: []string{"80:100"},
+		},
+		{
+			name: "adjacent single ports",
+			args: args{
+				ranges: []string{"80", "81", "82"},
+			},
+			want: []string{"80:82"},
+		},
+		{
+			name: "overlapping and contained",
+			args: args{
+				ranges: []string{"1:100", "50:60", "90:200"},
+			},
+			want: []string{"1:200"},
+		},
+		{
+			name: "disjoint",
+			args: args{
+				ranges: []string{"443", "22", "8000:9000"},
+			},
+			want: []string{"22", "443", "8000:9000"},
+		},
+		{
+			name: "duplicates",
+			args: args{
+				ranges: []string{"22", "22"},
+			},
+			want: []string{"22"},
+		},
+		{
+			name: "bad range",
+			args: args{
+				ranges: []string{"22", "abc"},
+			},
+			want:    []string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePortRanges(tt.args.ranges)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MergePortRanges() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergePortRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_buildRangesLarge(t *testing.T) {
+
+	ports := make([]int, 0, 65535)
+	for p := 1; p <= 65535; p++ {
+		if p != 1000 {
+			ports = append(ports, p)
+		}
+	}
+
+	want := []string{"1:999", "1001:65535"}
+	if got := buildRanges(ports); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildRanges() = %v, want %v", got, want)
+	}
+}
+
+func TestTrimPortRanges(t *testing.T) {
+	type args struct {
+		sports string
+		filter []string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "empty filter",
+			args: args{
+				sports: "1:65535",
+			},
+			want: []string{"1:65535"},
+		},
+		{
+			name: "full range",
+			args: args{
+				sports: "1:65535",
+				filter: []string{"443", "22", "8000:9000", "8500:10000"},
+			},
+			want: []string{"22", "443", "8000:10000"},
+		},
+		{
+			name: "partial overlap",
+			args: args{
+				sports: "100:200",
+				filter: []string{"50:150", "190:300"},
+			},
+			want: []string{"100:150", "190:200"},
+		},
+		{
+			name: "no overlap",
+			args: args{
+				sports: "100",
+				filter: []string{"50:99", "101"},
+			},
+			want: []string{},
+		},
+		{
+			name: "invalid sports",
+			args: args{
+				sports: "200:100",
+				filter: []string{"50"},
+			},
+			want:    []string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := portspec.ParsePortSet(tt.args.filter)
+			if err != nil {
+				t.Fatalf("ParsePortSet() error = %v", err)
+			}
+			got, err := TrimPortRanges(tt.args.sports, filter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TrimPortRanges() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrimPortRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimPortRange_Reference(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+
+		ranges := [][2]int{}
+		for j, n := 0, r.Intn(5); j < n; j++ {
+			min := r.Intn(65535) + 1
+			ranges = append(ranges, [2]int{min, min + r.Intn(65536-min)})
+		}
+		m := portMap(ranges...)
+
+		min := r.Intn(65535) + 1
+		sports := strconv.Itoa(min) + ":" + strconv.Itoa(min+r.Intn(65536-min))
+
+		got, err := TrimPortRange(sports, m)
+		if err != nil {
+			t.Fatalf("TrimPortRange(%s) error = %v", sports, err)
+		}
+		want, _ := trimPortRangeByPort(sports, m)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("TrimPortRange(%s, %v) = %v, want %v", sports, ranges, got, want)
+		}
+	}
+}
+
+func benchmarkFilter() map[int]struct{} {
+	return portMap([2]int{22, 22}, [2]int{80, 80}, [2]int{443, 443}, [2]int{1000, 2000}, [2]int{8000, 9000})
+}
+
+func BenchmarkTrimPortRange_ByPort(b *testing.B) {
+
+	m := benchmarkFilter()
+	for i := 0; i < b.N; i++ {
+		if _, err := trimPortRangeByPort("1:65535", m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTrimPortRange(b *testing.B) {
+
+	m := benchmarkFilter()
+	for i := 0; i < b.N; i++ {
+		if _, err := TrimPortRange("1:65535", m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTrimPortRanges(b *testing.B) {
+
+	filter, err := portspec.ParsePortSet([]string{"22", "80", "443", "1000:2000", "8000:9000"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := TrimPortRanges("1:65535", filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTrimPortRanges_FullFilter(b *testing.B) {
+
+	filter := portspec.NewFullPortSet()
+	for i := 0; i < b.N; i++ {
+		if _, err := TrimPortRanges("1:65535", filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package portspec
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Bounds of the ports a port set can contain.
+const (
+	MinPort = 1
+	MaxPort = 65535
+)
+
+// PortSet is a set of ports. It is stored as a sorted list of disjoint and non adjacent
+// port specs, so that all operations are O(n) on normalized sets and normalizing an
+// arbitrary list of port specs is O(n log n).
+type PortSet struct {
+	specs []*PortSpec
+}
+
+// NewPortSet returns the port set of the given port specs. The specs can be in any order
+// and overlap. Their values are dropped.
+func NewPortSet(specs ...*PortSpec) *PortSet {
+
+	s := &PortSet{
+		specs: make([]*PortSpec, 0, len(specs)),
+	}
+	for _, spec := range specs {
+		s.specs = append(s.specs, &PortSpec{Min: spec.Min, Max: spec.Max})
+	}
+	s.normalize()
+
+	return s
+}
+
+// NewFullPortSet returns the set of all ports from MinPort to MaxPort.
+func NewFullPortSet() *PortSet {
+	return &PortSet{specs: []*PortSpec{{Min: MinPort, Max: MaxPort}}}
+}
+
+// ParsePortSet returns the port set of a list of ports or port ranges: "80", "1000:2000".
+func ParsePortSet(ports []string) (*PortSet, error) {
+
+	specs := make([]*PortSpec, 0, len(ports))
+	for _, p := range ports {
+		spec, err := NewPortSpecFromString(p, nil)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+
+	return NewPortSet(specs...), nil
+}
+
+// normalize sorts the specs and merges the ones that overlap or are adjacent.
+func (s *PortSet) normalize() {
+
+	if len(s.specs) == 0 {
+		return
+	}
+
+	sort.Slice(s.specs, func(i, j int) bool {
+		return s.specs[i].Min < s.specs[j].Min
+	})
+
+	merged := s.specs[:1]
+	for _, spec := range s.specs[1:] {
+		last := merged[len(merged)-1]
+		if uint32(spec.Min) <= uint32(last.Max)+1 {
+			if spec.Max > last.Max {
+				last.Max = spec.Max
+			}
+			continue
+		}
+		merged = append(merged, spec)
+	}
+	s.specs = merged
+}
+
+// Specs returns the normalized port specs of the set.
+func (s *PortSet) Specs() []*PortSpec {
+
+	specs := make([]*PortSpec, 0, len(s.specs))
+	for _, spec := range s.specs {
+		specs = append(specs, &PortSpec{Min: spec.Min, Max: spec.Max})
+	}
+	return specs
+}
+
+// IsEmpty returns true if the set contains no port.
+func (s *PortSet) IsEmpty() bool {
+	return len(s.specs) == 0
+}
+
+// Len returns the number of ports in the set.
+func (s *PortSet) Len() int {
+
+	n := 0
+	for _, spec := range s.specs {
+		n += int(spec.Max) - int(spec.Min) + 1
+	}
+	return n
+}
+
+// Union returns the ports in s or o.
+func (s *PortSet) Union(o *PortSet) *PortSet {
+
+	specs := make([]*PortSpec, 0, len(s.specs)+len(o.specs))
+	specs = append(specs, s.specs...)
+	specs = append(specs, o.specs...)
+
+	return NewPortSet(specs...)
+}
+
+// Intersect returns the ports in s and o.
+func (s *PortSet) Intersect(o *PortSet) *PortSet {
+
+	r := &PortSet{specs: []*PortSpec{}}
+	for i, j := 0, 0; i < len(s.specs) && j < len(o.specs); {
+		a, b := s.specs[i], o.specs[j]
+
+		min, max := a.Min, a.Max
+		if b.Min > min {
+			min = b.Min
+		}
+		if b.Max < max {
+			max = b.Max
+		}
+		if min <= max {
+			r.specs = append(r.specs, &PortSpec{Min: min, Max: max})
+		}
+
+		if a.Max < b.Max {
+			i++
+		} else {
+			j++
+		}
+	}
+	return r
+}
+
+// Complement returns the ports from MinPort to MaxPort that are not in s.
+func (s *PortSet) Complement() *PortSet {
+
+	r := &PortSet{specs: []*PortSpec{}}
+	next := uint32(MinPort)
+	for _, spec := range s.specs {
+		if uint32(spec.Min) > next {
+			r.specs = append(r.specs, &PortSpec{Min: uint16(next), Max: spec.Min - 1})
+		}
+		if uint32(spec.Max)+1 > next {
+			next = uint32(spec.Max) + 1
+		}
+	}
+	if next <= MaxPort {
+		r.specs = append(r.specs, &PortSpec{Min: uint16(next), Max: MaxPort})
+	}
+	return r
+}
+
+// Difference returns the ports in s that are not in o.
+func (s *PortSet) Difference(o *PortSet) *PortSet {
+
+	// Port 0 is outside of the complement and is handled separately.
+	r := s.Intersect(o.Complement())
+	if s.ContainsPort(0) && !o.ContainsPort(0) {
+		r = r.Union(&PortSet{specs: []*PortSpec{{Min: 0, Max: 0}}})
+	}
+	return r
+}
+
+// Contains returns true if all ports of o are in s.
+func (s *PortSet) Contains(o *PortSet) bool {
+
+	i := 0
+	for _, b := range o.specs {
+		for i < len(s.specs) && s.specs[i].Max < b.Min {
+			i++
+		}
+		if i == len(s.specs) || s.specs[i].Min > b.Min || s.specs[i].Max < b.Max {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsPort returns true if the port is in s.
+func (s *PortSet) ContainsPort(port uint16) bool {
+
+	i := sort.Search(len(s.specs), func(i int) bool {
+		return s.specs[i].Max >= port
+	})
+	return i < len(s.specs) && s.specs[i].Min <= port
+}
+
+// Equal returns true if s and o contain the same ports.
+func (s *PortSet) Equal(o *PortSet) bool {
+
+	if len(s.specs) != len(o.specs) {
+		return false
+	}
+	for i := range s.specs {
+		if s.specs[i].Min != o.specs[i].Min || s.specs[i].Max != o.specs[i].Max {
+			return false
+		}
+	}
+	return true
+}
+
+// Strings returns the ports and port ranges of the set: "80", "1000:2000".
+func (s *PortSet) Strings() []string {
+
+	r := make([]string, 0, len(s.specs))
+	for _, spec := range s.specs {
+		r = append(r, spec.String())
+	}
+	return r
+}
+
+// String returns the comma separated ports and port ranges of the set.
+func (s *PortSet) String() string {
+	return strings.Join(s.Strings(), ",")
+}
+
+// ProtocolPortSets maps lowercase protocols to their port sets.
+type ProtocolPortSets map[string]*PortSet
+
+// ParseProtocolPortSets returns the port sets of a list of proto/port[:port] entries:
+// "tcp/80", "udp/53:54". A protocol without ports ("tcp") contains all ports.
+func ParseProtocolPortSets(servicePorts []string) (ProtocolPortSets, error) {
+
+	specs := map[string][]*PortSpec{}
+	for _, sp := range servicePorts {
+
+		parts := strings.SplitN(sp, "/", 2)
+		protocol := strings.ToLower(parts[0])
+		if protocol == "" {
+			return nil, errors.New("Invalid protocol in " + sp)
+		}
+
+		if len(parts) == 1 {
+			specs[protocol] = append(specs[protocol], &PortSpec{Min: MinPort, Max: MaxPort})
+			continue
+		}
+
+		spec, err := NewPortSpecFromString(parts[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		specs[protocol] = append(specs[protocol], spec)
+	}
+
+	p := ProtocolPortSets{}
+	for protocol, s := range specs {
+		p[protocol] = NewPortSet(s...)
+	}
+	return p, nil
+}
+
+// Protocols returns the sorted protocols of the sets.
+func (p ProtocolPortSets) Protocols() []string {
+
+	protocols := make([]string, 0, len(p))
+	for protocol := range p {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+// Strings returns the proto/port[:port] entries of the sets, sorted by protocol.
+func (p ProtocolPortSets) Strings() []string {
+
+	r := []string{}
+	for _, protocol := range p.Protocols() {
+		for _, ports := range p[protocol].Strings() {
+			r = append(r, protocol+"/"+ports)
+		}
+	}
+	return r
+}
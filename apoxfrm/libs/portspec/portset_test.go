@@ -0,0 +1,145 @@
+// +build !windows
+
+package portspec
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func mustParsePortSet(ports ...string) *PortSet {
+	s, err := ParsePortSet(ports)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestParsePortSet(t *testing.T) {
+	Convey("When I parse overlapping and adjacent ranges in any order", t, func() {
+		s, err := ParsePortSet([]string{"90:100", "80", "81:85", "95:120", "443", "200:300", "250"})
+		So(err, ShouldBeNil)
+		Convey("The set should be normalized", func() {
+			So(s.Strings(), ShouldResemble, []string{"80:85", "90:120", "200:300", "443"})
+			So(s.String(), ShouldEqual, "80:85,90:120,200:300,443")
+			So(s.Len(), ShouldEqual, 6+31+101+1)
+		})
+	})
+
+	Convey("When I parse an invalid range it should fail", t, func() {
+		_, err := ParsePortSet([]string{"80", "20:10"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I parse an empty list the set should be empty", t, func() {
+		s, err := ParsePortSet(nil)
+		So(err, ShouldBeNil)
+		So(s.IsEmpty(), ShouldBeTrue)
+		So(s.Strings(), ShouldResemble, []string{})
+	})
+}
+
+func TestPortSetUnion(t *testing.T) {
+	Convey("Given two port sets", t, func() {
+		a := mustParsePortSet("1:10", "20:30")
+		b := mustParsePortSet("11:15", "25:40", "100")
+		Convey("The union should merge adjacent and overlapping ranges", func() {
+			So(a.Union(b).Strings(), ShouldResemble, []string{"1:15", "20:40", "100"})
+		})
+		Convey("The operands should not be modified", func() {
+			So(a.Strings(), ShouldResemble, []string{"1:10", "20:30"})
+			So(b.Strings(), ShouldResemble, []string{"11:15", "25:40", "100"})
+		})
+	})
+}
+
+func TestPortSetIntersect(t *testing.T) {
+	Convey("Given two port sets", t, func() {
+		a := mustParsePortSet("1:10", "20:30", "50:60")
+		b := mustParsePortSet("5:25", "30", "55", "70:80")
+		Convey("The intersection should only contain the common ports", func() {
+			So(a.Intersect(b).Strings(), ShouldResemble, []string{"5:10", "20:25", "30", "55"})
+			So(b.Intersect(a).Strings(), ShouldResemble, []string{"5:10", "20:25", "30", "55"})
+		})
+		Convey("The intersection with an empty set should be empty", func() {
+			So(a.Intersect(NewPortSet()).IsEmpty(), ShouldBeTrue)
+		})
+		Convey("The intersection with the full set should be the set", func() {
+			So(a.Intersect(NewFullPortSet()).Equal(a), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPortSetComplement(t *testing.T) {
+	Convey("Given a port set", t, func() {
+		a := mustParsePortSet("1:10", "443", "60000:65535")
+		Convey("The complement should contain all the other ports", func() {
+			So(a.Complement().Strings(), ShouldResemble, []string{"11:442", "444:59999"})
+		})
+		Convey("The complement of the complement should be the set", func() {
+			So(a.Complement().Complement().Equal(a), ShouldBeTrue)
+		})
+		Convey("The complement of the empty set should be the full set", func() {
+			So(NewPortSet().Complement().Equal(NewFullPortSet()), ShouldBeTrue)
+			So(NewFullPortSet().Complement().IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPortSetDifference(t *testing.T) {
+	Convey("Given two port sets", t, func() {
+		a := mustParsePortSet("0:100", "200:300")
+		b := mustParsePortSet("50", "90:250")
+		Convey("The difference should remove the ports of the second set", func() {
+			So(a.Difference(b).Strings(), ShouldResemble, []string{"0:49", "51:89", "251:300"})
+			So(b.Difference(a).Strings(), ShouldResemble, []string{"101:199"})
+		})
+		Convey("The difference with itself should be empty", func() {
+			So(a.Difference(a).IsEmpty(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestPortSetContains(t *testing.T) {
+	Convey("Given a port set", t, func() {
+		a := mustParsePortSet("1:10", "20:30")
+		Convey("It should contain its subsets", func() {
+			So(a.Contains(mustParsePortSet("2:5", "20", "25:30")), ShouldBeTrue)
+			So(a.Contains(NewPortSet()), ShouldBeTrue)
+			So(a.Contains(a), ShouldBeTrue)
+		})
+		Convey("It should not contain ranges spanning a gap or outside of it", func() {
+			So(a.Contains(mustParsePortSet("5:25")), ShouldBeFalse)
+			So(a.Contains(mustParsePortSet("31")), ShouldBeFalse)
+			So(a.Contains(mustParsePortSet("1:10", "40")), ShouldBeFalse)
+		})
+		Convey("It should contain its ports only", func() {
+			So(a.ContainsPort(1), ShouldBeTrue)
+			So(a.ContainsPort(30), ShouldBeTrue)
+			So(a.ContainsPort(15), ShouldBeFalse)
+			So(a.ContainsPort(0), ShouldBeFalse)
+			So(a.ContainsPort(65535), ShouldBeFalse)
+		})
+	})
+}
+
+func TestParseProtocolPortSets(t *testing.T) {
+	Convey("When I parse a list of protocols and ports", t, func() {
+		p, err := ParseProtocolPortSets([]string{"TCP/443", "udp/53", "tcp/80:90", "tcp/85:100", "udp"})
+		So(err, ShouldBeNil)
+		Convey("The ports should be grouped and normalized by protocol", func() {
+			So(p.Protocols(), ShouldResemble, []string{"tcp", "udp"})
+			So(p["tcp"].Strings(), ShouldResemble, []string{"80:100", "443"})
+			So(p["udp"].Equal(NewFullPortSet()), ShouldBeTrue)
+			So(p.Strings(), ShouldResemble, []string{"tcp/80:100", "tcp/443", "udp/1:65535"})
+		})
+	})
+
+	Convey("When I parse an invalid entry it should fail", t, func() {
+		_, err := ParseProtocolPortSets([]string{"tcp/80:70"})
+		So(err, ShouldNotBeNil)
+		_, err = ParseProtocolPortSets([]string{"/80"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,132 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// writeText writes the entries as two columns of text. Highlighted lines are marked with '*'.
+func writeText(w io.Writer, entries []*Entry) error {
+
+	for _, e := range entries {
+
+		width := len("v1 network access policy")
+		for _, l := range e.Left {
+			if len(l.Text) > width {
+				width = len(l.Text)
+			}
+		}
+
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "\nPolicy=%s namespace=%s\n", e.Policy, e.Namespace)
+		fmt.Fprintf(b, "  %-*s | %s\n", width+2, "v1 network access policy", "v2 network rule set policies")
+		fmt.Fprintf(b, "  %s-+-%s\n", strings.Repeat("-", width+2), strings.Repeat("-", width+2))
+		for _, row := range e.Rows() {
+			line := fmt.Sprintf("  %s%-*s | %s%s", mark(row[0]), width, row[0].Text, mark(row[1]), row[1].Text)
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+		for _, n := range e.Notes {
+			fmt.Fprintf(b, "  note: %s\n", n)
+		}
+
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mark(l Line) string {
+	if l.Highlight {
+		return "* "
+	}
+	return "  "
+}
+
+// writeMarkdown writes the entries as markdown tables. Highlighted lines are in bold.
+func writeMarkdown(w io.Writer, entries []*Entry) error {
+
+	b := &strings.Builder{}
+	b.WriteString("# Migration report\n")
+	for _, e := range entries {
+		fmt.Fprintf(b, "\n## %s\n\n", markdownEscape(e.Policy))
+		fmt.Fprintf(b, "Namespace: `%s`\n\n", e.Namespace)
+		b.WriteString("| v1 network access policy | v2 network rule set policies |\n")
+		b.WriteString("| --- | --- |\n")
+		for _, row := range e.Rows() {
+			fmt.Fprintf(b, "| %s | %s |\n", markdownCell(row[0]), markdownCell(row[1]))
+		}
+		if len(e.Notes) > 0 {
+			b.WriteString("\n")
+			for _, n := range e.Notes {
+				fmt.Fprintf(b, "- %s\n", markdownEscape(n))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func markdownCell(l Line) string {
+
+	text := strings.TrimLeft(l.Text, " ")
+	if text == "" {
+		return ""
+	}
+	indent := strings.Repeat("&nbsp;", len(l.Text)-len(text))
+	text = markdownEscape(text)
+	if l.Highlight {
+		text = "**" + text + "**"
+	}
+	return indent + text
+}
+
+var markdownEscaper = strings.NewReplacer(`|`, `\|`, `*`, `\*`, `_`, `\_`, `<`, `&lt;`, `>`, `&gt;`)
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Migration report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; vertical-align: top; }
+td { font-family: monospace; white-space: pre; }
+mark { background-color: #fff3a0; }
+</style>
+</head>
+<body>
+<h1>Migration report</h1>
+{{- range . }}
+<h2>{{ .Policy }}</h2>
+<p>Namespace: <code>{{ .Namespace }}</code></p>
+<table>
+<tr><th>v1 network access policy</th><th>v2 network rule set policies</th></tr>
+{{- range .Rows }}
+<tr>{{ range . }}<td>{{ if .Highlight }}<mark>{{ .Text }}</mark>{{ else }}{{ .Text }}{{ end }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+{{- if .Notes }}
+<ul>
+{{- range .Notes }}
+<li>{{ . }}</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+// writeHTML writes the entries as an html page. Highlighted lines are marked.
+func writeHTML(w io.Writer, entries []*Entry) error {
+	return htmlReport.Execute(w, entries)
+}
@@ -0,0 +1,226 @@
+// Package report renders explanations of network policy transformations side by side:
+// the original network access policy next to the network rule set policies it generates,
+// highlighting the external network rewrites and the port intersections.
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"go.aporeto.io/gaia"
+)
+
+// Formats of a report.
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// Line is a line of one side of an entry. Highlighted lines were rewritten by the migration.
+type Line struct {
+	Text      string
+	Highlight bool
+}
+
+// Entry is the side by side view of a network policy and its network rule set policies.
+type Entry struct {
+	Policy    string
+	Namespace string
+	Left      []Line
+	Right     []Line
+	Notes     []string
+}
+
+// Rows returns the lines of both sides paired by row.
+func (e *Entry) Rows() [][2]Line {
+
+	n := len(e.Left)
+	if len(e.Right) > n {
+		n = len(e.Right)
+	}
+
+	rows := make([][2]Line, n)
+	for i := 0; i < n; i++ {
+		if i < len(e.Left) {
+			rows[i][0] = e.Left[i]
+		}
+		if i < len(e.Right) {
+			rows[i][1] = e.Right[i]
+		}
+	}
+	return rows
+}
+
+// NewEntry returns the side by side view of an explanation.
+func NewEntry(e *networkpolicies.Explanation) *Entry {
+
+	p := e.Policy
+	entry := &Entry{
+		Policy:    p.Name,
+		Namespace: p.Namespace,
+	}
+
+	// Original policy
+	entry.left("name: %s", p.Name)
+	entry.left("mode: %s", p.ApplyPolicyMode)
+	entry.left("action: %s", p.Action)
+	entry.left("ports: %s", list(p.Ports))
+	entry.left("subject:")
+	for i, s := range p.Subject {
+		entry.Left = append(entry.Left, Line{Text: fmt.Sprintf("  - [%d] %s", i, list(s)), Highlight: rewritten(e, networkpolicies.ClauseSubject, i)})
+	}
+	entry.left("object:")
+	for i, o := range p.Object {
+		entry.Left = append(entry.Left, Line{Text: fmt.Sprintf("  - [%d] %s", i, list(o)), Highlight: rewritten(e, networkpolicies.ClauseObject, i)})
+	}
+
+	// Generated rulesets
+	rewrites := map[string]struct{}{}
+	for _, r := range e.Rewrites {
+		rewrites[r.To] = struct{}{}
+	}
+	narrowed := map[string]struct{}{}
+	for _, i := range e.Intersections {
+		if i.Narrowed() {
+			for _, port := range i.Ports {
+				narrowed[port] = struct{}{}
+			}
+		}
+	}
+
+	if len(e.Rulesets) == 0 {
+		entry.right("no network rule set policy generated")
+	}
+	for _, r := range e.Rulesets {
+		entry.right("ruleset: %s", r.Name)
+		entry.Right = append(entry.Right, Line{Text: "  subject: " + clauses(r.Subject), Highlight: hasAny(r.Subject, rewrites)})
+		entry.rules("incoming rules", r.IncomingRules, rewrites, narrowed)
+		entry.rules("outgoing rules", r.OutgoingRules, rewrites, narrowed)
+	}
+
+	// Notes
+	for _, r := range e.Rewrites {
+		entry.Notes = append(entry.Notes, fmt.Sprintf("%s[%d]: %s renamed to %s", r.Clause, r.Index, r.From, r.To))
+	}
+	for _, i := range e.Intersections {
+		if i.Narrowed() {
+			entry.Notes = append(entry.Notes, fmt.Sprintf("%s[%d]: ports %s intersected with %s of external network %s: %s",
+				i.Clause, i.Index, list(i.PolicyPorts), list(i.ServicePorts), i.ExternalNetwork, list(i.Ports)))
+		}
+	}
+	if e.Decision != nil {
+		entry.Notes = append(entry.Notes, fmt.Sprintf("%s: %s", e.Decision.Action, e.Decision.Reason))
+	}
+	if e.Diagnostics != nil {
+		for _, w := range e.Diagnostics.Warnings {
+			if w.Clause == networkpolicies.ClausePolicy {
+				entry.Notes = append(entry.Notes, "warning: "+w.Name)
+			} else {
+				entry.Notes = append(entry.Notes, fmt.Sprintf("warning: %s[%d]: %s", w.Clause, w.Index, w.Name))
+			}
+		}
+		if e.Diagnostics.Exception {
+			entry.Notes = append(entry.Notes, "exception: the generated rulesets must be reviewed")
+		}
+	}
+
+	return entry
+}
+
+// Write writes the report of the explanations in the given format.
+func Write(w io.Writer, format string, explanations []*networkpolicies.Explanation) error {
+
+	entries := make([]*Entry, 0, len(explanations))
+	for _, e := range explanations {
+		entries = append(entries, NewEntry(e))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Policy < entries[j].Policy
+	})
+
+	switch format {
+	case FormatText:
+		return writeText(w, entries)
+	case FormatMarkdown:
+		return writeMarkdown(w, entries)
+	case FormatHTML:
+		return writeHTML(w, entries)
+	default:
+		return fmt.Errorf("unknown report format '%s'", format)
+	}
+}
+
+func (e *Entry) left(format string, a ...interface{}) {
+	e.Left = append(e.Left, Line{Text: fmt.Sprintf(format, a...)})
+}
+
+func (e *Entry) right(format string, a ...interface{}) {
+	e.Right = append(e.Right, Line{Text: fmt.Sprintf(format, a...)})
+}
+
+func (e *Entry) rules(title string, rules []*gaia.NetworkRule, rewrites, narrowed map[string]struct{}) {
+
+	if len(rules) == 0 {
+		return
+	}
+	e.right("  %s:", title)
+	for _, r := range rules {
+		highlight := hasAny(r.Object, rewrites)
+		for _, port := range r.ProtocolPorts {
+			if _, ok := narrowed[port]; ok {
+				highlight = true
+			}
+		}
+		e.Right = append(e.Right, Line{
+			Text:      fmt.Sprintf("    - %s %s ports: %s", r.Action, clauses(r.Object), list(r.ProtocolPorts)),
+			Highlight: highlight,
+		})
+	}
+}
+
+// rewritten returns true if a tag of the clause was rewritten.
+func rewritten(e *networkpolicies.Explanation, clause string, index int) bool {
+
+	for _, r := range e.Rewrites {
+		if r.Clause == clause && r.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAny returns true if any tag of the clauses is in tags.
+func hasAny(ref [][]string, tags map[string]struct{}) bool {
+
+	for _, c := range ref {
+		for _, t := range c {
+			if _, ok := tags[t]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func list(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ", ")
+}
+
+func clauses(ref [][]string) string {
+
+	s := []string{}
+	for _, c := range ref {
+		s = append(s, "["+strings.Join(c, " ")+"]")
+	}
+	return strings.Join(s, " or ")
+}
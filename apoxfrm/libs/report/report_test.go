@@ -0,0 +1,105 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"go.aporeto.io/gaia"
+)
+
+func explanations() []*networkpolicies.Explanation {
+
+	cfg := utils.MustNewConfig([]string{"customer:ext:net="}, nil, "", nil)
+	extnetList := gaia.ExternalNetworksList{
+		&gaia.ExternalNetwork{
+			Name:           "web",
+			Namespace:      "/customer/root",
+			AssociatedTags: []string{"customer:ext:net=web"},
+			ServicePorts:   []string{"tcp/443"},
+		},
+	}
+	netpol := &gaia.NetworkAccessPolicy{
+		Name:            "web",
+		Namespace:       "/customer/root",
+		Action:          gaia.NetworkAccessPolicyActionAllow,
+		ApplyPolicyMode: gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic,
+		Ports:           []string{"tcp/1:1000"},
+		Subject:         [][]string{{"app=x|y"}},
+		Object:          [][]string{{"customer:ext:net=web"}},
+	}
+
+	return []*networkpolicies.Explanation{networkpolicies.Explain(netpol, extnetList, networkpolicies.Options{Config: cfg})}
+}
+
+func TestNewEntry(t *testing.T) {
+
+	e := NewEntry(explanations()[0])
+
+	highlighted := func(lines []Line) []string {
+		r := []string{}
+		for _, l := range lines {
+			if l.Highlight {
+				r = append(r, strings.TrimSpace(l.Text))
+			}
+		}
+		return r
+	}
+
+	if got := highlighted(e.Left); len(got) != 1 || got[0] != "- [0] customer:ext:net=web" {
+		t.Errorf("NewEntry() highlighted left = %v", got)
+	}
+	if got := highlighted(e.Right); len(got) != 1 || !strings.Contains(got[0], "customer:ext:net=web-v2") {
+		t.Errorf("NewEntry() highlighted right = %v", got)
+	}
+	if len(e.Notes) < 2 {
+		t.Errorf("NewEntry() notes = %v, want rewrite and intersection", e.Notes)
+	}
+	for _, row := range e.Rows() {
+		if row[0].Text == "" && row[1].Text == "" {
+			t.Errorf("NewEntry() empty row")
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+
+	tests := []struct {
+		format   string
+		wantErr  bool
+		contains []string
+	}{
+		{
+			format:   FormatText,
+			contains: []string{"Policy=web", "* ", "renamed to customer:ext:net=web-v2"},
+		},
+		{
+			format:   FormatMarkdown,
+			contains: []string{"## web", "| v1 network access policy | v2 network rule set policies |", "**", `app=x\|y`},
+		},
+		{
+			format:   FormatHTML,
+			contains: []string{"<h2>web</h2>", "<mark>", "customer:ext:net=web-v2"},
+		},
+		{
+			format:  "pdf",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := Write(buf, tt.format, explanations()); (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(buf.String(), c) {
+					t.Errorf("Write() missing %q in:\n%s", c, buf.String())
+				}
+			}
+		})
+	}
+}
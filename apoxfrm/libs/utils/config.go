@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Config is the configuration of a migration: how tags reference external networks and
+// host services, and how migrated objects are named.
+type Config struct {
+	extnetPrefixes    []string
+	extnetPatterns    []*regexp.Regexp
+	hostServicePrefix string
+	naming            *Naming
+}
+
+// NewConfig returns a new configuration.
+//   - extnetPrefixes: prefixes of the tags referencing external networks.
+//   - extnetPatterns: regular expressions matching the tags referencing external networks.
+//   - hostServicePrefix: prefix of the tags referencing host services. Empty if host
+//     services are not referenced by tags.
+//   - naming: naming strategy of migrated objects. Defaults to the migration suffix.
+func NewConfig(extnetPrefixes, extnetPatterns []string, hostServicePrefix string, naming *Naming) (*Config, error) {
+
+	c := &Config{
+		hostServicePrefix: hostServicePrefix,
+		naming:            naming,
+	}
+
+	if c.naming == nil {
+		c.naming = NewSuffixNaming(MigrationSuffix)
+	}
+
+	for _, p := range extnetPrefixes {
+		if p == "" {
+			return nil, fmt.Errorf("empty external network prefix")
+		}
+		c.extnetPrefixes = append(c.extnetPrefixes, p)
+	}
+
+	for _, p := range extnetPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external network pattern '%s': %s", p, err)
+		}
+		c.extnetPatterns = append(c.extnetPatterns, re)
+	}
+
+	return c, nil
+}
+
+// MustNewConfig returns a new configuration and panics on errors. Meant for tests and
+// configurations without patterns.
+func MustNewConfig(extnetPrefixes, extnetPatterns []string, hostServicePrefix string, naming *Naming) *Config {
+
+	c, err := NewConfig(extnetPrefixes, extnetPatterns, hostServicePrefix, naming)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// IsExtnetTag returns true if a tag references external networks.
+func (c *Config) IsExtnetTag(tag string) bool {
+
+	for _, p := range c.extnetPrefixes {
+		if strings.HasPrefix(tag, p) {
+			return true
+		}
+	}
+	for _, re := range c.extnetPatterns {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHostServiceTag returns true if a tag references host services.
+func (c *Config) IsHostServiceTag(tag string) bool {
+	return c.hostServicePrefix != "" && strings.HasPrefix(tag, c.hostServicePrefix)
+}
+
+// Naming returns the naming strategy of migrated objects.
+func (c *Config) Naming() *Naming {
+	return c.naming
+}
+
+// String returns a printable configuration.
+func (c *Config) String() string {
+
+	patterns := []string{}
+	for _, re := range c.extnetPatterns {
+		patterns = append(patterns, re.String())
+	}
+	return fmt.Sprintf(
+		"extnet-prefixes=(%s) extnet-patterns=(%s) hostservice-prefix=%s naming=%s",
+		strings.Join(c.extnetPrefixes, ","),
+		strings.Join(patterns, ","),
+		c.hostServicePrefix,
+		c.naming,
+	)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestConfig_IsExtnetTag(t *testing.T) {
+
+	c, err := NewConfig(
+		[]string{"cns-customer:ext:network=", "extnet:name="},
+		[]string{`^legacy:(ext|net)=`},
+		"cns-customer:ext:hostservice=",
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("NewConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{tag: "cns-customer:ext:network=ssh", want: true},
+		{tag: "extnet:name=any-tcp", want: true},
+		{tag: "legacy:ext=dns", want: true},
+		{tag: "legacy:net=dns", want: true},
+		{tag: "legacy:other=dns", want: false},
+		{tag: "hs:name=ssh", want: false},
+		{tag: "cns-customer:ext:hostservice=ssh", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := c.IsExtnetTag(tt.tag); got != tt.want {
+				t.Errorf("IsExtnetTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !c.IsHostServiceTag("cns-customer:ext:hostservice=ssh") {
+		t.Errorf("IsHostServiceTag() = false, want true")
+	}
+	if c.Naming().Name("ssh") != "ssh"+MigrationSuffix {
+		t.Errorf("Naming() default = %v, want suffix %v", c.Naming(), MigrationSuffix)
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		prefixes []string
+		patterns []string
+		wantErr  bool
+	}{
+		{name: "prefixes", prefixes: []string{"a=", "b="}},
+		{name: "patterns", patterns: []string{"^a=", "b$"}},
+		{name: "empty prefix", prefixes: []string{""}, wantErr: true},
+		{name: "bad pattern", patterns: []string{"(a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewConfig(tt.prefixes, tt.patterns, "", nil); (err != nil) != tt.wantErr {
+				t.Errorf("NewConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Naming strategies for migrated objects.
+const (
+	NamingSuffix   = "suffix"
+	NamingPrefix   = "prefix"
+	NamingTemplate = "template"
+)
+
+// namePlaceholder is used to locate the original name in a rendered template.
+const namePlaceholder = "\x00name\x00"
+
+// Naming names migrated objects and the tags referencing them. Naming is idempotent: a
+// name that is already a migrated name is returned unchanged, so that objects that were
+// already migrated are not migrated again.
+type Naming struct {
+	strategy string
+	value    string
+	id       string
+	tmpl     *template.Template
+	re       *regexp.Regexp
+}
+
+// NewSuffixNaming returns a naming strategy that appends suffix to names.
+func NewSuffixNaming(suffix string) *Naming {
+
+	return &Naming{
+		strategy: NamingSuffix,
+		value:    suffix,
+		re:       regexp.MustCompile("^(.+)" + regexp.QuoteMeta(suffix) + "$"),
+	}
+}
+
+// NewPrefixNaming returns a naming strategy that prepends prefix to names.
+func NewPrefixNaming(prefix string) *Naming {
+
+	return &Naming{
+		strategy: NamingPrefix,
+		value:    prefix,
+		re:       regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + "(.+)$"),
+	}
+}
+
+// NewTemplateNaming returns a naming strategy based on a text/template. The template is
+// given the original name as {{.Name}} and the migration ID as {{.ID}}, and must use
+// the name exactly once. For example: {{.Name}}-{{.ID}}.
+func NewTemplateNaming(text, id string) (*Naming, error) {
+
+	tmpl, err := template.New("naming").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming template '%s': %s", text, err)
+	}
+
+	n := &Naming{
+		strategy: NamingTemplate,
+		value:    text,
+		id:       id,
+		tmpl:     tmpl,
+	}
+
+	rendered, err := n.render(namePlaceholder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming template '%s': %s", text, err)
+	}
+	if strings.Count(rendered, namePlaceholder) != 1 {
+		return nil, fmt.Errorf("invalid naming template '%s': {{.Name}} must be used exactly once", text)
+	}
+	if rendered == namePlaceholder {
+		return nil, fmt.Errorf("invalid naming template '%s': migrated names must differ from original names", text)
+	}
+
+	parts := strings.SplitN(rendered, namePlaceholder, 2)
+	n.re = regexp.MustCompile("^" + regexp.QuoteMeta(parts[0]) + "(.+)" + regexp.QuoteMeta(parts[1]) + "$")
+
+	return n, nil
+}
+
+// NewNaming returns the naming strategy for a strategy name and value. The value is the
+// suffix, the prefix or the template.
+func NewNaming(strategy, value, id string) (*Naming, error) {
+
+	switch strategy {
+	case NamingSuffix:
+		if value == "" {
+			return nil, fmt.Errorf("empty naming suffix")
+		}
+		return NewSuffixNaming(value), nil
+	case NamingPrefix:
+		if value == "" {
+			return nil, fmt.Errorf("empty naming prefix")
+		}
+		return NewPrefixNaming(value), nil
+	case NamingTemplate:
+		return NewTemplateNaming(value, id)
+	default:
+		return nil, fmt.Errorf("unknown naming strategy '%s'", strategy)
+	}
+}
+
+func (n *Naming) render(name string) (string, error) {
+
+	buf := &bytes.Buffer{}
+	data := struct {
+		Name string
+		ID   string
+	}{
+		Name: name,
+		ID:   n.id,
+	}
+	if err := n.tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// String returns a printable naming strategy.
+func (n *Naming) String() string {
+
+	if n.id != "" {
+		return fmt.Sprintf("%s '%s' (id: %s)", n.strategy, n.value, n.id)
+	}
+	return fmt.Sprintf("%s '%s'", n.strategy, n.value)
+}
+
+// IsMigrated returns true if name is already a migrated name.
+func (n *Naming) IsMigrated(name string) bool {
+	return n.re.MatchString(name)
+}
+
+// Name returns the migrated name of an object.
+func (n *Naming) Name(name string) string {
+
+	if n.IsMigrated(name) {
+		return name
+	}
+
+	switch n.strategy {
+	case NamingPrefix:
+		return n.value + name
+	case NamingTemplate:
+		// The template is validated on creation.
+		r, _ := n.render(name)
+		return r
+	default:
+		return name + n.value
+	}
+}
+
+// Original returns the original name of a migrated name. Names that are not migrated
+// names are returned unchanged.
+func (n *Naming) Original(name string) string {
+
+	m := n.re.FindStringSubmatch(name)
+	if m == nil {
+		return name
+	}
+	return m[1]
+}
+
+// Tag returns the migrated version of a key=value tag: only the value is migrated.
+func (n *Naming) Tag(tag string) string {
+
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 {
+		return n.Name(tag)
+	}
+	return parts[0] + "=" + n.Name(parts[1])
+}
+
+// OriginalTag returns the original version of a migrated key=value tag.
+func (n *Naming) OriginalTag(tag string) string {
+
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 {
+		return n.Original(tag)
+	}
+	return parts[0] + "=" + n.Original(parts[1])
+}
+
+// IsMigratedTag returns true if the value of a key=value tag is a migrated name.
+func (n *Naming) IsMigratedTag(tag string) bool {
+
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 {
+		return n.IsMigrated(tag)
+	}
+	return n.IsMigrated(parts[1])
+}
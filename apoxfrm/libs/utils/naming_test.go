@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNaming(t *testing.T) {
+
+	template, err := NewTemplateNaming("{{.Name}}.{{.ID}}", "m1")
+	if err != nil {
+		t.Fatalf("NewTemplateNaming() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		naming       *Naming
+		in           string
+		want         string
+		wantMigrated bool
+	}{
+		{
+			name:   "suffix",
+			naming: NewSuffixNaming("-v2"),
+			in:     "ssh",
+			want:   "ssh-v2",
+		},
+		{
+			name:         "suffix already migrated",
+			naming:       NewSuffixNaming("-v2"),
+			in:           "ssh-v2",
+			want:         "ssh-v2",
+			wantMigrated: true,
+		},
+		{
+			name:   "prefix",
+			naming: NewPrefixNaming("v2-"),
+			in:     "ssh",
+			want:   "v2-ssh",
+		},
+		{
+			name:         "prefix already migrated",
+			naming:       NewPrefixNaming("v2-"),
+			in:           "v2-ssh",
+			want:         "v2-ssh",
+			wantMigrated: true,
+		},
+		{
+			name:   "prefix is not a suffix",
+			naming: NewPrefixNaming("v2-"),
+			in:     "ssh-v2-",
+			want:   "v2-ssh-v2-",
+		},
+		{
+			name:   "template",
+			naming: template,
+			in:     "ssh",
+			want:   "ssh.m1",
+		},
+		{
+			name:         "template already migrated",
+			naming:       template,
+			in:           "ssh.m1",
+			want:         "ssh.m1",
+			wantMigrated: true,
+		},
+		{
+			name:   "template other migration",
+			naming: template,
+			in:     "ssh.m0",
+			want:   "ssh.m0.m1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.naming.IsMigrated(tt.in); got != tt.wantMigrated {
+				t.Errorf("IsMigrated() = %v, want %v", got, tt.wantMigrated)
+			}
+			got := tt.naming.Name(tt.in)
+			if got != tt.want {
+				t.Errorf("Name() = %v, want %v", got, tt.want)
+			}
+			if again := tt.naming.Name(got); again != got {
+				t.Errorf("Name() not idempotent = %v, want %v", again, got)
+			}
+			if !tt.wantMigrated {
+				if orig := tt.naming.Original(got); orig != tt.in {
+					t.Errorf("Original() = %v, want %v", orig, tt.in)
+				}
+			}
+			if tag := tt.naming.Tag("customer:ext:net=" + tt.in); tag != "customer:ext:net="+tt.want {
+				t.Errorf("Tag() = %v, want %v", tag, "customer:ext:net="+tt.want)
+			}
+		})
+	}
+}
+
+func TestNewNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		value    string
+		wantErr  bool
+	}{
+		{name: "suffix", strategy: NamingSuffix, value: "-v2"},
+		{name: "empty suffix", strategy: NamingSuffix, value: "", wantErr: true},
+		{name: "prefix", strategy: NamingPrefix, value: "v2-"},
+		{name: "template", strategy: NamingTemplate, value: "{{.ID}}-{{.Name}}"},
+		{name: "template without name", strategy: NamingTemplate, value: "{{.ID}}", wantErr: true},
+		{name: "template with name twice", strategy: NamingTemplate, value: "{{.Name}}-{{.Name}}", wantErr: true},
+		{name: "template with name only", strategy: NamingTemplate, value: "{{.Name}}", wantErr: true},
+		{name: "bad template", strategy: NamingTemplate, value: "{{.Name", wantErr: true},
+		{name: "unknown", strategy: "other", value: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNaming(tt.strategy, tt.value, "m1"); (err != nil) != tt.wantErr {
+				t.Errorf("NewNaming() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
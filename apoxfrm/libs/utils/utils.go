@@ -1,10 +1,7 @@
 package utils
 
-// ExtnetPrefix is the prefix used in tags for external networks.
-var ExtnetPrefix string
-
 // ExtnetNamePrefix is the prefix for name attributed for external networks.
 const ExtnetNamePrefix = "$name="
 
-// MigrationSuffix is the suffix used in external networks and tags that reference external networks.
+// MigrationSuffix is the default suffix used in external networks, host services and tags that reference them.
 const MigrationSuffix = "-v2"
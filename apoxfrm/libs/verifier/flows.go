@@ -0,0 +1,419 @@
+package verifier
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/portspec"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/gaia/protocols"
+)
+
+const (
+	identityExternalNetwork = "$identity=externalnetwork"
+	identityProcessingUnit  = "$identity=processingunit"
+	namespacePrefix         = "$namespace="
+)
+
+// flows returns the representative flows for both policy sets. Flows are derived
+// from every subject/object pair of every policy and every rule, so any flow allowed
+// or rejected by either model is represented.
+func (v *Verifier) flows() []Flow {
+
+	seen := map[string]struct{}{}
+	flows := []Flow{}
+
+	add := func(srcs, dsts []Endpoint, protocolPorts []string) {
+		for _, src := range srcs {
+			for _, dst := range dsts {
+				if src.IsExternal() && dst.IsExternal() {
+					continue
+				}
+				for _, pp := range protocolPorts {
+					f := Flow{Source: src, Destination: dst, ProtocolPort: pp}
+					if _, ok := seen[f.key()]; ok {
+						continue
+					}
+					seen[f.key()] = struct{}{}
+					flows = append(flows, f)
+				}
+			}
+		}
+	}
+
+	for _, p := range v.netpols {
+
+		ports := append([]string{}, p.Ports...)
+		subjects := []Endpoint{}
+		for _, clause := range p.Subject {
+			subjects = append(subjects, v.endpoints(p.Namespace, clause, v.extnets)...)
+			for _, e := range visible(p.Namespace, clause, v.extnets) {
+				ports = append(ports, e.ServicePorts...)
+			}
+		}
+		objects := []Endpoint{}
+		for _, clause := range p.Object {
+			objects = append(objects, v.endpoints(p.Namespace, clause, v.extnets)...)
+			for _, e := range visible(p.Namespace, clause, v.extnets) {
+				ports = append(ports, e.ServicePorts...)
+			}
+		}
+
+		pps := samples(ports)
+		add(subjects, objects, pps)
+		if p.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional {
+			add(objects, subjects, pps)
+		}
+	}
+
+	for _, r := range v.rulesets {
+
+		subjects := []Endpoint{}
+		for _, clause := range r.Subject {
+			subjects = append(subjects, v.endpoints(r.Namespace, clause, v.v2extnets)...)
+		}
+
+		for _, rule := range r.OutgoingRules {
+			objects := []Endpoint{}
+			for _, clause := range rule.Object {
+				objects = append(objects, v.endpoints(r.Namespace, clause, v.v2extnets)...)
+			}
+			add(subjects, objects, samples(rule.ProtocolPorts))
+		}
+
+		for _, rule := range r.IncomingRules {
+			objects := []Endpoint{}
+			for _, clause := range rule.Object {
+				objects = append(objects, v.endpoints(r.Namespace, clause, v.v2extnets)...)
+			}
+			add(objects, subjects, samples(rule.ProtocolPorts))
+		}
+	}
+
+	return flows
+}
+
+// endpoints returns the representative endpoints selected by a clause: one address per
+// entry of every external network the clause selects and, unless the clause only
+// selects external networks, a processing unit carrying the tags of the clause.
+func (v *Verifier) endpoints(namespace string, clause []string, extnets gaia.ExternalNetworksList) []Endpoint {
+
+	eps := []Endpoint{}
+
+	matched := visible(namespace, clause, extnets)
+	for _, e := range matched {
+		for _, entry := range e.Entries {
+			eps = append(eps, Endpoint{Address: entry})
+		}
+	}
+
+	if hasTag(clause, identityExternalNetwork) {
+		return eps
+	}
+
+	if len(matched) == 0 || onlySystemTags(clause) {
+		tags := append([]string{}, clause...)
+		if !hasTagPrefix(tags, "$identity=") {
+			tags = append(tags, identityProcessingUnit)
+		}
+		if namespace != "" && !hasTagPrefix(tags, namespacePrefix) {
+			tags = append(tags, namespacePrefix+namespace)
+		}
+		eps = append(eps, Endpoint{Tags: v.v1Tags(tags)})
+	}
+
+	return eps
+}
+
+// visible returns the external networks visible from namespace that are selected by a
+// clause, honoring the $namespace tags of the clause.
+func visible(namespace string, clause []string, extnets gaia.ExternalNetworksList) gaia.ExternalNetworksList {
+
+	r := gaia.ExternalNetworksList{}
+	for _, e := range networkpolicies.ExternalNetworksFromTags(namespace, clause, extnets) {
+		ok := true
+		for _, t := range clause {
+			if strings.HasPrefix(t, namespacePrefix) && !namespaceMatches(strings.TrimPrefix(t, namespacePrefix), e.Namespace) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+// selectsPU returns true if one of the clauses selects the processing unit.
+func selectsPU(clauses [][]string, ep Endpoint) bool {
+
+	if ep.IsExternal() {
+		return false
+	}
+
+	for _, clause := range clauses {
+		all := true
+		for _, t := range clause {
+			if !puHasTag(ep, t) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+
+	return false
+}
+
+func puHasTag(ep Endpoint, tag string) bool {
+
+	for _, t := range ep.Tags {
+		if t == tag {
+			return true
+		}
+		if strings.HasPrefix(tag, namespacePrefix) && strings.HasPrefix(t, namespacePrefix) {
+			if namespaceMatches(strings.TrimPrefix(tag, namespacePrefix), strings.TrimPrefix(t, namespacePrefix)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// namespaceMatches returns true if namespace ns is selected by pattern. A pattern
+// ending with /* selects all namespaces below it.
+func namespaceMatches(pattern, ns string) bool {
+
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(ns, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == ns
+}
+
+// appliesTo returns true if a policy defined in namespace applies to the endpoint.
+// Policies with no namespace (as read from import files) apply everywhere.
+func appliesTo(namespace string, propagate bool, ep Endpoint) bool {
+
+	if namespace == "" {
+		return true
+	}
+
+	for _, t := range ep.Tags {
+		if !strings.HasPrefix(t, namespacePrefix) {
+			continue
+		}
+		ns := strings.TrimPrefix(t, namespacePrefix)
+		ns = strings.TrimSuffix(ns, "/*")
+		if ns == namespace {
+			return true
+		}
+		return propagate && strings.HasPrefix(ns, namespace+"/")
+	}
+
+	return true
+}
+
+// covers returns true if one of the entries of the external network contains address.
+func covers(e *gaia.ExternalNetwork, address string) bool {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		if a, _, err := net.ParseCIDR(address); err == nil {
+			ip = a
+		}
+	}
+
+	for _, entry := range e.Entries {
+		if entry == address {
+			return true
+		}
+		if ip == nil {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(entry); err == nil && n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// includes returns true if protocolPort is included in the list of protocols/ports.
+// An empty list includes everything.
+func includes(list []string, protocolPort string) bool {
+
+	if len(list) == 0 {
+		return true
+	}
+
+	fparts := strings.SplitN(protocolPort, "/", 2)
+	for _, pp := range list {
+
+		if strings.EqualFold(pp, protocols.ANY) {
+			return true
+		}
+
+		parts := strings.SplitN(pp, "/", 2)
+		if !strings.EqualFold(parts[0], fparts[0]) {
+			continue
+		}
+		if len(parts) == 1 {
+			return true
+		}
+		if len(fparts) == 1 {
+			continue
+		}
+
+		if strings.EqualFold(parts[0], protocols.L4ProtocolTCP) || strings.EqualFold(parts[0], protocols.L4ProtocolUDP) {
+			spec, err := portspec.NewPortSpecFromString(parts[1], nil)
+			if err != nil {
+				continue
+			}
+			port, err := strconv.Atoi(fparts[1])
+			if err != nil {
+				continue
+			}
+			if spec.IsIncluded(port) {
+				return true
+			}
+			continue
+		}
+
+		if fparts[1] == parts[1] || strings.HasPrefix(fparts[1], parts[1]+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// samples returns representative protocol/ports for a list of protocols/ports: the
+// bounds of every range and the ports right outside of them.
+func samples(list []string) []string {
+
+	r := []string{}
+	add := func(s string) {
+		for _, x := range r {
+			if x == s {
+				return
+			}
+		}
+		r = append(r, s)
+	}
+	addPort := func(proto string, port int) {
+		if port >= 1 && port <= 65535 {
+			add(proto + "/" + strconv.Itoa(port))
+		}
+	}
+
+	if len(list) == 0 {
+		list = []string{protocols.ANY}
+	}
+
+	for _, pp := range list {
+
+		if strings.EqualFold(pp, protocols.ANY) {
+			for _, proto := range []string{"tcp", "udp"} {
+				addPort(proto, 1)
+				addPort(proto, 65535)
+			}
+			add("icmp")
+			continue
+		}
+
+		parts := strings.SplitN(pp, "/", 2)
+		proto := strings.ToLower(parts[0])
+		if proto != "tcp" && proto != "udp" {
+			add(strings.ToLower(pp))
+			continue
+		}
+
+		if len(parts) == 1 {
+			addPort(proto, 1)
+			addPort(proto, 65535)
+			continue
+		}
+
+		spec, err := portspec.NewPortSpecFromString(parts[1], nil)
+		if err != nil {
+			continue
+		}
+		min, max := spec.Range()
+		addPort(proto, int(min)-1)
+		addPort(proto, int(min))
+		addPort(proto, int(max))
+		addPort(proto, int(max)+1)
+	}
+
+	return r
+}
+
+// v1Tags returns the tags of a processing unit as seen by the network access policies:
+// the tags referencing a migrated host service are mapped back to the v1 host service.
+func (v *Verifier) v1Tags(tags []string) []string {
+
+	r := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if v.cfg.IsHostServiceTag(t) && v.cfg.Naming().IsMigratedTag(t) {
+			t = v.cfg.Naming().OriginalTag(t)
+		}
+		r = append(r, t)
+	}
+	return r
+}
+
+// v2Endpoint returns the endpoint as seen by the network rule set policies: the tags
+// referencing a v1 host service are mapped to the migrated host service, so that a
+// ruleset still referencing the v1 host service no longer selects the endpoint.
+func (v *Verifier) v2Endpoint(ep Endpoint) Endpoint {
+
+	if ep.IsExternal() {
+		return ep
+	}
+
+	r := make([]string, 0, len(ep.Tags))
+	for _, t := range ep.Tags {
+		if v.cfg.IsHostServiceTag(t) && !v.cfg.Naming().IsMigratedTag(t) {
+			t = v.cfg.Naming().Tag(t)
+		}
+		r = append(r, t)
+	}
+	return Endpoint{Tags: r, Address: ep.Address}
+}
+
+func hasTag(tags []string, tag string) bool {
+
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTagPrefix(tags []string, prefix string) bool {
+
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlySystemTags returns true if the clause only has system ($) tags.
+func onlySystemTags(tags []string) bool {
+
+	for _, t := range tags {
+		if !strings.HasPrefix(t, "$") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,355 @@
+package verifier
+
+// This package checks that a set of network rule set policies (v2) is semantically
+// equivalent to the network access policies (v1) it was generated from. It enumerates
+// representative flows derived from both policy sets and evaluates each flow against
+// both models.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"go.aporeto.io/gaia"
+)
+
+// Side identifies the processing unit where a verdict is enforced.
+type Side string
+
+// Sides of a flow.
+const (
+	// SideOutgoing is enforced by the source processing unit.
+	SideOutgoing Side = "outgoing"
+	// SideIncoming is enforced by the destination processing unit.
+	SideIncoming Side = "incoming"
+)
+
+// Verdict is the outcome of evaluating a flow.
+type Verdict string
+
+// Verdicts of a flow.
+const (
+	VerdictAllow   Verdict = "allow"
+	VerdictReject  Verdict = "reject"
+	VerdictNoMatch Verdict = "no-match"
+)
+
+// Endpoint is one end of a flow. It is either a processing unit identified by its
+// tags or an address taken from the entries of an external network.
+type Endpoint struct {
+	Tags    []string
+	Address string
+}
+
+// IsExternal returns true if the endpoint is an external network address.
+func (e Endpoint) IsExternal() bool {
+	return e.Address != ""
+}
+
+// String returns a printable version of the endpoint.
+func (e Endpoint) String() string {
+	if e.IsExternal() {
+		return e.Address
+	}
+	return "{" + strings.Join(e.Tags, " ") + "}"
+}
+
+func (e Endpoint) key() string {
+	if e.IsExternal() {
+		return "@" + e.Address
+	}
+	tags := append([]string{}, e.Tags...)
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// Flow is a connection from a source to a destination on a protocol/port.
+type Flow struct {
+	Source       Endpoint
+	Destination  Endpoint
+	ProtocolPort string
+}
+
+// String returns a printable version of the flow.
+func (f Flow) String() string {
+	return fmt.Sprintf("%s -> %s %s", f.Source, f.Destination, f.ProtocolPort)
+}
+
+func (f Flow) key() string {
+	return f.Source.key() + ">" + f.Destination.key() + "|" + f.ProtocolPort
+}
+
+// Discrepancy is a flow for which the v1 and v2 policies disagree.
+type Discrepancy struct {
+	Flow       Flow
+	Side       Side
+	V1         Verdict
+	V1Policies []string
+	V2         Verdict
+	V2Policies []string
+}
+
+// String returns a printable version of the discrepancy.
+func (d *Discrepancy) String() string {
+	return fmt.Sprintf(
+		"%s [%s] v1=%s (%s) v2=%s (%s)",
+		d.Flow, d.Side,
+		d.V1, strings.Join(d.V1Policies, ", "),
+		d.V2, strings.Join(d.V2Policies, ", "),
+	)
+}
+
+// Report is the result of a verification.
+type Report struct {
+	// Flows is the number of flows evaluated.
+	Flows int
+	// Discrepancies are the flows whose verdict differs.
+	Discrepancies []*Discrepancy
+}
+
+// Equivalent returns true if no discrepancies were found.
+func (r *Report) Equivalent() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// Verifier evaluates flows against both policy models.
+type Verifier struct {
+	cfg       *utils.Config
+	netpols   gaia.NetworkAccessPoliciesList
+	extnets   gaia.ExternalNetworksList
+	rulesets  gaia.NetworkRuleSetPoliciesList
+	v2extnets gaia.ExternalNetworksList
+}
+
+// New returns a verifier.
+//
+// Arguments:
+// - netpols: v1 network access policies.
+// - extnets: v1 external networks (from complete ns hierarchy that may be needed to resolve these policies).
+// - rulesets: v2 network rule set policies.
+// - v2extnets: v2 external networks (from complete ns hierarchy that may be needed to resolve these policies).
+func New(
+	cfg *utils.Config,
+	netpols gaia.NetworkAccessPoliciesList,
+	extnets gaia.ExternalNetworksList,
+	rulesets gaia.NetworkRuleSetPoliciesList,
+	v2extnets gaia.ExternalNetworksList,
+) *Verifier {
+
+	return &Verifier{
+		cfg:       cfg,
+		netpols:   netpols,
+		extnets:   extnets,
+		rulesets:  rulesets,
+		v2extnets: v2extnets,
+	}
+}
+
+// Verify enumerates representative flows and reports every flow for which the verdict
+// of the v1 policies differs from the verdict of the v2 policies.
+func (v *Verifier) Verify() *Report {
+
+	flows := v.flows()
+	r := &Report{
+		Flows:         len(flows),
+		Discrepancies: []*Discrepancy{},
+	}
+
+	for _, f := range flows {
+		for _, side := range []Side{SideOutgoing, SideIncoming} {
+
+			// External networks do not enforce anything.
+			if side == SideOutgoing && f.Source.IsExternal() {
+				continue
+			}
+			if side == SideIncoming && f.Destination.IsExternal() {
+				continue
+			}
+
+			v1, v1Policies := v.evaluateV1(f, side)
+			v2, v2Policies := v.evaluateV2(f, side)
+			if v1 == v2 {
+				continue
+			}
+
+			r.Discrepancies = append(r.Discrepancies, &Discrepancy{
+				Flow:       f,
+				Side:       side,
+				V1:         v1,
+				V1Policies: v1Policies,
+				V2:         v2,
+				V2Policies: v2Policies,
+			})
+		}
+	}
+
+	return r
+}
+
+// evaluateV1 returns the verdict of the network access policies for a flow.
+//
+// The semantics follow the transformation: a bidirectional policy is enforced on both
+// ends and is reflexive, incoming and outgoing policies are enforced on the object and
+// subject respectively. External networks referenced by a policy restrict the ports
+// to their service ports.
+func (v *Verifier) evaluateV1(f Flow, side Side) (Verdict, []string) {
+
+	allow := []string{}
+	reject := []string{}
+
+	for _, p := range v.netpols {
+
+		if p.Disabled {
+			continue
+		}
+
+		if len(p.Ports) > 0 && !includes(p.Ports, f.ProtocolPort) {
+			continue
+		}
+
+		switch p.ApplyPolicyMode {
+		case gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic:
+			if side != SideIncoming {
+				continue
+			}
+		case gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic:
+			if side != SideOutgoing {
+				continue
+			}
+		}
+
+		enforcer := f.Source
+		if side == SideIncoming {
+			enforcer = f.Destination
+		}
+		if !appliesTo(p.Namespace, p.Propagate, enforcer) {
+			continue
+		}
+
+		selected := v.v1Selects(p, p.Subject, f.Source, f.ProtocolPort) && v.v1Selects(p, p.Object, f.Destination, f.ProtocolPort)
+		if !selected && p.ApplyPolicyMode == gaia.NetworkAccessPolicyApplyPolicyModeBidirectional {
+			selected = v.v1Selects(p, p.Object, f.Source, f.ProtocolPort) && v.v1Selects(p, p.Subject, f.Destination, f.ProtocolPort)
+		}
+		if !selected {
+			continue
+		}
+
+		switch p.Action {
+		case gaia.NetworkAccessPolicyActionAllow:
+			allow = append(allow, p.Name)
+		case gaia.NetworkAccessPolicyActionReject:
+			reject = append(reject, p.Name)
+		}
+	}
+
+	return verdict(allow, reject)
+}
+
+// evaluateV2 returns the verdict of the network rule set policies for a flow.
+func (v *Verifier) evaluateV2(f Flow, side Side) (Verdict, []string) {
+
+	allow := []string{}
+	reject := []string{}
+
+	for _, r := range v.rulesets {
+
+		if r.Disabled {
+			continue
+		}
+
+		enforcer, peer, rules := f.Source, f.Destination, r.OutgoingRules
+		if side == SideIncoming {
+			enforcer, peer, rules = f.Destination, f.Source, r.IncomingRules
+		}
+
+		if !appliesTo(r.Namespace, r.Propagate, enforcer) || !selectsPU(r.Subject, v.v2Endpoint(enforcer)) {
+			continue
+		}
+
+		for _, rule := range rules {
+
+			if !includes(rule.ProtocolPorts, f.ProtocolPort) {
+				continue
+			}
+			if !v.v2Selects(r.Namespace, rule.Object, peer) {
+				continue
+			}
+
+			switch rule.Action {
+			case gaia.NetworkRuleActionAllow:
+				allow = append(allow, r.Name)
+			case gaia.NetworkRuleActionReject:
+				reject = append(reject, r.Name)
+			}
+		}
+	}
+
+	return verdict(allow, reject)
+}
+
+// v1Selects returns true if one of the clauses of policy p selects the endpoint.
+func (v *Verifier) v1Selects(p *gaia.NetworkAccessPolicy, clauses [][]string, ep Endpoint, protocolPort string) bool {
+
+	if !ep.IsExternal() {
+		return selectsPU(clauses, ep)
+	}
+
+	for _, clause := range clauses {
+		for _, e := range visible(p.Namespace, clause, v.extnets) {
+			if !covers(e, ep.Address) {
+				continue
+			}
+			if len(e.ServicePorts) == 0 || includes(e.ServicePorts, protocolPort) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// v2Selects returns true if one of the clauses of a rule selects the endpoint.
+func (v *Verifier) v2Selects(namespace string, clauses [][]string, ep Endpoint) bool {
+
+	if !ep.IsExternal() {
+		return selectsPU(clauses, v.v2Endpoint(ep))
+	}
+
+	for _, clause := range clauses {
+		for _, e := range visible(namespace, clause, v.v2extnets) {
+			if covers(e, ep.Address) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// verdict applies reject precedence to the matching policies.
+func verdict(allow, reject []string) (Verdict, []string) {
+
+	if len(reject) > 0 {
+		return VerdictReject, uniqueSorted(reject)
+	}
+	if len(allow) > 0 {
+		return VerdictAllow, uniqueSorted(allow)
+	}
+	return VerdictNoMatch, []string{}
+}
+
+func uniqueSorted(a []string) []string {
+
+	m := map[string]struct{}{}
+	r := []string{}
+	for _, s := range a {
+		if _, ok := m[s]; ok {
+			continue
+		}
+		m[s] = struct{}{}
+		r = append(r, s)
+	}
+	sort.Strings(r)
+	return r
+}
@@ -0,0 +1,252 @@
+package verifier
+
+import (
+	"testing"
+
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
+	"github.com/mitchellh/mapstructure"
+	"go.aporeto.io/gaia"
+)
+
+func collapse(t *testing.T, cfg *utils.Config, netpols gaia.NetworkAccessPoliciesList, extnets gaia.ExternalNetworksList) gaia.NetworkRuleSetPoliciesList {
+
+	rulesets := gaia.NetworkRuleSetPoliciesList{}
+	for _, netpol := range netpols {
+		r, _, _ := networkpolicies.Transform(netpol, extnets, networkpolicies.Options{Config: cfg, Collapse: true})
+		rulesets = append(rulesets, r...)
+	}
+	return rulesets
+}
+
+func xfrm(t *testing.T, cfg *utils.Config, netpols gaia.NetworkAccessPoliciesList, extnets gaia.ExternalNetworksList) (gaia.NetworkRuleSetPoliciesList, gaia.ExternalNetworksList) {
+
+	rulesets := gaia.NetworkRuleSetPoliciesList{}
+	for _, netpol := range netpols {
+		transformations, _ := networkpolicies.Get(netpol, extnets, networkpolicies.Options{Config: cfg})
+		for _, x := range transformations {
+			ruleset := gaia.NewNetworkRuleSetPolicy()
+			if err := mapstructure.Decode(x, ruleset); err != nil {
+				t.Fatalf("unable to decode ruleset: %s", err)
+			}
+			ruleset.Namespace = netpol.Namespace
+			rulesets = append(rulesets, ruleset)
+		}
+	}
+
+	v2extnets := gaia.ExternalNetworksList{}
+	for _, e := range extnets {
+		v2extnets = append(v2extnets, externalnetwork.Transform(cfg, e))
+	}
+
+	return rulesets, v2extnets
+}
+
+func TestVerify(t *testing.T) {
+
+	cfg := utils.MustNewConfig([]string{"customer:ext:net="}, nil, "customer:ext:hostservice=", nil)
+
+	extnetList := gaia.ExternalNetworksList{
+		&gaia.ExternalNetwork{
+			Name:           "ssh",
+			Namespace:      "/customer/root",
+			AssociatedTags: []string{"customer:ext:net=ssh"},
+			Entries:        []string{"10.0.0.0/8"},
+			ServicePorts:   []string{"tcp/22"},
+			Propagate:      true,
+		},
+		&gaia.ExternalNetwork{
+			Name:           "web",
+			Namespace:      "/customer/root/zone/tenant",
+			AssociatedTags: []string{"customer:ext:net=web"},
+			Entries:        []string{"192.168.1.0/24"},
+			ServicePorts:   []string{"tcp/80:90"},
+		},
+	}
+
+	outgoing := gaia.NewNetworkAccessPolicy()
+	outgoing.Name = "outgoing"
+	outgoing.Namespace = "/customer/root/zone/tenant"
+	outgoing.Action = gaia.NetworkAccessPolicyActionAllow
+	outgoing.ApplyPolicyMode = gaia.NetworkAccessPolicyApplyPolicyModeOutgoingTraffic
+	outgoing.Subject = [][]string{{"$identity=processingunit", "app=web"}}
+	outgoing.Object = [][]string{{"customer:ext:net=ssh"}, {"customer:ext:net=web"}}
+	outgoing.Ports = []string{"tcp/1:100"}
+
+	pu2pu := gaia.NewNetworkAccessPolicy()
+	pu2pu.Name = "pu2pu"
+	pu2pu.Namespace = "/customer/root/zone/tenant"
+	pu2pu.Action = gaia.NetworkAccessPolicyActionAllow
+	pu2pu.ApplyPolicyMode = gaia.NetworkAccessPolicyApplyPolicyModeBidirectional
+	pu2pu.Subject = [][]string{{"app=web"}}
+	pu2pu.Object = [][]string{{"app=db"}}
+	pu2pu.Ports = []string{"tcp/5432"}
+
+	ssh := gaia.NewNetworkAccessPolicy()
+	ssh.Name = "ssh"
+	ssh.Namespace = "/customer/root/zone/tenant"
+	ssh.Action = gaia.NetworkAccessPolicyActionAllow
+	ssh.ApplyPolicyMode = gaia.NetworkAccessPolicyApplyPolicyModeIncomingTraffic
+	ssh.Subject = [][]string{{"customer:ext:net=ssh"}}
+	ssh.Object = [][]string{{"customer:ext:hostservice=ssh"}}
+	ssh.Ports = []string{"tcp/22"}
+
+	web := gaia.NewNetworkAccessPolicy()
+	web.Name = "web"
+	web.Namespace = "/customer/root/zone/tenant"
+	web.Action = gaia.NetworkAccessPolicyActionAllow
+	web.ApplyPolicyMode = gaia.NetworkAccessPolicyApplyPolicyModeBidirectional
+	web.Subject = [][]string{{"app=web"}}
+	web.Object = [][]string{{"customer:ext:net=web"}}
+	web.Ports = []string{"tcp/80"}
+
+	netpols := gaia.NetworkAccessPoliciesList{outgoing, pu2pu, ssh, web}
+	rulesets, v2extnets := xfrm(t, cfg, netpols, extnetList)
+
+	// Widen ports on one of the rules
+	widened, _ := xfrm(t, cfg, netpols, extnetList)
+	widened[0].OutgoingRules[0].ProtocolPorts = []string{"tcp/1:100"}
+
+	// Drop the rulesets of the bidirectional policy
+	dropped, _ := xfrm(t, cfg, gaia.NetworkAccessPoliciesList{outgoing, ssh, web}, extnetList)
+
+	// Keep the v1 host service in the rulesets, as a missing tag rewrite would
+	unrenamed, _ := xfrm(t, cfg, netpols, extnetList)
+	for _, r := range unrenamed {
+		for i, clause := range r.Subject {
+			for j, tag := range clause {
+				if cfg.IsHostServiceTag(tag) {
+					r.Subject[i][j] = cfg.Naming().OriginalTag(tag)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name                 string
+		rulesets             gaia.NetworkRuleSetPoliciesList
+		wantEquivalent       bool
+		wantDiscrepancyCheck func(*Discrepancy) bool
+	}{
+		{
+			name:           "generated rulesets",
+			rulesets:       rulesets,
+			wantEquivalent: true,
+		},
+		{
+			name:           "merged rulesets",
+			rulesets:       networkpolicies.Merge(rulesets),
+			wantEquivalent: true,
+		},
+		{
+			name:           "collapsed rulesets",
+			rulesets:       collapse(t, cfg, netpols, extnetList),
+			wantEquivalent: true,
+		},
+		{
+			name:           "widened ports",
+			rulesets:       widened,
+			wantEquivalent: false,
+			wantDiscrepancyCheck: func(d *Discrepancy) bool {
+				return d.Side == SideOutgoing && d.V1 == VerdictNoMatch && d.V2 == VerdictAllow && d.Flow.Destination.Address == "10.0.0.0/8"
+			},
+		},
+		{
+			name:           "missing rulesets",
+			rulesets:       dropped,
+			wantEquivalent: false,
+			wantDiscrepancyCheck: func(d *Discrepancy) bool {
+				return d.V1 == VerdictAllow && d.V2 == VerdictNoMatch && d.Flow.ProtocolPort == "tcp/5432"
+			},
+		},
+		{
+			name:           "host service tag not rewritten",
+			rulesets:       unrenamed,
+			wantEquivalent: false,
+			wantDiscrepancyCheck: func(d *Discrepancy) bool {
+				return d.V1 == VerdictAllow && d.V2 == VerdictNoMatch && d.Flow.ProtocolPort == "tcp/22"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(cfg, netpols, extnetList, tt.rulesets, v2extnets).Verify()
+			if r.Flows == 0 {
+				t.Errorf("Verify() no flows enumerated")
+			}
+			if r.Equivalent() != tt.wantEquivalent {
+				t.Errorf("Verify() equivalent = %v, want %v: %v", r.Equivalent(), tt.wantEquivalent, r.Discrepancies)
+			}
+			if tt.wantDiscrepancyCheck == nil {
+				return
+			}
+			for _, d := range r.Discrepancies {
+				if !tt.wantDiscrepancyCheck(d) {
+					t.Errorf("Verify() unexpected discrepancy %s", d)
+				}
+			}
+		})
+	}
+}
+
+func Test_includes(t *testing.T) {
+	type args struct {
+		list         []string
+		protocolPort string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "empty list",
+			args: args{list: []string{}, protocolPort: "tcp/22"},
+			want: true,
+		},
+		{
+			name: "any",
+			args: args{list: []string{"any"}, protocolPort: "udp/53"},
+			want: true,
+		},
+		{
+			name: "protocol only",
+			args: args{list: []string{"TCP"}, protocolPort: "tcp/22"},
+			want: true,
+		},
+		{
+			name: "in range",
+			args: args{list: []string{"tcp/20:30"}, protocolPort: "tcp/22"},
+			want: true,
+		},
+		{
+			name: "out of range",
+			args: args{list: []string{"tcp/20:30"}, protocolPort: "tcp/31"},
+			want: false,
+		},
+		{
+			name: "other protocol",
+			args: args{list: []string{"udp/22"}, protocolPort: "tcp/22"},
+			want: false,
+		},
+		{
+			name: "icmp type",
+			args: args{list: []string{"icmp/8"}, protocolPort: "icmp/8/0"},
+			want: true,
+		},
+		{
+			name: "icmp other type",
+			args: args{list: []string{"icmp/8"}, protocolPort: "icmp/11"},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := includes(tt.args.list, tt.args.protocolPort); got != tt.want {
+				t.Errorf("includes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
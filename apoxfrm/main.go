@@ -5,193 +5,311 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
-	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/externalnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/apoxfrm"
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/networkpolicies"
+	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/report"
 	"github.com/PaloAltoNetworks/cns-customer/apoxfrm/libs/utils"
 	"github.com/ghodss/yaml"
-	"github.com/mitchellh/mapstructure"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"go.aporeto.io/gaia"
 )
 
-// xfrmNetPols transforms the network access policies into new policies called network ruleset policies.
-//
-// Arguments:
-// - netpols: network policies
-// - extnetList: external networks (from complete ns hierarcy that may be needed to resolve these policies)
-//
-// Returns:
-// - netrulesetpolicies: network rule set policies.
-//
-// The key things to observe w.r.t. new policies are:
-// - Bidirectional policy mode not supported.
-// - One network rule set policy is applied to a set of processing units and contains both ingress and egress rules for this set of processing units.
-// - Multiple network rule set policies can still be applied to the same set of processing units.
-// - Port matching is a part of incoming and outgoing rules.
-//
-func xfrmNetPols(netpols []map[string]interface{}, extnetList gaia.ExternalNetworksList) (netrulesetpolicies []map[string]interface{}) {
-
-	netrulesetpolicies = make([]map[string]interface{}, 0)
-
-	for _, n := range netpols {
-
-		netpol := gaia.NewNetworkAccessPolicy()
-		if err := mapstructure.Decode(n, netpol); err != nil {
-			panic(err)
-		}
+// readExport reads an exported yaml file.
+func readExport(dir, file string) *gaia.Export {
 
-		transformations, err := networkpolicies.Get(netpol, extnetList)
-		if err != nil {
-			fmt.Println("    Error: " + err.Error())
-		}
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close() // nolint
+
+	exportedData, err := apoxfrm.ReadExport(f)
+	if err != nil {
+		panic(err)
+	}
+
+	return exportedData
+}
 
-		zap.L().Info(
-			"Network Policy",
-			zap.String("ns", netpol.Namespace),
-			zap.String("name", netpol.Name),
-			zap.Reflect("mode", netpol.ApplyPolicyMode),
-			zap.Strings("ports", netpol.Ports),
-			zap.Bool("propagate", netpol.Propagate),
-			zap.Int("num-subjects", len(netpol.Subject)),
-			zap.Int("num-objects", len(netpol.Object)),
-		)
+// writeYAML writes v as a yaml file.
+func writeYAML(dir, file string, v interface{}) {
 
-		netrulesetpolicies = append(netrulesetpolicies, transformations...)
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		panic(err)
 	}
 
-	return
+	location := filepath.Join(dir, file)
+	if err := os.WriteFile(location, data, 0644); err != nil {
+		panic(err)
+	}
 }
 
-// xfrmExtNets transforms the external networks to a v2 model.
-// The key thing here is an external network can not define protocols and ports in the external network definition.
-//
-// Arguments:
-// - extnets: external networks that are in this ns level and will need to be reimported.
-// - extraextnets: external networks that are in the higher ns level if any. these will not be generated in file to import.
-//
-// Returns:
-// - extnetList: list of external networks which will be used to resolve policies.
-// - xextnets: transformed external networks that will need to be added to import files.
-//
-func xfrmExtNets(extnets, extraextnets []map[string]interface{}) (extnetList gaia.ExternalNetworksList, xextnets []map[string]interface{}) {
-
-	for i, e := range append(extraextnets, extnets...) {
-
-		extnet, err := externalnetwork.Decode(e)
-		if err != nil {
-			panic("error in external network: " + err.Error())
-		}
+// newLogger returns a logger writing to stderr, as text or json, the messages of at least
+// the level.
+func newLogger(format, level string) (*zap.Logger, error) {
 
-		// create a global list that can be used in network policies
-		extnetList = append(extnetList, extnet)
-
-		// Process the external network - Create a v2 copy, add suffix to name, remove protocol and ports
-		v2extnet := externalnetwork.Transform(extnet)
-
-		zap.L().Info(
-			"External Network",
-			zap.String("ns", extnet.Namespace),
-			zap.String("name", extnet.Name),
-			zap.Strings("ports", extnet.ServicePorts),
-		)
-
-		// Dont export extra external networks
-		if i >= len(extraextnets) {
-			xe, err := externalnetwork.Encode(v2extnet)
-			if err != nil {
-				panic("error in external network: " + err.Error())
-			}
-			xextnets = append(xextnets, xe)
-		}
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level '%s': %s", level, err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case "text":
+		cfg = zap.NewDevelopmentConfig()
+		cfg.DisableStacktrace = true
+	case "json":
+		cfg = zap.NewProductionConfig()
+	default:
+		return nil, fmt.Errorf("invalid log format '%s': must be text or json", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.OutputPaths = []string{"stderr"}
+	cfg.ErrorOutputPaths = []string{"stderr"}
+
+	return cfg.Build()
+}
+
+// readParents reads the exports of the parent hierarchy.
+func readParents(dir string, extraFiles []string) (parents []*gaia.Export) {
+
+	for _, file := range extraFiles {
+		parents = append(parents, readExport(dir, file))
 	}
 	return
 }
 
-func process(dir, file string, extraFiles []string) {
+// process migrates <file> and writes the objects to import in out-<file>. In strict mode,
+// nothing is written if errors are found and it returns false.
+func process(m *apoxfrm.Migrator, dir, file string, extraFiles []string, collapse bool) bool {
 
-	location := filepath.Join(dir, file)
+	res, err := m.Migrate(readExport(dir, file), readParents(dir, extraFiles)...)
+	if res != nil {
+		for _, s := range res.Skipped {
+			fmt.Println("    Skipped: " + s)
+		}
+		for _, d := range res.Diagnostics {
+			fmt.Println(d.String())
+		}
+		for _, e := range res.Errors {
+			fmt.Println("    Error: " + e.Error())
+		}
+		for _, d := range res.Decisions {
+			fmt.Println("    Decision: " + d.String())
+		}
+		if collapse {
+			fmt.Printf("Collapsed policies:      %d\n", len(res.Decisions))
+		}
+	}
+	if err != nil {
+		if res == nil {
+			panic(err)
+		}
+		fmt.Println("Error: " + err.Error())
+		return false
+	}
+
+	writeYAML(dir, "out-"+file, res.Import.Data)
+	return true
+}
 
-	inputData, err := os.ReadFile(location)
+// verify checks that the network rule set policies and external networks generated in
+// out-<file> allow and reject exactly the same flows as the network access policies and
+// external networks in <file>. It returns false if any flow has a different verdict.
+func verify(m *apoxfrm.Migrator, dir, file string, extraFiles []string) bool {
+
+	report, err := m.Verify(readExport(dir, file), readExport(dir, "out-"+file), readParents(dir, extraFiles)...)
 	if err != nil {
 		panic(err)
 	}
 
-	exportedData := gaia.NewExport()
-	if err := yaml.Unmarshal([]byte(inputData), exportedData); err != nil {
-		panic(err)
+	for _, d := range report.Discrepancies {
+		fmt.Println("    Discrepancy: " + d.String())
 	}
+	fmt.Printf("Verified %d flows: %d discrepancies\n", report.Flows, len(report.Discrepancies))
 
-	extnets := exportedData.Data["externalnetworks"]
-	netpols := exportedData.Data["networkaccesspolicies"]
+	return report.Equivalent()
+}
 
-	// Process extra external networks from the parent hierarchy
-	var extraextnets []map[string]interface{}
-	for _, file := range extraFiles {
-		location := filepath.Join(dir, file)
-		inputData, err := os.ReadFile(location)
-		if err != nil {
-			panic(err)
-		}
+// explain prints the network policies of <file> named name next to the network rule set
+// policies they generate. It returns false if no network policy is named name.
+func explain(m *apoxfrm.Migrator, dir, file string, extraFiles []string, name string) bool {
 
-		exportedData := gaia.NewExport()
-		if err := yaml.Unmarshal([]byte(inputData), exportedData); err != nil {
-			panic(err)
-		}
+	explanations, err := m.Explain(readExport(dir, file), readParents(dir, extraFiles)...)
+	if err != nil {
+		panic(err)
+	}
 
-		extraextnets = append(extraextnets, exportedData.Data["externalnetworks"]...)
+	selected := []*networkpolicies.Explanation{}
+	for _, e := range explanations {
+		if e.Policy.Name == name {
+			selected = append(selected, e)
+		}
+	}
+	if len(selected) == 0 {
+		fmt.Println("Error: no network policy named " + name)
+		return false
 	}
 
-	gextnets, xextnets := xfrmExtNets(extnets, extraextnets)
-	netrulesetpolicies := xfrmNetPols(netpols, gextnets)
+	if err := report.Write(os.Stdout, report.FormatText, selected); err != nil {
+		panic(err)
+	}
+	return true
+}
 
-	importData := gaia.NewImport()
-	importData.Data.Label = exportedData.Label + utils.MigrationSuffix
-	importData.Data.Data["externalnetworks"] = xextnets
-	importData.Data.Identities = append(importData.Data.Identities, "externalnetworks")
-	importData.Data.Data["networkrulesetpolicies"] = netrulesetpolicies
-	importData.Data.Identities = append(importData.Data.Identities, "networkrulesetpolicies")
+// writeReport generates report-<file>.md or report-<file>.html with every network policy of
+// <file> next to the network rule set policies it generates.
+func writeReport(m *apoxfrm.Migrator, dir, file string, extraFiles []string, format string) {
 
-	data, err := yaml.Marshal(importData.Data)
+	explanations, err := m.Explain(readExport(dir, file), readParents(dir, extraFiles)...)
 	if err != nil {
 		panic(err)
 	}
 
-	location = filepath.Join(dir, "out-"+file)
-	err = os.WriteFile(location, data, 0644)
+	ext := ".md"
+	if format == report.FormatHTML {
+		ext = ".html"
+	}
+	location := filepath.Join(dir, "report-"+strings.TrimSuffix(file, filepath.Ext(file))+ext)
+
+	f, err := os.Create(location)
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close() // nolint
+
+	if err := report.Write(f, format, explanations); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Report:                  " + location)
 }
 
 func usage() {
-	fmt.Println("apoxfrm -extnet-prefix comcast:ext:network= -config-dir <directory> -config-file <yaml-file> [-extra-files <yaml-file1> <yaml-file2> ...]")
+	fmt.Println("apoxfrm -extnet-prefix comcast:ext:network= [-extnet-prefix <prefix> ...] [-extnet-pattern <regexp> ...] -config-dir <directory> -config-file <yaml-file> [-hostservice-prefix cns-customer:ext:hostservice=] [-extra-files <yaml-file1> <yaml-file2> ...] [-naming suffix|prefix|template] [-naming-value <value>] [-migration-id <id>] [-no-merge] [-collapse] [-strict] [-log-format text|json] [-log-level <level>] [-explain <policy> | -report markdown|html | -verify | -rollback [-label <label>] | -finalize]")
 	fmt.Println("examples:")
 	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file zone.yaml -extra-files root.yaml")
 	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -extra-files root.yaml zone.yaml")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -extra-files root.yaml zone.yaml -verify")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -extnet-prefix legacy:ext:name= -extnet-pattern '^extnet:[a-z]+=' -config-dir configs -config-file tenant.yaml")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -naming template -naming-value '{{.Name}}-{{.ID}}' -migration-id 2021q3")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -extra-files root.yaml zone.yaml -explain ssh")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -extra-files root.yaml zone.yaml -report html")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -rollback")
+	fmt.Println("  apoxfrm -extnet-prefix customer:ext:name= -config-dir configs -config-file tenant.yaml -extra-files root.yaml zone.yaml -finalize")
 }
 
 func main() {
 
-	var extraFiles arrayFlags
+	var extraFiles, prefixes, patterns arrayFlags
 	directory := flag.String("config-dir", "tests/reexternalrefwv1v2policyconversiondetails/", "configuation directory for yaml files")
 	file := flag.String("config-file", "Tenant_A_policies.yaml", "yaml configuation file")
 	flag.Var(&extraFiles, "extra-files", "additional files needed to resolve extra external networks.")
-	prefix := flag.String("extnet-prefix", "comcast:ext:network=", "prefix used in the tag to reference external networks")
+	flag.Var(&prefixes, "extnet-prefix", "prefix used in the tag to reference external networks (repeatable, defaults to comcast:ext:network=)")
+	flag.Var(&patterns, "extnet-pattern", "regular expression matching the tags that reference external networks (repeatable)")
+	hsPrefix := flag.String("hostservice-prefix", "cns-customer:ext:hostservice=", "prefix used in the tag to reference host services")
+	verifyOnly := flag.Bool("verify", false, "verify that the previously generated out-<config-file> is equivalent to <config-file>")
+	rollbackOnly := flag.Bool("rollback", false, "generate rollback-<config-file> to remove the objects imported from out-<config-file>")
+	label := flag.String("label", "", "import label to remove with -rollback (defaults to the label of out-<config-file>)")
+	finalizeOnly := flag.Bool("finalize", false, "verify out-<config-file> and generate finalize-<config-file> to remove the v1 objects of <config-file>")
+	noMerge := flag.Bool("no-merge", false, "do not merge network rule set policies with the same subject (one-to-one traceability)")
+	collapse := flag.Bool("collapse", false, "collapse bidirectional policies to the effective direction and drop ineffective policies")
+	naming := flag.String("naming", utils.NamingSuffix, "naming strategy for migrated objects: suffix, prefix or template")
+	namingValue := flag.String("naming-value", "", "suffix, prefix or template (with {{.Name}} and {{.ID}}) used to name migrated objects (defaults to "+utils.MigrationSuffix+" or v2-)")
+	migrationID := flag.String("migration-id", "", "migration ID available as {{.ID}} in naming templates")
+	explainPolicy := flag.String("explain", "", "print the network policy <policy> next to the network rule set policies it generates")
+	reportFormat := flag.String("report", "", "generate report-<config-file>.md or .html with every network policy next to its network rule set policies: markdown or html")
+	strict := flag.Bool("strict", false, "do not generate out-<config-file> if any policy has errors or warnings")
+	logFormat := flag.String("log-format", "text", "format of the logs written to stderr: text or json")
+	logLevel := flag.String("log-level", "warn", "minimum level of the logs: debug, info, warn or error")
 	flag.Parse()
 
-	if *prefix == "" {
+	logger, err := newLogger(*logFormat, *logLevel)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		usage()
+		os.Exit(1)
+	}
+	defer logger.Sync() // nolint
+
+	if len(prefixes) == 0 && len(patterns) == 0 {
+		prefixes = append(prefixes, "comcast:ext:network=")
+	}
+
+	if *namingValue == "" {
+		switch *naming {
+		case utils.NamingSuffix:
+			*namingValue = utils.MigrationSuffix
+		case utils.NamingPrefix:
+			*namingValue = strings.TrimPrefix(utils.MigrationSuffix, "-") + "-"
+		}
+	}
+	namingStrategy, err := utils.NewNaming(*naming, *namingValue, *migrationID)
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
 		usage()
 		os.Exit(1)
 	}
 
-	utils.ExtnetPrefix = *prefix
+	m, err := apoxfrm.New(apoxfrm.Options{
+		ExtnetPrefixes:    prefixes,
+		ExtnetPatterns:    patterns,
+		HostServicePrefix: *hsPrefix,
+		Naming:            namingStrategy,
+		NoMerge:           *noMerge,
+		Collapse:          *collapse,
+		Strict:            *strict,
+		Logger:            logger,
+	})
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		usage()
+		os.Exit(1)
+	}
 
 	location := filepath.Join(*directory, *file)
-	fmt.Println("External network prefix: " + *prefix)
+	fmt.Println("External network prefix: " + prefixes.String())
+	fmt.Println("External network regexp: " + patterns.String())
+	fmt.Println("Host service prefix:     " + *hsPrefix)
+	fmt.Println("Naming:                  " + m.Config().Naming().String())
 	fmt.Println("Processing file:         " + location)
 	fmt.Println("Additional files:        " + extraFiles.String())
 
-	process(*directory, *file, extraFiles)
+	switch {
+	case *explainPolicy != "":
+		if !explain(m, *directory, *file, extraFiles, *explainPolicy) {
+			os.Exit(1)
+		}
+		return
+	case *reportFormat != "":
+		if *reportFormat != report.FormatMarkdown && *reportFormat != report.FormatHTML {
+			fmt.Println("Error: unknown report format " + *reportFormat)
+			usage()
+			os.Exit(1)
+		}
+		writeReport(m, *directory, *file, extraFiles, *reportFormat)
+		return
+	case *verifyOnly:
+		if !verify(m, *directory, *file, extraFiles) {
+			os.Exit(1)
+		}
+		return
+	case *rollbackOnly:
+		rollback(*directory, *file, *label)
+		return
+	case *finalizeOnly:
+		if !finalize(m, *directory, *file, extraFiles) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if !process(m, *directory, *file, extraFiles, *collapse) {
+		os.Exit(1)
+	}
 }
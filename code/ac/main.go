@@ -2,19 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/approval/sqlite"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/compliance"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/extnetwork"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/graph"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/hostservice"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/networkpolicy"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/simulator"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/tenant"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/api/zone"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/audit"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/logging"
 	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/manipctx"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/reconciler"
+	"github.com/PaloAltoNetworks/cns-customer/aporeto-lib/server"
+	"go.aporeto.io/manipulate"
 )
 
 var scenarios []string
@@ -25,16 +41,31 @@ func init() {
 		"zone-delete",
 		"tenant-create",
 		"tenant-disable",
+		"tenant-enable",
 		"tenant-delete",
+		"tenant-backup",
+		"tenant-restore",
 		"service-create",
 		"service-delete",
 		"exception-create",
 		"exception-delete",
+		"exception-submit",
+		"exception-approve",
+		"exception-reject",
+		"exception-revoke",
+		"exception-expire",
+		"exception-list",
+		"extnet-sync",
+		"simulate",
+		"graph",
+		"validate",
+		"serve",
+		"reconcile",
 	}
 }
 
 func usage() {
-	fmt.Printf("Usage:\n  ac [-config <config-path>] -scenario <%s>\n", strings.Join(scenarios, "|"))
+	fmt.Printf("Usage:\n  ac [-config <config-path>] [-dry-run] [-format <dot|mermaid|json>] [-log-format <text|json>] [-log-level <level>] [-listen <address>] [-api-tokens <path>] [-tls-cert <path> -tls-key <path>] [-client-ca <path>] [-archive <path>] [-repair] [-lock-file <path>] [-resync <duration>] [-request <id>] [-comment <text>] -scenario <%s>\n", strings.Join(scenarios, "|"))
 }
 
 // Service definition.
@@ -53,20 +84,59 @@ type Policy struct {
 	SubjectTags   []string `json:"subject-tags"`
 	ObjectTenant  string   `json:"object-tenant"`
 	ObjectTags    []string `json:"object-tags"`
+	Encrypt       bool     `json:"encrypt"`
+
+	// Justification and Expiry (RFC 3339) are required by exception-submit.
+	Justification string `json:"justification"`
+	Expiry        string `json:"expiry"`
 
 	description string
 }
 
+// request returns the exception request of the policy.
+func (p *Policy) request(requester string) (*approval.Request, error) {
+
+	r := &approval.Request{
+		Policy: networkpolicy.NetworkPolicy{
+			Name:                   p.Name,
+			Description:            p.description,
+			SubjectTenantNamespace: p.SubjectTenant,
+			SubjectTags:            p.SubjectTags,
+			ObjectTenantNamespace:  p.ObjectTenant,
+			ObjectTags:             p.ObjectTags,
+			Encrypt:                p.Encrypt,
+		},
+		Requester:     requester,
+		Justification: p.Justification,
+	}
+
+	if p.Expiry != "" {
+		expiry, err := time.Parse(time.RFC3339, p.Expiry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry of exception policy '%s': %s", p.Name, err.Error())
+		}
+		r.Expiry = expiry
+	}
+
+	return r, nil
+}
+
 // Aporeto is the configuration script.
 type Aporeto struct {
-	AppCredPath            string     `json:"app-cred-path"`
-	Account                string     `json:"account"`
-	Zone                   string     `json:"zone"`
-	Tenant                 string     `json:"tenant"`
-	TenantAuthPolicyClaims [][]string `json:"tenant-auth-policy-claims"`
-	EnforcerAppCredPath    string     `json:"enforcer-app-cred-path"`
-	Services               []Service  `json:"services"`
-	ExceptionPolicies      []Policy   `json:"exception-policies"`
+	AppCredPath            string              `json:"app-cred-path"`
+	Account                string              `json:"account"`
+	Zone                   string              `json:"zone"`
+	Tenant                 string              `json:"tenant"`
+	TenantAuthPolicyClaims [][]string          `json:"tenant-auth-policy-claims"`
+	EnforcerAppCredPath    string              `json:"enforcer-app-cred-path"`
+	Services               []Service           `json:"services"`
+	ExceptionPolicies      []Policy            `json:"exception-policies"`
+	ExternalNetworkFeed    string              `json:"extnet-feed"`
+	ExternalNetworkZone    bool                `json:"extnet-feed-zone"`
+	Simulation             *simulator.Flow     `json:"simulation"`
+	ComplianceRules        string              `json:"compliance-rules"`
+	ExceptionStore         string              `json:"exception-store"`
+	TenantOwners           map[string][]string `json:"tenant-owners"`
 
 	zoneDescription             string
 	tenantDescription           string
@@ -87,12 +157,71 @@ func (a *Aporeto) Setup() {
 	}
 }
 
-func args() (*Aporeto, string) {
+// Options are the command line options other than the config.
+type Options struct {
+	Scenario    string
+	DryRun      bool
+	GraphFormat string
+	AuditLog    string
+	ChangeID    string
+	LogFormat   string
+	LogLevel    logging.Level
+	Listen      string
+	APITokens   string
+	TLSCert     string
+	TLSKey      string
+	ClientCA    string
+	Archive     string
+	Repair      bool
+	LockFile    string
+	Resync      time.Duration
+	Request     string
+	Comment     string
+}
+
+// Rules returns the compliance rules of the config, or nil if the config has no rule file.
+func (a *Aporeto) Rules() (*compliance.RuleSet, error) {
+
+	if a.ComplianceRules == "" {
+		return nil, nil
+	}
+	return compliance.LoadRules(a.ComplianceRules)
+}
+
+func args() (*Aporeto, *Options) {
 
 	configPtr := flag.String("config", "../config/tenant-a.json", "<config-path>")
 	scenarioPtr := flag.String("scenario", "", strings.Join(scenarios, "|"))
+	dryRunPtr := flag.Bool("dry-run", false, "only print the changes of extnet-sync")
+	formatPtr := flag.String("format", graph.FormatDOT, "format of the graph: dot|mermaid|json")
+	auditLogPtr := flag.String("audit-log", "ac-audit.jsonl", "file recording the changes made, empty to disable")
+	changeIDPtr := flag.String("change-id", "", "change ID of the changes made, random if empty")
+	logFormatPtr := flag.String("log-format", logging.FormatText, "format of the logs: text|json")
+	logLevelPtr := flag.String("log-level", "info", "minimum level of the logs: debug|info|warn|error")
+	listenPtr := flag.String("listen", "127.0.0.1:8080", "address of the API served by the serve scenario")
+	apiTokensPtr := flag.String("api-tokens", "", "json file mapping the bearer tokens accepted by the serve scenario to their client")
+	tlsCertPtr := flag.String("tls-cert", "", "certificate of the API served by the serve scenario")
+	tlsKeyPtr := flag.String("tls-key", "", "key of the certificate of the API served by the serve scenario")
+	clientCAPtr := flag.String("client-ca", "", "CA of the client certificates accepted by the serve scenario, requires -tls-cert")
+	archivePtr := flag.String("archive", "tenant-backup.json", "archive written by tenant-backup and read by tenant-restore")
+	repairPtr := flag.Bool("repair", false, "create the tenant layout objects found missing by reconcile")
+	lockFilePtr := flag.String("lock-file", "ac-reconcile.lock", "file locked by the reconcile leader")
+	resyncPtr := flag.Duration("resync", reconciler.DefaultResync, "interval of the full reconcile passes")
+	requestPtr := flag.String("request", "", "ID of the exception request to approve, reject or revoke")
+	commentPtr := flag.String("comment", "", "comment of the approval, rejection or revocation")
 	flag.Parse()
 
+	if *logFormatPtr != logging.FormatText && *logFormatPtr != logging.FormatJSON {
+		usage()
+		os.Exit(1)
+	}
+
+	logLevel, err := logging.ParseLevel(*logLevelPtr)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
 	if *configPtr == "" {
 		usage()
 		os.Exit(1)
@@ -130,40 +259,464 @@ func args() (*Aporeto, string) {
 	var aporeto Aporeto
 	json.Unmarshal(config, &aporeto)
 
-	return &aporeto, *scenarioPtr
+	return &aporeto, &Options{
+		Scenario:    *scenarioPtr,
+		DryRun:      *dryRunPtr,
+		GraphFormat: *formatPtr,
+		AuditLog:    *auditLogPtr,
+		ChangeID:    *changeIDPtr,
+		LogFormat:   *logFormatPtr,
+		LogLevel:    logLevel,
+		Listen:      *listenPtr,
+		APITokens:   *apiTokensPtr,
+		TLSCert:     *tlsCertPtr,
+		TLSKey:      *tlsKeyPtr,
+		ClientCA:    *clientCAPtr,
+		Archive:     *archivePtr,
+		Repair:      *repairPtr,
+		LockFile:    *lockFilePtr,
+		Resync:      *resyncPtr,
+		Request:     *requestPtr,
+		Comment:     *commentPtr,
+	}
+}
+
+func printSimulation(res *simulator.Result) {
+
+	verdict := "rejected"
+	if res.Allowed {
+		verdict = "allowed"
+	}
+	fmt.Printf("Verdict: %s\n", verdict)
+
+	for _, d := range []*simulator.Decision{res.Outgoing, res.Incoming} {
+		if d == nil {
+			continue
+		}
+		reason := ""
+		if d.Default {
+			reason = " (no matching policy)"
+		} else if d.Fallback {
+			reason = " (fallback policies)"
+		}
+		fmt.Printf("  %s: %s%s\n", d.Side, d.Action, reason)
+		for _, m := range d.Matches {
+			extnet := ""
+			if m.ExternalNetwork != "" {
+				extnet = " via external network " + m.ExternalNetwork
+			}
+			fmt.Printf("    - %s '%s' in %s%s\n", m.Policy.Action, m.Policy.Name, m.Policy.Namespace, extnet)
+		}
+	}
+}
+
+// validate prints the compliance violations of the exception policies and returns the
+// exit code: 1 if a policy is denied.
+func validate(cfg *Aporeto, logger logging.Logger) int {
+
+	rules, err := cfg.Rules()
+	if err != nil {
+		logger.Error("unable to load compliance rules", logging.Err(err))
+		return 1
+	}
+
+	code := 0
+	for _, e := range cfg.ExceptionPolicies {
+		np := networkpolicy.NetworkPolicy{
+			Name:                   e.Name,
+			SubjectTenantNamespace: e.SubjectTenant,
+			SubjectTags:            e.SubjectTags,
+			ObjectTenantNamespace:  e.ObjectTenant,
+			ObjectTags:             e.ObjectTags,
+			Encrypt:                e.Encrypt,
+			Rules:                  rules,
+		}
+		violations := np.Validate()
+		for _, v := range violations {
+			fmt.Println(v)
+		}
+		if compliance.Denied(violations) != nil {
+			code = 1
+		}
+	}
+
+	if code == 0 {
+		fmt.Println("All exception policies are compliant")
+	}
+	return code
+}
+
+// authenticator returns the authenticator of the API and the TLS config of the server, if
+// any: the clients are authenticated with their certificate if opts has a client CA,
+// with a bearer token of the api tokens file otherwise.
+func authenticator(opts *Options) (server.Authenticator, *tls.Config, error) {
+
+	var tlsConfig *tls.Config
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load certificate '%s': %s", opts.TLSCert, err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	if opts.ClientCA != "" {
+		if tlsConfig == nil {
+			return nil, nil, fmt.Errorf("-client-ca requires -tls-cert and -tls-key")
+		}
+		data, err := ioutil.ReadFile(opts.ClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read client CA '%s': %s", opts.ClientCA, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("unable to read client CA '%s': no certificate", opts.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		return &server.CertificateAuthenticator{}, tlsConfig, nil
+	}
+
+	if opts.APITokens != "" {
+		data, err := ioutil.ReadFile(opts.APITokens)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read api tokens '%s': %s", opts.APITokens, err)
+		}
+		tokens := server.TokenAuthenticator{}
+		if err := json.Unmarshal(data, &tokens); err != nil {
+			return nil, nil, fmt.Errorf("unable to read api tokens '%s': %s", opts.APITokens, err)
+		}
+		if len(tokens) == 0 {
+			return nil, nil, fmt.Errorf("unable to read api tokens '%s': no token", opts.APITokens)
+		}
+		return tokens, tlsConfig, nil
+	}
+
+	return nil, nil, fmt.Errorf("the API requires authentication: set -client-ca or -api-tokens")
+}
+
+// serve serves the onboarding API until ctx is canceled, then waits for the running jobs.
+func serve(ctx context.Context, m manipulate.Manipulator, cfg *Aporeto, opts *Options, logger logging.Logger) error {
+
+	auth, tlsConfig, err := authenticator(opts)
+	if err != nil {
+		return err
+	}
+
+	w, closer, err := cfg.Workflow()
+	if err != nil {
+		return err
+	}
+	defer closer() // nolint
+
+	s, err := server.New(ctx, m, server.Options{
+		Authenticator:      auth,
+		Logger:             logger,
+		Workflow:           w,
+		EnforcerAppCredDir: cfg.EnforcerAppCredPath,
+	})
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: opts.Listen, Handler: s, TLSConfig: tlsConfig}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx) // nolint
+	}()
+
+	logger.Info("serving onboarding API", logging.String("address", opts.Listen), logging.Any("tls", tlsConfig != nil))
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	s.Wait()
+
+	return nil
+}
+
+// backup writes the archive of the tenant of the config to path.
+func backup(ctx context.Context, m manipulate.Manipulator, cfg *Aporeto, path string) error {
+
+	t := &tenant.Tenant{
+		Account: cfg.Account,
+		Zone:    cfg.Zone,
+		Name:    cfg.Tenant,
+	}
+	a, err := t.Export(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create archive '%s': %s", path, err)
+	}
+	if err := a.Write(f); err != nil {
+		f.Close() // nolint
+		return fmt.Errorf("unable to write archive '%s': %s", path, err)
+	}
+
+	return f.Close()
+}
+
+// restore restores the archive at path in the zone and tenant of the config, the ones of
+// the archive if not set.
+func restore(ctx context.Context, m manipulate.Manipulator, cfg *Aporeto, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open archive '%s': %s", path, err)
+	}
+	defer f.Close() // nolint
+
+	a, err := tenant.ReadArchive(f)
+	if err != nil {
+		return err
+	}
+
+	return tenant.Restore(ctx, m, a, &tenant.Tenant{
+		Account:               cfg.Account,
+		Zone:                  cfg.Zone,
+		Name:                  cfg.Tenant,
+		EnforcerAppCredPath:   cfg.EnforcerAppCredPath,
+		EnforcerAppCredOutput: os.Stdout,
+	})
+}
+
+// reconcile waits to be the leader holding the lock file, then reconciles the tenants of
+// the account with their default layout until ctx is canceled. The tenant of the config
+// is reconciled with its authorization policy, the others are discovered.
+func reconcile(ctx context.Context, m manipulate.Manipulator, cfg *Aporeto, opts *Options, logger logging.Logger) error {
+
+	logger.Info("waiting for reconcile leadership", logging.String("lock-file", opts.LockFile))
+	lock, err := reconciler.AcquireLock(ctx, opts.LockFile, 0)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer lock.Release() // nolint
+	logger.Info("reconcile leadership acquired")
+
+	s, err := manipctx.Subscriber(ctx, cfg.AppCredPath)
+	if err != nil {
+		return err
+	}
+
+	var tenants []*tenant.Tenant
+	if cfg.Tenant != "" {
+		tenants = append(tenants, &tenant.Tenant{
+			Account:               cfg.Account,
+			Zone:                  cfg.Zone,
+			Name:                  cfg.Tenant,
+			Description:           cfg.tenantDescription,
+			AuthPolicyClaims:      cfg.TenantAuthPolicyClaims,
+			AuthPolicyDescription: cfg.tenantAuthPolicyDescription,
+		})
+	}
+
+	r := reconciler.New(m, reconciler.Options{
+		Logger:  logger,
+		Account: cfg.Account,
+		Tenants: tenants,
+		Repair:  opts.Repair,
+		Resync:  opts.Resync,
+	})
+
+	return r.Run(ctx, reconciler.NewPushSource(s))
+}
+
+// Workflow returns the approval workflow of the exception requests, with its store
+// closer. The store is a SQLite database if exception-store ends with .db or .sqlite, a
+// json file otherwise, exceptions.json by default.
+func (a *Aporeto) Workflow() (*approval.Workflow, func() error, error) {
+
+	rules, err := a.Rules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := a.ExceptionStore
+	if path == "" {
+		path = "exceptions.json"
+	}
+
+	var store approval.Store = approval.NewFileStore(path)
+	closer := func() error { return nil }
+	switch filepath.Ext(path) {
+	case ".db", ".sqlite":
+		s, err := sqlite.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, closer = s, s.Close
+	}
+
+	return &approval.Workflow{Store: store, Owners: a.TenantOwners, Rules: rules}, closer, nil
+}
+
+// exceptionRequests runs the scenarios of the exception requests that do not change the
+// control plane and returns the exit code. The user submitting and rejecting requests is
+// the application credential of the config, authenticated by the control plane.
+func exceptionRequests(ctx context.Context, cfg *Aporeto, opts *Options, logger logging.Logger) int {
+
+	w, closer, err := cfg.Workflow()
+	if err != nil {
+		logger.Error("unable to open exception requests", logging.Err(err))
+		return 1
+	}
+	defer closer() // nolint
+
+	user := ""
+	if opts.Scenario != "exception-list" {
+		if user, err = manipctx.Identity(ctx, cfg.AppCredPath); err != nil {
+			logger.Error("unable to authenticate", logging.Err(err))
+			return 1
+		}
+	}
+
+	switch opts.Scenario {
+	case "exception-submit":
+		for _, e := range cfg.ExceptionPolicies {
+			r, err := e.request(user)
+			if err != nil {
+				logger.Error("scenario failed", logging.Err(err))
+				return 1
+			}
+			if err := w.Submit(ctx, r); err != nil {
+				logger.Error("scenario failed", logging.Err(err))
+				return 1
+			}
+			fmt.Printf("Submitted %s: %s\n", r.ID, r.Policy.Name)
+		}
+
+	case "exception-reject":
+		if _, err := w.Reject(ctx, opts.Request, user, opts.Comment); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+
+	case "exception-list":
+		requests, err := w.List(ctx)
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+		printRequests(requests)
+	}
+
+	return 0
+}
+
+func printRequests(requests []*approval.Request) {
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tNAME\tREQUESTER\tEXPIRY\tWAITING FOR")
+	for _, r := range requests {
+		expiry := "-"
+		if !r.Expiry.IsZero() {
+			expiry = r.Expiry.Format(time.RFC3339)
+		}
+		waiting := "-"
+		if r.Status == approval.StatusPending {
+			waiting = strings.Join(r.Pending(), ", ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, r.Status, r.Policy.Name, r.Requester, expiry, waiting)
+	}
+	tw.Flush() // nolint
 }
 
 func main() {
 
-	cfg, scenario := args()
+	cfg, opts := args()
+
+	// Setup descriptions etc.
+	cfg.Setup()
+
+	// Log to stderr, stdout is kept for the results of the scenarios.
+	logger, err := logging.New(os.Stderr, opts.LogFormat, opts.LogLevel)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	logger = logger.With(logging.String("scenario", opts.Scenario))
+
+	// Validation does not need access to the control plane.
+	if opts.Scenario == "validate" {
+		os.Exit(validate(cfg, logger))
+	}
+
+	// Neither do the exception requests until they are approved or revoked, besides
+	// authenticating their user.
+	switch opts.Scenario {
+	case "exception-submit", "exception-reject", "exception-list":
+		os.Exit(exceptionRequests(logging.NewContext(context.Background(), logger), cfg, opts, logger))
+	}
 
 	// Create Context and Install Signal Handlers
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	manipctx.InstallSIGINTHandler(cancel)
+	ctx = logging.NewContext(ctx, logger)
 
-	// Utilize the application credential to get access to a manipulator.
-	m, err := manipctx.Manipulator(ctx, cfg.AppCredPath)
+	// Utilize the application credential to get access to a manipulator,
+	// recording the changes to the audit log if any.
+	var m manipulate.Manipulator
+	var sink *audit.FileSink
+	if opts.AuditLog != "" {
+		if sink, err = audit.NewFileSink(opts.AuditLog); err != nil {
+			logger.Error("unable to open audit log", logging.Err(err))
+			os.Exit(1)
+		}
+		m, err = manipctx.AuditedManipulator(ctx, cfg.AppCredPath, sink, opts.ChangeID)
+	} else {
+		m, err = manipctx.Manipulator(ctx, cfg.AppCredPath)
+	}
 	if err != nil {
-		log.Printf("unable to prepare manipulator: %s\n", err.Error())
+		logger.Error("unable to prepare manipulator", logging.Err(err))
 		os.Exit(1)
 	}
 
-	// Setup descriptions etc.
-	cfg.Setup()
+	code := run(ctx, m, cfg, opts, logger)
 
-	switch scenario {
+	// The scenario fails if a change was not recorded to the audit log.
+	if sink != nil {
+		if err := sink.Err(); err != nil {
+			logger.Error("unable to record changes to audit log", logging.Err(err))
+			code = 1
+		}
+		if err := sink.Close(); err != nil {
+			logger.Error("unable to close audit log", logging.Err(err))
+			code = 1
+		}
+	}
+
+	os.Exit(code)
+}
+
+// run runs the scenario with the manipulator and returns the exit code.
+func run(ctx context.Context, m manipulate.Manipulator, cfg *Aporeto, opts *Options, logger logging.Logger) int {
+
+	switch opts.Scenario {
 	case "zone-create":
 		zone := zone.New(cfg.Account, cfg.Zone, cfg.zoneDescription)
 		if err := zone.Create(ctx, m); err != nil {
-			log.Printf("error: %s\n", err)
-			os.Exit(1)
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	case "zone-delete":
 		zone := zone.New(cfg.Account, cfg.Zone, cfg.zoneDescription)
 		if err := zone.Delete(ctx, m); err != nil {
-			log.Printf("error: %s\n", err)
-			os.Exit(1)
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	case "tenant-create":
 		tenant := tenant.Tenant{
@@ -175,8 +728,8 @@ func main() {
 			AuthPolicyDescription: cfg.tenantAuthPolicyDescription,
 		}
 		if err := tenant.Create(ctx, m); err != nil {
-			log.Printf("error: %s\n", err)
-			os.Exit(1)
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	case "tenant-disable":
 		tenant := tenant.Tenant{
@@ -185,8 +738,20 @@ func main() {
 			Name:    cfg.Tenant,
 		}
 		if err := tenant.Disable(ctx, m); err != nil {
-			log.Printf("error: %s\n", err)
-			os.Exit(1)
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "tenant-enable":
+		tenant := tenant.Tenant{
+			Account:               cfg.Account,
+			Zone:                  cfg.Zone,
+			Name:                  cfg.Tenant,
+			AuthPolicyClaims:      cfg.TenantAuthPolicyClaims,
+			AuthPolicyDescription: cfg.tenantAuthPolicyDescription,
+		}
+		if err := tenant.Enable(ctx, m); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	case "tenant-delete":
 		tenant := tenant.Tenant{
@@ -195,8 +760,18 @@ func main() {
 			Name:    cfg.Tenant,
 		}
 		if err := tenant.Delete(ctx, m); err != nil {
-			log.Printf("error: %s\n", err)
-			os.Exit(1)
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "tenant-backup":
+		if err := backup(ctx, m, cfg, opts.Archive); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "tenant-restore":
+		if err := restore(ctx, m, cfg, opts.Archive); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	case "service-create":
 		for _, s := range cfg.Services {
@@ -209,8 +784,8 @@ func main() {
 				Description: s.description,
 			}
 			if err := svc.Create(ctx, m); err != nil {
-				log.Printf("error: %s\n", err)
-				os.Exit(1)
+				logger.Error("scenario failed", logging.Err(err))
+				return 1
 			}
 		}
 	case "service-delete":
@@ -222,42 +797,139 @@ func main() {
 				Rail:    s.Rail,
 			}
 			if err := svc.Delete(ctx, m); err != nil {
-				log.Printf("error: %s\n", err)
-				os.Exit(1)
+				logger.Error("scenario failed", logging.Err(err))
+				return 1
 			}
 		}
-	case "exception-create":
-		for _, e := range cfg.ExceptionPolicies {
-			np := networkpolicy.NetworkPolicy{
-				Name:                   e.Name,
-				Description:            e.description,
-				SubjectTenantNamespace: e.SubjectTenant,
-				SubjectTags:            e.SubjectTags,
-				ObjectTenantNamespace:  e.ObjectTenant,
-				ObjectTags:             e.ObjectTags,
-			}
-			if err := np.Create(ctx, m); err != nil {
-				log.Printf("error: %s\n", err)
-				os.Exit(1)
+	case "exception-create", "exception-delete", "exception-approve", "exception-revoke", "exception-expire":
+		w, closer, err := cfg.Workflow()
+		if err != nil {
+			logger.Error("unable to open exception requests", logging.Err(err))
+			return 1
+		}
+		defer closer() // nolint
+		// The user is the application credential of the manipulator.
+		user := ""
+		if opts.Scenario != "exception-expire" {
+			if user, err = manipctx.Identity(ctx, cfg.AppCredPath); err != nil {
+				logger.Error("unable to authenticate", logging.Err(err))
+				return 1
 			}
 		}
-	case "exception-delete":
-		for _, e := range cfg.ExceptionPolicies {
-			np := networkpolicy.NetworkPolicy{
-				Name:                   e.Name,
-				Description:            e.description,
-				SubjectTenantNamespace: e.SubjectTenant,
-				SubjectTags:            e.SubjectTags,
-				ObjectTenantNamespace:  e.ObjectTenant,
-				ObjectTags:             e.ObjectTags,
+		switch opts.Scenario {
+		case "exception-create":
+			// The policies are recorded as requests approved by the user.
+			for _, e := range cfg.ExceptionPolicies {
+				var r *approval.Request
+				if r, err = e.request(user); err != nil {
+					break
+				}
+				if err = w.Create(ctx, m, r, user, opts.Comment); err != nil {
+					break
+				}
+				fmt.Printf("Created %s: %s\n", r.ID, r.Policy.Name)
+			}
+		case "exception-delete":
+			// The policies created before the approvals have no request.
+			for _, e := range cfg.ExceptionPolicies {
+				_, err = w.RevokePolicy(ctx, m, "", e.Name, user, opts.Comment)
+				if err == approval.ErrNotFound {
+					np := networkpolicy.NetworkPolicy{Name: e.Name}
+					err = np.Delete(ctx, m)
+				}
+				if err != nil {
+					break
+				}
+			}
+		case "exception-approve":
+			var r *approval.Request
+			if r, err = w.Approve(ctx, m, opts.Request, user, opts.Comment); err == nil && r.Status == approval.StatusPending {
+				fmt.Printf("Waiting for the approval of %s\n", strings.Join(r.Pending(), ", "))
 			}
-			if err := np.Delete(ctx, m); err != nil {
-				log.Printf("error: %s\n", err)
-				os.Exit(1)
+		case "exception-revoke":
+			_, err = w.Revoke(ctx, m, opts.Request, user, opts.Comment)
+		case "exception-expire":
+			var expired []*approval.Request
+			expired, err = w.Expire(ctx, m)
+			printRequests(expired)
+		}
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "extnet-sync":
+		entries, err := extnetwork.ReadFeedFile(cfg.ExternalNetworkFeed)
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+		sync := extnetwork.Sync{
+			Account: cfg.Account,
+			Zone:    cfg.Zone,
+			Tenant:  cfg.Tenant,
+			Entries: entries,
+			DryRun:  opts.DryRun,
+		}
+		if cfg.ExternalNetworkZone {
+			sync.Tenant = ""
+		}
+		res, err := sync.Run(ctx, m)
+		if res != nil {
+			for _, n := range res.Conflicts {
+				logger.Warn("external network is not managed by extnet-sync, skipped", logging.String("name", n))
 			}
+			logger.Info(
+				"external networks synchronized",
+				logging.Any("created", res.Created),
+				logging.Any("updated", res.Updated),
+				logging.Any("deleted", res.Deleted),
+				logging.Any("unchanged", len(res.Unchanged)),
+			)
+		}
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "simulate":
+		if cfg.Simulation == nil {
+			logger.Error("no simulation in config")
+			return 1
+		}
+		sim, err := simulator.Fetch(ctx, m, cfg.Account)
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+		res, err := sim.Simulate(cfg.Simulation)
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+		printSimulation(res)
+	case "graph":
+		g, err := graph.Fetch(ctx, m, cfg.Account)
+		if err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+		if err := g.Write(os.Stdout, opts.GraphFormat); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "serve":
+		if err := serve(ctx, m, cfg, opts, logger); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
+		}
+	case "reconcile":
+		if err := reconcile(ctx, m, cfg, opts, logger); err != nil {
+			logger.Error("scenario failed", logging.Err(err))
+			return 1
 		}
 	default:
 		usage()
 		panic("invalid scenario")
 	}
+
+	return 0
 }